@@ -10,15 +10,17 @@ import (
 	"syscall"
 
 	"github.com/DataDog/datadog-go/statsd"
-	"github.com/hashicorp/consul/api"
 	"github.com/sirupsen/logrus"
 	"github.com/stripe/veneur/v14/diagnostics"
-	"github.com/stripe/veneur/v14/discovery/consul"
+	"github.com/stripe/veneur/v14/discovery"
 	"github.com/stripe/veneur/v14/proxy"
 	"github.com/stripe/veneur/v14/proxy/connect"
 	"github.com/stripe/veneur/v14/proxy/destinations"
+	"github.com/stripe/veneur/v14/proxy/limits"
 	"github.com/stripe/veneur/v14/util/build"
 	utilConfig "github.com/stripe/veneur/v14/util/config"
+	"github.com/stripe/veneur/v14/util/tlsconfig"
+	"google.golang.org/grpc/credentials"
 )
 
 var (
@@ -93,24 +95,107 @@ func main() {
 		serveMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
 	}
 
-	discoverer, err := consul.NewConsul(api.DefaultConfig())
+	discoverer, closeDiscoverer, err := discovery.New(
+		config.Discovery, logrus.NewEntry(logger), statsClient, "veneur_proxy.")
 	if err != nil {
 		statsClient.Incr("exit", []string{"error:true"}, 1.0)
 		logger.WithError(err).Fatal("failed to create discoverer")
 	}
+	defer closeDiscoverer()
+
+	var serverCredentials, clientCredentials credentials.TransportCredentials
+	if config.Tls.Serve || config.Tls.Forward {
+		reloader, err := tlsconfig.New(tlsconfig.Config{
+			Certificate:     config.Tls.Certificate,
+			CertificateFile: config.Tls.CertificateFile,
+			Key:             config.Tls.Key,
+			KeyFile:         config.Tls.KeyFile,
+			Authority:       config.Tls.AuthorityCertificate,
+			AuthorityFile:   config.Tls.AuthorityCertificateFile,
+			ClientAuth:      config.Tls.ClientAuth,
+		})
+		if err != nil {
+			statsClient.Incr("exit", []string{"error:true"}, 1.0)
+			logger.WithError(err).Fatal("invalid tls config")
+		}
+		if config.Tls.Serve {
+			serverCredentials = credentials.NewTLS(reloader.GRPCServerConfig())
+		}
+		if config.Tls.Forward {
+			clientCredentials = credentials.NewTLS(reloader.ClientConfig())
+		}
+	}
 
 	loggerEntry := logrus.NewEntry(logger)
+	newHash := func() destinations.Hash {
+		hash, err := destinations.NewHash(
+			config.Hash.Strategy, config.Hash.Replicas, config.Hash.LoadFactor)
+		if err != nil {
+			logger.WithError(err).Fatal("invalid hash configuration")
+		}
+		return hash
+	}
+	destinationOptions := []destinations.Option{}
+	if config.HandoffInterval > 0 {
+		destinationOptions = append(destinationOptions, destinations.WithHandoff())
+	}
+	replicaDestinations :=
+		make([]destinations.Destinations, len(config.ForwardReplicas))
+	replicaNames := map[string]struct{}{}
+	for index, replica := range config.ForwardReplicas {
+		if replica.Name == "" {
+			logger.Fatal("forward_replicas entries require a name")
+		}
+		if _, ok := replicaNames[replica.Name]; ok {
+			logger.WithField("name", replica.Name).
+				Fatal("duplicate forward_replicas name")
+		}
+		replicaNames[replica.Name] = struct{}{}
+		replicaDestinations[index] = destinations.Create(
+			connect.Create(
+				config.DialTimeout, loggerEntry, config.SendBufferSize, statsClient,
+				clientCredentials),
+			newHash(), loggerEntry, destinationOptions...)
+	}
+
+	var mirrorDestinations destinations.Destinations
+	if config.Mirror.ForwardService != "" ||
+		len(config.Mirror.ForwardAddresses) > 0 {
+		if config.Mirror.SampleRate < 0 || config.Mirror.SampleRate > 1 {
+			logger.WithField("sample_rate", config.Mirror.SampleRate).
+				Fatal("mirror sample_rate must be between 0 and 1")
+		}
+		mirrorDestinations = destinations.Create(
+			connect.Create(
+				config.DialTimeout, loggerEntry, config.SendBufferSize, statsClient,
+				clientCredentials),
+			newHash(), loggerEntry, destinationOptions...)
+	}
+
+	var limiter *limits.Limiter
+	if config.Limits.Enabled() {
+		limiter, err = limits.New(config.Limits)
+		if err != nil {
+			logger.WithError(err).Fatal("invalid limits configuration")
+		}
+	}
+
 	proxy := proxy.Create(&proxy.CreateParams{
 		Config: config,
 		Destinations: destinations.Create(
 			connect.Create(
-				config.DialTimeout, loggerEntry, config.SendBufferSize, statsClient),
-			loggerEntry),
-		Discoverer:         discoverer,
-		HealthcheckContext: ctx,
-		HttpHandler:        serveMux,
-		Logger:             loggerEntry,
-		Statsd:             statsClient,
+				config.DialTimeout, loggerEntry, config.SendBufferSize, statsClient,
+				clientCredentials),
+			newHash(), loggerEntry, destinationOptions...),
+		Discoverer:          discoverer,
+		HealthcheckContext:  ctx,
+		HttpHandler:         serveMux,
+		Limiter:             limiter,
+		Logger:              loggerEntry,
+		MirrorDestinations:  mirrorDestinations,
+		ReplicaDestinations: replicaDestinations,
+		ServerCredentials:   serverCredentials,
+		Statsd:              statsClient,
 	})
 
 	err = proxy.Start(ctx)
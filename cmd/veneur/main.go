@@ -13,11 +13,15 @@ import (
 	"github.com/stripe/veneur/v14/sinks/cortex"
 	"github.com/stripe/veneur/v14/sinks/datadog"
 	"github.com/stripe/veneur/v14/sinks/debug"
+	"github.com/stripe/veneur/v14/sinks/elasticsearch"
 	"github.com/stripe/veneur/v14/sinks/falconer"
+	"github.com/stripe/veneur/v14/sinks/graphite"
+	"github.com/stripe/veneur/v14/sinks/influxdb"
 	"github.com/stripe/veneur/v14/sinks/kafka"
 	"github.com/stripe/veneur/v14/sinks/lightstep"
 	"github.com/stripe/veneur/v14/sinks/localfile"
 	"github.com/stripe/veneur/v14/sinks/newrelic"
+	"github.com/stripe/veneur/v14/sinks/otlp"
 	"github.com/stripe/veneur/v14/sinks/prometheus"
 	"github.com/stripe/veneur/v14/sinks/s3"
 	"github.com/stripe/veneur/v14/sinks/signalfx"
@@ -108,6 +112,18 @@ func main() {
 				Create:      debug.CreateMetricSink,
 				ParseConfig: debug.ParseMetricConfig,
 			},
+			"elasticsearch": {
+				Create:      elasticsearch.CreateEventSink,
+				ParseConfig: elasticsearch.ParseEventConfig,
+			},
+			"graphite": {
+				Create:      graphite.Create,
+				ParseConfig: graphite.ParseConfig,
+			},
+			"influxdb": {
+				Create:      influxdb.Create,
+				ParseConfig: influxdb.ParseConfig,
+			},
 			"kafka": {
 				Create:      kafka.CreateMetricSink,
 				ParseConfig: kafka.ParseMetricConfig,
@@ -120,10 +136,18 @@ func main() {
 				Create:      newrelic.CreateMetricSink,
 				ParseConfig: newrelic.ParseMetricConfig,
 			},
+			"otlp": {
+				Create:      otlp.CreateMetricSink,
+				ParseConfig: otlp.ParseMetricConfig,
+			},
 			"prometheus": {
 				Create:      prometheus.CreateMetricSink,
 				ParseConfig: prometheus.ParseMetricConfig,
 			},
+			"prometheus_exporter": {
+				Create:      prometheus.CreateExporterSink,
+				ParseConfig: prometheus.ParseExporterConfig,
+			},
 			"s3": {
 				Create:      s3.Create,
 				ParseConfig: s3.ParseConfig,
@@ -142,6 +166,10 @@ func main() {
 				Create:      debug.CreateSpanSink,
 				ParseConfig: debug.ParseSpanConfig,
 			},
+			"elasticsearch": {
+				Create:      elasticsearch.CreateSpanSink,
+				ParseConfig: elasticsearch.ParseSpanConfig,
+			},
 			"falconer": {
 				Create:      falconer.Create,
 				ParseConfig: falconer.ParseConfig,
@@ -158,6 +186,10 @@ func main() {
 				Create:      newrelic.CreateSpanSink,
 				ParseConfig: newrelic.ParseSpanConfig,
 			},
+			"otlp": {
+				Create:      otlp.CreateSpanSink,
+				ParseConfig: otlp.ParseSpanConfig,
+			},
 			"splunk": {
 				Create:      splunk.Create,
 				ParseConfig: splunk.ParseConfig,
@@ -3,6 +3,7 @@ package veneur
 import (
 	"time"
 
+	"github.com/stripe/veneur/v14/discovery"
 	"github.com/stripe/veneur/v14/util"
 	"github.com/stripe/veneur/v14/util/matcher"
 )
@@ -18,8 +19,16 @@ type Config struct {
 	FlushOnShutdown             bool                `yaml:"flush_on_shutdown"`
 	FlushWatchdogMissedFlushes  int                 `yaml:"flush_watchdog_missed_flushes"`
 	ForwardAddress              string              `yaml:"forward_address"`
+	ForwardBuffer               ForwardBufferConfig `yaml:"forward_buffer"`
+	ForwardDiscovery            discovery.Config    `yaml:"forward_discovery"`
+	ForwardDiscoveryInterval    time.Duration       `yaml:"forward_discovery_interval"`
+	ForwardEvents               bool                `yaml:"forward_events"`
+	ForwardReplicas             []ReplicaConfig     `yaml:"forward_replicas"`
+	ForwardService              string              `yaml:"forward_service"`
+	ForwardSpans                bool                `yaml:"forward_spans"`
 	GrpcAddress                 string              `yaml:"grpc_address"`
 	GrpcListenAddresses         []util.Url          `yaml:"grpc_listen_addresses"`
+	HistogramThresholds         []ThresholdConfig   `yaml:"histogram_thresholds"`
 	Hostname                    string              `yaml:"hostname"`
 	HTTP                        HttpConfig          `yaml:"http"`
 	HTTPAddress                 string              `yaml:"http_address"`
@@ -37,6 +46,7 @@ type Config struct {
 	OmitEmptyHostname           bool                `yaml:"omit_empty_hostname"`
 	Percentiles                 []float64           `yaml:"percentiles"`
 	ReadBufferSizeBytes         int                 `yaml:"read_buffer_size_bytes"`
+	ReplicaName                 string              `yaml:"replica_name"`
 	SentryDsn                   util.StringSecret   `yaml:"sentry_dsn"`
 	Sources                     []SourceConfig      `yaml:"sources"`
 	SpanChannelCapacity         int                 `yaml:"span_channel_capacity"`
@@ -44,12 +54,19 @@ type Config struct {
 	SsfListenAddresses          []util.Url          `yaml:"ssf_listen_addresses"`
 	StatsAddress                string              `yaml:"stats_address"`
 	StatsdListenAddresses       []util.Url          `yaml:"statsd_listen_addresses"`
+	StatusChecks                StatusCheckConfig   `yaml:"status_checks"`
 	SynchronizeWithInterval     bool                `yaml:"synchronize_with_interval"`
 	Tags                        []string            `yaml:"tags"`
 	TagsExclude                 []string            `yaml:"tags_exclude"`
 	TLSAuthorityCertificate     string              `yaml:"tls_authority_certificate"`
+	TLSAuthorityCertificateFile string              `yaml:"tls_authority_certificate_file"`
 	TLSCertificate              string              `yaml:"tls_certificate"`
+	TLSCertificateFile          string              `yaml:"tls_certificate_file"`
+	TLSClientAuth               string              `yaml:"tls_client_auth"`
+	TLSForward                  bool                `yaml:"tls_forward"`
+	TLSImport                   bool                `yaml:"tls_import"`
 	TLSKey                      util.StringSecret   `yaml:"tls_key"`
+	TLSKeyFile                  string              `yaml:"tls_key_file"`
 	TraceMaxLengthBytes         int                 `yaml:"trace_max_length_bytes"`
 	VeneurMetricsAdditionalTags []string            `yaml:"veneur_metrics_additional_tags"`
 	VeneurMetricsScopes         struct {
@@ -66,6 +83,51 @@ type Features struct {
 	EnableMetricSinkRouting   bool `yaml:"enable_metric_sink_routing"`
 }
 
+// ForwardBufferConfig configures the on-disk buffer that a local Veneur
+// writes metrics to when it fails to forward them to the global tier.
+type ForwardBufferConfig struct {
+	// Directory stores the buffered intervals. The buffer is disabled if
+	// this is empty.
+	Directory string `yaml:"directory"`
+	// MaxSizeBytes and MaxAge cap the buffer. Once either is exceeded, the
+	// oldest intervals are dropped.
+	MaxSizeBytes int64         `yaml:"max_size_bytes"`
+	MaxAge       time.Duration `yaml:"max_age"`
+}
+
+// ReplicaConfig configures an additional global tier that a local Veneur
+// forwards every interval to, as well as forward_address or forward_service.
+// Exactly one of Address or Service is set; Name identifies the replica in
+// Veneur's own metrics, and defaults to Address or Service.
+type ReplicaConfig struct {
+	Address string `yaml:"address"`
+	Name    string `yaml:"name"`
+	Service string `yaml:"service"`
+}
+
+// ThresholdConfig sets the thresholds used by the fraction_below,
+// count_above and apdex aggregates for the histograms and timers that match
+// it. The first matching config applies.
+type ThresholdConfig struct {
+	Match           []matcher.Matcher `yaml:"match"`
+	Threshold       float64           `yaml:"threshold"`
+	ApdexSatisfied  float64           `yaml:"apdex_satisfied"`
+	ApdexTolerating float64           `yaml:"apdex_tolerating"`
+}
+
+// StatusCheckConfig controls how status checks tagged veneurglobalonly are
+// combined across hosts on the global tier.
+type StatusCheckConfig struct {
+	// AggregationPolicy is either "worst" (the default), where the most severe
+	// status reported by any host wins, or "quorum", where the combined check
+	// is only CRITICAL once more than CriticalQuorum of the hosts report
+	// CRITICAL.
+	AggregationPolicy string `yaml:"aggregation_policy"`
+	// CriticalQuorum is the fraction of hosts, between 0 and 1, used by the
+	// "quorum" policy.
+	CriticalQuorum float64 `yaml:"critical_quorum"`
+}
+
 type HttpConfig struct {
 	// Enables /config/json and /config/yaml endpoints for displaying the current
 	// configuration. Entries of type util.StringSecret will be redacted unless
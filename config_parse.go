@@ -20,6 +20,16 @@ var defaultConfig = Config{
 	SpanChannelCapacity: 100,
 }
 
+// The caps applied to the forward buffer when it is enabled.
+const (
+	defaultForwardBufferMaxSizeBytes = 256 * 1048576 // 256 MiB
+	defaultForwardBufferMaxAge       = time.Hour
+)
+
+// How often to query service discovery for the global pool, when forwarding
+// to a forward_service.
+const defaultForwardDiscoveryInterval = 10 * time.Second
+
 var defaultProxyConfig = ProxyConfig{
 	MaxIdleConnsPerHost:          100,
 	TracingClientCapacity:        1024,
@@ -156,6 +166,22 @@ func (c *Config) applyDefaults(logger *logrus.Entry) {
 	if c.Hostname == "" && !c.OmitEmptyHostname {
 		c.Hostname, _ = os.Hostname()
 	}
+	if c.ForwardBuffer.Directory != "" {
+		if c.ForwardBuffer.MaxSizeBytes == 0 {
+			c.ForwardBuffer.MaxSizeBytes = defaultForwardBufferMaxSizeBytes
+		}
+		if c.ForwardBuffer.MaxAge == 0 {
+			c.ForwardBuffer.MaxAge = defaultForwardBufferMaxAge
+		}
+	}
+	discoversReplicas := false
+	for _, replica := range c.ForwardReplicas {
+		discoversReplicas = discoversReplicas || replica.Service != ""
+	}
+	if (c.ForwardService != "" || discoversReplicas) &&
+		c.ForwardDiscoveryInterval == 0 {
+		c.ForwardDiscoveryInterval = defaultForwardDiscoveryInterval
+	}
 	if c.Interval == 0 {
 		c.Interval = defaultConfig.Interval
 	}
@@ -0,0 +1,93 @@
+package discovery
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/v14/discovery/consul"
+	"github.com/stripe/veneur/v14/discovery/dns"
+	"github.com/stripe/veneur/v14/discovery/file"
+	"github.com/stripe/veneur/v14/discovery/kubernetes"
+	"github.com/stripe/veneur/v14/scopedstatsd"
+)
+
+// Config selects the Discoverer used to find the instances of a service, and
+// configures it. Type is one of "consul" (the default), "dns", "file" or
+// "kubernetes".
+type Config struct {
+	Consul struct {
+		Datacenter string        `yaml:"datacenter"`
+		Tags       []string      `yaml:"tags"`
+		WaitTime   time.Duration `yaml:"wait_time"`
+	} `yaml:"consul"`
+	Dns struct {
+		MinTtl  time.Duration `yaml:"min_ttl"`
+		Server  string        `yaml:"server"`
+		Timeout time.Duration `yaml:"timeout"`
+	} `yaml:"dns"`
+	Kubernetes struct {
+		EndpointSlices bool   `yaml:"endpoint_slices"`
+		Kubeconfig     string `yaml:"kubeconfig"`
+		LabelSelector  string `yaml:"label_selector"`
+		Namespace      string `yaml:"namespace"`
+		PortName       string `yaml:"port_name"`
+	} `yaml:"kubernetes"`
+	Type string `yaml:"type"`
+}
+
+// New creates the Discoverer described by config. Its internal metrics are
+// reported to statsd, named with metricPrefix. The returned function stops
+// any watches the Discoverer started, and must be called once it's no longer
+// used.
+func New(
+	config Config, logger *logrus.Entry, statsd scopedstatsd.Client,
+	metricPrefix string,
+) (Discoverer, func(), error) {
+	switch config.Type {
+	case "", "consul":
+		consulDiscoverer, err := consul.NewConsulWithOptions(
+			api.DefaultConfig(), consul.Options{
+				Datacenter:   config.Consul.Datacenter,
+				Tags:         config.Consul.Tags,
+				Watch:        true,
+				WaitTime:     config.Consul.WaitTime,
+				Logger:       logger,
+				MetricPrefix: metricPrefix,
+				Statsd:       statsd,
+			})
+		if err != nil {
+			return nil, nil, err
+		}
+		return consulDiscoverer, consulDiscoverer.Close, nil
+	case "dns":
+		dnsDiscoverer, err := dns.NewDNSDiscoverer(dns.Config{
+			MinTTL:  config.Dns.MinTtl,
+			Server:  config.Dns.Server,
+			Timeout: config.Dns.Timeout,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return dnsDiscoverer, func() {}, nil
+	case "file":
+		fileDiscoverer := file.NewFileDiscoverer(logger)
+		return fileDiscoverer, fileDiscoverer.Close, nil
+	case "kubernetes":
+		watchDiscoverer, err := kubernetes.NewWatchDiscoverer(
+			kubernetes.WatchConfig{
+				EndpointSlices: config.Kubernetes.EndpointSlices,
+				Kubeconfig:     config.Kubernetes.Kubeconfig,
+				LabelSelector:  config.Kubernetes.LabelSelector,
+				Namespace:      config.Kubernetes.Namespace,
+				PortName:       config.Kubernetes.PortName,
+			}, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		return watchDiscoverer, watchDiscoverer.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown discovery type %q", config.Type)
+	}
+}
@@ -1,47 +1,233 @@
 package consul
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/v14/scopedstatsd"
 )
 
+const (
+	// How long to wait before retrying a blocking query that failed, at
+	// first and at most.
+	minRetryInterval = time.Second
+	maxRetryInterval = 30 * time.Second
+)
+
+var errNoHosts = errors.New("received no hosts from Consul")
+
+type Options struct {
+	// The datacenter to discover services in. Defaults to the datacenter of
+	// the Consul agent.
+	Datacenter string
+	// Only instances with all of these tags are discovered.
+	Tags []string
+	// Whether to watch services with blocking queries, rather than querying
+	// Consul each time destinations are requested.
+	Watch bool
+	// How long each blocking query waits for a change. Defaults to Consul's
+	// default of five minutes.
+	WaitTime time.Duration
+
+	Logger *logrus.Entry
+	// The prefix of the gauge reporting the Consul index of each watched
+	// service.
+	MetricPrefix string
+	Statsd       scopedstatsd.Client
+}
+
 // Consul is a Discoverer that uses Consul to find
 // healthy instances of a given name.
 type Consul struct {
 	ConsulHealth *api.Health
+
+	cancel   func()
+	changes  chan struct{}
+	ctx      context.Context
+	mutex    sync.Mutex
+	options  Options
+	services map[string][]string
 }
 
 // NewConsul creates a new instance of a Consul Discoverer
 func NewConsul(config *api.Config) (*Consul, error) {
+	return NewConsulWithOptions(config, Options{})
+}
+
+// NewConsulWithOptions creates a new instance of a Consul Discoverer that
+// filters instances by datacenter and tags, and optionally watches services
+// with blocking queries.
+func NewConsulWithOptions(config *api.Config, options Options) (*Consul, error) {
 	consulClient, err := api.NewClient(config)
 	if err != nil {
 		return nil, err
 	}
+	if options.Logger == nil {
+		options.Logger = logrus.NewEntry(logrus.StandardLogger())
+	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Consul{
 		ConsulHealth: consulClient.Health(),
+		cancel:       cancel,
+		changes:      make(chan struct{}, 1),
+		ctx:          ctx,
+		options:      options,
+		services:     map[string][]string{},
 	}, nil
 }
 
+// Changes is notified whenever the instances of a watched service change.
+func (c *Consul) Changes() <-chan struct{} {
+	return c.changes
+}
+
+// Close stops watching services.
+func (c *Consul) Close() {
+	c.cancel()
+}
+
 // GetDestinationsForService updates the list of destinations based on healthy nodes
 // found via Consul.  It returns destinations in the form "<host>:<port>".
+// When watching, services are queried the first time they are requested, and
+// their last known destinations are returned afterwards.
 func (c *Consul) GetDestinationsForService(serviceName string) ([]string, error) {
-	serviceEntries, _, err := c.ConsulHealth.Service(serviceName, "", true, &api.QueryOptions{})
-	if err != nil {
-		return nil, err
+	if !c.options.Watch {
+		hosts, _, err := c.query(c.ctx, serviceName, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(hosts) < 1 {
+			return nil, errNoHosts
+		}
+		return hosts, nil
 	}
 
-	numHosts := len(serviceEntries)
-	if numHosts < 1 {
-		return nil, errors.New("received no hosts from Consul")
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	hosts, ok := c.services[serviceName]
+	if !ok {
+		var index uint64
+		var err error
+		hosts, index, err = c.query(c.ctx, serviceName, 0)
+		if err != nil {
+			return nil, err
+		}
+		c.services[serviceName] = hosts
+		c.reportIndex(serviceName, index)
+		go c.watch(serviceName, index)
 	}
+	if len(hosts) < 1 {
+		return nil, errNoHosts
+	}
+	return append([]string{}, hosts...), nil
+}
+
+// Queries the healthy instances of a service. If index is set, the query
+// blocks until the service's index is past it, or the wait time expires.
+func (c *Consul) query(
+	ctx context.Context, serviceName string, index uint64,
+) ([]string, uint64, error) {
+	queryOptions := &api.QueryOptions{
+		Datacenter: c.options.Datacenter,
+		WaitIndex:  index,
+		WaitTime:   c.options.WaitTime,
+	}
+	serviceEntries, meta, err := c.ConsulHealth.ServiceMultipleTags(
+		serviceName, c.options.Tags, true, queryOptions.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+
 	// Make a slice to hold our returned hosts
-	hosts := make([]string, numHosts)
+	hosts := make([]string, len(serviceEntries))
 	for index, se := range serviceEntries {
-		hosts[index] = fmt.Sprintf("%s:%d", se.Node.Address, se.Service.Port)
+		// Services registered with their own address are reachable there,
+		// rather than at the address of their node.
+		address := se.Service.Address
+		if address == "" {
+			address = se.Node.Address
+		}
+		hosts[index] = net.JoinHostPort(address, strconv.Itoa(se.Service.Port))
 	}
 
-	return hosts, nil
+	return hosts, meta.LastIndex, nil
+}
+
+// Watches a service with blocking queries until the discoverer is closed,
+// and notifies Changes when its instances change.
+func (c *Consul) watch(serviceName string, index uint64) {
+	logger := c.options.Logger.WithField("service", serviceName)
+	retryInterval := minRetryInterval
+	for {
+		hosts, lastIndex, err := c.query(c.ctx, serviceName, index)
+		if c.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Warn("failed to watch Consul service")
+			select {
+			case <-time.After(retryInterval):
+			case <-c.ctx.Done():
+				return
+			}
+			retryInterval *= 2
+			if retryInterval > maxRetryInterval {
+				retryInterval = maxRetryInterval
+			}
+			continue
+		}
+		retryInterval = minRetryInterval
+
+		if lastIndex == index {
+			// The wait time expired without changes.
+			continue
+		}
+		if lastIndex < index {
+			// The index went backwards, e.g. because Consul's state was
+			// restored. Start over from the new index, without querying
+			// in a tight loop if it keeps happening.
+			logger.Debug("Consul index went backwards")
+			select {
+			case <-time.After(minRetryInterval):
+			case <-c.ctx.Done():
+				return
+			}
+		}
+		// Blocking queries require an index greater than zero.
+		index = lastIndex
+		if index < 1 {
+			index = 1
+		}
+		c.reportIndex(serviceName, lastIndex)
+
+		c.mutex.Lock()
+		changed := !reflect.DeepEqual(c.services[serviceName], hosts)
+		c.services[serviceName] = hosts
+		c.mutex.Unlock()
+		if changed {
+			logger.WithField("hosts", len(hosts)).
+				Debug("Consul service changed")
+			select {
+			case c.changes <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (c *Consul) reportIndex(serviceName string, index uint64) {
+	if c.options.Statsd == nil {
+		return
+	}
+	c.options.Statsd.Gauge(
+		c.options.MetricPrefix+"discoverer.consul_index", float64(index),
+		[]string{"service:" + serviceName}, 1.0)
 }
@@ -1,15 +1,22 @@
 package consul_test
 
 import (
+	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/golang/mock/gomock"
 	"github.com/hashicorp/consul/api"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stripe/veneur/v14/discovery/consul"
+	"github.com/stripe/veneur/v14/scopedstatsd"
 )
 
 type ConsulRoundTripper struct {
@@ -94,3 +101,163 @@ func TestConsulTwoHosts(t *testing.T) {
 		assert.Equal(t, "10.1.10.13:8000", destinations[1])
 	}
 }
+
+// A Consul HTTP server that answers health queries for a single service, and
+// blocks queries for an index until the service changes.
+type FakeConsul struct {
+	changed  chan struct{}
+	entries  []*api.ServiceEntry
+	index    uint64
+	mutex    sync.Mutex
+	requests chan *http.Request
+	server   *httptest.Server
+}
+
+func CreateFakeConsul(t *testing.T, entries []*api.ServiceEntry) *FakeConsul {
+	fakeConsul := &FakeConsul{
+		changed:  make(chan struct{}),
+		entries:  entries,
+		index:    10,
+		requests: make(chan *http.Request, 16),
+	}
+	fakeConsul.server = httptest.NewServer(http.HandlerFunc(fakeConsul.serve))
+	t.Cleanup(fakeConsul.server.Close)
+	return fakeConsul
+}
+
+func (fakeConsul *FakeConsul) serve(
+	writer http.ResponseWriter, request *http.Request,
+) {
+	if request.URL.Path != "/v1/health/service/service-name" {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	select {
+	case fakeConsul.requests <- request:
+	default:
+	}
+
+	fakeConsul.mutex.Lock()
+	waitIndex, _ := strconv.ParseUint(request.URL.Query().Get("index"), 10, 64)
+	if waitIndex >= fakeConsul.index {
+		changed := fakeConsul.changed
+		fakeConsul.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-request.Context().Done():
+			return
+		}
+		fakeConsul.mutex.Lock()
+	}
+	defer fakeConsul.mutex.Unlock()
+
+	writer.Header().Set(
+		"X-Consul-Index", strconv.FormatUint(fakeConsul.index, 10))
+	json.NewEncoder(writer).Encode(fakeConsul.entries)
+}
+
+func (fakeConsul *FakeConsul) Update(entries []*api.ServiceEntry) {
+	fakeConsul.mutex.Lock()
+	defer fakeConsul.mutex.Unlock()
+	fakeConsul.entries = entries
+	fakeConsul.index++
+	close(fakeConsul.changed)
+	fakeConsul.changed = make(chan struct{})
+}
+
+func (fakeConsul *FakeConsul) Config() *api.Config {
+	config := api.DefaultConfig()
+	config.Address = fakeConsul.server.Listener.Addr().String()
+	return config
+}
+
+func serviceEntry(nodeAddress, serviceAddress string) *api.ServiceEntry {
+	return &api.ServiceEntry{
+		Node: &api.Node{Address: nodeAddress},
+		Service: &api.AgentService{
+			Address: serviceAddress,
+			Port:    8128,
+		},
+	}
+}
+
+func TestConsulPrefersServiceAddress(t *testing.T) {
+	fakeConsul := CreateFakeConsul(t, []*api.ServiceEntry{
+		serviceEntry("10.1.10.12", "10.2.0.1"),
+		serviceEntry("10.1.10.13", ""),
+	})
+	client, err := consul.NewConsul(fakeConsul.Config())
+	require.NoError(t, err)
+
+	destinations, err := client.GetDestinationsForService("service-name")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.2.0.1:8128", "10.1.10.13:8128"}, destinations)
+}
+
+func TestConsulTagsAndDatacenter(t *testing.T) {
+	fakeConsul := CreateFakeConsul(t, []*api.ServiceEntry{
+		serviceEntry("10.1.10.12", ""),
+	})
+	client, err := consul.NewConsulWithOptions(
+		fakeConsul.Config(), consul.Options{
+			Datacenter: "us-west-2",
+			Tags:       []string{"global", "grpc"},
+		})
+	require.NoError(t, err)
+
+	_, err = client.GetDestinationsForService("service-name")
+	require.NoError(t, err)
+
+	request := <-fakeConsul.requests
+	assert.Equal(t, "us-west-2", request.URL.Query().Get("dc"))
+	assert.Equal(t, []string{"global", "grpc"}, request.URL.Query()["tag"])
+	assert.Equal(t, "1", request.URL.Query().Get("passing"))
+}
+
+func TestConsulWatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fakeConsul := CreateFakeConsul(t, []*api.ServiceEntry{
+		serviceEntry("10.1.10.12", ""),
+	})
+	mockStatsd := scopedstatsd.NewMockClient(ctrl)
+	client, err := consul.NewConsulWithOptions(
+		fakeConsul.Config(), consul.Options{
+			MetricPrefix: "veneur_proxy.",
+			Statsd:       mockStatsd,
+			Watch:        true,
+		})
+	require.NoError(t, err)
+	defer client.Close()
+
+	mockStatsd.EXPECT().Gauge(
+		"veneur_proxy.discoverer.consul_index", 10.0,
+		[]string{"service:service-name"}, 1.0)
+	destinations, err := client.GetDestinationsForService("service-name")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.1.10.12:8128"}, destinations)
+
+	// Destinations are cached until the service changes.
+	destinations, err = client.GetDestinationsForService("service-name")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.1.10.12:8128"}, destinations)
+
+	// The blocking query returns as soon as the service changes.
+	mockStatsd.EXPECT().Gauge(
+		"veneur_proxy.discoverer.consul_index", 11.0,
+		[]string{"service:service-name"}, 1.0)
+	fakeConsul.Update([]*api.ServiceEntry{
+		serviceEntry("10.1.10.12", ""),
+		serviceEntry("10.1.10.13", ""),
+	})
+	select {
+	case <-client.Changes():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a change notification")
+	}
+
+	destinations, err = client.GetDestinationsForService("service-name")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.1.10.12:8128", "10.1.10.13:8128"}, destinations)
+}
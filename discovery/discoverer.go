@@ -5,3 +5,11 @@ package discovery
 type Discoverer interface {
 	GetDestinationsForService(string) ([]string, error)
 }
+
+// Notifier is implemented by Discoverers that watch for changes. A value is
+// sent on the channel returned by Changes whenever the destinations of a
+// service may have changed, so that they can be discovered again without
+// waiting for the next poll.
+type Notifier interface {
+	Changes() <-chan struct{}
+}
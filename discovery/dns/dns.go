@@ -0,0 +1,381 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+	defaultMinTTL  = time.Second
+	resolvConfPath = "/etc/resolv.conf"
+	// The TTL of answers that never expire.
+	noExpiry = time.Duration(math.MaxInt64)
+)
+
+type Config struct {
+	// The minimum time an answer is cached for, regardless of its TTL.
+	// Defaults to one second.
+	MinTTL time.Duration
+	// The "host:port" address of the DNS server to query. Defaults to the
+	// first nameserver in /etc/resolv.conf.
+	Server string
+	// How long to wait for each query. Defaults to five seconds.
+	Timeout time.Duration
+}
+
+// DNSDiscoverer is a Discoverer that resolves destinations through DNS.
+// Services whose name starts with an underscore, such as
+// "_grpc._tcp.veneur-global.example.com", are resolved to the address and
+// port of each of their SRV records. Other services are "host:port" pairs,
+// and are resolved to the A and AAAA records of the host, with that port.
+//
+// Names are queried as fully qualified; search domains are not applied.
+// Answers are cached until their TTL expires, at which point Changes is
+// notified, so that the service is resolved again.
+type DNSDiscoverer struct {
+	cache   map[string]cacheEntry
+	changes chan struct{}
+	minTTL  time.Duration
+	mutex   sync.Mutex
+	server  string
+	timeout time.Duration
+}
+
+type cacheEntry struct {
+	addresses []string
+	expires   time.Time
+}
+
+// NewDNSDiscoverer creates a new instance of a DNS Discoverer.
+func NewDNSDiscoverer(config Config) (*DNSDiscoverer, error) {
+	server := config.Server
+	if server == "" {
+		var err error
+		server, err = readNameserver(resolvConfPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	minTTL := config.MinTTL
+	if minTTL <= 0 {
+		minTTL = defaultMinTTL
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return &DNSDiscoverer{
+		cache:   map[string]cacheEntry{},
+		changes: make(chan struct{}, 1),
+		minTTL:  minTTL,
+		server:  server,
+		timeout: timeout,
+	}, nil
+}
+
+// Returns the address of the first nameserver in a resolv.conf file.
+func readNameserver(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no nameserver in %s", path)
+}
+
+// Changes is notified whenever a cached answer expires.
+func (d *DNSDiscoverer) Changes() <-chan struct{} {
+	return d.changes
+}
+
+// GetDestinationsForService resolves a service, or returns its cached
+// destinations if their TTL hasn't expired yet. It returns destinations in
+// the form "<host>:<port>".
+func (d *DNSDiscoverer) GetDestinationsForService(
+	serviceName string,
+) ([]string, error) {
+	d.mutex.Lock()
+	entry, ok := d.cache[serviceName]
+	d.mutex.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return append([]string{}, entry.addresses...), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	var addresses []string
+	var ttl time.Duration
+	var err error
+	if strings.HasPrefix(serviceName, "_") {
+		addresses, ttl, err = d.resolveSRV(ctx, serviceName)
+	} else {
+		addresses, ttl, err = d.resolveHost(ctx, serviceName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("received no records for %s", serviceName)
+	}
+	sort.Strings(addresses)
+
+	if ttl < d.minTTL {
+		ttl = d.minTTL
+	}
+	d.mutex.Lock()
+	d.cache[serviceName] = cacheEntry{
+		addresses: addresses,
+		expires:   time.Now().Add(ttl),
+	}
+	d.mutex.Unlock()
+	if ttl != noExpiry {
+		time.AfterFunc(ttl, d.notify)
+	}
+
+	return append([]string{}, addresses...), nil
+}
+
+func (d *DNSDiscoverer) notify() {
+	select {
+	case d.changes <- struct{}{}:
+	default:
+	}
+}
+
+// Resolves the SRV records of a name. Targets are resolved through the
+// additional records of the answer if it has them, and queried otherwise.
+// Returns the smallest TTL of the records involved.
+func (d *DNSDiscoverer) resolveSRV(
+	ctx context.Context, name string,
+) ([]string, time.Duration, error) {
+	answer, err := d.query(ctx, name, dnsmessage.TypeSRV)
+	if err != nil {
+		return nil, 0, err
+	}
+	ttl := noExpiry
+	additional := map[string][]string{}
+	for _, resource := range answer.Additionals {
+		ip := resourceIP(resource)
+		if ip == "" {
+			continue
+		}
+		target := resource.Header.Name.String()
+		additional[target] = append(additional[target], ip)
+		ttl = minTTL(ttl, resource.Header)
+	}
+
+	addresses := []string{}
+	for _, resource := range answer.Answers {
+		srv, ok := resource.Body.(*dnsmessage.SRVResource)
+		if !ok {
+			continue
+		}
+		ttl = minTTL(ttl, resource.Header)
+		port := strconv.Itoa(int(srv.Port))
+		ips, ok := additional[srv.Target.String()]
+		if !ok {
+			var targetTTL time.Duration
+			ips, targetTTL, err = d.resolveIPs(ctx, srv.Target.String())
+			if err != nil {
+				return nil, 0, err
+			}
+			if targetTTL < ttl {
+				ttl = targetTTL
+			}
+		}
+		for _, ip := range ips {
+			addresses = append(addresses, net.JoinHostPort(ip, port))
+		}
+	}
+	return addresses, ttl, nil
+}
+
+// Resolves a "host:port" pair. IP addresses are returned as they are, and
+// never expire.
+func (d *DNSDiscoverer) resolveHost(
+	ctx context.Context, hostPort string,
+) ([]string, time.Duration, error) {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return nil, 0, err
+	}
+	if net.ParseIP(host) != nil {
+		return []string{hostPort}, noExpiry, nil
+	}
+
+	ips, ttl, err := d.resolveIPs(ctx, host)
+	if err != nil {
+		return nil, 0, err
+	}
+	addresses := make([]string, len(ips))
+	for index, ip := range ips {
+		addresses[index] = net.JoinHostPort(ip, port)
+	}
+	return addresses, ttl, nil
+}
+
+// Resolves the A and AAAA records of a host.
+func (d *DNSDiscoverer) resolveIPs(
+	ctx context.Context, host string,
+) ([]string, time.Duration, error) {
+	ttl := noExpiry
+	ips := []string{}
+	for _, recordType := range []dnsmessage.Type{
+		dnsmessage.TypeA, dnsmessage.TypeAAAA,
+	} {
+		answer, err := d.query(ctx, host, recordType)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, resource := range answer.Answers {
+			if ip := resourceIP(resource); ip != "" {
+				ips = append(ips, ip)
+				ttl = minTTL(ttl, resource.Header)
+			}
+		}
+	}
+	return ips, ttl, nil
+}
+
+func resourceIP(resource dnsmessage.Resource) string {
+	switch body := resource.Body.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(body.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		return net.IP(body.AAAA[:]).String()
+	default:
+		return ""
+	}
+}
+
+func minTTL(ttl time.Duration, header dnsmessage.ResourceHeader) time.Duration {
+	recordTTL := time.Duration(header.TTL) * time.Second
+	if recordTTL < ttl {
+		return recordTTL
+	}
+	return ttl
+}
+
+// Queries the server for the records of a name over UDP, and again over TCP
+// if the answer is truncated. A name that doesn't exist has no records.
+func (d *DNSDiscoverer) query(
+	ctx context.Context, name string, recordType dnsmessage.Type,
+) (*dnsmessage.Message, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	questionName, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	id := uint16(rand.Uint32())
+	request, err := (&dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  questionName,
+			Type:  recordType,
+			Class: dnsmessage.ClassINET,
+		}},
+	}).Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	answer, err := d.exchange(ctx, "udp", request)
+	if err == nil && answer.Truncated {
+		answer, err = d.exchange(ctx, "tcp", request)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", name, err)
+	}
+	if answer.ID != id {
+		return nil, fmt.Errorf("failed to query %s: mismatched id", name)
+	}
+	switch answer.RCode {
+	case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+		return answer, nil
+	default:
+		return nil, fmt.Errorf("failed to query %s: %s", name, answer.RCode)
+	}
+}
+
+func (d *DNSDiscoverer) exchange(
+	ctx context.Context, network string, request []byte,
+) (*dnsmessage.Message, error) {
+	var dialer net.Dialer
+	connection, err := dialer.DialContext(ctx, network, d.server)
+	if err != nil {
+		return nil, err
+	}
+	defer connection.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		connection.SetDeadline(deadline)
+	}
+
+	var response []byte
+	if network == "tcp" {
+		// Messages over TCP are prefixed with their length.
+		length := make([]byte, 2)
+		binary.BigEndian.PutUint16(length, uint16(len(request)))
+		_, err = connection.Write(append(length, request...))
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.ReadFull(connection, length)
+		if err != nil {
+			return nil, err
+		}
+		response = make([]byte, binary.BigEndian.Uint16(length))
+		_, err = io.ReadFull(connection, response)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		_, err = connection.Write(request)
+		if err != nil {
+			return nil, err
+		}
+		response = make([]byte, math.MaxUint16)
+		length, err := connection.Read(response)
+		if err != nil {
+			return nil, err
+		}
+		response = response[:length]
+	}
+
+	answer := &dnsmessage.Message{}
+	err = answer.Unpack(response)
+	if err != nil {
+		return nil, errors.New("invalid response")
+	}
+	return answer, nil
+}
@@ -0,0 +1,240 @@
+package dns_test
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14/discovery/dns"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type question struct {
+	name       string
+	recordType dnsmessage.Type
+}
+
+type answer struct {
+	answers     []dnsmessage.Resource
+	additionals []dnsmessage.Resource
+}
+
+// A DNS server that answers queries over UDP from a fixed set of answers, and
+// with NXDOMAIN otherwise.
+type fakeServer struct {
+	answers    map[question]answer
+	connection net.PacketConn
+	queries    int64
+}
+
+func createFakeServer(t *testing.T, answers map[question]answer) *fakeServer {
+	connection, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &fakeServer{
+		answers:    answers,
+		connection: connection,
+	}
+	t.Cleanup(func() {
+		connection.Close()
+	})
+	go server.serve(t)
+	return server
+}
+
+func (server *fakeServer) serve(t *testing.T) {
+	buffer := make([]byte, 512)
+	for {
+		length, address, err := server.connection.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		atomic.AddInt64(&server.queries, 1)
+
+		var request dnsmessage.Message
+		if !assert.NoError(t, request.Unpack(buffer[:length])) {
+			return
+		}
+		response := dnsmessage.Message{
+			Header: dnsmessage.Header{
+				ID:       request.ID,
+				Response: true,
+			},
+			Questions: request.Questions,
+		}
+		answer, ok := server.answers[question{
+			name:       request.Questions[0].Name.String(),
+			recordType: request.Questions[0].Type,
+		}]
+		if ok {
+			response.Answers = answer.answers
+			response.Additionals = answer.additionals
+		} else {
+			response.RCode = dnsmessage.RCodeNameError
+		}
+		packed, err := response.Pack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		server.connection.WriteTo(packed, address)
+	}
+}
+
+func srvRecord(
+	name string, ttl uint32, target string, port uint16,
+) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName(name),
+			Class: dnsmessage.ClassINET,
+			TTL:   ttl,
+		},
+		Body: &dnsmessage.SRVResource{
+			Target: dnsmessage.MustNewName(target),
+			Port:   port,
+		},
+	}
+}
+
+func aRecord(name string, ttl uint32, ip string) dnsmessage.Resource {
+	var a [4]byte
+	copy(a[:], net.ParseIP(ip).To4())
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName(name),
+			Class: dnsmessage.ClassINET,
+			TTL:   ttl,
+		},
+		Body: &dnsmessage.AResource{A: a},
+	}
+}
+
+func TestSRVWithAdditionalRecords(t *testing.T) {
+	server := createFakeServer(t, map[question]answer{{
+		name:       "_grpc._tcp.global.example.com.",
+		recordType: dnsmessage.TypeSRV,
+	}: {
+		answers: []dnsmessage.Resource{
+			srvRecord("_grpc._tcp.global.example.com.", 30, "a.example.com.", 8128),
+			srvRecord("_grpc._tcp.global.example.com.", 30, "b.example.com.", 8129),
+		},
+		additionals: []dnsmessage.Resource{
+			aRecord("a.example.com.", 30, "10.0.0.1"),
+			aRecord("b.example.com.", 30, "10.0.0.2"),
+		},
+	}})
+	discoverer, err := dns.NewDNSDiscoverer(dns.Config{
+		Server: server.connection.LocalAddr().String(),
+	})
+	require.NoError(t, err)
+
+	destinations, err :=
+		discoverer.GetDestinationsForService("_grpc._tcp.global.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:8128", "10.0.0.2:8129"}, destinations)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&server.queries))
+}
+
+func TestSRVWithoutAdditionalRecords(t *testing.T) {
+	server := createFakeServer(t, map[question]answer{{
+		name:       "_grpc._tcp.global.example.com.",
+		recordType: dnsmessage.TypeSRV,
+	}: {
+		answers: []dnsmessage.Resource{
+			srvRecord("_grpc._tcp.global.example.com.", 30, "a.example.com.", 8128),
+		},
+	}, {
+		name:       "a.example.com.",
+		recordType: dnsmessage.TypeA,
+	}: {
+		answers: []dnsmessage.Resource{
+			aRecord("a.example.com.", 30, "10.0.0.1"),
+		},
+	}})
+	discoverer, err := dns.NewDNSDiscoverer(dns.Config{
+		Server: server.connection.LocalAddr().String(),
+	})
+	require.NoError(t, err)
+
+	destinations, err :=
+		discoverer.GetDestinationsForService("_grpc._tcp.global.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:8128"}, destinations)
+}
+
+func TestHostCached(t *testing.T) {
+	server := createFakeServer(t, map[question]answer{{
+		name:       "global.example.com.",
+		recordType: dnsmessage.TypeA,
+	}: {
+		answers: []dnsmessage.Resource{
+			aRecord("global.example.com.", 60, "10.0.0.2"),
+			aRecord("global.example.com.", 60, "10.0.0.1"),
+		},
+	}})
+	discoverer, err := dns.NewDNSDiscoverer(dns.Config{
+		Server: server.connection.LocalAddr().String(),
+	})
+	require.NoError(t, err)
+
+	for index := 0; index < 2; index++ {
+		destinations, err :=
+			discoverer.GetDestinationsForService("global.example.com:8128")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10.0.0.1:8128", "10.0.0.2:8128"}, destinations)
+	}
+	// The A and AAAA queries are only made once, since the TTL hasn't expired.
+	assert.Equal(t, int64(2), atomic.LoadInt64(&server.queries))
+}
+
+func TestTTLExpiryNotifies(t *testing.T) {
+	server := createFakeServer(t, map[question]answer{{
+		name:       "global.example.com.",
+		recordType: dnsmessage.TypeA,
+	}: {
+		answers: []dnsmessage.Resource{
+			aRecord("global.example.com.", 0, "10.0.0.1"),
+		},
+	}})
+	discoverer, err := dns.NewDNSDiscoverer(dns.Config{
+		MinTTL: 10 * time.Millisecond,
+		Server: server.connection.LocalAddr().String(),
+	})
+	require.NoError(t, err)
+
+	_, err = discoverer.GetDestinationsForService("global.example.com:8128")
+	require.NoError(t, err)
+	select {
+	case <-discoverer.Changes():
+	case <-time.After(time.Second):
+		t.Fatal("expected a change notification")
+	}
+
+	_, err = discoverer.GetDestinationsForService("global.example.com:8128")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), atomic.LoadInt64(&server.queries))
+}
+
+func TestNoRecords(t *testing.T) {
+	server := createFakeServer(t, map[question]answer{})
+	discoverer, err := dns.NewDNSDiscoverer(dns.Config{
+		Server: server.connection.LocalAddr().String(),
+	})
+	require.NoError(t, err)
+
+	_, err = discoverer.GetDestinationsForService("_grpc._tcp.missing.example.com")
+	assert.Error(t, err)
+}
+
+func TestIPAddress(t *testing.T) {
+	discoverer, err := dns.NewDNSDiscoverer(dns.Config{
+		Server: "127.0.0.1:0",
+	})
+	require.NoError(t, err)
+
+	destinations, err := discoverer.GetDestinationsForService("10.0.0.1:8128")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:8128"}, destinations)
+}
@@ -0,0 +1,108 @@
+package file
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// FileDiscoverer is a Discoverer that reads destinations from files. The
+// service name is the path of a JSON or YAML file containing a list of
+// "<host>:<port>" addresses. Each file is watched once it has been read, and
+// reloaded when it changes.
+type FileDiscoverer struct {
+	changes chan struct{}
+	closed  chan struct{}
+	files   map[string][]string
+	logger  *logrus.Entry
+	mutex   sync.Mutex
+}
+
+// NewFileDiscoverer creates a new instance of a file Discoverer.
+func NewFileDiscoverer(logger *logrus.Entry) *FileDiscoverer {
+	return &FileDiscoverer{
+		changes: make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+		files:   map[string][]string{},
+		logger:  logger,
+	}
+}
+
+// Changes is notified whenever a watched file is reloaded with different
+// destinations.
+func (f *FileDiscoverer) Changes() <-chan struct{} {
+	return f.changes
+}
+
+// Close stops watching files.
+func (f *FileDiscoverer) Close() {
+	close(f.closed)
+}
+
+// GetDestinationsForService returns the destinations listed in a file, reading
+// and starting to watch it the first time it is requested.
+func (f *FileDiscoverer) GetDestinationsForService(
+	path string,
+) ([]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	addresses, ok := f.files[path]
+	if !ok {
+		var err error
+		addresses, err = readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		err = watch(path, f.closed, func() {
+			f.reload(path)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+		f.files[path] = addresses
+	}
+	return append([]string{}, addresses...), nil
+}
+
+// Reads a file again after it may have changed. If it can't be read, the
+// previous destinations are kept.
+func (f *FileDiscoverer) reload(path string) {
+	addresses, err := readFile(path)
+	if err != nil {
+		f.logger.WithError(err).WithField("path", path).
+			Warn("failed to reload destinations")
+		return
+	}
+
+	f.mutex.Lock()
+	changed := !reflect.DeepEqual(f.files[path], addresses)
+	f.files[path] = addresses
+	f.mutex.Unlock()
+
+	if changed {
+		f.logger.WithField("path", path).Debug("reloaded destinations")
+		select {
+		case f.changes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Reads a list of addresses from a JSON or YAML file.
+func readFile(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	addresses := []string{}
+	err = yaml.UnmarshalStrict(data, &addresses)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return addresses, nil
+}
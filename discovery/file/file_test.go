@@ -0,0 +1,109 @@
+package file_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14/discovery/file"
+)
+
+func TestReadYaml(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "destinations.yaml")
+	require.NoError(t, ioutil.WriteFile(
+		path, []byte("- 10.0.0.1:8128\n- 10.0.0.2:8128\n"), 0644))
+
+	discoverer := file.NewFileDiscoverer(logrus.NewEntry(logrus.New()))
+	defer discoverer.Close()
+
+	destinations, err := discoverer.GetDestinationsForService(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:8128", "10.0.0.2:8128"}, destinations)
+}
+
+func TestReadJson(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "destinations.json")
+	require.NoError(t, ioutil.WriteFile(
+		path, []byte(`["10.0.0.1:8128", "10.0.0.2:8128"]`), 0644))
+
+	discoverer := file.NewFileDiscoverer(logrus.NewEntry(logrus.New()))
+	defer discoverer.Close()
+
+	destinations, err := discoverer.GetDestinationsForService(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:8128", "10.0.0.2:8128"}, destinations)
+}
+
+func TestReadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "destinations.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("address: 10.0.0.1"), 0644))
+
+	discoverer := file.NewFileDiscoverer(logrus.NewEntry(logrus.New()))
+	defer discoverer.Close()
+
+	_, err := discoverer.GetDestinationsForService(path)
+	assert.Error(t, err)
+}
+
+func TestReadMissing(t *testing.T) {
+	discoverer := file.NewFileDiscoverer(logrus.NewEntry(logrus.New()))
+	defer discoverer.Close()
+
+	_, err := discoverer.GetDestinationsForService(
+		filepath.Join(t.TempDir(), "destinations.yaml"))
+	assert.Error(t, err)
+}
+
+func TestReloadOnChange(t *testing.T) {
+	directory := t.TempDir()
+	path := filepath.Join(directory, "destinations.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("- 10.0.0.1:8128\n"), 0644))
+
+	discoverer := file.NewFileDiscoverer(logrus.NewEntry(logrus.New()))
+	defer discoverer.Close()
+
+	destinations, err := discoverer.GetDestinationsForService(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:8128"}, destinations)
+
+	// Replace the file by renaming another file over it.
+	newPath := filepath.Join(directory, "destinations.yaml.new")
+	require.NoError(t, ioutil.WriteFile(
+		newPath, []byte("- 10.0.0.1:8128\n- 10.0.0.2:8128\n"), 0644))
+	require.NoError(t, os.Rename(newPath, path))
+
+	select {
+	case <-discoverer.Changes():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a change notification")
+	}
+	destinations, err = discoverer.GetDestinationsForService(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:8128", "10.0.0.2:8128"}, destinations)
+}
+
+func TestReloadKeepsDestinationsOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "destinations.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("- 10.0.0.1:8128\n"), 0644))
+
+	discoverer := file.NewFileDiscoverer(logrus.NewEntry(logrus.New()))
+	defer discoverer.Close()
+
+	_, err := discoverer.GetDestinationsForService(path)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("{invalid"), 0644))
+	select {
+	case <-discoverer.Changes():
+		t.Fatal("unexpected change notification")
+	case <-time.After(100 * time.Millisecond):
+	}
+	destinations, err := discoverer.GetDestinationsForService(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:8128"}, destinations)
+}
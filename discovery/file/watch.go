@@ -0,0 +1,40 @@
+//go:build !linux
+// +build !linux
+
+package file
+
+import (
+	"os"
+	"time"
+)
+
+// How often files are checked for changes where inotify isn't available.
+const pollInterval = time.Second
+
+// Polls the modification time of a file, and calls changed when it changes.
+func watch(path string, done <-chan struct{}, changed func()) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	modTime := info.ModTime()
+
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				info, err := os.Stat(path)
+				if err != nil || info.ModTime().Equal(modTime) {
+					continue
+				}
+				modTime = info.ModTime()
+				changed()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,45 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// Watches the directory containing a file with inotify, and calls changed
+// after any event in it. Watching the directory rather than the file picks
+// up files that are replaced by renaming another file over them, as
+// Kubernetes does for mounted ConfigMaps.
+func watch(path string, done <-chan struct{}, changed func()) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	_, err = unix.InotifyAddWatch(fd, filepath.Dir(path),
+		unix.IN_CLOSE_WRITE|unix.IN_CREATE|unix.IN_DELETE|unix.IN_MODIFY|
+			unix.IN_MOVED_FROM|unix.IN_MOVED_TO)
+	if err != nil {
+		unix.Close(fd)
+		return err
+	}
+
+	// The file descriptor is non-blocking, so reads from the file use the
+	// runtime's poller, and closing the file interrupts them.
+	events := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-done
+		events.Close()
+	}()
+	go func() {
+		buffer := make([]byte, 4096)
+		for {
+			_, err := events.Read(buffer)
+			if err != nil {
+				return
+			}
+			changed()
+		}
+	}()
+	return nil
+}
@@ -12,6 +12,10 @@ import (
 	"k8s.io/client-go/rest"
 )
 
+// KubernetesDiscoverer is a Discoverer that lists the running pods labeled
+// "app=veneur-global" in every namespace, regardless of the service name.
+//
+// Deprecated: Use WatchDiscoverer, which watches the endpoints of a service.
 type KubernetesDiscoverer struct {
 	clientset *kubernetes.Clientset
 	Logger    *logrus.Entry
@@ -0,0 +1,326 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	defaultNamespace = "default"
+	// How long to wait for the initial list of a service's endpoints.
+	syncTimeout = 10 * time.Second
+)
+
+type WatchConfig struct {
+	// Whether to watch EndpointSlices rather than Endpoints.
+	EndpointSlices bool
+	// The path of a kubeconfig file, to run outside of the cluster. If empty,
+	// the in-cluster config is used.
+	Kubeconfig string
+	// Only endpoints whose labels match this selector are discovered.
+	LabelSelector string
+	// The namespace of services that aren't given as "<namespace>/<name>".
+	// Defaults to "default".
+	Namespace string
+	// The name of the port to forward to. May be left empty if endpoints have
+	// a single port.
+	PortName string
+}
+
+// WatchDiscoverer is a Discoverer that watches the endpoints of Kubernetes
+// services. The service name is the name of a Kubernetes Service, optionally
+// prefixed with its namespace as "<namespace>/<name>". Each service is
+// watched once it has been requested, and Changes is notified as soon as its
+// endpoints change.
+type WatchDiscoverer struct {
+	changes   chan struct{}
+	clientset kubernetes.Interface
+	config    WatchConfig
+	logger    *logrus.Entry
+	mutex     sync.Mutex
+	services  map[string]watchedService
+}
+
+type watchedService struct {
+	cancel func()
+	store  cache.Store
+}
+
+// NewWatchDiscoverer creates a new instance of a watch-based Kubernetes
+// Discoverer, using the in-cluster config or the configured kubeconfig.
+func NewWatchDiscoverer(
+	config WatchConfig, logger *logrus.Entry,
+) (*WatchDiscoverer, error) {
+	var restConfig *rest.Config
+	var err error
+	if config.Kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", config.Kubeconfig)
+	} else {
+		restConfig, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return NewWatchDiscovererForClientset(clientset, config, logger), nil
+}
+
+// NewWatchDiscovererForClientset creates a new instance of a watch-based
+// Kubernetes Discoverer that uses the provided clientset.
+func NewWatchDiscovererForClientset(
+	clientset kubernetes.Interface, config WatchConfig, logger *logrus.Entry,
+) *WatchDiscoverer {
+	if config.Namespace == "" {
+		config.Namespace = defaultNamespace
+	}
+	return &WatchDiscoverer{
+		changes:   make(chan struct{}, 1),
+		clientset: clientset,
+		config:    config,
+		logger:    logger,
+		services:  map[string]watchedService{},
+	}
+}
+
+// Changes is notified whenever the endpoints of a watched service change.
+func (wd *WatchDiscoverer) Changes() <-chan struct{} {
+	return wd.changes
+}
+
+// Close stops watching services.
+func (wd *WatchDiscoverer) Close() {
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+	for _, service := range wd.services {
+		service.cancel()
+	}
+}
+
+// GetDestinationsForService returns the ready endpoints of a service, starting
+// to watch it the first time it is requested. It returns destinations in the
+// form "<ip>:<port>".
+func (wd *WatchDiscoverer) GetDestinationsForService(
+	serviceName string,
+) ([]string, error) {
+	namespace, name := wd.config.Namespace, serviceName
+	if index := strings.Index(serviceName, "/"); index >= 0 {
+		namespace, name = serviceName[:index], serviceName[index+1:]
+	}
+
+	wd.mutex.Lock()
+	service, ok := wd.services[serviceName]
+	if !ok {
+		var err error
+		service, err = wd.watch(namespace, name)
+		if err != nil {
+			wd.mutex.Unlock()
+			return nil, err
+		}
+		wd.services[serviceName] = service
+	}
+	wd.mutex.Unlock()
+
+	addresses := map[string]struct{}{}
+	for _, object := range service.store.List() {
+		switch object := object.(type) {
+		case *v1.Endpoints:
+			// Endpoints are filtered by name on the server, but not by fake
+			// clientsets.
+			if object.Name == name {
+				wd.endpointsAddresses(object, addresses)
+			}
+		case *discoveryv1beta1.EndpointSlice:
+			wd.endpointSliceAddresses(object, addresses)
+		}
+	}
+
+	destinations := make([]string, 0, len(addresses))
+	for address := range addresses {
+		destinations = append(destinations, address)
+	}
+	sort.Strings(destinations)
+	return destinations, nil
+}
+
+// Starts an informer for the Endpoints or EndpointSlices of a service, and
+// waits for its initial list.
+func (wd *WatchDiscoverer) watch(
+	namespace, name string,
+) (watchedService, error) {
+	var listWatch *cache.ListWatch
+	var objectType runtime.Object
+	if wd.config.EndpointSlices {
+		selector := discoveryv1beta1.LabelServiceName + "=" + name
+		if wd.config.LabelSelector != "" {
+			selector += "," + wd.config.LabelSelector
+		}
+		_, err := labels.Parse(selector)
+		if err != nil {
+			return watchedService{}, err
+		}
+		client := wd.clientset.DiscoveryV1beta1().EndpointSlices(namespace)
+		listWatch = &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.LabelSelector = selector
+				return client.List(context.Background(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.LabelSelector = selector
+				return client.Watch(context.Background(), options)
+			},
+		}
+		objectType = &discoveryv1beta1.EndpointSlice{}
+	} else {
+		_, err := labels.Parse(wd.config.LabelSelector)
+		if err != nil {
+			return watchedService{}, err
+		}
+		fieldSelector := fields.OneTermEqualSelector("metadata.name", name).String()
+		client := wd.clientset.CoreV1().Endpoints(namespace)
+		listWatch = &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = fieldSelector
+				options.LabelSelector = wd.config.LabelSelector
+				return client.List(context.Background(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = fieldSelector
+				options.LabelSelector = wd.config.LabelSelector
+				return client.Watch(context.Background(), options)
+			},
+		}
+		objectType = &v1.Endpoints{}
+	}
+
+	store, controller := cache.NewInformer(
+		listWatch, objectType, 0, cache.ResourceEventHandlerFuncs{
+			AddFunc: func(interface{}) {
+				wd.notify()
+			},
+			UpdateFunc: func(interface{}, interface{}) {
+				wd.notify()
+			},
+			DeleteFunc: func(interface{}) {
+				wd.notify()
+			},
+		})
+	watchContext, watchCancel := context.WithCancel(context.Background())
+	go controller.Run(watchContext.Done())
+
+	syncContext, syncCancel := context.WithTimeout(watchContext, syncTimeout)
+	defer syncCancel()
+	if !cache.WaitForCacheSync(syncContext.Done(), controller.HasSynced) {
+		watchCancel()
+		return watchedService{}, fmt.Errorf(
+			"timed out listing endpoints of %s/%s", namespace, name)
+	}
+	wd.logger.WithField("service", namespace+"/"+name).
+		Debug("watching endpoints")
+	return watchedService{cancel: watchCancel, store: store}, nil
+}
+
+func (wd *WatchDiscoverer) notify() {
+	select {
+	case wd.changes <- struct{}{}:
+	default:
+	}
+}
+
+func (wd *WatchDiscoverer) endpointsAddresses(
+	endpoints *v1.Endpoints, addresses map[string]struct{},
+) {
+	for _, subset := range endpoints.Subsets {
+		ports := make([]portName, len(subset.Ports))
+		for index, port := range subset.Ports {
+			ports[index] = portName{name: port.Name, port: port.Port}
+		}
+		port, err := wd.choosePort(ports)
+		if err != nil {
+			wd.logger.WithError(err).WithField("endpoints", endpoints.Name).
+				Warn("skipping endpoints")
+			continue
+		}
+		for _, address := range subset.Addresses {
+			addresses[net.JoinHostPort(address.IP, port)] = struct{}{}
+		}
+	}
+}
+
+func (wd *WatchDiscoverer) endpointSliceAddresses(
+	slice *discoveryv1beta1.EndpointSlice, addresses map[string]struct{},
+) {
+	if slice.AddressType != discoveryv1beta1.AddressTypeIPv4 &&
+		slice.AddressType != discoveryv1beta1.AddressTypeIPv6 {
+		return
+	}
+	ports := []portName{}
+	for _, port := range slice.Ports {
+		if port.Port == nil {
+			continue
+		}
+		name := ""
+		if port.Name != nil {
+			name = *port.Name
+		}
+		ports = append(ports, portName{name: name, port: *port.Port})
+	}
+	port, err := wd.choosePort(ports)
+	if err != nil {
+		wd.logger.WithError(err).WithField("endpoint_slice", slice.Name).
+			Warn("skipping endpoint slice")
+		return
+	}
+	for _, endpoint := range slice.Endpoints {
+		// Endpoints whose readiness is unknown are considered ready.
+		if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+			continue
+		}
+		for _, address := range endpoint.Addresses {
+			addresses[net.JoinHostPort(address, port)] = struct{}{}
+		}
+	}
+}
+
+type portName struct {
+	name string
+	port int32
+}
+
+// Returns the configured port, or the only port if none is configured.
+func (wd *WatchDiscoverer) choosePort(ports []portName) (string, error) {
+	if wd.config.PortName == "" {
+		if len(ports) != 1 {
+			return "", errors.New("port_name is required for multiple ports")
+		}
+		return strconv.Itoa(int(ports[0].port)), nil
+	}
+	for _, port := range ports {
+		if port.name == wd.config.PortName {
+			return strconv.Itoa(int(port.port)), nil
+		}
+	}
+	return "", fmt.Errorf("no port named %q", wd.config.PortName)
+}
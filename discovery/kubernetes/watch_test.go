@@ -0,0 +1,205 @@
+package kubernetes_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14/discovery/kubernetes"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func endpoints(
+	namespace, name string, labels map[string]string, ips ...string,
+) *v1.Endpoints {
+	addresses := make([]v1.EndpointAddress, len(ips))
+	for index, ip := range ips {
+		addresses[index] = v1.EndpointAddress{IP: ip}
+	}
+	return &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Subsets: []v1.EndpointSubset{{
+			Addresses: addresses,
+			NotReadyAddresses: []v1.EndpointAddress{{
+				IP: "10.0.0.100",
+			}},
+			Ports: []v1.EndpointPort{{
+				Name: "grpc",
+				Port: 8128,
+			}, {
+				Name: "http",
+				Port: 8127,
+			}},
+		}},
+	}
+}
+
+// Discards the notification for the initial list of endpoints.
+func drainChanges(discoverer *kubernetes.WatchDiscoverer) {
+	select {
+	case <-discoverer.Changes():
+	default:
+	}
+}
+
+func waitForChange(t *testing.T, discoverer *kubernetes.WatchDiscoverer) {
+	select {
+	case <-discoverer.Changes():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a change notification")
+	}
+}
+
+func TestWatchEndpoints(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		endpoints("veneur", "veneur-global", nil, "10.0.0.1", "10.0.0.2"),
+		endpoints("veneur", "other-service", nil, "10.0.0.3"))
+	discoverer := kubernetes.NewWatchDiscovererForClientset(
+		clientset, kubernetes.WatchConfig{
+			Namespace: "veneur",
+			PortName:  "grpc",
+		}, logrus.NewEntry(logrus.New()))
+	defer discoverer.Close()
+
+	destinations, err := discoverer.GetDestinationsForService("veneur-global")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:8128", "10.0.0.2:8128"}, destinations)
+
+	drainChanges(discoverer)
+	_, err = clientset.CoreV1().Endpoints("veneur").Update(
+		context.Background(),
+		endpoints("veneur", "veneur-global", nil, "10.0.0.1", "10.0.0.4"),
+		metav1.UpdateOptions{})
+	require.NoError(t, err)
+	waitForChange(t, discoverer)
+
+	destinations, err = discoverer.GetDestinationsForService("veneur-global")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:8128", "10.0.0.4:8128"}, destinations)
+}
+
+func TestWatchEndpointsNamespacedService(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		endpoints("default", "veneur-global", nil, "10.0.0.1"),
+		endpoints("veneur", "veneur-global", nil, "10.0.0.2"))
+	discoverer := kubernetes.NewWatchDiscovererForClientset(
+		clientset, kubernetes.WatchConfig{
+			PortName: "http",
+		}, logrus.NewEntry(logrus.New()))
+	defer discoverer.Close()
+
+	destinations, err :=
+		discoverer.GetDestinationsForService("veneur/veneur-global")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.2:8127"}, destinations)
+
+	destinations, err = discoverer.GetDestinationsForService("veneur-global")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:8127"}, destinations)
+}
+
+func TestWatchEndpointsLabelSelector(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		endpoints("default", "veneur-global", map[string]string{
+			"tier": "canary",
+		}, "10.0.0.1"))
+	discoverer := kubernetes.NewWatchDiscovererForClientset(
+		clientset, kubernetes.WatchConfig{
+			LabelSelector: "tier=stable",
+			PortName:      "grpc",
+		}, logrus.NewEntry(logrus.New()))
+	defer discoverer.Close()
+
+	destinations, err := discoverer.GetDestinationsForService("veneur-global")
+	require.NoError(t, err)
+	assert.Empty(t, destinations)
+}
+
+func TestWatchEndpointsAmbiguousPort(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		endpoints("default", "veneur-global", nil, "10.0.0.1"))
+	discoverer := kubernetes.NewWatchDiscovererForClientset(
+		clientset, kubernetes.WatchConfig{}, logrus.NewEntry(logrus.New()))
+	defer discoverer.Close()
+
+	// Without a port name, endpoints with several ports are skipped.
+	destinations, err := discoverer.GetDestinationsForService("veneur-global")
+	require.NoError(t, err)
+	assert.Empty(t, destinations)
+}
+
+func TestWatchEndpointSlices(t *testing.T) {
+	portName := "grpc"
+	port := int32(8128)
+	ready := true
+	notReady := false
+	clientset := fake.NewSimpleClientset(&discoveryv1beta1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "veneur-global-abcde",
+			Namespace: "default",
+			Labels: map[string]string{
+				discoveryv1beta1.LabelServiceName: "veneur-global",
+			},
+		},
+		AddressType: discoveryv1beta1.AddressTypeIPv4,
+		Endpoints: []discoveryv1beta1.Endpoint{{
+			Addresses:  []string{"10.0.0.1"},
+			Conditions: discoveryv1beta1.EndpointConditions{Ready: &ready},
+		}, {
+			Addresses:  []string{"10.0.0.2"},
+			Conditions: discoveryv1beta1.EndpointConditions{Ready: &notReady},
+		}, {
+			Addresses: []string{"10.0.0.3"},
+		}},
+		Ports: []discoveryv1beta1.EndpointPort{{
+			Name: &portName,
+			Port: &port,
+		}},
+	}, &discoveryv1beta1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "other-service-abcde",
+			Namespace: "default",
+			Labels: map[string]string{
+				discoveryv1beta1.LabelServiceName: "other-service",
+			},
+		},
+		AddressType: discoveryv1beta1.AddressTypeIPv4,
+		Endpoints: []discoveryv1beta1.Endpoint{{
+			Addresses: []string{"10.0.0.4"},
+		}},
+		Ports: []discoveryv1beta1.EndpointPort{{
+			Name: &portName,
+			Port: &port,
+		}},
+	})
+	discoverer := kubernetes.NewWatchDiscovererForClientset(
+		clientset, kubernetes.WatchConfig{
+			EndpointSlices: true,
+			PortName:       "grpc",
+		}, logrus.NewEntry(logrus.New()))
+	defer discoverer.Close()
+
+	destinations, err := discoverer.GetDestinationsForService("veneur-global")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:8128", "10.0.0.3:8128"}, destinations)
+
+	drainChanges(discoverer)
+	err = clientset.DiscoveryV1beta1().EndpointSlices("default").Delete(
+		context.Background(), "veneur-global-abcde", metav1.DeleteOptions{})
+	require.NoError(t, err)
+	waitForChange(t, discoverer)
+
+	destinations, err = discoverer.GetDestinationsForService("veneur-global")
+	require.NoError(t, err)
+	assert.Empty(t, destinations)
+}
@@ -3,8 +3,10 @@ package veneur
 import (
 	"context"
 	"fmt"
+	"io"
 	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -12,16 +14,30 @@ import (
 
 	"github.com/axiomhq/hyperloglog"
 	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/v14/forwardbuffer"
 	"github.com/stripe/veneur/v14/forwardrpc"
+	"github.com/stripe/veneur/v14/proxy/connect"
+	"github.com/stripe/veneur/v14/proxy/destinations"
 	"github.com/stripe/veneur/v14/samplers"
 	"github.com/stripe/veneur/v14/samplers/metricpb"
 	"github.com/stripe/veneur/v14/sinks"
 	"github.com/stripe/veneur/v14/ssf"
 	"github.com/stripe/veneur/v14/trace"
 	"github.com/stripe/veneur/v14/util/matcher"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+const (
+	// forwardBatchSize bounds the number of metrics sent in each batch over
+	// SendMetricsV3.
+	forwardBatchSize = 1000
+	// forwardAttempts bounds the number of times a metric that the upstream
+	// Veneur dropped is forwarded during a flush.
+	forwardAttempts = 3
+)
+
 // Flush collects sampler's metrics and passes them to sinks.
 func (s *Server) Flush(ctx context.Context) {
 	span := tracer.StartSpan("flush").(*trace.Span)
@@ -43,11 +59,23 @@ func (s *Server) Flush(ctx context.Context) {
 		s.Statsd.Count("flush.unique_timeseries_total", s.tallyTimeseries(), []string{fmt.Sprintf("global_veneur:%t", !s.IsLocal())}, 1.0)
 	}
 
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
 	samples := s.EventWorker.Flush()
 
-	// TODO Concurrency
-	for _, sink := range s.metricSinks {
-		sink.sink.FlushOtherSamples(span.Attach(ctx), samples)
+	if s.forwardEvents {
+		// Events and service checks are flushed by the global tier's sinks.
+		wg.Add(1)
+		go func() {
+			s.forwardSamples(span.Attach(ctx), samples)
+			wg.Done()
+		}()
+	} else {
+		// TODO Concurrency
+		for _, sink := range s.metricSinks {
+			sink.sink.FlushOtherSamples(span.Attach(ctx), samples)
+		}
 	}
 
 	go s.flushTraces(span.Attach(ctx))
@@ -75,9 +103,6 @@ func (s *Server) Flush(ctx context.Context) {
 
 	s.reportMetricsFlushCounts(ms)
 
-	wg := sync.WaitGroup{}
-	defer wg.Wait()
-
 	if s.IsLocal() {
 		wg.Add(1)
 		go func() {
@@ -87,6 +112,8 @@ func (s *Server) Flush(ctx context.Context) {
 	} else {
 		s.reportGlobalMetricsFlushCounts(ms)
 		s.reportGlobalReceivedProtocolMetrics()
+		finalMetrics = append(finalMetrics,
+			s.generateReplayedInterMetrics(span.Attach(ctx), percentiles, aggregates)...)
 	}
 
 	// Return early if there's nothing to flush.
@@ -228,10 +255,11 @@ type metricsSummary struct {
 	totalSets       int
 	totalTimers     int
 
-	totalGlobalCounters   int
-	totalGlobalGauges     int
-	totalGlobalHistograms int
-	totalGlobalTimers     int
+	totalGlobalCounters     int
+	totalGlobalGauges       int
+	totalGlobalHistograms   int
+	totalGlobalTimers       int
+	totalGlobalStatusChecks int
 
 	totalLocalHistograms   int
 	totalLocalSets         int
@@ -269,6 +297,7 @@ func (s *Server) tallyMetrics(percentiles []float64) ([]WorkerMetrics, metricsSu
 		ms.totalGlobalGauges += len(wm.globalGauges)
 		ms.totalGlobalHistograms += len(wm.globalHistograms)
 		ms.totalGlobalTimers += len(wm.globalTimers)
+		ms.totalGlobalStatusChecks += len(wm.globalStatusChecks)
 
 		ms.totalLocalHistograms += len(wm.localHistograms)
 		ms.totalLocalSets += len(wm.localSets)
@@ -295,6 +324,7 @@ func (s *Server) tallyMetrics(percentiles []float64) ([]WorkerMetrics, metricsSu
 		ms.totalLength += ms.totalGlobalGauges
 		ms.totalLength += ms.totalGlobalHistograms * (s.HistogramAggregates.Count + len(s.HistogramPercentiles))
 		ms.totalLength += ms.totalGlobalTimers * (s.HistogramAggregates.Count + len(s.HistogramPercentiles))
+		ms.totalLength += ms.totalGlobalStatusChecks
 	}
 
 	return tempMetrics, ms
@@ -308,6 +338,12 @@ func (s *Server) generateInterMetrics(ctx context.Context, percentiles []float64
 	span, _ := trace.StartSpanFromContext(ctx, "")
 	defer span.ClientFinish(s.TraceClient)
 
+	// the standard deviation and variance of mixed scope histograms are only
+	// reported by the global veneur, from the samples merged across every
+	// host, rather than by each veneur from only the samples it received
+	mixedAggregates := s.HistogramAggregates
+	mixedAggregates.Value &^= samplers.AggregateStdDev | samplers.AggregateVariance
+
 	finalMetrics := make([]samplers.InterMetric, 0, ms.totalLength)
 	for _, wm := range tempMetrics {
 		for _, c := range wm.counters {
@@ -321,10 +357,22 @@ func (s *Server) generateInterMetrics(ctx context.Context, percentiles []float64
 		//
 		// if we're a global veneur, aggregates will be nil.
 		for _, h := range wm.histograms {
-			finalMetrics = append(finalMetrics, h.Flush(s.Interval, percentiles, s.HistogramAggregates, false)...)
+			finalMetrics = append(finalMetrics, h.Flush(s.Interval, percentiles, mixedAggregates, false)...)
 		}
 		for _, t := range wm.timers {
-			finalMetrics = append(finalMetrics, t.Flush(s.Interval, percentiles, s.HistogramAggregates, false)...)
+			finalMetrics = append(finalMetrics, t.Flush(s.Interval, percentiles, mixedAggregates, false)...)
+		}
+		// like percentiles, threshold aggregates of mixed scope histograms
+		// are only accurate once aggregated globally
+		if !s.IsLocal() {
+			for _, h := range wm.histograms {
+				finalMetrics = append(finalMetrics, h.FlushMergedVariance(s.HistogramAggregates)...)
+				finalMetrics = append(finalMetrics, s.flushThresholds(h)...)
+			}
+			for _, t := range wm.timers {
+				finalMetrics = append(finalMetrics, t.FlushMergedVariance(s.HistogramAggregates)...)
+				finalMetrics = append(finalMetrics, s.flushThresholds(t)...)
+			}
 		}
 
 		// local-only samplers should be flushed in their entirety, since they
@@ -333,12 +381,14 @@ func (s *Server) generateInterMetrics(ctx context.Context, percentiles []float64
 		// we use the original percentile list when flushing them
 		for _, h := range wm.localHistograms {
 			finalMetrics = append(finalMetrics, h.Flush(s.Interval, s.HistogramPercentiles, s.HistogramAggregates, false)...)
+			finalMetrics = append(finalMetrics, s.flushThresholds(h)...)
 		}
 		for _, s := range wm.localSets {
 			finalMetrics = append(finalMetrics, s.Flush()...)
 		}
 		for _, t := range wm.localTimers {
 			finalMetrics = append(finalMetrics, t.Flush(s.Interval, s.HistogramPercentiles, s.HistogramAggregates, false)...)
+			finalMetrics = append(finalMetrics, s.flushThresholds(t)...)
 		}
 
 		for _, status := range wm.localStatusChecks {
@@ -368,9 +418,17 @@ func (s *Server) generateInterMetrics(ctx context.Context, percentiles []float64
 
 			for _, h := range wm.globalHistograms {
 				finalMetrics = append(finalMetrics, h.Flush(s.Interval, s.HistogramPercentiles, s.HistogramAggregates, true)...)
+				finalMetrics = append(finalMetrics, s.flushThresholds(h)...)
 			}
 			for _, h := range wm.globalTimers {
 				finalMetrics = append(finalMetrics, h.Flush(s.Interval, s.HistogramPercentiles, s.HistogramAggregates, true)...)
+				finalMetrics = append(finalMetrics, s.flushThresholds(h)...)
+			}
+
+			// global status checks are combined from every host that
+			// reported them into a single fleet-level check
+			for _, status := range wm.globalStatusChecks {
+				finalMetrics = append(finalMetrics, status.FlushGlobal(s.StatusAggregation)...)
 			}
 		}
 	}
@@ -378,8 +436,66 @@ func (s *Server) generateInterMetrics(ctx context.Context, percentiles []float64
 	return finalMetrics
 }
 
+// generateReplayedInterMetrics generates the InterMetrics for the intervals
+// that local Veneurs replayed from their forward buffers since the last flush.
+// Each interval is flushed on its own and timestamped with the time it was
+// originally flushed at, so it's never merged into the current interval.
+func (s *Server) generateReplayedInterMetrics(ctx context.Context, percentiles []float64, aggregates samplers.HistogramAggregates) []samplers.InterMetric {
+	intervals := map[int64][]WorkerMetrics{}
+	for _, w := range s.Workers {
+		for timestamp, wm := range w.FlushReplayed() {
+			intervals[timestamp] = append(intervals[timestamp], wm)
+		}
+	}
+	if len(intervals) == 0 {
+		return nil
+	}
+
+	timestamps := make([]int64, 0, len(intervals))
+	for timestamp := range intervals {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+
+	var finalMetrics []samplers.InterMetric
+	for _, timestamp := range timestamps {
+		metrics := s.generateInterMetrics(ctx, percentiles, aggregates, intervals[timestamp], metricsSummary{})
+		for i := range metrics {
+			metrics[i].Timestamp = timestamp
+		}
+		finalMetrics = append(finalMetrics, metrics...)
+	}
+	s.Statsd.Count("flush.replayed_intervals_total", int64(len(timestamps)), nil, 1.0)
+	return finalMetrics
+}
+
+// flushThresholds generates the threshold aggregates of a histogram or timer
+// using the first histogram_thresholds config that matches it. It generates
+// nothing if no threshold aggregates are enabled or no config matches.
+func (s *Server) flushThresholds(h *samplers.Histo) []samplers.InterMetric {
+	if s.HistogramAggregates.Value&samplers.ThresholdAggregates == 0 {
+		return nil
+	}
+	for _, config := range s.Config.HistogramThresholds {
+		if matcher.Match(config.Match, h.Name, h.Tags) {
+			return h.FlushThresholds(s.HistogramAggregates, samplers.HistogramThresholds{
+				Threshold:       config.Threshold,
+				ApdexSatisfied:  config.ApdexSatisfied,
+				ApdexTolerating: config.ApdexTolerating,
+			})
+		}
+	}
+	return nil
+}
+
 const flushTotalMetric = "worker.metrics_flushed_total"
 
+// forwardSendBufferSize is the number of metrics that can be queued for each
+// global instance of forward_service.
+const forwardSendBufferSize = 1024
+
 // reportMetricsFlushCounts reports the counts of
 // Counters, Gauges, LocalHistograms, LocalSets, and LocalTimers
 // as metrics. These are shared by both global and local flush operations.
@@ -411,6 +527,7 @@ func (s *Server) reportGlobalMetricsFlushCounts(ms metricsSummary) {
 	s.Statsd.Count(flushTotalMetric, int64(ms.totalGlobalGauges), []string{"metric_type:global_gauge"}, 1.0)
 	s.Statsd.Count(flushTotalMetric, int64(ms.totalGlobalHistograms), []string{"metric_type:global_histogram"}, 1.0)
 	s.Statsd.Count(flushTotalMetric, int64(ms.totalGlobalTimers), []string{"metric_type:global_timers"}, 1.0)
+	s.Statsd.Count(flushTotalMetric, int64(ms.totalGlobalStatusChecks), []string{"metric_type:global_status"}, 1.0)
 	s.Statsd.Count(flushTotalMetric, int64(ms.totalHistograms), []string{"metric_type:histogram"}, 1.0)
 	s.Statsd.Count(flushTotalMetric, int64(ms.totalSets), []string{"metric_type:set"}, 1.0)
 	s.Statsd.Count(flushTotalMetric, int64(ms.totalTimers), []string{"metric_type:timer"}, 1.0)
@@ -501,6 +618,24 @@ func (s *Server) forward(
 
 	if len(metrics) == 0 {
 		s.logger.Debug("Nothing to forward, skipping.")
+		if s.forwardBuffer != nil {
+			s.replayForwardBuffer(ctx, span, s.forwardSend(span))
+		}
+		return
+	}
+
+	if len(s.forwardReplicas) > 0 {
+		wg := sync.WaitGroup{}
+		defer wg.Wait()
+		wg.Add(1)
+		go func() {
+			s.forwardToReplicas(ctx, span, metrics)
+			wg.Done()
+		}()
+	}
+
+	if s.forwardDestinations != nil {
+		s.forwardSharded(ctx, span, exportStart, metrics)
 		return
 	}
 
@@ -510,10 +645,8 @@ func (s *Server) forward(
 		"grpcstate":   s.grpcForwardConn.GetState().String(),
 	})
 
-	c := forwardrpc.NewForwardClient(s.grpcForwardConn)
-
 	grpcStart := time.Now()
-	err := forwardGrpc(ctx, c, metrics)
+	failed, err := forwardMetrics(ctx, span, nil, s.grpcForwardConn, metrics)
 	if err != nil {
 		if ctx.Err() != nil {
 			// We exceeded the deadline of the flush context.
@@ -528,8 +661,21 @@ func (s *Server) forward(
 			span.Add(ssf.Count("forward.error_total", 1, map[string]string{"cause": "send"}))
 			entry.WithError(err).Error("Failed to forward to an upstream Veneur")
 		}
+		if s.forwardBuffer != nil {
+			s.bufferForward(span, exportStart, failed)
+		}
 	} else {
+		if len(failed) > 0 {
+			entry.WithField("dropped", len(failed)).
+				Warn("Upstream Veneur dropped forwarded metrics")
+			if s.forwardBuffer != nil {
+				s.bufferForward(span, exportStart, failed)
+			}
+		}
 		entry.Info("Completed forward to an upstream Veneur")
+		if s.forwardBuffer != nil {
+			s.replayForwardBuffer(ctx, span, s.forwardSend(span))
+		}
 	}
 
 	span.Add(
@@ -539,9 +685,360 @@ func (s *Server) forward(
 	)
 }
 
+// forwardSharded forwards each metric to the global instance of
+// forward_service that its key hashes to.
+func (s *Server) forwardSharded(
+	ctx context.Context, span *trace.Span, exportStart time.Time,
+	metrics []*metricpb.Metric,
+) {
+	entry := s.logger.WithFields(logrus.Fields{
+		"metrics":      len(metrics),
+		"service":      s.forwardService,
+		"destinations": s.forwardDestinations.Size(),
+	})
+	span.Add(ssf.Gauge("forward.destinations_total",
+		float32(s.forwardDestinations.Size()), nil))
+
+	grpcStart := time.Now()
+	failed, err := sendSharded(ctx, s.forwardDestinations, metrics)
+	if err != nil {
+		if ctx.Err() != nil {
+			// We exceeded the deadline of the flush context.
+			span.Add(ssf.Count("forward.error_total", 1, map[string]string{"cause": "deadline_exceeded"}))
+		} else {
+			span.Add(ssf.Count("forward.error_total", 1, map[string]string{"cause": "send"}))
+			entry.WithError(err).WithField("failed", len(failed)).
+				Error("Failed to forward to upstream Veneurs")
+		}
+		if s.forwardBuffer != nil {
+			s.bufferForward(span, exportStart, failed)
+		}
+	} else {
+		entry.Info("Completed forward to upstream Veneurs")
+		if s.forwardBuffer != nil {
+			s.replayForwardBuffer(ctx, span, s.forwardSend(span))
+		}
+	}
+
+	span.Add(
+		ssf.Timing("forward.duration_ns", time.Since(grpcStart), time.Nanosecond,
+			map[string]string{"part": "grpc"}),
+		ssf.Count("forward.error_total", 0, nil),
+	)
+}
+
+// sendSharded sends each metric to the global instance its key hashes to,
+// keyed on its name, type and every one of its tags. It returns the metrics that could not
+// be sent, along with the last error encountered.
+func sendSharded(
+	ctx context.Context, forwardDestinations destinations.Destinations,
+	metrics []*metricpb.Metric,
+) ([]*metricpb.Metric, error) {
+	var failed []*metricpb.Metric
+	var lastErr error
+
+	requests := make([]connect.SendRequest, len(metrics))
+	for i, metric := range metrics {
+		// The error channel is buffered so that destinations never block on
+		// us while we're still enqueueing metrics.
+		key := destinations.MetricKey(metric, metric.Tags)
+		requests[i] = connect.SendRequest{
+			Metric:       metric,
+			ErrorChannel: make(chan error, 1),
+			Key:          key,
+		}
+		destination, err := forwardDestinations.Get(key)
+		if err == nil {
+			err = enqueueSend(ctx, destination, requests[i])
+		}
+		if err == nil {
+			forwardDestinations.Count(key)
+		} else {
+			failed = append(failed, metric)
+			lastErr = err
+			requests[i].ErrorChannel = nil
+		}
+	}
+
+	for _, request := range requests {
+		if request.ErrorChannel == nil {
+			continue
+		}
+		var err error
+		select {
+		case err = <-request.ErrorChannel:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			failed = append(failed, request.Metric)
+			lastErr = err
+		}
+	}
+	return failed, lastErr
+}
+
+// enqueueSend queues a metric to be sent to a global instance. The
+// destination's send channel is closed once its connection closes, so
+// sending to it may panic.
+func enqueueSend(
+	ctx context.Context, destination connect.Destination,
+	request connect.SendRequest,
+) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("destination closed: %v", recovered)
+		}
+	}()
+
+	select {
+	case destination.SendChannel() <- request:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// forwardMetrics forwards metrics to the upstream Veneur, and reports how many
+// it accepted, rejected and dropped. Dropped metrics are forwarded again, up to
+// forwardAttempts times in all, and those that are still dropped or that
+// failed to send are returned. The counts are reported with the given tags.
+func forwardMetrics(
+	ctx context.Context, span *trace.Span, tags map[string]string,
+	connection *grpc.ClientConn, metrics []*metricpb.Metric,
+) ([]*metricpb.Metric, error) {
+	client := forwardrpc.NewForwardClient(connection)
+	for attempt := 1; ; attempt++ {
+		acks, err := forwardGrpc(ctx, client, metrics)
+		if err != nil {
+			return metrics, err
+		}
+		if acks == nil {
+			// The upstream Veneur doesn't acknowledge metrics.
+			return nil, nil
+		}
+		acks.report(span, tags)
+		if len(acks.retry) == 0 || attempt >= forwardAttempts {
+			return acks.retry, nil
+		}
+		span.Add(ssf.Count("forward.metrics_retried_total", float32(len(acks.retry)), tags))
+		metrics = acks.retry
+	}
+}
+
+// forwardSend returns the function used to replay buffered intervals to the
+// upstream Veneur, or to the global instances of forward_service. It returns
+// the metrics that failed to send, so that only those stay buffered.
+func (s *Server) forwardSend(
+	span *trace.Span,
+) func(context.Context, []*metricpb.Metric) ([]*metricpb.Metric, error) {
+	if s.forwardDestinations != nil {
+		return func(ctx context.Context, metrics []*metricpb.Metric) ([]*metricpb.Metric, error) {
+			return sendSharded(ctx, s.forwardDestinations, metrics)
+		}
+	}
+	return func(ctx context.Context, metrics []*metricpb.Metric) ([]*metricpb.Metric, error) {
+		// Metrics that are still dropped after being retried are counted, but
+		// the interval isn't buffered again, as most of it was accepted.
+		failed, err := forwardMetrics(ctx, span, nil, s.grpcForwardConn, metrics)
+		if err != nil {
+			return failed, err
+		}
+		return nil, nil
+	}
+}
+
+// bufferForward writes metrics that failed to forward to the forward buffer,
+// so they can be replayed once the upstream Veneur is reachable again.
+func (s *Server) bufferForward(
+	span *trace.Span, timestamp time.Time, metrics []*metricpb.Metric,
+) {
+	err := s.forwardBuffer.Push(forwardbuffer.Batch{
+		Timestamp: timestamp,
+		Metrics:   metrics,
+	})
+	if err != nil {
+		span.Add(ssf.Count("forward.buffer.error_total", 1, map[string]string{"cause": "write"}))
+		s.logger.WithError(err).Error("Failed to buffer metrics for forwarding")
+	}
+	s.reportForwardBuffer(span)
+}
+
+// replayForwardBuffer forwards the intervals in the forward buffer, oldest
+// first. Each interval is sent in its own stream and carries the time it was
+// flushed at, so intervals are never merged before they reach the upstream
+// Veneur, nor once they're there.
+func (s *Server) replayForwardBuffer(
+	ctx context.Context, span *trace.Span,
+	send func(context.Context, []*metricpb.Metric) ([]*metricpb.Metric, error),
+) {
+	replayed, err := s.forwardBuffer.Replay(ctx, func(batch forwardbuffer.Batch) ([]*metricpb.Metric, error) {
+		// The timestamp tells the global tier which interval the metrics
+		// belong to, so it doesn't merge them into its current interval.
+		for _, metric := range batch.Metrics {
+			metric.Timestamp = batch.Timestamp.Unix()
+		}
+		if failed, err := send(ctx, batch.Metrics); err != nil {
+			return failed, err
+		}
+		span.Add(ssf.Timing("forward.buffer.replay_lag_ns",
+			time.Since(batch.Timestamp), time.Nanosecond, nil))
+		return nil, nil
+	})
+	if replayed > 0 {
+		span.Add(ssf.Count("forward.buffer.replayed_total", float32(replayed), nil))
+		s.logger.WithField("intervals", replayed).
+			Info("Replayed buffered metrics to an upstream Veneur")
+	}
+	if err != nil && ctx.Err() == nil {
+		span.Add(ssf.Count("forward.buffer.error_total", 1, map[string]string{"cause": "replay"}))
+		s.logger.WithError(err).Warn("Failed to replay buffered metrics to an upstream Veneur")
+	}
+	s.reportForwardBuffer(span)
+}
+
+func (s *Server) reportForwardBuffer(span *trace.Span) {
+	stats := s.forwardBuffer.Stats()
+	span.Add(
+		ssf.Gauge("forward.buffer.intervals", float32(stats.Batches), nil),
+		ssf.Gauge("forward.buffer.size_bytes", float32(stats.SizeBytes), nil),
+		ssf.Count("forward.buffer.dropped_total", float32(stats.Dropped), nil),
+	)
+}
+
+// forwardAcks tallies the acknowledgements of the batches forwarded over
+// SendMetricsV3.
+type forwardAcks struct {
+	accepted uint64
+	rejected map[string]uint64
+	dropped  map[string]uint64
+	// retry holds the metrics that were dropped, or whose batch was never
+	// acknowledged, and can be forwarded again.
+	retry []*metricpb.Metric
+}
+
+// report adds the acknowledged counts to a span, with the given tags.
+func (acks *forwardAcks) report(span *trace.Span, tags map[string]string) {
+	span.Add(ssf.Count("forward.metrics_accepted_total", float32(acks.accepted), tags))
+	for reason, count := range acks.rejected {
+		span.Add(ssf.Count("forward.metrics_rejected_total", float32(count),
+			withTag(tags, "reason", reason)))
+	}
+	for reason, count := range acks.dropped {
+		span.Add(ssf.Count("forward.metrics_dropped_total", float32(count),
+			withTag(tags, "reason", reason)))
+	}
+}
+
+// withTag returns a copy of tags with a tag added.
+func withTag(tags map[string]string, key, value string) map[string]string {
+	result := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		result[k] = v
+	}
+	result[key] = value
+	return result
+}
+
+// forwardGrpc forwards metrics in batches over SendMetricsV3, and returns
+// their acknowledgements. If the upstream Veneur doesn't implement
+// SendMetricsV3, the metrics are forwarded over SendMetricsV2 instead, and no
+// acknowledgements are returned.
 func forwardGrpc(
 	ctx context.Context, client forwardrpc.ForwardClient,
 	metrics []*metricpb.Metric,
+) (*forwardAcks, error) {
+	stream, err := client.SendMetricsV3(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var batches [][]*metricpb.Metric
+	for start := 0; start < len(metrics); start += forwardBatchSize {
+		end := start + forwardBatchSize
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+		batches = append(batches, metrics[start:end])
+	}
+
+	// Acknowledgements are received while batches are still being sent, so
+	// that neither side blocks on the other.
+	type result struct {
+		acks *forwardAcks
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		acks := &forwardAcks{
+			rejected: map[string]uint64{},
+			dropped:  map[string]uint64{},
+		}
+		acked := make([]bool, len(batches))
+		for {
+			ack, err := stream.Recv()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				results <- result{err: err}
+				return
+			}
+			if ack.Id >= uint64(len(batches)) || acked[ack.Id] {
+				continue
+			}
+			acked[ack.Id] = true
+			batch := batches[ack.Id]
+			acks.accepted += ack.Accepted
+			for reason, count := range ack.Rejected {
+				acks.rejected[reason] += count
+			}
+			for reason, count := range ack.Dropped {
+				acks.dropped[reason] += count
+			}
+			for _, index := range ack.Retry {
+				if int(index) < len(batch) {
+					acks.retry = append(acks.retry, batch[index])
+				}
+			}
+		}
+		for id, batch := range batches {
+			if !acked[id] {
+				acks.dropped["unacknowledged"] += uint64(len(batch))
+				acks.retry = append(acks.retry, batch...)
+			}
+		}
+		results <- result{acks: acks}
+	}()
+
+	for id, batch := range batches {
+		err := stream.Send(&forwardrpc.MetricBatch{
+			Id:      uint64(id),
+			Metrics: batch,
+		})
+		if err == io.EOF {
+			// The stream was aborted; its status is returned by Recv.
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	err = stream.CloseSend()
+	if err != nil {
+		return nil, err
+	}
+
+	received := <-results
+	if status.Code(received.err) == codes.Unimplemented {
+		return nil, forwardGrpcV2(ctx, client, metrics)
+	}
+	return received.acks, received.err
+}
+
+// forwardGrpcV2 forwards metrics over SendMetricsV2, for upstream Veneurs that
+// don't implement SendMetricsV3.
+func forwardGrpcV2(
+	ctx context.Context, client forwardrpc.ForwardClient,
+	metrics []*metricpb.Metric,
 ) error {
 	sendMetricsClient, err := client.SendMetricsV2(ctx)
 	if err != nil {
@@ -2,6 +2,7 @@ package veneur
 
 import (
 	"context"
+	"net"
 	"net/url"
 	"sync"
 	"testing"
@@ -9,6 +10,9 @@ import (
 
 	"github.com/golang/mock/gomock"
 	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/v14/discovery"
+	"github.com/stripe/veneur/v14/discovery/file"
+	"github.com/stripe/veneur/v14/forwardrpc"
 	"github.com/stripe/veneur/v14/samplers"
 	"github.com/stripe/veneur/v14/scopedstatsd"
 	"github.com/stripe/veneur/v14/sinks"
@@ -16,6 +20,9 @@ import (
 	"github.com/stripe/veneur/v14/trace"
 	"github.com/stripe/veneur/v14/util"
 	"github.com/stripe/veneur/v14/util/matcher"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -219,6 +226,249 @@ func TestServerFlushGRPCBadAddress(t *testing.T) {
 	}
 }
 
+func TestServerFlushGRPCBuffersFailedForwards(t *testing.T) {
+	localCfg := localConfig()
+	localCfg.ForwardAddress = "bad-address:123"
+	localCfg.ForwardBuffer.Directory = t.TempDir()
+
+	local := setupVeneurServer(t, localCfg, nil, nil, nil, nil)
+	defer local.Shutdown()
+
+	local.Workers[0].ProcessMetric(forwardGRPCTestMetrics()[1])
+	require.Eventually(t, func() bool {
+		return local.forwardBuffer.Stats().Batches > 0
+	}, 5*time.Second, 10*time.Millisecond, "the failed forward should be buffered")
+
+	done := make(chan []*metricpb.Metric, 1)
+	testServer := forwardtest.NewServer(func(ms []*metricpb.Metric) {
+		done <- ms
+	})
+	testServer.Start(t)
+	defer testServer.Stop()
+
+	conn, err := grpc.Dial(testServer.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	span, ctx := trace.StartSpanFromContext(context.Background(), "")
+	local.replayForwardBuffer(ctx, span,
+		func(ctx context.Context, metrics []*metricpb.Metric) ([]*metricpb.Metric, error) {
+			_, err := forwardGrpc(ctx, forwardrpc.NewForwardClient(conn), metrics)
+			return metrics, err
+		})
+
+	select {
+	case v := <-done:
+		require.Len(t, v, 1)
+		assert.Equal(t, "test.grpc.histogram_global", v[0].Name)
+		assert.NotZero(t, v[0].Timestamp,
+			"replayed metrics should carry the interval they were flushed in")
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the buffered metrics to be replayed")
+	}
+}
+
+func TestServerFlushGRPCSharded(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// Each global instance reports the metrics it receives as they arrive,
+	// since the streams to them stay open across flushes.
+	received := make(chan string, 100)
+	var addresses []string
+	for i := 0; i < 2; i++ {
+		globalVeneur := grpc.NewServer()
+		server := forwardrpc.NewMockForwardServer(ctrl)
+		forwardrpc.RegisterForwardServer(globalVeneur, server)
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer globalVeneur.Stop()
+		go globalVeneur.Serve(listener)
+		addresses = append(addresses, listener.Addr().String())
+
+		server.EXPECT().SendMetricsV3(gomock.Any()).AnyTimes().
+			Return(status.Error(codes.Unimplemented, "unknown method"))
+		server.EXPECT().SendMetricsV2(gomock.Any()).AnyTimes().
+			DoAndReturn(func(server forwardrpc.Forward_SendMetricsV2Server) error {
+				for {
+					metric, err := server.Recv()
+					if err != nil {
+						return err
+					}
+					received <- metric.Name
+				}
+			})
+	}
+
+	discoverer := discovery.NewMockDiscoverer(ctrl)
+	discoverer.EXPECT().
+		GetDestinationsForService("veneur-global").
+		Return(addresses, nil)
+
+	localCfg := localConfig()
+	localCfg.ForwardAddress = ""
+	localCfg.ForwardService = "veneur-global"
+	local, err := NewFromConfig(ServerConfig{
+		Config:     localCfg,
+		Discoverer: discoverer,
+		Logger:     logrus.New(),
+	})
+	require.NoError(t, err)
+	trace.NeutralizeClient(local.TraceClient)
+	defer local.forwardDestinations.Clear()
+	assert.True(t, local.IsLocal())
+
+	ctx := context.Background()
+	local.handleForwardDiscovery(ctx)
+	require.Equal(t, 2, local.forwardDestinations.Size())
+
+	for _, input := range forwardGRPCTestMetrics() {
+		local.Workers[0].ProcessMetric(input)
+	}
+	local.forward(ctx, []WorkerMetrics{local.Workers[0].Flush()})
+
+	expected := []string{
+		"test.grpc.histogram",
+		"test.grpc.histogram_global",
+		"test.grpc.timer",
+		"test.grpc.timer_mixed",
+		"test.grpc.counter",
+		"test.grpc.gauge",
+		"test.grpc.set",
+	}
+	var names []string
+	for range expected {
+		select {
+		case name := <-received:
+			names = append(names, name)
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for the gRPC servers to receive the flush")
+		}
+	}
+	assert.ElementsMatch(t, expected, names,
+		"each metric should be forwarded to exactly one global instance")
+}
+
+func TestForwardMetricsRetriesDropped(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	globalVeneur := grpc.NewServer()
+	server := forwardrpc.NewMockForwardServer(ctrl)
+	forwardrpc.RegisterForwardServer(globalVeneur, server)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer globalVeneur.Stop()
+	go globalVeneur.Serve(listener)
+
+	// The first batch drops its first metric and rejects its last one, and
+	// the retried metric is then accepted.
+	var batches [][]string
+	server.EXPECT().SendMetricsV3(gomock.Any()).Times(2).
+		DoAndReturn(func(server forwardrpc.Forward_SendMetricsV3Server) error {
+			for {
+				batch, err := server.Recv()
+				if err != nil {
+					return nil
+				}
+				var names []string
+				for _, metric := range batch.Metrics {
+					names = append(names, metric.Name)
+				}
+				batches = append(batches, names)
+				ack := &forwardrpc.MetricBatchAck{
+					Id:       batch.Id,
+					Accepted: uint64(len(batch.Metrics)),
+				}
+				if len(batches) == 1 {
+					ack.Accepted = 1
+					ack.Dropped = map[string]uint64{"queue_full": 1}
+					ack.Rejected = map[string]uint64{"type_mismatch": 1}
+					ack.Retry = []uint32{0}
+				}
+				if err := server.Send(ack); err != nil {
+					return err
+				}
+			}
+		})
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	span, ctx := trace.StartSpanFromContext(context.Background(), "")
+	failed, err := forwardMetrics(ctx, span, nil, conn, []*metricpb.Metric{
+		{Name: "dropped"}, {Name: "accepted"}, {Name: "rejected"},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, failed)
+	assert.Equal(t, [][]string{
+		{"dropped", "accepted", "rejected"},
+		{"dropped"},
+	}, batches)
+}
+
+func TestForwardGrpcUnacknowledgedBatches(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	globalVeneur := grpc.NewServer()
+	server := forwardrpc.NewMockForwardServer(ctrl)
+	forwardrpc.RegisterForwardServer(globalVeneur, server)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer globalVeneur.Stop()
+	go globalVeneur.Serve(listener)
+
+	// The global instance closes the stream without acknowledging anything.
+	server.EXPECT().SendMetricsV3(gomock.Any()).
+		DoAndReturn(func(server forwardrpc.Forward_SendMetricsV3Server) error {
+			for {
+				if _, err := server.Recv(); err != nil {
+					return nil
+				}
+			}
+		})
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	metrics := []*metricpb.Metric{{Name: "a"}, {Name: "b"}}
+	acks, err := forwardGrpc(
+		context.Background(), forwardrpc.NewForwardClient(conn), metrics)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), acks.accepted)
+	assert.Equal(t, map[string]uint64{"unacknowledged": 2}, acks.dropped)
+	assert.Equal(t, metrics, acks.retry)
+}
+
+func TestNewFromConfigForwardServiceUnknownDiscoveryType(t *testing.T) {
+	localCfg := localConfig()
+	localCfg.ForwardAddress = ""
+	localCfg.ForwardService = "veneur-global"
+	localCfg.ForwardDiscovery.Type = "zookeeper"
+	_, err := NewFromConfig(ServerConfig{
+		Config: localCfg,
+		Logger: logrus.New(),
+	})
+	assert.Error(t, err)
+}
+
+func TestNewFromConfigForwardServiceCreatesDiscoverer(t *testing.T) {
+	localCfg := localConfig()
+	localCfg.ForwardAddress = ""
+	localCfg.ForwardService = "veneur-global"
+	localCfg.ForwardDiscovery.Type = "file"
+	server, err := NewFromConfig(ServerConfig{
+		Config: localCfg,
+		Logger: logrus.New(),
+	})
+	require.NoError(t, err)
+	defer server.Shutdown()
+	assert.IsType(t, &file.FileDiscoverer{}, server.forwardDiscoverer)
+}
+
 // Ensure that if someone sends a histogram to the global stats box directly,
 // it emits both aggregates and percentiles (basically behaves like a global
 // histo).
@@ -256,6 +506,162 @@ func TestGlobalAcceptsHistogramsOverUDP(t *testing.T) {
 	}
 }
 
+func TestGlobalFlushesHistogramThresholds(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	cfg := globalConfig()
+	cfg.Percentiles = []float64{}
+	cfg.Aggregates = []string{"fraction_below"}
+	cfg.HistogramThresholds = []ThresholdConfig{{
+		Match: []matcher.Matcher{{
+			Name: matcher.CreateNameMatcher(&matcher.NameMatcherConfig{
+				Kind:  "exact",
+				Value: "histo",
+			}),
+			Tags: []matcher.TagMatcher{},
+		}},
+		Threshold: 30,
+	}}
+	global := setupVeneurServer(t, cfg, nil, sink, nil, nil)
+	defer global.Shutdown()
+
+	for _, name := range []string{"histo", "other"} {
+		global.Workers[0].ProcessMetric(&samplers.UDPMetric{
+			MetricKey: samplers.MetricKey{
+				Name: name,
+				Type: HistogramTypeName,
+			},
+			Value:      20.0,
+			Digest:     12345,
+			SampleRate: 1.0,
+			Scope:      samplers.MixedScope,
+		})
+	}
+	global.Flush(context.Background())
+
+	select {
+	case results := <-rcv:
+		// only the histogram matching a threshold config is reported on
+		require.Len(t, results, 1, "unexpected metrics for global histo flush")
+		assert.Equal(t, "histo.fraction_below", results[0].Name)
+		assert.Equal(t, 1.0, results[0].Value)
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for global veneur flush")
+	}
+}
+
+func TestGlobalFlushesMergedStdDevOfMixedHistograms(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	cfg := globalConfig()
+	cfg.Percentiles = []float64{}
+	cfg.Aggregates = []string{"stddev", "variance"}
+	global := setupVeneurServer(t, cfg, nil, sink, nil, nil)
+	defer global.Shutdown()
+
+	// two local veneurs forward their part of the same mixed scope
+	// histogram, with variances of 0.75 and 2.75 on their own
+	for _, samples := range [][]float64{{2, 4, 4, 4}, {5, 5, 7, 9}} {
+		local := samplers.NewHist("histo", nil)
+		for _, sample := range samples {
+			local.Sample(sample, 1.0)
+		}
+		metric, err := local.Metric()
+		require.NoError(t, err)
+		metric.Scope = metricpb.Scope_Mixed
+		require.NoError(t, global.Workers[0].ImportMetric(metric))
+	}
+	global.Flush(context.Background())
+
+	select {
+	case results := <-rcv:
+		// the merged samples have a mean of 5 and a variance of 4
+		require.Len(t, results, 2, "unexpected metrics for global histo flush")
+		assert.Equal(t, "histo.stddev", results[0].Name)
+		assert.InDelta(t, 2, results[0].Value, 1e-9)
+		assert.Equal(t, "histo.variance", results[1].Name)
+		assert.InDelta(t, 4, results[1].Value, 1e-9)
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for global veneur flush")
+	}
+}
+
+func TestGlobalFlushesReplayedIntervalsSeparately(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	global := setupVeneurServer(t, globalConfig(), nil, sink, nil, nil)
+	defer global.Shutdown()
+
+	replayedAt := time.Now().Add(-time.Hour).Unix()
+	for _, metric := range []*metricpb.Metric{{
+		Name:  "counter",
+		Type:  metricpb.Type_Counter,
+		Value: &metricpb.Metric_Counter{Counter: &metricpb.CounterValue{Value: 2}},
+	}, {
+		Name:      "counter",
+		Type:      metricpb.Type_Counter,
+		Value:     &metricpb.Metric_Counter{Counter: &metricpb.CounterValue{Value: 5}},
+		Timestamp: replayedAt,
+	}} {
+		require.NoError(t, global.Workers[0].ImportMetric(metric))
+	}
+	global.Flush(context.Background())
+
+	select {
+	case results := <-rcv:
+		// the replayed counter is flushed under its own interval, rather
+		// than being added to the current one
+		require.Len(t, results, 2)
+		assert.Equal(t, "counter", results[0].Name)
+		assert.Equal(t, 2.0, results[0].Value)
+		assert.NotEqual(t, replayedAt, results[0].Timestamp)
+		assert.Equal(t, "counter", results[1].Name)
+		assert.Equal(t, 5.0, results[1].Value)
+		assert.Equal(t, replayedAt, results[1].Timestamp)
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for global veneur flush")
+	}
+}
+
+func TestGlobalMergesIntervalsReplayedByDifferentHosts(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	cfg := globalConfig()
+	cfg.Interval = 10 * time.Second
+	global := setupVeneurServer(t, cfg, nil, sink, nil, nil)
+	defer global.Shutdown()
+
+	// two local veneurs flushed the same interval at different times
+	interval := time.Now().Add(-time.Hour).Truncate(cfg.Interval)
+	for i, flushedAt := range []time.Time{interval.Add(time.Second), interval.Add(cfg.Interval - time.Second)} {
+		require.NoError(t, global.Workers[0].ImportMetric(&metricpb.Metric{
+			Name:      "counter",
+			Type:      metricpb.Type_Counter,
+			Value:     &metricpb.Metric_Counter{Counter: &metricpb.CounterValue{Value: int64(i + 2)}},
+			Timestamp: flushedAt.Unix(),
+		}))
+	}
+	global.Flush(context.Background())
+
+	select {
+	case results := <-rcv:
+		require.Len(t, results, 1)
+		assert.Equal(t, "counter", results[0].Name)
+		assert.Equal(t, 5.0, results[0].Value)
+		assert.Equal(t, interval.Unix(), results[0].Timestamp)
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for global veneur flush")
+	}
+}
+
 func TestFlushResetsWorkerUniqueMTS(t *testing.T) {
 	config := localConfig()
 	config.CountUniqueTimeseries = true
@@ -0,0 +1,88 @@
+package veneur
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	"github.com/stripe/veneur/v14/forwardrpc"
+	"github.com/stripe/veneur/v14/proxy/destinations"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+)
+
+// forwardSamples forwards events and service checks to the global tier, so
+// that only the global instances need credentials for the metric sinks. If
+// Veneur forwards to a discovered pool of global instances, samples are
+// spread across the pool by name.
+func (s *Server) forwardSamples(ctx context.Context, samples []ssf.SSFSample) {
+	if len(samples) == 0 {
+		return
+	}
+
+	span, _ := trace.StartSpanFromContext(ctx, "")
+	defer span.ClientFinish(s.TraceClient)
+
+	groups, dropped := s.groupSamples(samples)
+
+	sent := int64(0)
+	wg := sync.WaitGroup{}
+	for connection, batch := range groups {
+		wg.Add(1)
+		go func(connection *grpc.ClientConn, batch []*ssf.SSFSample) {
+			defer wg.Done()
+			_, err := forwardrpc.NewForwardClient(connection).SendSamples(
+				ctx, &forwardrpc.SampleList{Samples: batch})
+			if err != nil {
+				s.logger.WithError(err).WithFields(logrus.Fields{
+					"samples": len(batch),
+					"target":  connection.Target(),
+				}).Error("Failed to forward events to upstream Veneur")
+				atomic.AddInt64(&dropped, int64(len(batch)))
+				return
+			}
+			atomic.AddInt64(&sent, int64(len(batch)))
+		}(connection, batch)
+	}
+	wg.Wait()
+
+	span.Add(
+		ssf.Count("forward.events_total", float32(sent), nil),
+		ssf.Count("forward.events_dropped_total", float32(dropped), nil),
+	)
+}
+
+// groupSamples assigns each sample to the connection of the global instance
+// it's forwarded to. It returns the number of samples that have nowhere to go.
+func (s *Server) groupSamples(
+	samples []ssf.SSFSample,
+) (map[*grpc.ClientConn][]*ssf.SSFSample, int64) {
+	groups := map[*grpc.ClientConn][]*ssf.SSFSample{}
+	if s.forwardDestinations == nil {
+		if s.grpcForwardConn == nil {
+			return groups, int64(len(samples))
+		}
+		batch := make([]*ssf.SSFSample, len(samples))
+		for i := range samples {
+			batch[i] = &samples[i]
+		}
+		groups[s.grpcForwardConn] = batch
+		return groups, 0
+	}
+
+	dropped := int64(0)
+	for i := range samples {
+		sample := &samples[i]
+		destination, err := s.forwardDestinations.Get(destinations.SampleKey(sample))
+		if err != nil {
+			dropped++
+			continue
+		}
+		connection := destination.Connection()
+		groups[connection] = append(groups[connection], sample)
+	}
+	return groups, dropped
+}
@@ -0,0 +1,71 @@
+package veneur
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/stripe/veneur/v14/internal/forwardtest"
+	"github.com/stripe/veneur/v14/samplers/metricpb"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+)
+
+func TestForwardEvents(t *testing.T) {
+	received := make(chan []*ssf.SSFSample, 1)
+	global := forwardtest.NewServer(func([]*metricpb.Metric) {})
+	global.SampleHandler = func(samples []*ssf.SSFSample) {
+		received <- samples
+	}
+	global.Start(t)
+	defer global.Stop()
+
+	localCfg := localConfig()
+	localCfg.ForwardAddress = global.Addr().String()
+	localCfg.ForwardEvents = true
+	local, err := NewFromConfig(ServerConfig{
+		Config: localCfg,
+		Logger: logrus.New(),
+	})
+	require.NoError(t, err)
+	trace.NeutralizeClient(local.TraceClient)
+	local.grpcForwardConn, err = grpc.Dial(
+		local.ForwardAddr, local.forwardTransportOption())
+	require.NoError(t, err)
+	defer local.grpcForwardConn.Close()
+
+	local.forwardSamples(context.Background(), []ssf.SSFSample{{
+		Name:    "test.event",
+		Message: "something happened",
+		Tags:    map[string]string{"foo": "bar"},
+	}, {
+		Name:   "test.check",
+		Status: ssf.SSFSample_WARNING,
+	}})
+
+	select {
+	case samples := <-received:
+		require.Len(t, samples, 2)
+		assert.Equal(t, "test.event", samples[0].Name)
+		assert.Equal(t, map[string]string{"foo": "bar"}, samples[0].Tags)
+		assert.Equal(t, "test.check", samples[1].Name)
+		assert.Equal(t, ssf.SSFSample_WARNING, samples[1].Status)
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the global instance to receive events")
+	}
+}
+
+func TestForwardEventsRequiresForwarding(t *testing.T) {
+	cfg := globalConfig()
+	cfg.ForwardEvents = true
+	_, err := NewFromConfig(ServerConfig{
+		Config: cfg,
+		Logger: logrus.New(),
+	})
+	assert.Error(t, err)
+}
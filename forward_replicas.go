@@ -0,0 +1,182 @@
+package veneur
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	"github.com/stripe/veneur/v14/proxy/connect"
+	"github.com/stripe/veneur/v14/proxy/destinations"
+	"github.com/stripe/veneur/v14/samplers/metricpb"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+)
+
+// replicaTagKey is the tag that a global Veneur adds to everything it flushes
+// if replica_name is set, so that sinks can pick one copy of the aggregates
+// computed by redundant global tiers.
+const replicaTagKey = "veneurreplica"
+
+// forwardReplica is an additional global tier that a local Veneur forwards
+// every interval to, so that global aggregates survive the loss of a tier.
+// A replica is either a single global instance at address, or a pool of
+// global instances discovered as service, across which metrics are sharded.
+type forwardReplica struct {
+	name         string
+	address      string
+	service      string
+	connection   *grpc.ClientConn
+	destinations destinations.Destinations
+}
+
+// newForwardReplicas validates the configured replicas, and creates the
+// destinations of those that are discovered.
+func (s *Server) newForwardReplicas(
+	configs []ReplicaConfig, discoverer bool,
+) ([]*forwardReplica, error) {
+	var replicas []*forwardReplica
+	names := map[string]bool{}
+	for _, config := range configs {
+		if (config.Address == "") == (config.Service == "") {
+			return nil, errors.New(
+				"each of forward_replicas must set exactly one of address or service")
+		}
+		if config.Service != "" && !discoverer {
+			return nil, errors.New("forward_replicas with a service require a discoverer")
+		}
+
+		replica := &forwardReplica{
+			name:    config.Name,
+			address: config.Address,
+			service: config.Service,
+		}
+		if replica.name == "" {
+			replica.name = config.Address + config.Service
+		}
+		if names[replica.name] {
+			return nil, fmt.Errorf("forward_replicas has duplicate name %q", replica.name)
+		}
+		names[replica.name] = true
+		if config.Service != "" {
+			replica.destinations = destinations.Create(
+				connect.Create(s.Interval, s.logger, forwardSendBufferSize, s.Statsd,
+					s.forwardTransportCredentials()),
+				destinations.NewConsistentHash(0), s.logger)
+		}
+		replicas = append(replicas, replica)
+	}
+	return replicas, nil
+}
+
+// tags returns the tags that the replica's forward metrics are reported with.
+func (replica *forwardReplica) tags() map[string]string {
+	return map[string]string{"replica": replica.name}
+}
+
+// forwardToReplicas forwards metrics to every replica in parallel. Failures
+// are counted for each replica, but metrics that a replica doesn't receive
+// aren't buffered; the forward buffer only applies to the primary global
+// tier.
+func (s *Server) forwardToReplicas(
+	ctx context.Context, span *trace.Span, metrics []*metricpb.Metric,
+) {
+	wg := sync.WaitGroup{}
+	for _, replica := range s.forwardReplicas {
+		wg.Add(1)
+		go func(replica *forwardReplica) {
+			defer wg.Done()
+
+			start := time.Now()
+			var failed []*metricpb.Metric
+			var err error
+			if replica.destinations != nil {
+				failed, err = sendSharded(ctx, replica.destinations, metrics)
+			} else {
+				failed, err = forwardMetrics(
+					ctx, span, replica.tags(), replica.connection, metrics)
+			}
+			span.Add(ssf.Timing("forward.duration_ns", time.Since(start),
+				time.Nanosecond, withTag(replica.tags(), "part", "grpc")))
+
+			if err != nil {
+				cause := "send"
+				if ctx.Err() != nil {
+					cause = "deadline_exceeded"
+				}
+				span.Add(ssf.Count("forward.error_total", 1,
+					withTag(replica.tags(), "cause", cause)))
+				s.logger.WithError(err).WithFields(logrus.Fields{
+					"replica": replica.name,
+					"failed":  len(failed),
+				}).Warn("Failed to forward to a replica global tier")
+				return
+			}
+			s.logger.WithFields(logrus.Fields{
+				"replica": replica.name,
+				"metrics": len(metrics),
+			}).Debug("Completed forward to a replica global tier")
+		}(replica)
+	}
+	wg.Wait()
+}
+
+// connectForwardReplicas dials the replicas that are a single global
+// instance.
+func (s *Server) connectForwardReplicas() error {
+	for _, replica := range s.forwardReplicas {
+		if replica.address == "" {
+			continue
+		}
+		var err error
+		replica.connection, err = grpc.Dial(replica.address, s.forwardTransportOption())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeForwardReplicas closes the connections to every replica.
+func (s *Server) closeForwardReplicas() {
+	for _, replica := range s.forwardReplicas {
+		if replica.connection != nil {
+			replica.connection.Close()
+		}
+		if replica.destinations != nil {
+			replica.destinations.Clear()
+		}
+	}
+}
+
+// usesForwardDiscovery returns whether the primary global tier or any
+// replica is configured to be found through service discovery.
+func usesForwardDiscovery(conf Config) bool {
+	if conf.ForwardService != "" {
+		return true
+	}
+	for _, replica := range conf.ForwardReplicas {
+		if replica.Service != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDiscoveredForwarding returns whether the primary global tier or any
+// replica is discovered through service discovery.
+func (s *Server) hasDiscoveredForwarding() bool {
+	if s.forwardDestinations != nil {
+		return true
+	}
+	for _, replica := range s.forwardReplicas {
+		if replica.destinations != nil {
+			return true
+		}
+	}
+	return false
+}
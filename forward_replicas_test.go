@@ -0,0 +1,154 @@
+package veneur
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/discovery"
+	"github.com/stripe/veneur/v14/internal/forwardtest"
+	"github.com/stripe/veneur/v14/samplers/metricpb"
+)
+
+func TestForwardReplicas(t *testing.T) {
+	received := map[string]chan []string{}
+	var servers []*forwardtest.Server
+	for _, name := range []string{"primary", "replica"} {
+		metrics := make(chan []string, 1)
+		received[name] = metrics
+		server := forwardtest.NewServer(func(ms []*metricpb.Metric) {
+			var names []string
+			for _, m := range ms {
+				names = append(names, m.Name)
+			}
+			metrics <- names
+		})
+		server.Start(t)
+		defer server.Stop()
+		servers = append(servers, server)
+	}
+
+	localCfg := localConfig()
+	localCfg.ForwardAddress = servers[0].Addr().String()
+	localCfg.ForwardReplicas = []ReplicaConfig{{
+		Address: servers[1].Addr().String(),
+		Name:    "replica",
+	}}
+	local := setupVeneurServer(t, localCfg, nil, nil, nil, nil)
+	defer local.Shutdown()
+
+	for _, input := range forwardGRPCTestMetrics() {
+		local.Workers[0].ProcessMetric(input)
+	}
+
+	expected := []string{
+		"test.grpc.histogram",
+		"test.grpc.histogram_global",
+		"test.grpc.timer",
+		"test.grpc.timer_mixed",
+		"test.grpc.counter",
+		"test.grpc.gauge",
+		"test.grpc.set",
+	}
+	for name, metrics := range received {
+		select {
+		case names := <-metrics:
+			assert.ElementsMatch(t, expected, names,
+				"the %s global tier didn't receive the right metrics", name)
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for the %s global tier to receive the flush", name)
+		}
+	}
+}
+
+func TestForwardReplicasDiscovery(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	discoverer := discovery.NewMockDiscoverer(ctrl)
+	discoverer.EXPECT().
+		GetDestinationsForService("veneur-global-b").
+		Return([]string{}, nil)
+
+	localCfg := localConfig()
+	localCfg.ForwardReplicas = []ReplicaConfig{{Service: "veneur-global-b"}}
+	local, err := NewFromConfig(ServerConfig{
+		Config:     localCfg,
+		Discoverer: discoverer,
+		Logger:     logrus.New(),
+	})
+	require.NoError(t, err)
+	require.Len(t, local.forwardReplicas, 1)
+	assert.Equal(t, "veneur-global-b", local.forwardReplicas[0].name)
+	assert.True(t, local.hasDiscoveredForwarding())
+
+	local.handleForwardDiscovery(context.Background())
+	assert.Equal(t, 0, local.forwardReplicas[0].destinations.Size())
+}
+
+func TestForwardReplicasInvalidConfig(t *testing.T) {
+	tests := map[string]struct {
+		forwardAddress string
+		discoveryType  string
+		replicas       []ReplicaConfig
+	}{
+		"not local": {
+			replicas: []ReplicaConfig{{Address: "localhost:1"}},
+		},
+		"no target": {
+			forwardAddress: "localhost:1",
+			replicas:       []ReplicaConfig{{Name: "b"}},
+		},
+		"two targets": {
+			forwardAddress: "localhost:1",
+			replicas: []ReplicaConfig{{
+				Address: "localhost:2",
+				Service: "veneur-global-b",
+			}},
+		},
+		"unknown discovery type": {
+			forwardAddress: "localhost:1",
+			discoveryType:  "zookeeper",
+			replicas:       []ReplicaConfig{{Service: "veneur-global-b"}},
+		},
+		"duplicate names": {
+			forwardAddress: "localhost:1",
+			replicas: []ReplicaConfig{
+				{Address: "localhost:2", Name: "b"},
+				{Address: "localhost:3", Name: "b"},
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := globalConfig()
+			cfg.ForwardAddress = test.forwardAddress
+			cfg.ForwardDiscovery.Type = test.discoveryType
+			cfg.ForwardReplicas = test.replicas
+			_, err := NewFromConfig(ServerConfig{
+				Config: cfg,
+				Logger: logrus.New(),
+			})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestReplicaNameTagsFlushedMetrics(t *testing.T) {
+	cfg := globalConfig()
+	cfg.Tags = []string{"env:test"}
+	cfg.ReplicaName = "b"
+	server, err := NewFromConfig(ServerConfig{
+		Config: cfg,
+		Logger: logrus.New(),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"env:test", "veneurreplica:b"}, server.Tags)
+	assert.Equal(t, "b", server.TagsAsMap["veneurreplica"])
+	assert.Equal(t, []string{"env:test"}, cfg.Tags)
+}
@@ -0,0 +1,175 @@
+package veneur
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	"github.com/stripe/veneur/v14/forwardrpc"
+	"github.com/stripe/veneur/v14/protocol"
+	"github.com/stripe/veneur/v14/proxy/destinations"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/trace/metrics"
+)
+
+// forwardSpanBufferSize bounds the number of spans buffered between flushes.
+// Spans received once the buffer is full are dropped.
+const forwardSpanBufferSize = 1 << 16
+
+// spanForwardSink is a span sink that forwards spans to the global tier, so
+// that the span sinks only need to run there. Spans are buffered until the
+// next flush, and every span of a trace is sent to the same global instance.
+type spanForwardSink struct {
+	server      *Server
+	traceClient *trace.Client
+
+	mtx   sync.Mutex
+	spans []*ssf.SSFSpan
+
+	sentCount    int64
+	droppedCount int64
+}
+
+var _ sinks.SpanSink = &spanForwardSink{}
+
+func newSpanForwardSink(server *Server) *spanForwardSink {
+	return &spanForwardSink{server: server}
+}
+
+// Name returns "forward".
+func (f *spanForwardSink) Name() string {
+	return "forward"
+}
+
+// Start sets the trace client used to report the sink's metrics.
+func (f *spanForwardSink) Start(cl *trace.Client) error {
+	f.traceClient = cl
+	return nil
+}
+
+// Ingest buffers a span to be forwarded on the next flush. Metrics carried by
+// the span are not forwarded, since they're already extracted by the local
+// Veneur.
+func (f *spanForwardSink) Ingest(span *ssf.SSFSpan) error {
+	if err := protocol.ValidateTrace(span); err != nil {
+		return err
+	}
+
+	forwarded := &ssf.SSFSpan{}
+	*forwarded = *span
+	forwarded.Metrics = nil
+	forwarded.Tags = make(map[string]string, len(span.Tags))
+	for k, v := range span.Tags {
+		forwarded.Tags[k] = v
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if len(f.spans) >= forwardSpanBufferSize {
+		atomic.AddInt64(&f.droppedCount, 1)
+		return nil
+	}
+	f.spans = append(f.spans, forwarded)
+	return nil
+}
+
+// Flush sends the spans buffered since the last flush to the global tier. If
+// Veneur forwards to a discovered pool of global instances, spans are sharded
+// across the pool by trace ID.
+func (f *spanForwardSink) Flush() {
+	f.mtx.Lock()
+	spans := f.spans
+	f.spans = nil
+	f.mtx.Unlock()
+
+	if len(spans) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), f.server.Interval)
+		defer cancel()
+
+		wg := sync.WaitGroup{}
+		for connection, batch := range f.group(spans) {
+			wg.Add(1)
+			go func(connection *grpc.ClientConn, batch []*ssf.SSFSpan) {
+				defer wg.Done()
+				err := sendSpans(ctx, connection, batch)
+				if err != nil {
+					f.server.logger.WithError(err).WithFields(logrus.Fields{
+						"spans":  len(batch),
+						"target": connection.Target(),
+					}).Error("Failed to forward spans to upstream Veneur")
+					atomic.AddInt64(&f.droppedCount, int64(len(batch)))
+					return
+				}
+				atomic.AddInt64(&f.sentCount, int64(len(batch)))
+			}(connection, batch)
+		}
+		wg.Wait()
+	}
+
+	samples := &ssf.Samples{}
+	samples.Add(
+		ssf.Count(
+			sinks.MetricKeyTotalSpansFlushed,
+			float32(atomic.SwapInt64(&f.sentCount, 0)),
+			map[string]string{"sink": f.Name()}),
+		ssf.Count(
+			sinks.MetricKeyTotalSpansDropped,
+			float32(atomic.SwapInt64(&f.droppedCount, 0)),
+			map[string]string{"sink": f.Name()}),
+	)
+	metrics.Report(f.traceClient, samples)
+}
+
+// group assigns each span to the connection of the global instance it's
+// forwarded to. Spans that have nowhere to go are counted as dropped.
+func (f *spanForwardSink) group(
+	spans []*ssf.SSFSpan,
+) map[*grpc.ClientConn][]*ssf.SSFSpan {
+	groups := map[*grpc.ClientConn][]*ssf.SSFSpan{}
+	if f.server.forwardDestinations == nil {
+		if f.server.grpcForwardConn == nil {
+			atomic.AddInt64(&f.droppedCount, int64(len(spans)))
+			return groups
+		}
+		groups[f.server.grpcForwardConn] = spans
+		return groups
+	}
+
+	for _, span := range spans {
+		destination, err := f.server.forwardDestinations.Get(destinations.SpanKey(span))
+		if err != nil {
+			atomic.AddInt64(&f.droppedCount, 1)
+			continue
+		}
+		connection := destination.Connection()
+		groups[connection] = append(groups[connection], span)
+	}
+	return groups
+}
+
+// sendSpans streams spans to a global instance.
+func sendSpans(
+	ctx context.Context, connection *grpc.ClientConn, spans []*ssf.SSFSpan,
+) error {
+	client, err := forwardrpc.NewForwardClient(connection).SendSpans(ctx)
+	if err != nil {
+		return err
+	}
+	for _, span := range spans {
+		err := client.Send(span)
+		if err == io.EOF {
+			// The stream was aborted; its status is returned by CloseAndRecv.
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	_, err = client.CloseAndRecv()
+	return err
+}
@@ -0,0 +1,194 @@
+package veneur
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/stripe/veneur/v14/discovery"
+	"github.com/stripe/veneur/v14/forwardrpc"
+	"github.com/stripe/veneur/v14/internal/forwardtest"
+	"github.com/stripe/veneur/v14/samplers/metricpb"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+)
+
+func forwardSpansTestSpan(traceID, id int64) *ssf.SSFSpan {
+	return &ssf.SSFSpan{
+		TraceId:        traceID,
+		Id:             id,
+		StartTimestamp: time.Now().UnixNano(),
+		EndTimestamp:   time.Now().UnixNano(),
+		Service:        "test-srv",
+		Name:           "test.span",
+		Tags:           map[string]string{"foo": "bar"},
+		Metrics:        []*ssf.SSFSample{ssf.Count("test.count", 1, nil)},
+	}
+}
+
+// spanForwardSinkOf returns the span sink that forwards spans.
+func spanForwardSinkOf(t *testing.T, server *Server) *spanForwardSink {
+	for _, sink := range server.spanSinks {
+		if forwarder, ok := sink.(*spanForwardSink); ok {
+			return forwarder
+		}
+	}
+	t.Fatal("no span sink forwards spans")
+	return nil
+}
+
+func TestForwardSpans(t *testing.T) {
+	received := make(chan []*ssf.SSFSpan, 1)
+	global := forwardtest.NewServer(func([]*metricpb.Metric) {})
+	global.SpanHandler = func(spans []*ssf.SSFSpan) {
+		received <- spans
+	}
+	global.Start(t)
+	defer global.Stop()
+
+	localCfg := localConfig()
+	localCfg.ForwardAddress = global.Addr().String()
+	localCfg.ForwardSpans = true
+	local, err := NewFromConfig(ServerConfig{
+		Config: localCfg,
+		Logger: logrus.New(),
+	})
+	require.NoError(t, err)
+	trace.NeutralizeClient(local.TraceClient)
+	local.grpcForwardConn, err = grpc.Dial(
+		local.ForwardAddr, local.forwardTransportOption())
+	require.NoError(t, err)
+	defer local.grpcForwardConn.Close()
+
+	sink := spanForwardSinkOf(t, local)
+	require.NoError(t, sink.Start(local.TraceClient))
+	require.NoError(t, sink.Ingest(forwardSpansTestSpan(1, 1)))
+	// spans that only carry metrics aren't forwarded
+	assert.Error(t, sink.Ingest(&ssf.SSFSpan{
+		Metrics: []*ssf.SSFSample{ssf.Count("test.count", 1, nil)},
+	}))
+	sink.Flush()
+
+	select {
+	case spans := <-received:
+		require.Len(t, spans, 1)
+		assert.Equal(t, int64(1), spans[0].TraceId)
+		assert.Equal(t, map[string]string{"foo": "bar"}, spans[0].Tags)
+		assert.Empty(t, spans[0].Metrics,
+			"metrics are extracted locally, and shouldn't be forwarded")
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the global instance to receive spans")
+	}
+}
+
+func TestForwardSpansShardedByTrace(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	type receivedSpan struct {
+		address string
+		traceID int64
+	}
+	received := make(chan receivedSpan, 100)
+	var addresses []string
+	for i := 0; i < 2; i++ {
+		globalVeneur := grpc.NewServer()
+		server := forwardrpc.NewMockForwardServer(ctrl)
+		forwardrpc.RegisterForwardServer(globalVeneur, server)
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer globalVeneur.Stop()
+		go globalVeneur.Serve(listener)
+		address := listener.Addr().String()
+		addresses = append(addresses, address)
+
+		// Keep the metric streams open, as the local instance closes its
+		// destinations once they do.
+		server.EXPECT().SendMetricsV3(gomock.Any()).AnyTimes().
+			Return(status.Error(codes.Unimplemented, "unknown method"))
+		server.EXPECT().SendMetricsV2(gomock.Any()).AnyTimes().
+			DoAndReturn(func(server forwardrpc.Forward_SendMetricsV2Server) error {
+				<-server.Context().Done()
+				return nil
+			})
+		server.EXPECT().SendSpans(gomock.Any()).AnyTimes().
+			DoAndReturn(func(server forwardrpc.Forward_SendSpansServer) error {
+				for {
+					span, err := server.Recv()
+					if err != nil {
+						return server.SendAndClose(&emptypb.Empty{})
+					}
+					received <- receivedSpan{address, span.TraceId}
+				}
+			})
+	}
+
+	discoverer := discovery.NewMockDiscoverer(ctrl)
+	discoverer.EXPECT().
+		GetDestinationsForService("veneur-global").
+		Return(addresses, nil)
+
+	localCfg := localConfig()
+	localCfg.ForwardAddress = ""
+	localCfg.ForwardService = "veneur-global"
+	localCfg.ForwardSpans = true
+	local, err := NewFromConfig(ServerConfig{
+		Config:     localCfg,
+		Discoverer: discoverer,
+		Logger:     logrus.New(),
+	})
+	require.NoError(t, err)
+	trace.NeutralizeClient(local.TraceClient)
+	defer local.forwardDestinations.Clear()
+
+	local.handleForwardDiscovery(context.Background())
+	require.Equal(t, 2, local.forwardDestinations.Size())
+
+	sink := spanForwardSinkOf(t, local)
+	require.NoError(t, sink.Start(local.TraceClient))
+	const traces, spansPerTrace = 20, 3
+	for traceID := int64(1); traceID <= traces; traceID++ {
+		for id := int64(0); id < spansPerTrace; id++ {
+			require.NoError(t, sink.Ingest(forwardSpansTestSpan(traceID, traceID*10+id)))
+		}
+	}
+	sink.Flush()
+
+	traceAddresses := map[int64]map[string]bool{}
+	for i := 0; i < traces*spansPerTrace; i++ {
+		select {
+		case span := <-received:
+			if traceAddresses[span.traceID] == nil {
+				traceAddresses[span.traceID] = map[string]bool{}
+			}
+			traceAddresses[span.traceID][span.address] = true
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for the global instances to receive spans")
+		}
+	}
+	assert.Len(t, traceAddresses, traces)
+	for traceID, addresses := range traceAddresses {
+		assert.Len(t, addresses, 1,
+			"every span of trace %d should go to the same global instance", traceID)
+	}
+}
+
+func TestForwardSpansRequiresForwarding(t *testing.T) {
+	cfg := globalConfig()
+	cfg.ForwardSpans = true
+	_, err := NewFromConfig(ServerConfig{
+		Config: cfg,
+		Logger: logrus.New(),
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,325 @@
+// Package forwardbuffer implements a durable on-disk buffer for metrics that
+// a local Veneur failed to forward to the global tier.
+//
+// Each interval that could not be forwarded is written to its own file, so
+// buffered intervals are never merged with each other and can be replayed in
+// the order they were flushed once the global tier is reachable again.
+package forwardbuffer
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/veneur/v14/samplers/metricpb"
+)
+
+const batchSuffix = ".batch"
+
+// maxMetricSize bounds the size of a single encoded metric read back from
+// disk, so a corrupt length prefix can't make us allocate without bound.
+const maxMetricSize = 64 << 20
+
+// Batch is the set of metrics flushed during a single interval.
+type Batch struct {
+	// Timestamp is the time at which the interval was originally flushed.
+	Timestamp time.Time
+	Metrics   []*metricpb.Metric
+}
+
+// Stats reports on the state of a Buffer.
+type Stats struct {
+	// Batches is the number of intervals waiting to be replayed.
+	Batches int
+	// SizeBytes is the size of those intervals on disk.
+	SizeBytes int64
+	// Dropped is the number of intervals dropped since the last call to
+	// Stats because they exceeded the size or age cap, or could not be read.
+	Dropped int
+}
+
+type batchFile struct {
+	path      string
+	timestamp time.Time
+	size      int64
+}
+
+// Buffer persists batches of metrics to files in a directory, capped by the
+// total size of the files and the age of the oldest batch. When the size cap
+// is exceeded, the oldest batches are dropped first.
+type Buffer struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	now      func() time.Time
+
+	mtx     sync.Mutex
+	batches []batchFile
+	size    int64
+	dropped int
+}
+
+// New creates a Buffer that stores batches in dir, creating the directory if
+// necessary. Batches left in dir by a previous process are picked up, so they
+// can be replayed after a restart. A maxBytes or maxAge of zero disables that
+// cap.
+func New(dir string, maxBytes int64, maxAge time.Duration) (*Buffer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	b := &Buffer{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		now:      time.Now,
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), batchSuffix) {
+			continue
+		}
+		nanos, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), batchSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		b.batches = append(b.batches, batchFile{
+			path:      filepath.Join(dir, entry.Name()),
+			timestamp: time.Unix(0, nanos),
+			size:      entry.Size(),
+		})
+		b.size += entry.Size()
+	}
+	sort.Slice(b.batches, func(i, j int) bool {
+		return b.batches[i].timestamp.Before(b.batches[j].timestamp)
+	})
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.enforceLimits()
+	return b, nil
+}
+
+// Push writes a batch to disk, then drops the oldest batches if the buffer
+// exceeds its caps.
+func (b *Buffer) Push(batch Batch) error {
+	path := filepath.Join(b.dir, strconv.FormatInt(batch.Timestamp.UnixNano(), 10)+batchSuffix)
+	size, err := writeBatch(path, batch.Metrics)
+	if err != nil {
+		return err
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.batches = append(b.batches, batchFile{
+		path:      path,
+		timestamp: batch.Timestamp,
+		size:      size,
+	})
+	sort.SliceStable(b.batches, func(i, j int) bool {
+		return b.batches[i].timestamp.Before(b.batches[j].timestamp)
+	})
+	b.size += size
+	b.enforceLimits()
+	return nil
+}
+
+// Replay calls send with each buffered batch, oldest first, and removes the
+// batches that were sent successfully. It stops at the first error returned
+// by send, leaving that batch and any newer ones in the buffer, or when ctx
+// is done. Along with the error, send returns the metrics it failed to send;
+// if only some of the batch failed, just those are kept. It returns the
+// number of batches that were sent.
+func (b *Buffer) Replay(
+	ctx context.Context, send func(Batch) ([]*metricpb.Metric, error),
+) (int, error) {
+	sent := 0
+	for ctx.Err() == nil {
+		b.mtx.Lock()
+		b.enforceLimits()
+		if len(b.batches) == 0 {
+			b.mtx.Unlock()
+			return sent, nil
+		}
+		file := b.batches[0]
+		b.mtx.Unlock()
+
+		metrics, err := readBatch(file.path)
+		if err != nil {
+			// there's nothing we can do with a batch we can't read
+			b.remove(file, true)
+			continue
+		}
+		failed, err := send(Batch{Timestamp: file.timestamp, Metrics: metrics})
+		if err != nil {
+			if len(failed) > 0 && len(failed) < len(metrics) {
+				b.shrink(file, failed)
+			}
+			return sent, err
+		}
+		b.remove(file, false)
+		sent++
+	}
+	return sent, ctx.Err()
+}
+
+// Stats returns the current state of the buffer, and resets the count of
+// dropped batches.
+func (b *Buffer) Stats() Stats {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	stats := Stats{
+		Batches:   len(b.batches),
+		SizeBytes: b.size,
+		Dropped:   b.dropped,
+	}
+	b.dropped = 0
+	return stats
+}
+
+// remove deletes a batch from disk and from the buffer, if it's still there.
+func (b *Buffer) remove(file batchFile, dropped bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	for i, f := range b.batches {
+		if f.path == file.path {
+			b.batches = append(b.batches[:i], b.batches[i+1:]...)
+			b.size -= f.size
+			os.Remove(f.path)
+			if dropped {
+				b.dropped++
+			}
+			return
+		}
+	}
+}
+
+// shrink rewrites a batch with only the given metrics, so the metrics that
+// were already sent aren't replayed again. If the batch can't be rewritten,
+// it's kept as it was.
+func (b *Buffer) shrink(file batchFile, metrics []*metricpb.Metric) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	for i, f := range b.batches {
+		if f.path != file.path {
+			continue
+		}
+		// the lock is held while writing, so the batch can't be dropped
+		// from under us and leave its file behind
+		size, err := writeBatch(f.path, metrics)
+		if err != nil {
+			return
+		}
+		b.batches[i].size = size
+		b.size += size - f.size
+		return
+	}
+}
+
+// enforceLimits drops the oldest batches until the buffer is within its
+// caps. It must be called with mtx held.
+func (b *Buffer) enforceLimits() {
+	cutoff := b.now().Add(-b.maxAge)
+	for len(b.batches) > 0 {
+		oldest := b.batches[0]
+		tooBig := b.maxBytes > 0 && b.size > b.maxBytes
+		tooOld := b.maxAge > 0 && oldest.timestamp.Before(cutoff)
+		if !tooBig && !tooOld {
+			return
+		}
+		os.Remove(oldest.path)
+		b.batches = b.batches[1:]
+		b.size -= oldest.size
+		b.dropped++
+	}
+}
+
+// writeBatch encodes metrics as a sequence of length-prefixed protobuf
+// messages. The file is written under a temporary name and renamed into
+// place, so a crash never leaves a partial batch behind.
+func writeBatch(path string, metrics []*metricpb.Metric) (int64, error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+
+	w := bufio.NewWriter(f)
+	var size int64
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	for _, metric := range metrics {
+		data, err := metric.Marshal()
+		if err != nil {
+			f.Close()
+			return 0, err
+		}
+		n := binary.PutUvarint(lenBuf, uint64(len(data)))
+		if _, err := w.Write(lenBuf[:n]); err != nil {
+			f.Close()
+			return 0, err
+		}
+		if _, err := w.Write(data); err != nil {
+			f.Close()
+			return 0, err
+		}
+		size += int64(n + len(data))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	return size, os.Rename(tmp, path)
+}
+
+// readBatch decodes a file written by writeBatch.
+func readBatch(path string) ([]*metricpb.Metric, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var metrics []*metricpb.Metric
+	for {
+		length, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return metrics, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if length > maxMetricSize {
+			return nil, fmt.Errorf("metric of %d bytes in %s is too large", length, path)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		metric := &metricpb.Metric{}
+		if err := metric.Unmarshal(data); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, metric)
+	}
+}
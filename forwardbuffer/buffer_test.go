@@ -0,0 +1,203 @@
+package forwardbuffer
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14/samplers/metricpb"
+)
+
+func testBatch(timestamp time.Time, names ...string) Batch {
+	batch := Batch{Timestamp: timestamp}
+	for _, name := range names {
+		batch.Metrics = append(batch.Metrics, &metricpb.Metric{
+			Name: name,
+			Tags: []string{"foo:bar"},
+			Type: metricpb.Type_Counter,
+			Value: &metricpb.Metric_Counter{
+				Counter: &metricpb.CounterValue{Value: 1},
+			},
+			Scope: metricpb.Scope_Global,
+		})
+	}
+	return batch
+}
+
+func names(batch Batch) []string {
+	var result []string
+	for _, metric := range batch.Metrics {
+		result = append(result, metric.Name)
+	}
+	return result
+}
+
+func TestReplayInOrder(t *testing.T) {
+	b, err := New(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, b.Push(testBatch(start.Add(time.Second), "b1", "b2")))
+	require.NoError(t, b.Push(testBatch(start, "a")))
+	assert.Equal(t, 2, b.Stats().Batches)
+
+	var replayed []Batch
+	sent, err := b.Replay(context.Background(), func(batch Batch) ([]*metricpb.Metric, error) {
+		replayed = append(replayed, batch)
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	require.Len(t, replayed, 2)
+	assert.Equal(t, []string{"a"}, names(replayed[0]))
+	assert.Equal(t, start.UnixNano(), replayed[0].Timestamp.UnixNano())
+	assert.Equal(t, []string{"b1", "b2"}, names(replayed[1]))
+	assert.Equal(t, int64(1), replayed[1].Metrics[0].GetCounter().Value)
+
+	stats := b.Stats()
+	assert.Zero(t, stats.Batches)
+	assert.Zero(t, stats.SizeBytes)
+	assert.Zero(t, stats.Dropped)
+}
+
+func TestReplayStopsOnError(t *testing.T) {
+	b, err := New(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, b.Push(testBatch(start, "a")))
+	require.NoError(t, b.Push(testBatch(start.Add(time.Second), "b")))
+
+	sent, err := b.Replay(context.Background(), func(batch Batch) ([]*metricpb.Metric, error) {
+		if names(batch)[0] == "b" {
+			return batch.Metrics, errors.New("unavailable")
+		}
+		return nil, nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, 1, b.Stats().Batches, "the failed batch should stay buffered")
+}
+
+func TestReplayKeepsOnlyFailedMetrics(t *testing.T) {
+	b, err := New(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, b.Push(testBatch(start, "a", "b", "c")))
+	size := b.Stats().SizeBytes
+
+	_, err = b.Replay(context.Background(), func(batch Batch) ([]*metricpb.Metric, error) {
+		return batch.Metrics[1:2], errors.New("unavailable")
+	})
+	assert.Error(t, err)
+	stats := b.Stats()
+	assert.Equal(t, 1, stats.Batches)
+	assert.Less(t, stats.SizeBytes, size)
+
+	var replayed []Batch
+	sent, err := b.Replay(context.Background(), func(batch Batch) ([]*metricpb.Metric, error) {
+		replayed = append(replayed, batch)
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	require.Len(t, replayed, 1)
+	assert.Equal(t, []string{"b"}, names(replayed[0]),
+		"only the metrics that failed should be replayed again")
+	assert.Equal(t, start.UnixNano(), replayed[0].Timestamp.UnixNano())
+}
+
+func TestReloadFromDisk(t *testing.T) {
+	dir := t.TempDir()
+	b, err := New(dir, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, b.Push(testBatch(time.Now(), "a")))
+
+	b, err = New(dir, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, b.Stats().Batches)
+
+	var replayed []Batch
+	_, err = b.Replay(context.Background(), func(batch Batch) ([]*metricpb.Metric, error) {
+		replayed = append(replayed, batch)
+		return nil, nil
+	})
+	require.NoError(t, err)
+	require.Len(t, replayed, 1)
+	assert.Equal(t, []string{"a"}, names(replayed[0]))
+}
+
+func TestSizeCap(t *testing.T) {
+	dir := t.TempDir()
+	b, err := New(dir, 0, 0)
+	require.NoError(t, err)
+	start := time.Now()
+	require.NoError(t, b.Push(testBatch(start, "a")))
+	size := b.Stats().SizeBytes
+
+	// room for two batches of the same size
+	b, err = New(dir, 2*size, 0)
+	require.NoError(t, err)
+	require.NoError(t, b.Push(testBatch(start.Add(time.Second), "b")))
+	require.NoError(t, b.Push(testBatch(start.Add(2*time.Second), "c")))
+
+	stats := b.Stats()
+	assert.Equal(t, 2, stats.Batches)
+	assert.Equal(t, 2*size, stats.SizeBytes)
+	assert.Equal(t, 1, stats.Dropped)
+
+	var replayed []string
+	_, err = b.Replay(context.Background(), func(batch Batch) ([]*metricpb.Metric, error) {
+		replayed = append(replayed, names(batch)...)
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, replayed, "the oldest batch should be dropped")
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestAgeCap(t *testing.T) {
+	b, err := New(t.TempDir(), 0, time.Minute)
+	require.NoError(t, err)
+
+	now := time.Now()
+	require.NoError(t, b.Push(testBatch(now.Add(-2*time.Minute), "old")))
+	require.NoError(t, b.Push(testBatch(now, "new")))
+
+	var replayed []string
+	_, err = b.Replay(context.Background(), func(batch Batch) ([]*metricpb.Metric, error) {
+		replayed = append(replayed, names(batch)...)
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new"}, replayed)
+	assert.Equal(t, 1, b.Stats().Dropped)
+}
+
+func TestCorruptBatchDropped(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(
+		filepath.Join(dir, "1"+batchSuffix), []byte{0xff, 0xff, 0xff}, 0644))
+
+	b, err := New(dir, 0, 0)
+	require.NoError(t, err)
+	sent, err := b.Replay(context.Background(), func(batch Batch) ([]*metricpb.Metric, error) {
+		t.Fatal("a corrupt batch should not be sent")
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Zero(t, sent)
+
+	stats := b.Stats()
+	assert.Zero(t, stats.Batches)
+	assert.Equal(t, 1, stats.Dropped)
+}
@@ -9,6 +9,7 @@ import (
 	proto "github.com/gogo/protobuf/proto"
 	empty "github.com/golang/protobuf/ptypes/empty"
 	metricpb "github.com/stripe/veneur/v14/samplers/metricpb"
+	ssf "github.com/stripe/veneur/v14/ssf"
 	grpc "google.golang.org/grpc"
 	io "io"
 	math "math"
@@ -70,28 +71,233 @@ func (m *MetricList) GetMetrics() []*metricpb.Metric {
 	return nil
 }
 
+// MetricBatch is a batch of metrics sent over SendMetricsV3. The id is chosen
+// by the client, and is returned in the batch's acknowledgement. A batch with
+// no metrics can be sent to check that the server implements SendMetricsV3.
+type MetricBatch struct {
+	Id      uint64             `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Metrics []*metricpb.Metric `protobuf:"bytes,2,rep,name=metrics,proto3" json:"metrics,omitempty"`
+}
+
+func (m *MetricBatch) Reset()         { *m = MetricBatch{} }
+func (m *MetricBatch) String() string { return proto.CompactTextString(m) }
+func (*MetricBatch) ProtoMessage()    {}
+func (*MetricBatch) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0f9bdf2b06f7b9ea, []int{1}
+}
+func (m *MetricBatch) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *MetricBatch) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_MetricBatch.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *MetricBatch) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MetricBatch.Merge(m, src)
+}
+func (m *MetricBatch) XXX_Size() int {
+	return m.Size()
+}
+func (m *MetricBatch) XXX_DiscardUnknown() {
+	xxx_messageInfo_MetricBatch.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MetricBatch proto.InternalMessageInfo
+
+func (m *MetricBatch) GetId() uint64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *MetricBatch) GetMetrics() []*metricpb.Metric {
+	if m != nil {
+		return m.Metrics
+	}
+	return nil
+}
+
+// MetricBatchAck acknowledges a MetricBatch. Rejected metrics are invalid and
+// shouldn't be sent again, while dropped metrics couldn't be ingested in time
+// and can be retried; retry holds the index of each dropped metric within the
+// batch. Rejected and dropped metrics are counted by reason. Accepted metrics
+// were taken on by the server, which doesn't mean they were delivered: a
+// global Veneur acknowledges the metrics it ingested, while veneur-proxy
+// acknowledges the metrics it accepted for forwarding, which may still be
+// lost if no global Veneur can take them.
+type MetricBatchAck struct {
+	Id       uint64            `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Accepted uint64            `protobuf:"varint,2,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	Rejected map[string]uint64 `protobuf:"bytes,3,rep,name=rejected,proto3" json:"rejected,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+	Dropped  map[string]uint64 `protobuf:"bytes,4,rep,name=dropped,proto3" json:"dropped,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+	Retry    []uint32          `protobuf:"varint,5,rep,packed,name=retry,proto3" json:"retry,omitempty"`
+}
+
+func (m *MetricBatchAck) Reset()         { *m = MetricBatchAck{} }
+func (m *MetricBatchAck) String() string { return proto.CompactTextString(m) }
+func (*MetricBatchAck) ProtoMessage()    {}
+func (*MetricBatchAck) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0f9bdf2b06f7b9ea, []int{2}
+}
+func (m *MetricBatchAck) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *MetricBatchAck) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_MetricBatchAck.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *MetricBatchAck) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MetricBatchAck.Merge(m, src)
+}
+func (m *MetricBatchAck) XXX_Size() int {
+	return m.Size()
+}
+func (m *MetricBatchAck) XXX_DiscardUnknown() {
+	xxx_messageInfo_MetricBatchAck.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MetricBatchAck proto.InternalMessageInfo
+
+func (m *MetricBatchAck) GetId() uint64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *MetricBatchAck) GetAccepted() uint64 {
+	if m != nil {
+		return m.Accepted
+	}
+	return 0
+}
+
+func (m *MetricBatchAck) GetRejected() map[string]uint64 {
+	if m != nil {
+		return m.Rejected
+	}
+	return nil
+}
+
+func (m *MetricBatchAck) GetDropped() map[string]uint64 {
+	if m != nil {
+		return m.Dropped
+	}
+	return nil
+}
+
+func (m *MetricBatchAck) GetRetry() []uint32 {
+	if m != nil {
+		return m.Retry
+	}
+	return nil
+}
+
+// SampleList wraps a list of events and service checks.
+type SampleList struct {
+	Samples []*ssf.SSFSample `protobuf:"bytes,1,rep,name=samples,proto3" json:"samples,omitempty"`
+}
+
+func (m *SampleList) Reset()         { *m = SampleList{} }
+func (m *SampleList) String() string { return proto.CompactTextString(m) }
+func (*SampleList) ProtoMessage()    {}
+func (*SampleList) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0f9bdf2b06f7b9ea, []int{3}
+}
+func (m *SampleList) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *SampleList) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_SampleList.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *SampleList) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SampleList.Merge(m, src)
+}
+func (m *SampleList) XXX_Size() int {
+	return m.Size()
+}
+func (m *SampleList) XXX_DiscardUnknown() {
+	xxx_messageInfo_SampleList.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SampleList proto.InternalMessageInfo
+
+func (m *SampleList) GetSamples() []*ssf.SSFSample {
+	if m != nil {
+		return m.Samples
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*MetricList)(nil), "forwardrpc.MetricList")
+	proto.RegisterType((*MetricBatch)(nil), "forwardrpc.MetricBatch")
+	proto.RegisterType((*MetricBatchAck)(nil), "forwardrpc.MetricBatchAck")
+	proto.RegisterMapType((map[string]uint64)(nil), "forwardrpc.MetricBatchAck.DroppedEntry")
+	proto.RegisterMapType((map[string]uint64)(nil), "forwardrpc.MetricBatchAck.RejectedEntry")
+	proto.RegisterType((*SampleList)(nil), "forwardrpc.SampleList")
 }
 
 func init() { proto.RegisterFile("forwardrpc/forward.proto", fileDescriptor_0f9bdf2b06f7b9ea) }
 
 var fileDescriptor_0f9bdf2b06f7b9ea = []byte{
-	// 219 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x92, 0x48, 0xcb, 0x2f, 0x2a,
-	0x4f, 0x2c, 0x4a, 0x29, 0x2a, 0x48, 0xd6, 0x87, 0x32, 0xf5, 0x0a, 0x8a, 0xf2, 0x4b, 0xf2, 0x85,
-	0xb8, 0x10, 0x32, 0x52, 0x72, 0xc5, 0x89, 0xb9, 0x05, 0x39, 0xa9, 0x45, 0xc5, 0xfa, 0xb9, 0xa9,
-	0x25, 0x45, 0x99, 0xc9, 0x05, 0x49, 0x50, 0x06, 0x44, 0xad, 0x94, 0x74, 0x7a, 0x7e, 0x7e, 0x7a,
-	0x4e, 0xaa, 0x3e, 0x98, 0x97, 0x54, 0x9a, 0xa6, 0x9f, 0x9a, 0x5b, 0x50, 0x52, 0x09, 0x91, 0x54,
-	0xb2, 0xe0, 0xe2, 0xf2, 0x05, 0x2b, 0xf6, 0xc9, 0x2c, 0x2e, 0x11, 0xd2, 0xe2, 0x62, 0x87, 0x68,
-	0x2d, 0x96, 0x60, 0x54, 0x60, 0xd6, 0xe0, 0x36, 0x12, 0xd0, 0x83, 0x99, 0xa9, 0x07, 0x51, 0x16,
-	0x04, 0x53, 0x60, 0xd4, 0xc9, 0xc8, 0xc5, 0xee, 0x06, 0x71, 0x85, 0x90, 0x3d, 0x17, 0x77, 0x70,
-	0x6a, 0x5e, 0x0a, 0x44, 0x49, 0xb1, 0x90, 0x98, 0x1e, 0xc2, 0x79, 0x7a, 0x08, 0xe3, 0xa5, 0xc4,
-	0xf4, 0x20, 0x4e, 0xd1, 0x83, 0x39, 0x45, 0xcf, 0x15, 0xe4, 0x14, 0x25, 0x06, 0x21, 0x5b, 0x2e,
-	0x5e, 0x24, 0x03, 0xc2, 0x8c, 0x84, 0x30, 0x2c, 0xc6, 0xad, 0x59, 0x83, 0xd1, 0x49, 0xe2, 0xc4,
-	0x23, 0x39, 0xc6, 0x0b, 0x8f, 0xe4, 0x18, 0x1f, 0x3c, 0x92, 0x63, 0x9c, 0xf0, 0x58, 0x8e, 0xe1,
-	0xc2, 0x63, 0x39, 0x86, 0x1b, 0x8f, 0xe5, 0x18, 0x92, 0xd8, 0xc0, 0xaa, 0x8d, 0x01, 0x01, 0x00,
-	0x00, 0xff, 0xff, 0x72, 0xd7, 0x3e, 0xf8, 0x4b, 0x01, 0x00, 0x00,
+	// 468 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x92, 0xcd, 0x6a, 0xdb, 0x40,
+	0x10, 0xc7, 0xbd, 0x72, 0x52, 0x27, 0xe3, 0xd8, 0x98, 0xa5, 0xa4, 0x62, 0x0b, 0xc2, 0xf8, 0x52,
+	0xd1, 0xc3, 0xaa, 0x38, 0xb4, 0x84, 0x94, 0x52, 0x12, 0x92, 0x40, 0x4b, 0x7b, 0x91, 0xa0, 0x77,
+	0x59, 0x5a, 0xa7, 0x6e, 0x6c, 0x6b, 0xd9, 0xdd, 0xb4, 0xf8, 0x2d, 0xfa, 0x58, 0xbd, 0x14, 0x72,
+	0xe8, 0xa1, 0xc7, 0x62, 0xbf, 0x48, 0xd1, 0x8e, 0xe4, 0x8f, 0x1a, 0xb9, 0xe4, 0xa6, 0xd9, 0xf9,
+	0xff, 0x7f, 0xa3, 0xf9, 0x00, 0x77, 0x98, 0xa9, 0x6f, 0xb1, 0x4a, 0x95, 0x4c, 0x82, 0xe2, 0x93,
+	0x4b, 0x95, 0x99, 0x8c, 0xc2, 0x2a, 0xc3, 0x3c, 0x1d, 0x4f, 0xe4, 0x58, 0x28, 0x1d, 0x4c, 0x84,
+	0x51, 0xa3, 0x44, 0x0e, 0x8a, 0x0f, 0xd4, 0xb2, 0x8e, 0xd6, 0xc3, 0x00, 0x35, 0xc5, 0xcb, 0xd3,
+	0x9b, 0x2c, 0xbb, 0x19, 0x8b, 0xc0, 0x46, 0x83, 0xbb, 0x61, 0x20, 0x26, 0xd2, 0xcc, 0x30, 0xd9,
+	0x3b, 0x05, 0xf8, 0x68, 0xed, 0x1f, 0x46, 0xda, 0xd0, 0xe7, 0xd0, 0x40, 0x98, 0x76, 0x49, 0xb7,
+	0xee, 0x37, 0xfb, 0x1d, 0x5e, 0x56, 0xe1, 0x28, 0x0b, 0x4b, 0x41, 0xef, 0x1d, 0x34, 0xf1, 0xe9,
+	0x22, 0x36, 0xc9, 0x67, 0xda, 0x06, 0x67, 0x94, 0xba, 0xa4, 0x4b, 0xfc, 0xbd, 0xd0, 0x19, 0xa5,
+	0xeb, 0x28, 0xe7, 0x7f, 0xa8, 0x5f, 0x0e, 0xb4, 0xd7, 0x58, 0xe7, 0xc9, 0xed, 0x16, 0x8e, 0xc1,
+	0x41, 0x9c, 0x24, 0x42, 0x1a, 0x91, 0xba, 0x8e, 0x7d, 0x5d, 0xc6, 0xf4, 0x12, 0x0e, 0x94, 0xf8,
+	0x22, 0x92, 0x3c, 0x57, 0xb7, 0xb5, 0x7c, 0xbe, 0x9a, 0x18, 0xdf, 0x24, 0xf3, 0xb0, 0x90, 0x5e,
+	0x4d, 0x8d, 0x9a, 0x85, 0x4b, 0x27, 0x3d, 0x87, 0x46, 0xaa, 0x32, 0x29, 0x45, 0xea, 0xee, 0x59,
+	0xc8, 0xb3, 0x1d, 0x90, 0x4b, 0x54, 0x22, 0xa3, 0xf4, 0xd1, 0xc7, 0xb0, 0xaf, 0x84, 0x51, 0x33,
+	0x77, 0xbf, 0x5b, 0xf7, 0x5b, 0x21, 0x06, 0xec, 0x35, 0xb4, 0x36, 0x6a, 0xd2, 0x0e, 0xd4, 0x6f,
+	0xc5, 0xcc, 0x36, 0x77, 0x18, 0xe6, 0x9f, 0xb9, 0xf1, 0x6b, 0x3c, 0xbe, 0x13, 0x45, 0x6b, 0x18,
+	0x9c, 0x39, 0xa7, 0x84, 0x9d, 0xc1, 0xd1, 0x7a, 0xad, 0x87, 0x78, 0x7b, 0xaf, 0x00, 0x22, 0x7b,
+	0x08, 0x76, 0xb7, 0x3e, 0x34, 0xf0, 0x2c, 0xca, 0xdd, 0xb6, 0xb9, 0xd6, 0x43, 0x1e, 0x45, 0xd7,
+	0x28, 0x0a, 0xcb, 0x74, 0xff, 0xa7, 0x03, 0x8d, 0x6b, 0x6c, 0x9d, 0xbe, 0x85, 0x66, 0x24, 0xa6,
+	0x29, 0xb6, 0xaf, 0xe9, 0xf1, 0xf6, 0x4c, 0x72, 0x38, 0x3b, 0xe6, 0x78, 0x64, 0xbc, 0x3c, 0x32,
+	0x7e, 0x95, 0x1f, 0x59, 0xaf, 0x46, 0xdf, 0x40, 0x6b, 0x0d, 0xf0, 0xa9, 0x4f, 0xb7, 0xee, 0xa0,
+	0xda, 0xec, 0x13, 0xfa, 0x7e, 0xd3, 0x7e, 0x42, 0x9f, 0x54, 0x6c, 0x85, 0xb1, 0xea, 0x75, 0xe5,
+	0xa4, 0x17, 0x84, 0xbe, 0x84, 0xc3, 0x9c, 0x15, 0xc9, 0x78, 0xaa, 0xe9, 0xd1, 0xb2, 0x7b, 0x19,
+	0x4f, 0x77, 0xfe, 0x42, 0x31, 0x02, 0x9c, 0xd2, 0x3f, 0x23, 0x58, 0xcd, 0xb7, 0x1a, 0x71, 0xe1,
+	0xfe, 0x98, 0x7b, 0xe4, 0x7e, 0xee, 0x91, 0x3f, 0x73, 0x8f, 0x7c, 0x5f, 0x78, 0xb5, 0xfb, 0x85,
+	0x57, 0xfb, 0xbd, 0xf0, 0x6a, 0x83, 0x47, 0x56, 0x7b, 0xf2, 0x77, 0x00, 0x29, 0xef, 0x8f, 0x11,
+	0xfb, 0x03, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -109,6 +315,17 @@ type ForwardClient interface {
 	// SendMetrics sends a batch of metrics at once, and returns no response.
 	SendMetrics(ctx context.Context, in *MetricList, opts ...grpc.CallOption) (*empty.Empty, error)
 	SendMetricsV2(ctx context.Context, opts ...grpc.CallOption) (Forward_SendMetricsV2Client, error)
+	// SendMetricsV3 streams batches of metrics, and acknowledges each batch
+	// with the number of its metrics that were accepted, rejected or dropped.
+	// Servers that don't implement it return Unimplemented, in which case
+	// clients fall back to SendMetricsV2.
+	SendMetricsV3(ctx context.Context, opts ...grpc.CallOption) (Forward_SendMetricsV3Client, error)
+	// SendSpans streams spans from a local Veneur to the global instance
+	// responsible for their trace.
+	SendSpans(ctx context.Context, opts ...grpc.CallOption) (Forward_SendSpansClient, error)
+	// SendSamples sends a batch of events and service checks collected by a
+	// local Veneur, so that they're flushed by the global tier's sinks.
+	SendSamples(ctx context.Context, in *SampleList, opts ...grpc.CallOption) (*empty.Empty, error)
 }
 
 type forwardClient struct {
@@ -162,11 +379,96 @@ func (x *forwardSendMetricsV2Client) CloseAndRecv() (*empty.Empty, error) {
 	return m, nil
 }
 
+func (c *forwardClient) SendMetricsV3(ctx context.Context, opts ...grpc.CallOption) (Forward_SendMetricsV3Client, error) {
+	stream, err := c.cc.NewStream(ctx, &_Forward_serviceDesc.Streams[1], "/forwardrpc.Forward/SendMetricsV3", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &forwardSendMetricsV3Client{stream}
+	return x, nil
+}
+
+type Forward_SendMetricsV3Client interface {
+	Send(*MetricBatch) error
+	Recv() (*MetricBatchAck, error)
+	grpc.ClientStream
+}
+
+type forwardSendMetricsV3Client struct {
+	grpc.ClientStream
+}
+
+func (x *forwardSendMetricsV3Client) Send(m *MetricBatch) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *forwardSendMetricsV3Client) Recv() (*MetricBatchAck, error) {
+	m := new(MetricBatchAck)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *forwardClient) SendSpans(ctx context.Context, opts ...grpc.CallOption) (Forward_SendSpansClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Forward_serviceDesc.Streams[2], "/forwardrpc.Forward/SendSpans", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &forwardSendSpansClient{stream}
+	return x, nil
+}
+
+type Forward_SendSpansClient interface {
+	Send(*ssf.SSFSpan) error
+	CloseAndRecv() (*empty.Empty, error)
+	grpc.ClientStream
+}
+
+type forwardSendSpansClient struct {
+	grpc.ClientStream
+}
+
+func (x *forwardSendSpansClient) Send(m *ssf.SSFSpan) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *forwardSendSpansClient) CloseAndRecv() (*empty.Empty, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(empty.Empty)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *forwardClient) SendSamples(ctx context.Context, in *SampleList, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/forwardrpc.Forward/SendSamples", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ForwardServer is the server API for Forward service.
 type ForwardServer interface {
 	// SendMetrics sends a batch of metrics at once, and returns no response.
 	SendMetrics(context.Context, *MetricList) (*empty.Empty, error)
 	SendMetricsV2(Forward_SendMetricsV2Server) error
+	// SendMetricsV3 streams batches of metrics, and acknowledges each batch
+	// with the number of its metrics that were accepted, rejected or dropped.
+	// Servers that don't implement it return Unimplemented, in which case
+	// clients fall back to SendMetricsV2.
+	SendMetricsV3(Forward_SendMetricsV3Server) error
+	// SendSpans streams spans from a local Veneur to the global instance
+	// responsible for their trace.
+	SendSpans(Forward_SendSpansServer) error
+	// SendSamples sends a batch of events and service checks collected by a
+	// local Veneur, so that they're flushed by the global tier's sinks.
+	SendSamples(context.Context, *SampleList) (*empty.Empty, error)
 }
 
 func RegisterForwardServer(s *grpc.Server, srv ForwardServer) {
@@ -217,6 +519,76 @@ func (x *forwardSendMetricsV2Server) Recv() (*metricpb.Metric, error) {
 	return m, nil
 }
 
+func _Forward_SendMetricsV3_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ForwardServer).SendMetricsV3(&forwardSendMetricsV3Server{stream})
+}
+
+type Forward_SendMetricsV3Server interface {
+	Send(*MetricBatchAck) error
+	Recv() (*MetricBatch, error)
+	grpc.ServerStream
+}
+
+type forwardSendMetricsV3Server struct {
+	grpc.ServerStream
+}
+
+func (x *forwardSendMetricsV3Server) Send(m *MetricBatchAck) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *forwardSendMetricsV3Server) Recv() (*MetricBatch, error) {
+	m := new(MetricBatch)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Forward_SendSpans_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ForwardServer).SendSpans(&forwardSendSpansServer{stream})
+}
+
+type Forward_SendSpansServer interface {
+	SendAndClose(*empty.Empty) error
+	Recv() (*ssf.SSFSpan, error)
+	grpc.ServerStream
+}
+
+type forwardSendSpansServer struct {
+	grpc.ServerStream
+}
+
+func (x *forwardSendSpansServer) SendAndClose(m *empty.Empty) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *forwardSendSpansServer) Recv() (*ssf.SSFSpan, error) {
+	m := new(ssf.SSFSpan)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Forward_SendSamples_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SampleList)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ForwardServer).SendSamples(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/forwardrpc.Forward/SendSamples",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ForwardServer).SendSamples(ctx, req.(*SampleList))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Forward_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "forwardrpc.Forward",
 	HandlerType: (*ForwardServer)(nil),
@@ -225,6 +597,10 @@ var _Forward_serviceDesc = grpc.ServiceDesc{
 			MethodName: "SendMetrics",
 			Handler:    _Forward_SendMetrics_Handler,
 		},
+		{
+			MethodName: "SendSamples",
+			Handler:    _Forward_SendSamples_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -232,6 +608,17 @@ var _Forward_serviceDesc = grpc.ServiceDesc{
 			Handler:       _Forward_SendMetricsV2_Handler,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "SendMetricsV3",
+			Handler:       _Forward_SendMetricsV3_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "SendSpans",
+			Handler:       _Forward_SendSpans_Handler,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "forwardrpc/forward.proto",
 }
@@ -266,41 +653,254 @@ func (m *MetricList) MarshalTo(dAtA []byte) (int, error) {
 	return i, nil
 }
 
-func encodeVarintForward(dAtA []byte, offset int, v uint64) int {
-	for v >= 1<<7 {
-		dAtA[offset] = uint8(v&0x7f | 0x80)
-		v >>= 7
-		offset++
+func (m *MetricBatch) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
 	}
-	dAtA[offset] = uint8(v)
-	return offset + 1
+	return dAtA[:n], nil
 }
-func (m *MetricList) Size() (n int) {
-	if m == nil {
-		return 0
-	}
+
+func (m *MetricBatch) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
 	var l int
 	_ = l
+	if m.Id != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintForward(dAtA, i, uint64(m.Id))
+	}
 	if len(m.Metrics) > 0 {
-		for _, e := range m.Metrics {
-			l = e.Size()
-			n += 1 + l + sovForward(uint64(l))
+		for _, msg := range m.Metrics {
+			dAtA[i] = 0x12
+			i++
+			i = encodeVarintForward(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
 		}
 	}
-	return n
+	return i, nil
 }
 
-func sovForward(x uint64) (n int) {
-	for {
-		n++
-		x >>= 7
-		if x == 0 {
-			break
-		}
-	}
-	return n
-}
-func sozForward(x uint64) (n int) {
+func (m *MetricBatchAck) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *MetricBatchAck) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.Id != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintForward(dAtA, i, uint64(m.Id))
+	}
+	if m.Accepted != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintForward(dAtA, i, uint64(m.Accepted))
+	}
+	if len(m.Rejected) > 0 {
+		for k, _ := range m.Rejected {
+			dAtA[i] = 0x1a
+			i++
+			v := m.Rejected[k]
+			mapSize := 1 + len(k) + sovForward(uint64(len(k))) + 1 + sovForward(uint64(v))
+			i = encodeVarintForward(dAtA, i, uint64(mapSize))
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintForward(dAtA, i, uint64(len(k)))
+			i += copy(dAtA[i:], k)
+			dAtA[i] = 0x10
+			i++
+			i = encodeVarintForward(dAtA, i, uint64(v))
+		}
+	}
+	if len(m.Dropped) > 0 {
+		for k, _ := range m.Dropped {
+			dAtA[i] = 0x22
+			i++
+			v := m.Dropped[k]
+			mapSize := 1 + len(k) + sovForward(uint64(len(k))) + 1 + sovForward(uint64(v))
+			i = encodeVarintForward(dAtA, i, uint64(mapSize))
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintForward(dAtA, i, uint64(len(k)))
+			i += copy(dAtA[i:], k)
+			dAtA[i] = 0x10
+			i++
+			i = encodeVarintForward(dAtA, i, uint64(v))
+		}
+	}
+	if len(m.Retry) > 0 {
+		dAtA2 := make([]byte, len(m.Retry)*10)
+		var j1 int
+		for _, num := range m.Retry {
+			for num >= 1<<7 {
+				dAtA2[j1] = uint8(uint64(num)&0x7f | 0x80)
+				num >>= 7
+				j1++
+			}
+			dAtA2[j1] = uint8(num)
+			j1++
+		}
+		dAtA[i] = 0x2a
+		i++
+		i = encodeVarintForward(dAtA, i, uint64(j1))
+		i += copy(dAtA[i:], dAtA2[:j1])
+	}
+	return i, nil
+}
+
+func (m *SampleList) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *SampleList) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Samples) > 0 {
+		for _, msg := range m.Samples {
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintForward(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	return i, nil
+}
+
+func encodeVarintForward(dAtA []byte, offset int, v uint64) int {
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return offset + 1
+}
+func (m *MetricList) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if len(m.Metrics) > 0 {
+		for _, e := range m.Metrics {
+			l = e.Size()
+			n += 1 + l + sovForward(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *MetricBatch) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Id != 0 {
+		n += 1 + sovForward(uint64(m.Id))
+	}
+	if len(m.Metrics) > 0 {
+		for _, e := range m.Metrics {
+			l = e.Size()
+			n += 1 + l + sovForward(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *MetricBatchAck) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Id != 0 {
+		n += 1 + sovForward(uint64(m.Id))
+	}
+	if m.Accepted != 0 {
+		n += 1 + sovForward(uint64(m.Accepted))
+	}
+	if len(m.Rejected) > 0 {
+		for k, v := range m.Rejected {
+			_ = k
+			_ = v
+			mapEntrySize := 1 + len(k) + sovForward(uint64(len(k))) + 1 + sovForward(uint64(v))
+			n += mapEntrySize + 1 + sovForward(uint64(mapEntrySize))
+		}
+	}
+	if len(m.Dropped) > 0 {
+		for k, v := range m.Dropped {
+			_ = k
+			_ = v
+			mapEntrySize := 1 + len(k) + sovForward(uint64(len(k))) + 1 + sovForward(uint64(v))
+			n += mapEntrySize + 1 + sovForward(uint64(mapEntrySize))
+		}
+	}
+	if len(m.Retry) > 0 {
+		l = 0
+		for _, e := range m.Retry {
+			l += sovForward(uint64(e))
+		}
+		n += 1 + sovForward(uint64(l)) + l
+	}
+	return n
+}
+
+func (m *SampleList) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if len(m.Samples) > 0 {
+		for _, e := range m.Samples {
+			l = e.Size()
+			n += 1 + l + sovForward(uint64(l))
+		}
+	}
+	return n
+}
+
+func sovForward(x uint64) (n int) {
+	for {
+		n++
+		x >>= 7
+		if x == 0 {
+			break
+		}
+	}
+	return n
+}
+func sozForward(x uint64) (n int) {
 	return sovForward(uint64((x << 1) ^ uint64((int64(x) >> 63))))
 }
 func (m *MetricList) Unmarshal(dAtA []byte) error {
@@ -390,6 +990,592 @@ func (m *MetricList) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *MetricBatch) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowForward
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: MetricBatch: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: MetricBatch: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Id", wireType)
+			}
+			m.Id = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowForward
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Id |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Metrics", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowForward
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthForward
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthForward
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Metrics = append(m.Metrics, &metricpb.Metric{})
+			if err := m.Metrics[len(m.Metrics)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipForward(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthForward
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthForward
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *MetricBatchAck) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowForward
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: MetricBatchAck: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: MetricBatchAck: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Id", wireType)
+			}
+			m.Id = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowForward
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Id |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Accepted", wireType)
+			}
+			m.Accepted = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowForward
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Accepted |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Rejected", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowForward
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthForward
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthForward
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Rejected == nil {
+				m.Rejected = make(map[string]uint64)
+			}
+			var mapkey string
+			var mapvalue uint64
+			for iNdEx < postIndex {
+				entryPreIndex := iNdEx
+				var wire uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowForward
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					wire |= uint64(b&0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				fieldNum := int32(wire >> 3)
+				if fieldNum == 1 {
+					var stringLenmapkey uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowForward
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapkey |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapkey := int(stringLenmapkey)
+					if intStringLenmapkey < 0 {
+						return ErrInvalidLengthForward
+					}
+					postStringIndexmapkey := iNdEx + intStringLenmapkey
+					if postStringIndexmapkey < 0 {
+						return ErrInvalidLengthForward
+					}
+					if postStringIndexmapkey > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapkey = string(dAtA[iNdEx:postStringIndexmapkey])
+					iNdEx = postStringIndexmapkey
+				} else if fieldNum == 2 {
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowForward
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						mapvalue |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+				} else {
+					iNdEx = entryPreIndex
+					skippy, err := skipForward(dAtA[iNdEx:])
+					if err != nil {
+						return err
+					}
+					if skippy < 0 {
+						return ErrInvalidLengthForward
+					}
+					if (iNdEx + skippy) > postIndex {
+						return io.ErrUnexpectedEOF
+					}
+					iNdEx += skippy
+				}
+			}
+			m.Rejected[mapkey] = mapvalue
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Dropped", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowForward
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthForward
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthForward
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Dropped == nil {
+				m.Dropped = make(map[string]uint64)
+			}
+			var mapkey string
+			var mapvalue uint64
+			for iNdEx < postIndex {
+				entryPreIndex := iNdEx
+				var wire uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowForward
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					wire |= uint64(b&0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				fieldNum := int32(wire >> 3)
+				if fieldNum == 1 {
+					var stringLenmapkey uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowForward
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapkey |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapkey := int(stringLenmapkey)
+					if intStringLenmapkey < 0 {
+						return ErrInvalidLengthForward
+					}
+					postStringIndexmapkey := iNdEx + intStringLenmapkey
+					if postStringIndexmapkey < 0 {
+						return ErrInvalidLengthForward
+					}
+					if postStringIndexmapkey > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapkey = string(dAtA[iNdEx:postStringIndexmapkey])
+					iNdEx = postStringIndexmapkey
+				} else if fieldNum == 2 {
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowForward
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						mapvalue |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+				} else {
+					iNdEx = entryPreIndex
+					skippy, err := skipForward(dAtA[iNdEx:])
+					if err != nil {
+						return err
+					}
+					if skippy < 0 {
+						return ErrInvalidLengthForward
+					}
+					if (iNdEx + skippy) > postIndex {
+						return io.ErrUnexpectedEOF
+					}
+					iNdEx += skippy
+				}
+			}
+			m.Dropped[mapkey] = mapvalue
+			iNdEx = postIndex
+		case 5:
+			if wireType == 0 {
+				var v uint32
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowForward
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					v |= uint32(b&0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				m.Retry = append(m.Retry, v)
+			} else if wireType == 2 {
+				var packedLen int
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowForward
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					packedLen |= int(b&0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				if packedLen < 0 {
+					return ErrInvalidLengthForward
+				}
+				postIndex := iNdEx + packedLen
+				if postIndex < 0 {
+					return ErrInvalidLengthForward
+				}
+				if postIndex > l {
+					return io.ErrUnexpectedEOF
+				}
+				var elementCount int
+				var count int
+				for _, integer := range dAtA[iNdEx:postIndex] {
+					if integer < 128 {
+						count++
+					}
+				}
+				elementCount = count
+				if elementCount != 0 && len(m.Retry) == 0 {
+					m.Retry = make([]uint32, 0, elementCount)
+				}
+				for iNdEx < postIndex {
+					var v uint32
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowForward
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						v |= uint32(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					m.Retry = append(m.Retry, v)
+				}
+			} else {
+				return fmt.Errorf("proto: wrong wireType = %d for field Retry", wireType)
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipForward(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthForward
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthForward
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *SampleList) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowForward
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: SampleList: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: SampleList: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Samples", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowForward
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthForward
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthForward
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Samples = append(m.Samples, &ssf.SSFSample{})
+			if err := m.Samples[len(m.Samples)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipForward(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthForward
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthForward
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func skipForward(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
@@ -11,6 +11,7 @@ import (
 	gomock "github.com/golang/mock/gomock"
 	empty "github.com/golang/protobuf/ptypes/empty"
 	metricpb "github.com/stripe/veneur/v14/samplers/metricpb"
+	ssf "github.com/stripe/veneur/v14/ssf"
 	grpc "google.golang.org/grpc"
 	metadata "google.golang.org/grpc/metadata"
 )
@@ -78,6 +79,66 @@ func (mr *MockForwardClientMockRecorder) SendMetricsV2(ctx interface{}, opts ...
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMetricsV2", reflect.TypeOf((*MockForwardClient)(nil).SendMetricsV2), varargs...)
 }
 
+// SendMetricsV3 mocks base method.
+func (m *MockForwardClient) SendMetricsV3(ctx context.Context, opts ...grpc.CallOption) (Forward_SendMetricsV3Client, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "SendMetricsV3", varargs...)
+	ret0, _ := ret[0].(Forward_SendMetricsV3Client)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SendMetricsV3 indicates an expected call of SendMetricsV3.
+func (mr *MockForwardClientMockRecorder) SendMetricsV3(ctx interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMetricsV3", reflect.TypeOf((*MockForwardClient)(nil).SendMetricsV3), varargs...)
+}
+
+// SendSamples mocks base method.
+func (m *MockForwardClient) SendSamples(ctx context.Context, in *SampleList, opts ...grpc.CallOption) (*empty.Empty, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "SendSamples", varargs...)
+	ret0, _ := ret[0].(*empty.Empty)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SendSamples indicates an expected call of SendSamples.
+func (mr *MockForwardClientMockRecorder) SendSamples(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendSamples", reflect.TypeOf((*MockForwardClient)(nil).SendSamples), varargs...)
+}
+
+// SendSpans mocks base method.
+func (m *MockForwardClient) SendSpans(ctx context.Context, opts ...grpc.CallOption) (Forward_SendSpansClient, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "SendSpans", varargs...)
+	ret0, _ := ret[0].(Forward_SendSpansClient)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SendSpans indicates an expected call of SendSpans.
+func (mr *MockForwardClientMockRecorder) SendSpans(ctx interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendSpans", reflect.TypeOf((*MockForwardClient)(nil).SendSpans), varargs...)
+}
+
 // MockForward_SendMetricsV2Client is a mock of Forward_SendMetricsV2Client interface.
 type MockForward_SendMetricsV2Client struct {
 	ctrl     *gomock.Controller
@@ -215,6 +276,280 @@ func (mr *MockForward_SendMetricsV2ClientMockRecorder) Trailer() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Trailer", reflect.TypeOf((*MockForward_SendMetricsV2Client)(nil).Trailer))
 }
 
+// MockForward_SendMetricsV3Client is a mock of Forward_SendMetricsV3Client interface.
+type MockForward_SendMetricsV3Client struct {
+	ctrl     *gomock.Controller
+	recorder *MockForward_SendMetricsV3ClientMockRecorder
+}
+
+// MockForward_SendMetricsV3ClientMockRecorder is the mock recorder for MockForward_SendMetricsV3Client.
+type MockForward_SendMetricsV3ClientMockRecorder struct {
+	mock *MockForward_SendMetricsV3Client
+}
+
+// NewMockForward_SendMetricsV3Client creates a new mock instance.
+func NewMockForward_SendMetricsV3Client(ctrl *gomock.Controller) *MockForward_SendMetricsV3Client {
+	mock := &MockForward_SendMetricsV3Client{ctrl: ctrl}
+	mock.recorder = &MockForward_SendMetricsV3ClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockForward_SendMetricsV3Client) EXPECT() *MockForward_SendMetricsV3ClientMockRecorder {
+	return m.recorder
+}
+
+// CloseSend mocks base method.
+func (m *MockForward_SendMetricsV3Client) CloseSend() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CloseSend")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CloseSend indicates an expected call of CloseSend.
+func (mr *MockForward_SendMetricsV3ClientMockRecorder) CloseSend() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloseSend", reflect.TypeOf((*MockForward_SendMetricsV3Client)(nil).CloseSend))
+}
+
+// Context mocks base method.
+func (m *MockForward_SendMetricsV3Client) Context() context.Context {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Context")
+	ret0, _ := ret[0].(context.Context)
+	return ret0
+}
+
+// Context indicates an expected call of Context.
+func (mr *MockForward_SendMetricsV3ClientMockRecorder) Context() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Context", reflect.TypeOf((*MockForward_SendMetricsV3Client)(nil).Context))
+}
+
+// Header mocks base method.
+func (m *MockForward_SendMetricsV3Client) Header() (metadata.MD, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Header")
+	ret0, _ := ret[0].(metadata.MD)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Header indicates an expected call of Header.
+func (mr *MockForward_SendMetricsV3ClientMockRecorder) Header() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Header", reflect.TypeOf((*MockForward_SendMetricsV3Client)(nil).Header))
+}
+
+// Recv mocks base method.
+func (m *MockForward_SendMetricsV3Client) Recv() (*MetricBatchAck, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Recv")
+	ret0, _ := ret[0].(*MetricBatchAck)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Recv indicates an expected call of Recv.
+func (mr *MockForward_SendMetricsV3ClientMockRecorder) Recv() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Recv", reflect.TypeOf((*MockForward_SendMetricsV3Client)(nil).Recv))
+}
+
+// RecvMsg mocks base method.
+func (m_2 *MockForward_SendMetricsV3Client) RecvMsg(m interface{}) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "RecvMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecvMsg indicates an expected call of RecvMsg.
+func (mr *MockForward_SendMetricsV3ClientMockRecorder) RecvMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecvMsg", reflect.TypeOf((*MockForward_SendMetricsV3Client)(nil).RecvMsg), m)
+}
+
+// Send mocks base method.
+func (m *MockForward_SendMetricsV3Client) Send(arg0 *MetricBatch) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Send", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Send indicates an expected call of Send.
+func (mr *MockForward_SendMetricsV3ClientMockRecorder) Send(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Send", reflect.TypeOf((*MockForward_SendMetricsV3Client)(nil).Send), arg0)
+}
+
+// SendMsg mocks base method.
+func (m_2 *MockForward_SendMetricsV3Client) SendMsg(m interface{}) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "SendMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMsg indicates an expected call of SendMsg.
+func (mr *MockForward_SendMetricsV3ClientMockRecorder) SendMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMsg", reflect.TypeOf((*MockForward_SendMetricsV3Client)(nil).SendMsg), m)
+}
+
+// Trailer mocks base method.
+func (m *MockForward_SendMetricsV3Client) Trailer() metadata.MD {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Trailer")
+	ret0, _ := ret[0].(metadata.MD)
+	return ret0
+}
+
+// Trailer indicates an expected call of Trailer.
+func (mr *MockForward_SendMetricsV3ClientMockRecorder) Trailer() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Trailer", reflect.TypeOf((*MockForward_SendMetricsV3Client)(nil).Trailer))
+}
+
+// MockForward_SendSpansClient is a mock of Forward_SendSpansClient interface.
+type MockForward_SendSpansClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockForward_SendSpansClientMockRecorder
+}
+
+// MockForward_SendSpansClientMockRecorder is the mock recorder for MockForward_SendSpansClient.
+type MockForward_SendSpansClientMockRecorder struct {
+	mock *MockForward_SendSpansClient
+}
+
+// NewMockForward_SendSpansClient creates a new mock instance.
+func NewMockForward_SendSpansClient(ctrl *gomock.Controller) *MockForward_SendSpansClient {
+	mock := &MockForward_SendSpansClient{ctrl: ctrl}
+	mock.recorder = &MockForward_SendSpansClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockForward_SendSpansClient) EXPECT() *MockForward_SendSpansClientMockRecorder {
+	return m.recorder
+}
+
+// CloseAndRecv mocks base method.
+func (m *MockForward_SendSpansClient) CloseAndRecv() (*empty.Empty, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CloseAndRecv")
+	ret0, _ := ret[0].(*empty.Empty)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CloseAndRecv indicates an expected call of CloseAndRecv.
+func (mr *MockForward_SendSpansClientMockRecorder) CloseAndRecv() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloseAndRecv", reflect.TypeOf((*MockForward_SendSpansClient)(nil).CloseAndRecv))
+}
+
+// CloseSend mocks base method.
+func (m *MockForward_SendSpansClient) CloseSend() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CloseSend")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CloseSend indicates an expected call of CloseSend.
+func (mr *MockForward_SendSpansClientMockRecorder) CloseSend() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloseSend", reflect.TypeOf((*MockForward_SendSpansClient)(nil).CloseSend))
+}
+
+// Context mocks base method.
+func (m *MockForward_SendSpansClient) Context() context.Context {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Context")
+	ret0, _ := ret[0].(context.Context)
+	return ret0
+}
+
+// Context indicates an expected call of Context.
+func (mr *MockForward_SendSpansClientMockRecorder) Context() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Context", reflect.TypeOf((*MockForward_SendSpansClient)(nil).Context))
+}
+
+// Header mocks base method.
+func (m *MockForward_SendSpansClient) Header() (metadata.MD, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Header")
+	ret0, _ := ret[0].(metadata.MD)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Header indicates an expected call of Header.
+func (mr *MockForward_SendSpansClientMockRecorder) Header() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Header", reflect.TypeOf((*MockForward_SendSpansClient)(nil).Header))
+}
+
+// RecvMsg mocks base method.
+func (m_2 *MockForward_SendSpansClient) RecvMsg(m interface{}) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "RecvMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecvMsg indicates an expected call of RecvMsg.
+func (mr *MockForward_SendSpansClientMockRecorder) RecvMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecvMsg", reflect.TypeOf((*MockForward_SendSpansClient)(nil).RecvMsg), m)
+}
+
+// Send mocks base method.
+func (m *MockForward_SendSpansClient) Send(arg0 *ssf.SSFSpan) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Send", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Send indicates an expected call of Send.
+func (mr *MockForward_SendSpansClientMockRecorder) Send(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Send", reflect.TypeOf((*MockForward_SendSpansClient)(nil).Send), arg0)
+}
+
+// SendMsg mocks base method.
+func (m_2 *MockForward_SendSpansClient) SendMsg(m interface{}) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "SendMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMsg indicates an expected call of SendMsg.
+func (mr *MockForward_SendSpansClientMockRecorder) SendMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMsg", reflect.TypeOf((*MockForward_SendSpansClient)(nil).SendMsg), m)
+}
+
+// Trailer mocks base method.
+func (m *MockForward_SendSpansClient) Trailer() metadata.MD {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Trailer")
+	ret0, _ := ret[0].(metadata.MD)
+	return ret0
+}
+
+// Trailer indicates an expected call of Trailer.
+func (mr *MockForward_SendSpansClientMockRecorder) Trailer() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Trailer", reflect.TypeOf((*MockForward_SendSpansClient)(nil).Trailer))
+}
+
 // MockForwardServer is a mock of ForwardServer interface.
 type MockForwardServer struct {
 	ctrl     *gomock.Controller
@@ -267,6 +602,49 @@ func (mr *MockForwardServerMockRecorder) SendMetricsV2(arg0 interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMetricsV2", reflect.TypeOf((*MockForwardServer)(nil).SendMetricsV2), arg0)
 }
 
+// SendMetricsV3 mocks base method.
+func (m *MockForwardServer) SendMetricsV3(arg0 Forward_SendMetricsV3Server) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendMetricsV3", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMetricsV3 indicates an expected call of SendMetricsV3.
+func (mr *MockForwardServerMockRecorder) SendMetricsV3(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMetricsV3", reflect.TypeOf((*MockForwardServer)(nil).SendMetricsV3), arg0)
+}
+
+// SendSamples mocks base method.
+func (m *MockForwardServer) SendSamples(arg0 context.Context, arg1 *SampleList) (*empty.Empty, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendSamples", arg0, arg1)
+	ret0, _ := ret[0].(*empty.Empty)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SendSamples indicates an expected call of SendSamples.
+func (mr *MockForwardServerMockRecorder) SendSamples(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendSamples", reflect.TypeOf((*MockForwardServer)(nil).SendSamples), arg0, arg1)
+}
+
+// SendSpans mocks base method.
+func (m *MockForwardServer) SendSpans(arg0 Forward_SendSpansServer) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendSpans", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendSpans indicates an expected call of SendSpans.
+func (mr *MockForwardServerMockRecorder) SendSpans(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendSpans", reflect.TypeOf((*MockForwardServer)(nil).SendSpans), arg0)
+}
+
 // MockForward_SendMetricsV2Server is a mock of Forward_SendMetricsV2Server interface.
 type MockForward_SendMetricsV2Server struct {
 	ctrl     *gomock.Controller
@@ -400,3 +778,271 @@ func (mr *MockForward_SendMetricsV2ServerMockRecorder) SetTrailer(arg0 interface
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTrailer", reflect.TypeOf((*MockForward_SendMetricsV2Server)(nil).SetTrailer), arg0)
 }
+
+// MockForward_SendMetricsV3Server is a mock of Forward_SendMetricsV3Server interface.
+type MockForward_SendMetricsV3Server struct {
+	ctrl     *gomock.Controller
+	recorder *MockForward_SendMetricsV3ServerMockRecorder
+}
+
+// MockForward_SendMetricsV3ServerMockRecorder is the mock recorder for MockForward_SendMetricsV3Server.
+type MockForward_SendMetricsV3ServerMockRecorder struct {
+	mock *MockForward_SendMetricsV3Server
+}
+
+// NewMockForward_SendMetricsV3Server creates a new mock instance.
+func NewMockForward_SendMetricsV3Server(ctrl *gomock.Controller) *MockForward_SendMetricsV3Server {
+	mock := &MockForward_SendMetricsV3Server{ctrl: ctrl}
+	mock.recorder = &MockForward_SendMetricsV3ServerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockForward_SendMetricsV3Server) EXPECT() *MockForward_SendMetricsV3ServerMockRecorder {
+	return m.recorder
+}
+
+// Context mocks base method.
+func (m *MockForward_SendMetricsV3Server) Context() context.Context {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Context")
+	ret0, _ := ret[0].(context.Context)
+	return ret0
+}
+
+// Context indicates an expected call of Context.
+func (mr *MockForward_SendMetricsV3ServerMockRecorder) Context() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Context", reflect.TypeOf((*MockForward_SendMetricsV3Server)(nil).Context))
+}
+
+// Recv mocks base method.
+func (m *MockForward_SendMetricsV3Server) Recv() (*MetricBatch, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Recv")
+	ret0, _ := ret[0].(*MetricBatch)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Recv indicates an expected call of Recv.
+func (mr *MockForward_SendMetricsV3ServerMockRecorder) Recv() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Recv", reflect.TypeOf((*MockForward_SendMetricsV3Server)(nil).Recv))
+}
+
+// RecvMsg mocks base method.
+func (m_2 *MockForward_SendMetricsV3Server) RecvMsg(m interface{}) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "RecvMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecvMsg indicates an expected call of RecvMsg.
+func (mr *MockForward_SendMetricsV3ServerMockRecorder) RecvMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecvMsg", reflect.TypeOf((*MockForward_SendMetricsV3Server)(nil).RecvMsg), m)
+}
+
+// Send mocks base method.
+func (m *MockForward_SendMetricsV3Server) Send(arg0 *MetricBatchAck) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Send", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Send indicates an expected call of Send.
+func (mr *MockForward_SendMetricsV3ServerMockRecorder) Send(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Send", reflect.TypeOf((*MockForward_SendMetricsV3Server)(nil).Send), arg0)
+}
+
+// SendHeader mocks base method.
+func (m *MockForward_SendMetricsV3Server) SendHeader(arg0 metadata.MD) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendHeader", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendHeader indicates an expected call of SendHeader.
+func (mr *MockForward_SendMetricsV3ServerMockRecorder) SendHeader(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendHeader", reflect.TypeOf((*MockForward_SendMetricsV3Server)(nil).SendHeader), arg0)
+}
+
+// SendMsg mocks base method.
+func (m_2 *MockForward_SendMetricsV3Server) SendMsg(m interface{}) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "SendMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMsg indicates an expected call of SendMsg.
+func (mr *MockForward_SendMetricsV3ServerMockRecorder) SendMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMsg", reflect.TypeOf((*MockForward_SendMetricsV3Server)(nil).SendMsg), m)
+}
+
+// SetHeader mocks base method.
+func (m *MockForward_SendMetricsV3Server) SetHeader(arg0 metadata.MD) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetHeader", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetHeader indicates an expected call of SetHeader.
+func (mr *MockForward_SendMetricsV3ServerMockRecorder) SetHeader(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetHeader", reflect.TypeOf((*MockForward_SendMetricsV3Server)(nil).SetHeader), arg0)
+}
+
+// SetTrailer mocks base method.
+func (m *MockForward_SendMetricsV3Server) SetTrailer(arg0 metadata.MD) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetTrailer", arg0)
+}
+
+// SetTrailer indicates an expected call of SetTrailer.
+func (mr *MockForward_SendMetricsV3ServerMockRecorder) SetTrailer(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTrailer", reflect.TypeOf((*MockForward_SendMetricsV3Server)(nil).SetTrailer), arg0)
+}
+
+// MockForward_SendSpansServer is a mock of Forward_SendSpansServer interface.
+type MockForward_SendSpansServer struct {
+	ctrl     *gomock.Controller
+	recorder *MockForward_SendSpansServerMockRecorder
+}
+
+// MockForward_SendSpansServerMockRecorder is the mock recorder for MockForward_SendSpansServer.
+type MockForward_SendSpansServerMockRecorder struct {
+	mock *MockForward_SendSpansServer
+}
+
+// NewMockForward_SendSpansServer creates a new mock instance.
+func NewMockForward_SendSpansServer(ctrl *gomock.Controller) *MockForward_SendSpansServer {
+	mock := &MockForward_SendSpansServer{ctrl: ctrl}
+	mock.recorder = &MockForward_SendSpansServerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockForward_SendSpansServer) EXPECT() *MockForward_SendSpansServerMockRecorder {
+	return m.recorder
+}
+
+// Context mocks base method.
+func (m *MockForward_SendSpansServer) Context() context.Context {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Context")
+	ret0, _ := ret[0].(context.Context)
+	return ret0
+}
+
+// Context indicates an expected call of Context.
+func (mr *MockForward_SendSpansServerMockRecorder) Context() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Context", reflect.TypeOf((*MockForward_SendSpansServer)(nil).Context))
+}
+
+// Recv mocks base method.
+func (m *MockForward_SendSpansServer) Recv() (*ssf.SSFSpan, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Recv")
+	ret0, _ := ret[0].(*ssf.SSFSpan)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Recv indicates an expected call of Recv.
+func (mr *MockForward_SendSpansServerMockRecorder) Recv() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Recv", reflect.TypeOf((*MockForward_SendSpansServer)(nil).Recv))
+}
+
+// RecvMsg mocks base method.
+func (m_2 *MockForward_SendSpansServer) RecvMsg(m interface{}) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "RecvMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecvMsg indicates an expected call of RecvMsg.
+func (mr *MockForward_SendSpansServerMockRecorder) RecvMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecvMsg", reflect.TypeOf((*MockForward_SendSpansServer)(nil).RecvMsg), m)
+}
+
+// SendAndClose mocks base method.
+func (m *MockForward_SendSpansServer) SendAndClose(arg0 *empty.Empty) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendAndClose", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendAndClose indicates an expected call of SendAndClose.
+func (mr *MockForward_SendSpansServerMockRecorder) SendAndClose(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendAndClose", reflect.TypeOf((*MockForward_SendSpansServer)(nil).SendAndClose), arg0)
+}
+
+// SendHeader mocks base method.
+func (m *MockForward_SendSpansServer) SendHeader(arg0 metadata.MD) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendHeader", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendHeader indicates an expected call of SendHeader.
+func (mr *MockForward_SendSpansServerMockRecorder) SendHeader(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendHeader", reflect.TypeOf((*MockForward_SendSpansServer)(nil).SendHeader), arg0)
+}
+
+// SendMsg mocks base method.
+func (m_2 *MockForward_SendSpansServer) SendMsg(m interface{}) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "SendMsg", m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMsg indicates an expected call of SendMsg.
+func (mr *MockForward_SendSpansServerMockRecorder) SendMsg(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMsg", reflect.TypeOf((*MockForward_SendSpansServer)(nil).SendMsg), m)
+}
+
+// SetHeader mocks base method.
+func (m *MockForward_SendSpansServer) SetHeader(arg0 metadata.MD) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetHeader", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetHeader indicates an expected call of SetHeader.
+func (mr *MockForward_SendSpansServerMockRecorder) SetHeader(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetHeader", reflect.TypeOf((*MockForward_SendSpansServer)(nil).SetHeader), arg0)
+}
+
+// SetTrailer mocks base method.
+func (m *MockForward_SendSpansServer) SetTrailer(arg0 metadata.MD) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetTrailer", arg0)
+}
+
+// SetTrailer indicates an expected call of SetTrailer.
+func (mr *MockForward_SendSpansServerMockRecorder) SetTrailer(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTrailer", reflect.TypeOf((*MockForward_SendSpansServer)(nil).SetTrailer), arg0)
+}
@@ -13,18 +13,33 @@ import (
 
 	"github.com/stripe/veneur/v14/forwardrpc"
 	"github.com/stripe/veneur/v14/samplers/metricpb"
+	"github.com/stripe/veneur/v14/ssf"
 )
 
 // SendMetricHandler is a handler that is called when a Server gets a
 // SendMetrics RPC
 type SendMetricHandler func([]*metricpb.Metric)
 
+// SendSpanHandler is a handler that is called when a Server gets a SendSpans
+// RPC
+type SendSpanHandler func([]*ssf.SSFSpan)
+
+// SendSampleHandler is a handler that is called when a Server gets a
+// SendSamples RPC
+type SendSampleHandler func([]*ssf.SSFSample)
+
 // Server is a gRPC server similar to httptest.Server
 type Server struct {
 	*grpc.Server
 	lis      net.Listener
 	handler  SendMetricHandler
 	startMtx sync.Mutex
+
+	// SpanHandler, if set, is called with the spans of each SendSpans RPC
+	SpanHandler SendSpanHandler
+	// SampleHandler, if set, is called with the samples of each SendSamples
+	// RPC
+	SampleHandler SendSampleHandler
 }
 
 // NewServer creates an unstarted Server with the specified handler
@@ -92,3 +107,54 @@ func (s *Server) SendMetricsV2(
 	})
 	return err
 }
+
+// SendMetricsV3 calls the input SendMetricsHandler with the metrics of each
+// batch it receives, and acknowledges all of them as accepted.
+func (s *Server) SendMetricsV3(
+	server forwardrpc.Forward_SendMetricsV3Server,
+) error {
+	for {
+		batch, err := server.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if len(batch.Metrics) > 0 {
+			s.handler(batch.Metrics)
+		}
+		err = server.Send(&forwardrpc.MetricBatchAck{
+			Id:       batch.Id,
+			Accepted: uint64(len(batch.Metrics)),
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) SendSpans(server forwardrpc.Forward_SendSpansServer) error {
+	spans := []*ssf.SSFSpan{}
+	for {
+		span, err := server.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		spans = append(spans, span)
+	}
+	if s.SpanHandler != nil {
+		s.SpanHandler(spans)
+	}
+	return server.SendAndClose(&empty.Empty{})
+}
+
+func (s *Server) SendSamples(
+	ctx context.Context, sampleList *forwardrpc.SampleList,
+) (*empty.Empty, error) {
+	if s.SampleHandler != nil {
+		s.SampleHandler(sampleList.Samples)
+	}
+	return &empty.Empty{}, nil
+}
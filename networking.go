@@ -364,7 +364,7 @@ func (source *GrpcMetricsSource) startGRPCTCP(
 	var grpcServer *grpc.Server
 	mode := "unencrypted"
 	if s.tlsConfig != nil {
-		tlsCreds := credentials.NewTLS(s.tlsConfig)
+		tlsCreds := credentials.NewTLS(s.tlsReloader.GRPCServerConfig())
 		if s.tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert {
 			mode = "authenticated"
 		} else {
@@ -2,9 +2,13 @@ package veneur
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/url"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -16,6 +20,7 @@ import (
 	"github.com/stripe/veneur/v14/protocol"
 	"github.com/stripe/veneur/v14/protocol/dogstatsd"
 	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/util/tlsconfig"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health/grpc_health_v1"
 )
@@ -216,6 +221,46 @@ func TestHealthCheckGRPC(t *testing.T) {
 	grpcServer.Stop()
 }
 
+func TestGRPCTLSNegotiatesHTTP2(t *testing.T) {
+	reloader, err := tlsconfig.New(tlsconfig.Config{
+		CertificateFile: filepath.Join("testdata", "servercert.pem"),
+		KeyFile:         filepath.Join("testdata", "serverkey.pem"),
+		AuthorityFile:   filepath.Join("testdata", "cacert.pem"),
+		ClientAuth:      tlsconfig.ClientAuthNone,
+	})
+	require.NoError(t, err)
+	srv := &Server{
+		logger:      logrus.NewEntry(logrus.New()),
+		tlsConfig:   reloader.ServerConfig(),
+		tlsReloader: reloader,
+	}
+	source := GrpcMetricsSource{
+		logger: srv.logger,
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	grpcServer, listenAddr := source.startGRPCTCP(srv, addr)
+	defer grpcServer.Stop()
+
+	authority, err := ioutil.ReadFile(filepath.Join("testdata", "cacert.pem"))
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(authority))
+	conn, err := tls.Dial("tcp", listenAddr.String(), &tls.Config{
+		NextProtos: []string{"h2"},
+		RootCAs:    roots,
+		// the test certificate is issued for localhost, and its authority has expired
+		ServerName: "localhost",
+		Time: func() time.Time {
+			return time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+		},
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "h2", conn.ConnectionState().NegotiatedProtocol)
+}
+
 func TestConnectSSFGRPC(t *testing.T) {
 	srv := &Server{
 		logger:   logrus.NewEntry(logrus.New()),
@@ -3,15 +3,19 @@ package proxy
 import (
 	"time"
 
+	"github.com/stripe/veneur/v14/discovery"
+	"github.com/stripe/veneur/v14/proxy/limits"
 	"github.com/stripe/veneur/v14/util/matcher"
 )
 
 type Config struct {
-	Debug             bool          `yaml:"debug"`
-	DialTimeout       time.Duration `yaml:"dial_timeout"`
-	DiscoveryInterval time.Duration `yaml:"discovery_interval"`
-	ForwardAddresses  []string      `yaml:"forward_addresses"`
-	ForwardService    string        `yaml:"forward_service"`
+	Debug             bool             `yaml:"debug"`
+	DialTimeout       time.Duration    `yaml:"dial_timeout"`
+	Discovery         discovery.Config `yaml:"discovery"`
+	DiscoveryInterval time.Duration    `yaml:"discovery_interval"`
+	ForwardAddresses  []string         `yaml:"forward_addresses"`
+	ForwardReplicas   []ReplicaConfig  `yaml:"forward_replicas"`
+	ForwardService    string           `yaml:"forward_service"`
 	GrpcServer        struct {
 		ConnectionTimeout     time.Duration `yaml:"connection_timeout"`
 		MaxConnectionIdle     time.Duration `yaml:"max_connection_idle"`
@@ -20,16 +24,27 @@ type Config struct {
 		PingTimeout           time.Duration `yaml:"ping_timeout"`
 		KeepaliveTimeout      time.Duration `yaml:"keepalive_timeout"`
 	} `yaml:"grpc_server"`
-	GrpcAddress string `yaml:"grpc_address"`
-	Http        struct {
+	GrpcAddress     string        `yaml:"grpc_address"`
+	HandoffInterval time.Duration `yaml:"handoff_interval"`
+	Hash            struct {
+		LoadFactor float64 `yaml:"load_factor"`
+		Replicas   int     `yaml:"replicas"`
+		Strategy   string  `yaml:"strategy"`
+	} `yaml:"hash"`
+	Http struct {
+		EnableAdmin     bool `yaml:"enable_admin"`
 		EnableConfig    bool `yaml:"enable_config"`
 		EnableProfiling bool `yaml:"enable_profiling"`
 	} `yaml:"http"`
 	HttpAddress            string               `yaml:"http_address"`
 	IgnoreTags             []matcher.TagMatcher `yaml:"ignore_tags"`
+	Limits                 limits.Config        `yaml:"limits"`
+	Mirror                 MirrorConfig         `yaml:"mirror"`
 	RuntimeMetricsInterval time.Duration        `yaml:"runtime_metrics_interval"`
 	SendBufferSize         uint                 `yaml:"send_buffer_size"`
+	SendTimeout            time.Duration        `yaml:"send_timeout"`
 	SentryDsn              string               `yaml:"sentry_dsn"`
+	ShareInterval          time.Duration        `yaml:"share_interval"`
 	ShutdownTimeout        time.Duration        `yaml:"shutdown_timeout"`
 	Statsd                 struct {
 		Address             string        `yaml:"address"`
@@ -37,4 +52,31 @@ type Config struct {
 		ChannelBufferSize   int           `yaml:"channel_buffer_size"`
 		MessagesPerPayload  int           `yaml:"messages_per_payload"`
 	} `yaml:"statsd"`
+	Tls struct {
+		AuthorityCertificate     string `yaml:"authority_certificate"`
+		AuthorityCertificateFile string `yaml:"authority_certificate_file"`
+		Certificate              string `yaml:"certificate"`
+		CertificateFile          string `yaml:"certificate_file"`
+		ClientAuth               string `yaml:"client_auth"`
+		Forward                  bool   `yaml:"forward"`
+		Key                      string `yaml:"key"`
+		KeyFile                  string `yaml:"key_file"`
+		Serve                    bool   `yaml:"serve"`
+	} `yaml:"tls"`
+}
+
+// A shadow pool of global Veneurs that receives a copy of the metrics that
+// match, sampled by series.
+type MirrorConfig struct {
+	ForwardAddresses []string          `yaml:"forward_addresses"`
+	ForwardService   string            `yaml:"forward_service"`
+	Match            []matcher.Matcher `yaml:"match"`
+	SampleRate       float64           `yaml:"sample_rate"`
+}
+
+// A redundant pool of global Veneurs that receives a copy of every metric.
+type ReplicaConfig struct {
+	ForwardAddresses []string `yaml:"forward_addresses"`
+	ForwardService   string   `yaml:"forward_service"`
+	Name             string   `yaml:"name"`
 }
@@ -2,7 +2,10 @@ package connect
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/golang/protobuf/ptypes/empty"
@@ -12,6 +15,27 @@ import (
 	"github.com/stripe/veneur/v14/samplers/metricpb"
 	"github.com/stripe/veneur/v14/scopedstatsd"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// maxBatchSize bounds the number of metrics sent in each batch over
+	// SendMetricsV3.
+	maxBatchSize = 1000
+	// maxSendAttempts bounds the number of times a metric that the
+	// destination dropped is sent.
+	maxSendAttempts = 3
+	// retryBuffer bounds the number of batches waiting to be retried.
+	retryBuffer = 16
+	// drainTimeout bounds how long a closed destination waits for the
+	// destination to acknowledge the metrics it flushed before disconnecting.
+	drainTimeout = 5 * time.Second
+	// errorWindow is how far back errors are counted in a destination's
+	// status, in errorBuckets buckets.
+	errorWindow  = time.Minute
+	errorBuckets = 6
 )
 
 type Connect interface {
@@ -21,37 +45,124 @@ type Connect interface {
 var _ Connect = &connect{}
 
 type connect struct {
-	dialTimeout time.Duration
-	logger      *logrus.Entry
-	sendBuffer  uint
-	statsd      scopedstatsd.Client
+	dialTimeout          time.Duration
+	logger               *logrus.Entry
+	sendBuffer           uint
+	statsd               scopedstatsd.Client
+	transportCredentials credentials.TransportCredentials
 }
 
+// Create a connecter that dials destinations with the given transport
+// credentials, or insecurely if transportCredentials is nil.
 func Create(
 	dialTimeout time.Duration, logger *logrus.Entry, sendBuffer uint,
 	statsd scopedstatsd.Client,
+	transportCredentials credentials.TransportCredentials,
 ) Connect {
 	return &connect{
-		dialTimeout: dialTimeout,
-		logger:      logger,
-		sendBuffer:  sendBuffer,
-		statsd:      statsd,
+		dialTimeout:          dialTimeout,
+		logger:               logger,
+		sendBuffer:           sendBuffer,
+		statsd:               statsd,
+		transportCredentials: transportCredentials,
 	}
 }
 
 type Destination interface {
 	SendChannel() chan<- SendRequest
+	Connection() *grpc.ClientConn
 	Close()
+	Status() DestinationStatus
+}
+
+// DestinationStatus describes the state of a destination, for inspection by
+// operators.
+type DestinationStatus struct {
+	Address string `json:"address"`
+	// Whether the destination was closed, and is flushing the metrics waiting
+	// to be sent to it.
+	Closing bool `json:"closing"`
+	// The state of the gRPC connection, such as "READY" or
+	// "TRANSIENT_FAILURE".
+	ConnectionState string `json:"connection_state"`
+	// The number of batches sent over SendMetricsV3 that haven't been
+	// acknowledged yet.
+	PendingBatches int `json:"pending_batches"`
+	// "v3" if the destination acknowledges batches, or "v2" otherwise.
+	Protocol      string `json:"protocol"`
+	QueueCapacity int    `json:"queue_capacity"`
+	QueueLength   int    `json:"queue_length"`
+	// The number of metrics that failed to be sent in the last minute, by
+	// kind of failure.
+	RecentErrors  map[string]int64 `json:"recent_errors"`
+	LastError     string           `json:"last_error,omitempty"`
+	LastErrorTime *time.Time       `json:"last_error_time,omitempty"`
 }
 
 type SendRequest struct {
 	Metric       *metricpb.Metric
 	ErrorChannel chan error
+	// The key used to choose the destination, so that the request can be sent
+	// to another destination if this one disconnects before sending it.
+	Key string
+}
+
+// Reports the result of sending the request, if the sender is waiting for it.
+// Requests without an ErrorChannel are sent asynchronously.
+func (request SendRequest) Done(err error) {
+	if request.ErrorChannel != nil {
+		request.ErrorChannel <- err
+		close(request.ErrorChannel)
+	}
+}
+
+var (
+	// Returned when enqueueing a request to a destination that was removed.
+	ErrDestinationClosed = errors.New("destination closed")
+	// Returned when a destination's send channel stays full.
+	ErrEnqueueTimeout = errors.New("timed out waiting to enqueue")
+)
+
+// Enqueues a request to a destination, waiting up to `timeout` for room in its
+// send channel, or not at all if timeout is zero. Waiting applies backpressure
+// to the sender while the destination catches up.
+func Enqueue(
+	ctx context.Context, destination Destination, request SendRequest,
+	timeout time.Duration,
+) (err error) {
+	// The send channel is closed once the destination is removed.
+	defer func() {
+		if recover() != nil {
+			err = ErrDestinationClosed
+		}
+	}()
+
+	if timeout <= 0 {
+		select {
+		case destination.SendChannel() <- request:
+			return nil
+		default:
+			return ErrEnqueueTimeout
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case destination.SendChannel() <- request:
+		return nil
+	case <-timer.C:
+		return ErrEnqueueTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 type DestinationHash interface {
 	RemoveDestination(string)
 	ConnectionClosed()
+	// Sends a request to another destination, or fails it if there is none.
+	Reroute(SendRequest)
 }
 
 var _ Destination = &destination{}
@@ -60,11 +171,32 @@ type destination struct {
 	address         string
 	cancel          func()
 	client          forwardrpc.Forward_SendMetricsV2Client
+	clientV3        forwardrpc.Forward_SendMetricsV3Client
+	closeOnce       sync.Once
+	closed          chan struct{}
+	closing         chan struct{}
 	connection      *grpc.ClientConn
 	destinationHash DestinationHash
-	logger          *logrus.Entry
-	sendChannel     chan SendRequest
-	statsd          scopedstatsd.Client
+	errors          errorCounts
+	// Requests that couldn't be sent because the stream failed, to be rerouted
+	// once this destination is removed.
+	failed      []SendRequest
+	logger      *logrus.Entry
+	sendChannel chan SendRequest
+	statsd      scopedstatsd.Client
+
+	// Batches sent over SendMetricsV3 that haven't been acknowledged yet, and
+	// batches of dropped metrics to send again.
+	nextBatchID  uint64
+	pending      map[uint64]pendingBatch
+	pendingMutex sync.Mutex
+	retryChannel chan pendingBatch
+	streamCancel func()
+}
+
+type pendingBatch struct {
+	attempts int
+	requests []SendRequest
 }
 
 func (connect *connect) Connect(
@@ -77,8 +209,13 @@ func (connect *connect) Connect(
 	connection, err := func() (*grpc.ClientConn, error) {
 		dialContext, cancel := context.WithTimeout(ctx, connect.dialTimeout)
 		defer cancel()
+		transportOption := grpc.WithInsecure()
+		if connect.transportCredentials != nil {
+			transportOption =
+				grpc.WithTransportCredentials(connect.transportCredentials)
+		}
 		return grpc.DialContext(
-			dialContext, address, grpc.WithBlock(), grpc.WithInsecure(),
+			dialContext, address, grpc.WithBlock(), transportOption,
 			grpc.WithStatsHandler(&grpcstats.StatsHandler{
 				IsClient: true,
 				Statsd:   connect.statsd,
@@ -91,14 +228,21 @@ func (connect *connect) Connect(
 		return nil, err
 	}
 
-	// Open a streaming gRPC connection to the destination.
+	// Open a streaming gRPC connection to the destination, falling back to
+	// SendMetricsV2 if the destination doesn't acknowledge metrics.
 	logger.Debug("connecting to destination")
 	forwardClient := forwardrpc.NewForwardClient(connection)
-	client, err := forwardClient.SendMetricsV2(ctx)
+	var client forwardrpc.Forward_SendMetricsV2Client
+	clientV3, streamCancel, err := connect.openStreamV3(ctx, forwardClient)
+	if status.Code(err) == codes.Unimplemented {
+		logger.Debug("destination does not support SendMetricsV3")
+		client, err = forwardClient.SendMetricsV2(ctx)
+	}
 	if err != nil {
 		logger.WithError(err).Error("failed to connect to destination")
 		connect.statsd.Count(
 			"veneur_proxy.forward.connect", 1, []string{"status:failed_connect"}, 1.0)
+		connection.Close()
 		return nil, err
 	}
 
@@ -107,11 +251,17 @@ func (connect *connect) Connect(
 		address:         address,
 		cancel:          cancel,
 		client:          client,
+		clientV3:        clientV3,
+		closed:          make(chan struct{}),
+		closing:         make(chan struct{}),
 		connection:      connection,
 		destinationHash: destinationHash,
 		logger:          logger,
 		sendChannel:     make(chan SendRequest, connect.sendBuffer),
 		statsd:          connect.statsd,
+		pending:         map[uint64]pendingBatch{},
+		retryChannel:    make(chan pendingBatch, retryBuffer),
+		streamCancel:    streamCancel,
 	}
 
 	go d.sendMetrics(sendContext)
@@ -124,68 +274,442 @@ func (connect *connect) Connect(
 	return &d, nil
 }
 
-// Send metrics to the destination. Once the context expires, remove the the
-// destination and close the connection.
+// Opens a SendMetricsV3 stream, and sends an empty batch to check that the
+// destination implements it. Returns a function that cancels the stream.
+func (connect *connect) openStreamV3(
+	ctx context.Context, forwardClient forwardrpc.ForwardClient,
+) (forwardrpc.Forward_SendMetricsV3Client, func(), error) {
+	streamContext, cancel := context.WithCancel(ctx)
+	client, err := forwardClient.SendMetricsV3(streamContext)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	probeResult := make(chan error, 1)
+	go func() {
+		err := client.Send(&forwardrpc.MetricBatch{})
+		if err == nil || err == io.EOF {
+			// On io.EOF, the stream was aborted and Recv returns its status.
+			_, err = client.Recv()
+		}
+		probeResult <- err
+	}()
+
+	select {
+	case err = <-probeResult:
+	case <-time.After(connect.dialTimeout):
+		err = fmt.Errorf("timed out waiting for the destination to respond")
+	}
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return client, cancel, nil
+}
+
+// Send metrics to the destination. Once the destination is closed, remove it,
+// flush the metrics that are waiting to be sent, and close the connection. If
+// the connection is lost instead, the waiting metrics are rerouted to other
+// destinations.
 func (d *destination) sendMetrics(ctx context.Context) {
+	graceful := false
 sendLoop:
 	for {
 		select {
 		case request := <-d.sendChannel:
-			err := d.client.Send(request.Metric)
+			d.sendRequest(request)
+		case batch := <-d.retryChannel:
+			err := d.send(batch)
 			if err != nil {
-				d.logger.WithError(err).Debug("failed to forward metric")
-				d.statsd.Count(
-					"veneur_proxy.forward.metrics_count", 1,
-					[]string{"error:true"}, 1.0)
+				d.fail(err, batch.requests)
 			} else {
 				d.statsd.Count(
-					"veneur_proxy.forward.metrics_count", 1,
+					"veneur_proxy.forward.metrics_count", int64(len(batch.requests)),
 					[]string{"error:false"}, 1.0)
 			}
-			request.ErrorChannel <- err
-			close(request.ErrorChannel)
+		case <-d.closing:
+			graceful = true
+			break sendLoop
 		case <-ctx.Done():
 			break sendLoop
 		}
 	}
 
-	close(d.sendChannel)
 	d.destinationHash.RemoveDestination(d.address)
+	close(d.sendChannel)
+	d.drain(graceful)
 
-	err := d.client.CloseSend()
+	var err error
+	if d.clientV3 != nil {
+		err = d.clientV3.CloseSend()
+	} else {
+		err = d.client.CloseSend()
+	}
 	if err != nil {
 		d.logger.WithError(err).Error("failed to close stream")
 	}
+	if graceful {
+		// Wait for the destination to acknowledge the flushed metrics and end
+		// the stream.
+		select {
+		case <-d.closed:
+		case <-time.After(drainTimeout):
+			d.logger.Warn("timed out waiting for destination to disconnect")
+		}
+	}
 	err = d.connection.Close()
 	if err != nil {
 		d.logger.WithError(err).Error("failed to close connection")
 	}
+	if d.streamCancel != nil {
+		d.streamCancel()
+	}
 
 	d.destinationHash.ConnectionClosed()
 }
 
+// Sends a single request, over SendMetricsV3 if the destination supports it.
+func (d *destination) sendRequest(request SendRequest) {
+	if d.clientV3 != nil {
+		d.sendBatch(request)
+		return
+	}
+	err := d.client.Send(request.Metric)
+	if err != nil {
+		d.fail(err, []SendRequest{request})
+		return
+	}
+	d.statsd.Count(
+		"veneur_proxy.forward.metrics_count", 1,
+		[]string{"error:false"}, 1.0)
+	request.Done(nil)
+}
+
+// Holds on to requests that couldn't be sent because the stream failed, and
+// disconnects from the destination. The requests are rerouted to the next
+// destination in the hash once this one is removed.
+func (d *destination) fail(err error, requests []SendRequest) {
+	d.logger.WithError(err).Debug("failed to forward metrics")
+	d.errors.add("send", int64(len(requests)), err.Error())
+	d.statsd.Count(
+		"veneur_proxy.forward.metrics_count", int64(len(requests)),
+		[]string{"error:true"}, 1.0)
+	d.failed = append(d.failed, requests...)
+	d.cancel()
+}
+
+// Handles the requests left in the closed send channel, so that none of them
+// are discarded. If the destination was closed, they are flushed to it, so
+// that series keep being aggregated by the same destination until it leaves.
+// If the connection failed instead, they are rerouted to other destinations,
+// along with the batches the destination never acknowledged.
+func (d *destination) drain(graceful bool) {
+	var flushed int64
+	for request := range d.sendChannel {
+		if graceful && len(d.failed) == 0 {
+			d.sendRequest(request)
+			flushed += 1
+		} else {
+			d.failed = append(d.failed, request)
+		}
+	}
+	if !graceful || len(d.failed) > 0 {
+		// A batch may have reached the destination without being
+		// acknowledged, so metrics may be sent more than once.
+		d.pendingMutex.Lock()
+		for id, batch := range d.pending {
+			d.failed = append(d.failed, batch.requests...)
+			delete(d.pending, id)
+		}
+		d.pendingMutex.Unlock()
+	retryLoop:
+		for {
+			select {
+			case batch := <-d.retryChannel:
+				d.failed = append(d.failed, batch.requests...)
+			default:
+				break retryLoop
+			}
+		}
+	}
+	for _, request := range d.failed {
+		d.destinationHash.Reroute(request)
+	}
+	rerouted := int64(len(d.failed))
+	d.failed = nil
+
+	if flushed > 0 {
+		d.statsd.Count(
+			"veneur_proxy.forward.drained_metrics_count", flushed,
+			[]string{"status:flushed"}, 1.0)
+	}
+	if rerouted > 0 {
+		d.statsd.Count(
+			"veneur_proxy.forward.drained_metrics_count", rerouted,
+			[]string{"status:rerouted"}, 1.0)
+	}
+}
+
+// Sends a request as part of a batch, along with any other requests that are
+// already waiting to be sent.
+func (d *destination) sendBatch(request SendRequest) {
+	requests := []SendRequest{request}
+drainLoop:
+	for len(requests) < maxBatchSize {
+		select {
+		case request, ok := <-d.sendChannel:
+			if !ok {
+				break drainLoop
+			}
+			requests = append(requests, request)
+		default:
+			break drainLoop
+		}
+	}
+
+	err := d.send(pendingBatch{attempts: 1, requests: requests})
+	if err != nil {
+		d.fail(err, requests)
+		return
+	}
+	d.statsd.Count(
+		"veneur_proxy.forward.metrics_count", int64(len(requests)),
+		[]string{"error:false"}, 1.0)
+	for _, request := range requests {
+		request.Done(nil)
+	}
+}
+
+// Sends a batch over SendMetricsV3, and holds on to it until the destination
+// acknowledges it.
+func (d *destination) send(batch pendingBatch) error {
+	d.nextBatchID++
+	id := d.nextBatchID
+	metrics := make([]*metricpb.Metric, len(batch.requests))
+	pending := pendingBatch{
+		attempts: batch.attempts,
+		requests: make([]SendRequest, len(batch.requests)),
+	}
+	for index, request := range batch.requests {
+		metrics[index] = request.Metric
+		// The sender is told the result once the batch is sent, so the
+		// pending batch doesn't report it again.
+		request.ErrorChannel = nil
+		pending.requests[index] = request
+	}
+	d.pendingMutex.Lock()
+	d.pending[id] = pending
+	d.pendingMutex.Unlock()
+
+	err := d.clientV3.Send(&forwardrpc.MetricBatch{
+		Id:      id,
+		Metrics: metrics,
+	})
+	if err != nil {
+		d.pendingMutex.Lock()
+		delete(d.pending, id)
+		d.pendingMutex.Unlock()
+	}
+	return err
+}
+
+// Receives acknowledgements from the destination until the stream closes.
+func (d *destination) receiveAcks() error {
+	for {
+		ack, err := d.clientV3.Recv()
+		if err != nil {
+			return err
+		}
+		d.handleAck(ack)
+	}
+}
+
+// Reports the outcome of a batch, and queues the metrics that the destination
+// dropped to be sent again.
+func (d *destination) handleAck(ack *forwardrpc.MetricBatchAck) {
+	d.pendingMutex.Lock()
+	batch, ok := d.pending[ack.Id]
+	delete(d.pending, ack.Id)
+	d.pendingMutex.Unlock()
+
+	if ack.Accepted > 0 {
+		d.statsd.Count(
+			"veneur_proxy.forward.acked_metrics_count", int64(ack.Accepted),
+			[]string{"status:accepted"}, 1.0)
+	}
+	for reason, count := range ack.Rejected {
+		d.errors.add("rejected", int64(count), "rejected: "+reason)
+		d.statsd.Count(
+			"veneur_proxy.forward.acked_metrics_count", int64(count),
+			[]string{"status:rejected", "reason:" + reason}, 1.0)
+	}
+	for reason, count := range ack.Dropped {
+		d.errors.add("dropped", int64(count), "dropped: "+reason)
+		d.statsd.Count(
+			"veneur_proxy.forward.acked_metrics_count", int64(count),
+			[]string{"status:dropped", "reason:" + reason}, 1.0)
+	}
+
+	if !ok || len(ack.Retry) == 0 {
+		return
+	}
+	retry := pendingBatch{attempts: batch.attempts + 1}
+	for _, index := range ack.Retry {
+		if int(index) < len(batch.requests) {
+			retry.requests = append(retry.requests, batch.requests[index])
+		}
+	}
+	if batch.attempts >= maxSendAttempts {
+		d.errors.add(
+			"retry_exhausted", int64(len(retry.requests)),
+			"gave up resending dropped metrics")
+		d.statsd.Count(
+			"veneur_proxy.forward.retry_metrics_count", int64(len(retry.requests)),
+			[]string{"status:exhausted"}, 1.0)
+		return
+	}
+	select {
+	case d.retryChannel <- retry:
+		d.statsd.Count(
+			"veneur_proxy.forward.retry_metrics_count", int64(len(retry.requests)),
+			[]string{"status:queued"}, 1.0)
+	default:
+		d.errors.add(
+			"retry_overflow", int64(len(retry.requests)),
+			"too many batches waiting to be resent")
+		d.statsd.Count(
+			"veneur_proxy.forward.retry_metrics_count", int64(len(retry.requests)),
+			[]string{"status:overflow"}, 1.0)
+	}
+}
+
 // Listen for the streaming gRPC connection to the destination to close, and
 // cancel the context once it does.
 func (d *destination) listenForClose() {
-	var empty empty.Empty
-	err := d.client.RecvMsg(&empty)
+	var err error
+	if d.clientV3 != nil {
+		err = d.receiveAcks()
+	} else {
+		var empty empty.Empty
+		err = d.client.RecvMsg(&empty)
+	}
 	if err == nil || err == io.EOF {
 		d.logger.Debug("disconnected from destination")
 		d.statsd.Count(
 			"veneur_proxy.forward.disconnect", 1, []string{"error:false"}, 1.0)
 	} else {
 		d.logger.WithError(err).Error("disconnected from destination")
+		d.errors.add("disconnect", 1, err.Error())
 		d.statsd.Count(
 			"veneur_proxy.forward.disconnect", 1, []string{"error:true"}, 1.0)
 	}
 
 	d.cancel()
+	close(d.closed)
 }
 
 func (d *destination) SendChannel() chan<- SendRequest {
 	return d.sendChannel
 }
 
+// Returns the underlying connection to the destination, for RPCs other than
+// forwarding metrics.
+func (d *destination) Connection() *grpc.ClientConn {
+	return d.connection
+}
+
+// Closes the destination once the metrics waiting to be sent to it are
+// flushed.
 func (d *destination) Close() {
-	d.cancel()
+	d.closeOnce.Do(func() {
+		close(d.closing)
+	})
+}
+
+// Returns the current state of the destination.
+func (d *destination) Status() DestinationStatus {
+	closing := false
+	select {
+	case <-d.closing:
+		closing = true
+	default:
+	}
+	protocol := "v2"
+	if d.clientV3 != nil {
+		protocol = "v3"
+	}
+	d.pendingMutex.Lock()
+	pendingBatches := len(d.pending)
+	d.pendingMutex.Unlock()
+
+	status := DestinationStatus{
+		Address:         d.address,
+		Closing:         closing,
+		ConnectionState: d.connection.GetState().String(),
+		PendingBatches:  pendingBatches,
+		Protocol:        protocol,
+		QueueCapacity:   cap(d.sendChannel),
+		QueueLength:     len(d.sendChannel),
+	}
+	status.RecentErrors, status.LastError, status.LastErrorTime =
+		d.errors.recent(time.Now())
+	return status
+}
+
+// Counts errors over the last errorWindow, in buckets that are reused as the
+// window moves.
+type errorCounts struct {
+	buckets       [errorBuckets]errorBucket
+	lastError     string
+	lastErrorTime time.Time
+	mutex         sync.Mutex
+}
+
+type errorBucket struct {
+	counts map[string]int64
+	start  time.Time
+}
+
+// Adds count errors of the given kind, and records message as the last
+// error.
+func (e *errorCounts) add(kind string, count int64, message string) {
+	now := time.Now()
+	bucketDuration := errorWindow / errorBuckets
+	start := now.Truncate(bucketDuration)
+	bucket := &e.buckets[(start.UnixNano()/int64(bucketDuration))%errorBuckets]
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if !bucket.start.Equal(start) {
+		bucket.counts = map[string]int64{}
+		bucket.start = start
+	}
+	bucket.counts[kind] += count
+	e.lastError = message
+	e.lastErrorTime = now
+}
+
+// Returns the number of errors of each kind within errorWindow of now, and
+// the last error if there was one.
+func (e *errorCounts) recent(
+	now time.Time,
+) (map[string]int64, string, *time.Time) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	counts := map[string]int64{}
+	for _, bucket := range e.buckets {
+		if !bucket.start.After(now.Add(-errorWindow)) {
+			continue
+		}
+		for kind, count := range bucket.counts {
+			counts[kind] += count
+		}
+	}
+	if e.lastErrorTime.IsZero() {
+		return counts, "", nil
+	}
+	lastErrorTime := e.lastErrorTime
+	return counts, e.lastError, &lastErrorTime
 }
@@ -9,6 +9,7 @@ import (
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
+	grpc "google.golang.org/grpc"
 )
 
 // MockConnect is a mock of Connect interface.
@@ -84,6 +85,20 @@ func (mr *MockDestinationMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockDestination)(nil).Close))
 }
 
+// Connection mocks base method.
+func (m *MockDestination) Connection() *grpc.ClientConn {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Connection")
+	ret0, _ := ret[0].(*grpc.ClientConn)
+	return ret0
+}
+
+// Connection indicates an expected call of Connection.
+func (mr *MockDestinationMockRecorder) Connection() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Connection", reflect.TypeOf((*MockDestination)(nil).Connection))
+}
+
 // SendChannel mocks base method.
 func (m *MockDestination) SendChannel() chan<- SendRequest {
 	m.ctrl.T.Helper()
@@ -98,6 +113,20 @@ func (mr *MockDestinationMockRecorder) SendChannel() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendChannel", reflect.TypeOf((*MockDestination)(nil).SendChannel))
 }
 
+// Status mocks base method.
+func (m *MockDestination) Status() DestinationStatus {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Status")
+	ret0, _ := ret[0].(DestinationStatus)
+	return ret0
+}
+
+// Status indicates an expected call of Status.
+func (mr *MockDestinationMockRecorder) Status() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Status", reflect.TypeOf((*MockDestination)(nil).Status))
+}
+
 // MockDestinationHash is a mock of DestinationHash interface.
 type MockDestinationHash struct {
 	ctrl     *gomock.Controller
@@ -144,3 +173,15 @@ func (mr *MockDestinationHashMockRecorder) RemoveDestination(arg0 interface{}) *
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveDestination", reflect.TypeOf((*MockDestinationHash)(nil).RemoveDestination), arg0)
 }
+
+// Reroute mocks base method.
+func (m *MockDestinationHash) Reroute(arg0 SendRequest) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Reroute", arg0)
+}
+
+// Reroute indicates an expected call of Reroute.
+func (mr *MockDestinationHashMockRecorder) Reroute(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reroute", reflect.TypeOf((*MockDestinationHash)(nil).Reroute), arg0)
+}
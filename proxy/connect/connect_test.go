@@ -2,6 +2,7 @@ package connect_test
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"testing"
 	"time"
@@ -15,16 +16,19 @@ import (
 	"github.com/stripe/veneur/v14/samplers/metricpb"
 	"github.com/stripe/veneur/v14/scopedstatsd"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 type FakeServer struct {
-	closeConnection   chan struct{}
-	connectionChannel chan forwardrpc.Forward_SendMetricsV2Server
-	grpcListener      net.Listener
-	handler           *forwardrpc.MockForwardServer
-	serveError        chan error
-	server            *grpc.Server
+	closeConnection     chan struct{}
+	connectionChannel   chan forwardrpc.Forward_SendMetricsV2Server
+	connectionV3Channel chan forwardrpc.Forward_SendMetricsV3Server
+	grpcListener        net.Listener
+	handler             *forwardrpc.MockForwardServer
+	serveError          chan error
+	server              *grpc.Server
 }
 
 func CreateFakeServer(
@@ -43,12 +47,13 @@ func CreateFakeServer(
 	}()
 
 	return &FakeServer{
-		closeConnection:   make(chan struct{}),
-		connectionChannel: make(chan forwardrpc.Forward_SendMetricsV2Server),
-		grpcListener:      grpcListener,
-		handler:           mockHandler,
-		serveError:        serveError,
-		server:            server,
+		closeConnection:     make(chan struct{}),
+		connectionChannel:   make(chan forwardrpc.Forward_SendMetricsV2Server),
+		connectionV3Channel: make(chan forwardrpc.Forward_SendMetricsV3Server),
+		grpcListener:        grpcListener,
+		handler:             mockHandler,
+		serveError:          serveError,
+		server:              server,
 	}
 }
 
@@ -93,6 +98,8 @@ func TestConnect(t *testing.T) {
 	mockStatsd.EXPECT().Count(
 		"veneur_proxy.forward.metrics_count",
 		int64(1), []string{"error:false"}, 1.0)
+	server.handler.EXPECT().SendMetricsV3(gomock.Any()).Times(1).
+		Return(status.Error(codes.Unimplemented, "unknown method"))
 	server.handler.EXPECT().SendMetricsV2(gomock.Any()).Times(1).DoAndReturn(func(
 		connection forwardrpc.Forward_SendMetricsV2Server,
 	) error {
@@ -103,7 +110,7 @@ func TestConnect(t *testing.T) {
 	})
 
 	connecter := connect.Create(
-		time.Second, logrus.NewEntry(logger), 1, mockStatsd)
+		time.Second, logrus.NewEntry(logger), 1, mockStatsd, nil)
 	destination, err := connecter.Connect(
 		context.Background(), server.grpcListener.Addr().String(),
 		mockDestinationsHash)
@@ -137,6 +144,122 @@ func TestConnect(t *testing.T) {
 	<-connectionClosed
 }
 
+func TestConnectV3(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStatsd := scopedstatsd.NewMockClient(ctrl)
+	mockDestinationsHash := connect.NewMockDestinationHash(ctrl)
+	server := CreateFakeServer(t, ctrl)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.DebugLevel)
+
+	metric := &metricpb.Metric{
+		Name: "metric-name",
+		Tags: []string{"tag1:value1"},
+		Type: metricpb.Type_Counter,
+		Value: &metricpb.Metric_Counter{
+			Counter: &metricpb.CounterValue{
+				Value: 1,
+			},
+		},
+	}
+
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.grpc.conn_begin", int64(1),
+		[]string{"client:true"}, 1.0)
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.forward.connect", int64(1),
+		[]string{"status:success"}, 1.0)
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.forward.metrics_count",
+		int64(1), []string{"error:false"}, 1.0).Times(2)
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.forward.acked_metrics_count", int64(1),
+		[]string{"status:dropped", "reason:queue_full"}, 1.0)
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.forward.retry_metrics_count", int64(1),
+		[]string{"status:queued"}, 1.0)
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.forward.acked_metrics_count", int64(1),
+		[]string{"status:accepted"}, 1.0)
+	server.handler.EXPECT().SendMetricsV3(gomock.Any()).Times(1).DoAndReturn(func(
+		connection forwardrpc.Forward_SendMetricsV3Server,
+	) error {
+		// Acknowledge the empty batch sent when connecting.
+		probe, err := connection.Recv()
+		if err != nil {
+			return err
+		}
+		err = connection.Send(&forwardrpc.MetricBatchAck{Id: probe.Id})
+		if err != nil {
+			return err
+		}
+		server.connectionV3Channel <- connection
+		<-server.closeConnection
+		return nil
+	})
+
+	connecter := connect.Create(
+		time.Second, logrus.NewEntry(logger), 1, mockStatsd, nil)
+	destination, err := connecter.Connect(
+		context.Background(), server.grpcListener.Addr().String(),
+		mockDestinationsHash)
+	require.NoError(t, err)
+
+	connection := <-server.connectionV3Channel
+
+	errorChannel := make(chan error, 1)
+	destination.SendChannel() <- connect.SendRequest{
+		ErrorChannel: errorChannel,
+		Metric:       metric,
+	}
+	batch, err := connection.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, []*metricpb.Metric{metric}, batch.Metrics)
+	assert.NoError(t, <-errorChannel)
+
+	// Metrics that are dropped are sent again in a new batch.
+	require.NoError(t, connection.Send(&forwardrpc.MetricBatchAck{
+		Id:      batch.Id,
+		Dropped: map[string]uint64{"queue_full": 1},
+		Retry:   []uint32{0},
+	}))
+	retry, err := connection.Recv()
+	require.NoError(t, err)
+	assert.NotEqual(t, batch.Id, retry.Id)
+	assert.Equal(t, []*metricpb.Metric{metric}, retry.Metrics)
+	require.NoError(t, connection.Send(&forwardrpc.MetricBatchAck{
+		Id:       retry.Id,
+		Accepted: 1,
+	}))
+
+	status := destination.Status()
+	assert.Equal(t, server.grpcListener.Addr().String(), status.Address)
+	assert.Equal(t, "v3", status.Protocol)
+	assert.Equal(t, 1, status.QueueCapacity)
+	assert.Equal(t, map[string]int64{"dropped": 1}, status.RecentErrors)
+	assert.Equal(t, "dropped: queue_full", status.LastError)
+	assert.NotNil(t, status.LastErrorTime)
+
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.forward.disconnect", int64(1),
+		[]string{"error:false"}, 1.0)
+	mockDestinationsHash.EXPECT().RemoveDestination(
+		server.grpcListener.Addr().String())
+	connectionClosed := make(chan struct{})
+	mockDestinationsHash.EXPECT().ConnectionClosed().Do(func() {
+		close(connectionClosed)
+	})
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.grpc.conn_end", int64(1),
+		[]string{"client:true"}, 1.0)
+
+	server.Close(t)
+	<-connectionClosed
+}
+
 func TestConnectDialTimeoutExpired(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -151,9 +274,183 @@ func TestConnectDialTimeoutExpired(t *testing.T) {
 		"veneur_proxy.forward.connect", int64(1),
 		[]string{"status:failed_dial"}, 1.0)
 
-	connecter := connect.Create(0, logrus.NewEntry(logger), 1, mockStatsd)
+	connecter := connect.Create(0, logrus.NewEntry(logger), 1, mockStatsd, nil)
 	_, err := connecter.Connect(
 		context.Background(), "address", mockDestinationsHash)
 	assert.Error(t, err)
 	assert.Equal(t, "context deadline exceeded", err.Error())
 }
+
+func TestCloseFlushesWaitingMetrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStatsd := scopedstatsd.NewMockClient(ctrl)
+	mockDestinationsHash := connect.NewMockDestinationHash(ctrl)
+	server := CreateFakeServer(t, ctrl)
+	defer server.Close(t)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.DebugLevel)
+
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.grpc.conn_begin", int64(1),
+		[]string{"client:true"}, 1.0)
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.forward.connect", int64(1),
+		[]string{"status:success"}, 1.0)
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.forward.metrics_count",
+		int64(1), []string{"error:false"}, 1.0).AnyTimes()
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.forward.drained_metrics_count",
+		gomock.Any(), []string{"status:flushed"}, 1.0).AnyTimes()
+	received := make(chan []*metricpb.Metric, 1)
+	server.handler.EXPECT().SendMetricsV3(gomock.Any()).Times(1).
+		Return(status.Error(codes.Unimplemented, "unknown method"))
+	server.handler.EXPECT().SendMetricsV2(gomock.Any()).Times(1).DoAndReturn(func(
+		connection forwardrpc.Forward_SendMetricsV2Server,
+	) error {
+		var metrics []*metricpb.Metric
+		for {
+			metric, err := connection.Recv()
+			if err != nil {
+				break
+			}
+			metrics = append(metrics, metric)
+		}
+		received <- metrics
+		return connection.SendAndClose(&emptypb.Empty{})
+	})
+
+	connecter := connect.Create(
+		time.Second, logrus.NewEntry(logger), 10, mockStatsd, nil)
+	destination, err := connecter.Connect(
+		context.Background(), server.grpcListener.Addr().String(),
+		mockDestinationsHash)
+	require.NoError(t, err)
+
+	var errorChannels []chan error
+	var metrics []*metricpb.Metric
+	for index := 0; index < 5; index++ {
+		metric := &metricpb.Metric{
+			Name: fmt.Sprintf("metric-%d", index),
+			Type: metricpb.Type_Counter,
+			Value: &metricpb.Metric_Counter{
+				Counter: &metricpb.CounterValue{Value: 1},
+			},
+		}
+		metrics = append(metrics, metric)
+		errorChannel := make(chan error, 1)
+		errorChannels = append(errorChannels, errorChannel)
+		destination.SendChannel() <- connect.SendRequest{
+			ErrorChannel: errorChannel,
+			Metric:       metric,
+		}
+	}
+
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.forward.disconnect", int64(1),
+		[]string{"error:false"}, 1.0)
+	mockDestinationsHash.EXPECT().RemoveDestination(
+		server.grpcListener.Addr().String())
+	connectionClosed := make(chan struct{})
+	mockDestinationsHash.EXPECT().ConnectionClosed().Do(func() {
+		close(connectionClosed)
+	})
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.grpc.conn_end", int64(1),
+		[]string{"client:true"}, 1.0)
+
+	destination.Close()
+	<-connectionClosed
+
+	for _, errorChannel := range errorChannels {
+		assert.NoError(t, <-errorChannel)
+	}
+	assert.ElementsMatch(t, metrics, <-received)
+}
+
+func TestDisconnectReroutesWaitingMetrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStatsd := scopedstatsd.NewMockClient(ctrl)
+	mockDestinationsHash := connect.NewMockDestinationHash(ctrl)
+	server := CreateFakeServer(t, ctrl)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.DebugLevel)
+
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.grpc.conn_begin", int64(1),
+		[]string{"client:true"}, 1.0)
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.forward.connect", int64(1),
+		[]string{"status:success"}, 1.0)
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.forward.metrics_count",
+		int64(1), gomock.Any(), 1.0).AnyTimes()
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.forward.drained_metrics_count",
+		gomock.Any(), []string{"status:rerouted"}, 1.0).AnyTimes()
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.forward.disconnect", int64(1), gomock.Any(), 1.0)
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.grpc.conn_end", int64(1),
+		[]string{"client:true"}, 1.0)
+	server.handler.EXPECT().SendMetricsV3(gomock.Any()).Times(1).
+		Return(status.Error(codes.Unimplemented, "unknown method"))
+	connected := make(chan struct{})
+	server.handler.EXPECT().SendMetricsV2(gomock.Any()).Times(1).DoAndReturn(func(
+		connection forwardrpc.Forward_SendMetricsV2Server,
+	) error {
+		close(connected)
+		<-server.closeConnection
+		return status.Error(codes.Unavailable, "shutting down")
+	})
+
+	connecter := connect.Create(
+		time.Second, logrus.NewEntry(logger), 10, mockStatsd, nil)
+	destination, err := connecter.Connect(
+		context.Background(), server.grpcListener.Addr().String(),
+		mockDestinationsHash)
+	require.NoError(t, err)
+	<-connected
+
+	var errorChannels []chan error
+	for index := 0; index < 5; index++ {
+		errorChannel := make(chan error, 1)
+		errorChannels = append(errorChannels, errorChannel)
+		destination.SendChannel() <- connect.SendRequest{
+			ErrorChannel: errorChannel,
+			Key:          "key",
+			Metric:       &metricpb.Metric{Name: "metric"},
+		}
+	}
+
+	// Every request is answered, either when it is sent or when it is
+	// rerouted after the connection is lost.
+	mockDestinationsHash.EXPECT().RemoveDestination(
+		server.grpcListener.Addr().String())
+	mockDestinationsHash.EXPECT().Reroute(gomock.Any()).AnyTimes().Do(
+		func(request connect.SendRequest) {
+			assert.Equal(t, "key", request.Key)
+			request.Done(nil)
+		})
+	connectionClosed := make(chan struct{})
+	mockDestinationsHash.EXPECT().ConnectionClosed().Do(func() {
+		close(connectionClosed)
+	})
+
+	server.Close(t)
+	<-connectionClosed
+
+	for _, errorChannel := range errorChannels {
+		select {
+		case <-errorChannel:
+		case <-time.After(time.Second):
+			t.Fatal("request was discarded")
+		}
+	}
+}
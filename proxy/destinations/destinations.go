@@ -3,18 +3,41 @@ package destinations
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/axiomhq/hyperloglog"
 	"github.com/sirupsen/logrus"
 	"github.com/stripe/veneur/v14/proxy/connect"
-	"stathat.com/c/consistent"
+	"github.com/stripe/veneur/v14/samplers/metricpb"
+	"github.com/stripe/veneur/v14/ssf"
+)
+
+const (
+	// How many destinations a request is offered to when rerouting it, in case
+	// they are removed concurrently.
+	maxRerouteAttempts = 3
+	// How long rerouting waits for room in a destination's send channel.
+	rerouteTimeout = 100 * time.Millisecond
+	// How many sketches each destination's share is split between, so that
+	// concurrent counts rarely wait on the same lock.
+	shareStripes = 16
 )
 
 type Destinations interface {
 	Add(ctx context.Context, destinations []string)
 	Clear()
+	Count(key string)
 	Get(key string) (connect.Destination, error)
+	Handoff() (joined int, left int)
+	Lookup(key string) (string, error)
+	Shares() map[string]int64
 	Size() int
+	Status() []DestinationStatus
+	Update(ctx context.Context, destinations []string)
 	Wait()
 }
 
@@ -25,21 +48,96 @@ type destinations struct {
 	connecter           connect.Connect
 	connectionWaitGroup sync.WaitGroup
 	destinations        map[string]connect.Destination
-	destinationsHash    *consistent.Consistent
-	logger              *logrus.Entry
-	mutex               sync.RWMutex
+	destinationsHash    Hash
+	// If set, destinations join and leave the hash only when Handoff is
+	// called.
+	handoff bool
+	// Destinations that are connected, but don't receive metrics until the
+	// next handoff.
+	joining map[string]connect.Destination
+	// Destinations that keep receiving metrics until the next handoff, and are
+	// then closed.
+	leaving map[string]struct{}
+	logger  *logrus.Entry
+	mutex   sync.RWMutex
+	// The distinct keys counted for each destination since the last call to
+	// Shares.
+	shares map[string]*share
+}
+
+// The distinct keys counted for a destination, estimated with HyperLogLogs so
+// that a busy proxy doesn't keep every key of an interval in memory. Each key
+// always goes to the same stripe, so the stripes count disjoint sets of keys
+// and their estimates add up.
+type share struct {
+	stripes [shareStripes]shareStripe
+}
+
+type shareStripe struct {
+	keys  *hyperloglog.Sketch
+	mutex sync.Mutex
+}
+
+func newShare() *share {
+	s := &share{}
+	for index := range s.stripes {
+		s.stripes[index].keys = hyperloglog.New()
+	}
+	return s
+}
+
+func (s *share) add(key string) {
+	stripe := &s.stripes[hashString(key)%shareStripes]
+	stripe.mutex.Lock()
+	stripe.keys.Insert([]byte(key))
+	stripe.mutex.Unlock()
+}
+
+// Returns the number of distinct keys added since the last call, and resets
+// it.
+func (s *share) reset() int64 {
+	var count int64
+	for index := range s.stripes {
+		stripe := &s.stripes[index]
+		stripe.mutex.Lock()
+		keys := stripe.keys
+		stripe.keys = hyperloglog.New()
+		stripe.mutex.Unlock()
+		count += int64(keys.Estimate())
+	}
+	return count
 }
 
-// Create a new set of destinations to forward metrics to.
+type Option func(*destinations)
+
+// WithHandoff defers changes to the destinations that receive metrics until
+// Handoff is called, e.g. at the end of each interval, so that a series isn't
+// split between two destinations within an interval.
+func WithHandoff() Option {
+	return func(d *destinations) {
+		d.handoff = true
+	}
+}
+
+// Create a new set of destinations to forward metrics to, choosing between
+// them with the given hash.
 func Create(
-	connecter connect.Connect, logger *logrus.Entry,
+	connecter connect.Connect, hash Hash, logger *logrus.Entry,
+	options ...Option,
 ) Destinations {
-	return &destinations{
+	d := &destinations{
 		connecter:        connecter,
 		destinations:     map[string]connect.Destination{},
-		destinationsHash: consistent.New(),
+		destinationsHash: hash,
+		joining:          map[string]connect.Destination{},
+		leaving:          map[string]struct{}{},
 		logger:           logger,
+		shares:           map[string]*share{},
 	}
+	for _, option := range options {
+		option(d)
+	}
+	return d
 }
 
 // Adds and connects to a destination. Destinations will be automatically
@@ -55,7 +153,8 @@ func (d *destinations) Add(
 
 		for _, destination := range destinations {
 			_, ok := d.destinations[destination]
-			if !ok {
+			_, joining := d.joining[destination]
+			if !ok && !joining {
 				addedDestinations = append(addedDestinations, destination)
 			}
 		}
@@ -80,7 +179,8 @@ func (d *destinations) Add(
 	waitGroup.Wait()
 }
 
-// Adds a destination to the consistent hash.
+// Adds a destination to the consistent hash, or waits for the next handoff to
+// add it.
 func (d *destinations) addDestination(
 	address string, destination connect.Destination,
 ) {
@@ -88,12 +188,98 @@ func (d *destinations) addDestination(
 	defer d.mutex.Unlock()
 
 	oldDestination, ok := d.destinations[address]
+	if !ok {
+		oldDestination, ok = d.joining[address]
+	}
 	if ok {
 		d.logger.WithField("destination", address).Error("duplicate destination")
 		oldDestination.Close()
 	}
+	// With no destinations, there are no series to split, so a destination
+	// joins immediately.
+	if d.handoff && len(d.destinations) > 0 {
+		d.joining[address] = destination
+		return
+	}
+	d.joinDestination(address, destination)
+}
+
+// Adds a destination to the consistent hash. The mutex must be held.
+func (d *destinations) joinDestination(
+	address string, destination connect.Destination,
+) {
 	d.destinations[address] = destination
 	d.destinationsHash.Add(address)
+	d.shares[address] = newShare()
+}
+
+// Adds and connects to new destinations, and removes the current destinations
+// that aren't listed. Removed destinations are closed once the metrics waiting
+// to be sent to them are flushed; if handoff is enabled, they keep receiving
+// metrics until the next handoff.
+func (d *destinations) Update(
+	ctx context.Context, destinations []string,
+) {
+	d.Add(ctx, destinations)
+
+	listed := make(map[string]struct{}, len(destinations))
+	for _, destination := range destinations {
+		listed[destination] = struct{}{}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for address, destination := range d.joining {
+		if _, ok := listed[address]; !ok {
+			delete(d.joining, address)
+			destination.Close()
+		}
+	}
+	for address, destination := range d.destinations {
+		if _, ok := listed[address]; ok {
+			delete(d.leaving, address)
+			continue
+		}
+		if d.handoff {
+			d.leaving[address] = struct{}{}
+			continue
+		}
+		d.leaveDestination(address)
+		destination.Close()
+	}
+}
+
+// Adds the destinations that joined since the last handoff to the consistent
+// hash, and removes and closes the destinations that left. Returns the number
+// of destinations that joined and left.
+func (d *destinations) Handoff() (joined int, left int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for address, destination := range d.joining {
+		delete(d.joining, address)
+		d.joinDestination(address, destination)
+		joined += 1
+	}
+	for address := range d.leaving {
+		delete(d.leaving, address)
+		destination, ok := d.destinations[address]
+		if !ok {
+			continue
+		}
+		d.leaveDestination(address)
+		destination.Close()
+		left += 1
+	}
+	return joined, left
+}
+
+// Removes a destination from the consistent hash. The mutex must be held.
+func (d *destinations) leaveDestination(address string) {
+	d.destinationsHash.Remove(address)
+	delete(d.destinations, address)
+	delete(d.shares, address)
 }
 
 // Removes a destination from the consistent hash.
@@ -101,12 +287,37 @@ func (d *destinations) RemoveDestination(address string) {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
+	delete(d.joining, address)
+	delete(d.leaving, address)
 	_, ok := d.destinations[address]
 	if !ok {
 		return
 	}
-	d.destinationsHash.Remove(address)
-	delete(d.destinations, address)
+	d.leaveDestination(address)
+}
+
+// Sends a request that couldn't be sent to its original destination to the
+// destination now chosen for its key, which is the next destination in the
+// hash once the original is removed. If there is none, or that destination
+// doesn't accept the request within rerouteTimeout, the request fails.
+func (d *destinations) Reroute(request connect.SendRequest) {
+	var err error
+	for attempt := 0; attempt < maxRerouteAttempts; attempt++ {
+		var destination connect.Destination
+		destination, err = d.Get(request.Key)
+		if err != nil {
+			break
+		}
+		err = connect.Enqueue(
+			context.Background(), destination, request, rerouteTimeout)
+		if err != connect.ErrDestinationClosed {
+			break
+		}
+	}
+	if err != nil {
+		d.logger.WithError(err).Debug("failed to reroute metric")
+		request.Done(err)
+	}
 }
 
 func (d *destinations) ConnectionClosed() {
@@ -121,8 +332,16 @@ func (d *destinations) Clear() {
 	d.destinationsHash.Set([]string{})
 	for address, destination := range d.destinations {
 		delete(d.destinations, address)
+		delete(d.shares, address)
 		destination.Close()
 	}
+	for address, destination := range d.joining {
+		delete(d.joining, address)
+		destination.Close()
+	}
+	for address := range d.leaving {
+		delete(d.leaving, address)
+	}
 }
 
 // Gets a destination for a given key.
@@ -141,6 +360,98 @@ func (d *destinations) Get(key string) (connect.Destination, error) {
 	return destination, nil
 }
 
+// Counts a metric's key towards the share of the destination it's sent to.
+// Only keys of metrics are counted, so that the shares reflect the series each
+// destination aggregates.
+func (d *destinations) Count(key string) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	destinationAddress, err := d.destinationsHash.Get(key)
+	if err != nil {
+		return
+	}
+	if share, ok := d.shares[destinationAddress]; ok {
+		share.add(key)
+	}
+}
+
+// Returns the address of the destination for a given key.
+func (d *destinations) Lookup(key string) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.destinationsHash.Get(key)
+}
+
+// DestinationStatus describes a destination and whether it receives metrics.
+type DestinationStatus struct {
+	connect.DestinationStatus
+	// "active" if the destination receives metrics, "joining" if it starts
+	// receiving metrics at the next handoff, or "leaving" if it stops.
+	Membership string `json:"membership"`
+}
+
+// Returns the status of each destination, sorted by address.
+func (d *destinations) Status() []DestinationStatus {
+	d.mutex.RLock()
+	statuses := make(
+		[]DestinationStatus, 0, len(d.destinations)+len(d.joining))
+	for address, destination := range d.destinations {
+		membership := "active"
+		if _, ok := d.leaving[address]; ok {
+			membership = "leaving"
+		}
+		statuses = append(statuses, DestinationStatus{
+			DestinationStatus: destination.Status(),
+			Membership:        membership,
+		})
+	}
+	for _, destination := range d.joining {
+		statuses = append(statuses, DestinationStatus{
+			DestinationStatus: destination.Status(),
+			Membership:        "joining",
+		})
+	}
+	d.mutex.RUnlock()
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Address < statuses[j].Address
+	})
+	return statuses
+}
+
+// Returns the number of distinct keys, i.e. series, sent to each destination
+// since the last call, and resets the counts.
+func (d *destinations) Shares() map[string]int64 {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	shares := make(map[string]int64, len(d.shares))
+	for address, share := range d.shares {
+		shares[address] = share.reset()
+	}
+	return shares
+}
+
+// Returns the key used to choose a destination for a metric with the given
+// tags, so that every instance of a timeseries goes to the same destination.
+func MetricKey(metric *metricpb.Metric, tags []string) string {
+	return fmt.Sprintf("%s%s%s",
+		metric.Name, strings.ToLower(metric.Type.String()), strings.Join(tags, ","))
+}
+
+// Returns the key used to choose a destination for a span, so that every span
+// of a trace goes to the same destination.
+func SpanKey(span *ssf.SSFSpan) string {
+	return strconv.FormatInt(span.TraceId, 10)
+}
+
+// Returns the key used to choose a destination for an event or service check.
+func SampleKey(sample *ssf.SSFSample) string {
+	return sample.Name
+}
+
 // Returns the current number of destinations.
 func (d *destinations) Size() int {
 	return len(d.destinations)
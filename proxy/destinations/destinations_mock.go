@@ -59,6 +59,18 @@ func (mr *MockDestinationsMockRecorder) Clear() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Clear", reflect.TypeOf((*MockDestinations)(nil).Clear))
 }
 
+// Count mocks base method.
+func (m *MockDestinations) Count(key string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Count", key)
+}
+
+// Count indicates an expected call of Count.
+func (mr *MockDestinationsMockRecorder) Count(key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Count", reflect.TypeOf((*MockDestinations)(nil).Count), key)
+}
+
 // Get mocks base method.
 func (m *MockDestinations) Get(key string) (connect.Destination, error) {
 	m.ctrl.T.Helper()
@@ -74,6 +86,50 @@ func (mr *MockDestinationsMockRecorder) Get(key interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockDestinations)(nil).Get), key)
 }
 
+// Handoff mocks base method.
+func (m *MockDestinations) Handoff() (int, int) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Handoff")
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(int)
+	return ret0, ret1
+}
+
+// Handoff indicates an expected call of Handoff.
+func (mr *MockDestinationsMockRecorder) Handoff() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Handoff", reflect.TypeOf((*MockDestinations)(nil).Handoff))
+}
+
+// Lookup mocks base method.
+func (m *MockDestinations) Lookup(key string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Lookup", key)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Lookup indicates an expected call of Lookup.
+func (mr *MockDestinationsMockRecorder) Lookup(key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Lookup", reflect.TypeOf((*MockDestinations)(nil).Lookup), key)
+}
+
+// Shares mocks base method.
+func (m *MockDestinations) Shares() map[string]int64 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Shares")
+	ret0, _ := ret[0].(map[string]int64)
+	return ret0
+}
+
+// Shares indicates an expected call of Shares.
+func (mr *MockDestinationsMockRecorder) Shares() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Shares", reflect.TypeOf((*MockDestinations)(nil).Shares))
+}
+
 // Size mocks base method.
 func (m *MockDestinations) Size() int {
 	m.ctrl.T.Helper()
@@ -88,6 +144,32 @@ func (mr *MockDestinationsMockRecorder) Size() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Size", reflect.TypeOf((*MockDestinations)(nil).Size))
 }
 
+// Status mocks base method.
+func (m *MockDestinations) Status() []DestinationStatus {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Status")
+	ret0, _ := ret[0].([]DestinationStatus)
+	return ret0
+}
+
+// Status indicates an expected call of Status.
+func (mr *MockDestinationsMockRecorder) Status() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Status", reflect.TypeOf((*MockDestinations)(nil).Status))
+}
+
+// Update mocks base method.
+func (m *MockDestinations) Update(ctx context.Context, destinations []string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Update", ctx, destinations)
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockDestinationsMockRecorder) Update(ctx, destinations interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockDestinations)(nil).Update), ctx, destinations)
+}
+
 // Wait mocks base method.
 func (m *MockDestinations) Wait() {
 	m.ctrl.T.Helper()
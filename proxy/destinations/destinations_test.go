@@ -3,6 +3,7 @@ package destinations_test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -33,7 +34,8 @@ func CreateTestDestinations(
 	return &TestDestinations{
 		connect: mockConnect,
 		destinations: destinations.Create(
-			mockConnect, logrus.NewEntry(logger)),
+			mockConnect, destinations.NewConsistentHash(0),
+			logrus.NewEntry(logger)),
 		logger: logger,
 		statsd: mockStatsd,
 	}
@@ -205,3 +207,244 @@ func TestWait(t *testing.T) {
 	destinationHash.ConnectionClosed()
 	fixture.destinations.Wait()
 }
+
+func TestShares(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestDestinations(ctrl, 30*time.Second)
+	destination1 := connect.NewMockDestination(ctrl)
+	fixture.connect.EXPECT().Connect(
+		gomock.Any(), "address1", fixture.destinations).
+		Return(destination1, nil)
+	destination2 := connect.NewMockDestination(ctrl)
+	fixture.connect.EXPECT().Connect(
+		gomock.Any(), "address2", fixture.destinations).
+		Return(destination2, nil)
+
+	fixture.destinations.Add(context.Background(), []string{
+		"address1", "address2",
+	})
+	expected := map[string]int64{"address1": 0, "address2": 0}
+	for index := 0; index < 100; index++ {
+		key := fmt.Sprintf("key%d", index)
+		destination, err := fixture.destinations.Get(key)
+		assert.NoError(t, err)
+		fixture.destinations.Count(key)
+		if destination == destination1 {
+			expected["address1"] += 1
+		} else {
+			expected["address2"] += 1
+		}
+	}
+
+	// a series counts once, however many of its metrics are sent
+	for index := 0; index < 100; index++ {
+		fixture.destinations.Count(fmt.Sprintf("key%d", index))
+	}
+
+	// getting a destination doesn't count the key, e.g. for spans
+	for index := 100; index < 200; index++ {
+		_, err := fixture.destinations.Get(fmt.Sprintf("key%d", index))
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, expected, fixture.destinations.Shares())
+	assert.Equal(t, map[string]int64{"address1": 0, "address2": 0},
+		fixture.destinations.Shares())
+}
+
+func TestLookup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestDestinations(ctrl, 30*time.Second)
+	destination1 := connect.NewMockDestination(ctrl)
+	fixture.connect.EXPECT().Connect(
+		gomock.Any(), "address1", fixture.destinations).
+		Return(destination1, nil)
+	destination2 := connect.NewMockDestination(ctrl)
+	fixture.connect.EXPECT().Connect(
+		gomock.Any(), "address2", fixture.destinations).
+		Return(destination2, nil)
+
+	fixture.destinations.Add(context.Background(), []string{
+		"address1", "address2",
+	})
+	for index := 0; index < 100; index++ {
+		key := fmt.Sprintf("key%d", index)
+		address, err := fixture.destinations.Lookup(key)
+		assert.NoError(t, err)
+		destination, err := fixture.destinations.Get(key)
+		assert.NoError(t, err)
+		fixture.destinations.Count(key)
+		if address == "address1" {
+			assert.Equal(t, destination1, destination)
+		} else {
+			assert.Equal(t, destination2, destination)
+		}
+	}
+
+	// looking keys up doesn't count them towards the shares
+	for index := 100; index < 200; index++ {
+		_, err := fixture.destinations.Lookup(fmt.Sprintf("key%d", index))
+		assert.NoError(t, err)
+	}
+	shares := fixture.destinations.Shares()
+	assert.Equal(t, int64(100), shares["address1"]+shares["address2"])
+}
+
+func TestUpdate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestDestinations(ctrl, 30*time.Second)
+	destination1 := connect.NewMockDestination(ctrl)
+	fixture.connect.EXPECT().Connect(
+		gomock.Any(), "address1", fixture.destinations).
+		Return(destination1, nil)
+	destination2 := connect.NewMockDestination(ctrl)
+	fixture.connect.EXPECT().Connect(
+		gomock.Any(), "address2", fixture.destinations).
+		Return(destination2, nil)
+
+	fixture.destinations.Update(context.Background(), []string{"address1"})
+	assert.Equal(t, 1, fixture.destinations.Size())
+
+	destination1.EXPECT().Close()
+	fixture.destinations.Update(context.Background(), []string{"address2"})
+	assert.Equal(t, 1, fixture.destinations.Size())
+
+	actualDestination, err := fixture.destinations.Get("key")
+	assert.NoError(t, err)
+	assert.Equal(t, destination2, actualDestination)
+}
+
+func TestHandoff(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger := logrus.New()
+	mockConnect := connect.NewMockConnect(ctrl)
+	handoffDestinations := destinations.Create(
+		mockConnect, destinations.NewConsistentHash(0), logrus.NewEntry(logger),
+		destinations.WithHandoff())
+
+	destination1 := connect.NewMockDestination(ctrl)
+	mockConnect.EXPECT().Connect(
+		gomock.Any(), "address1", handoffDestinations).
+		Return(destination1, nil)
+	destination2 := connect.NewMockDestination(ctrl)
+	mockConnect.EXPECT().Connect(
+		gomock.Any(), "address2", handoffDestinations).
+		Return(destination2, nil)
+
+	// The first destination joins immediately, since there are no series to
+	// split.
+	handoffDestinations.Update(context.Background(), []string{"address1"})
+	assert.Equal(t, 1, handoffDestinations.Size())
+
+	// The second destination doesn't receive metrics until the handoff, and
+	// the first keeps receiving metrics until then.
+	handoffDestinations.Update(context.Background(), []string{"address2"})
+	for index := 0; index < 100; index++ {
+		actualDestination, err :=
+			handoffDestinations.Get(fmt.Sprintf("key%d", index))
+		assert.NoError(t, err)
+		assert.Equal(t, destination1, actualDestination)
+	}
+
+	destination1.EXPECT().Close()
+	joined, left := handoffDestinations.Handoff()
+	assert.Equal(t, 1, joined)
+	assert.Equal(t, 1, left)
+
+	for index := 0; index < 100; index++ {
+		actualDestination, err :=
+			handoffDestinations.Get(fmt.Sprintf("key%d", index))
+		assert.NoError(t, err)
+		assert.Equal(t, destination2, actualDestination)
+	}
+
+	joined, left = handoffDestinations.Handoff()
+	assert.Zero(t, joined)
+	assert.Zero(t, left)
+}
+
+func TestReroute(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestDestinations(ctrl, 30*time.Second)
+	destination := connect.NewMockDestination(ctrl)
+	fixture.connect.EXPECT().Connect(
+		gomock.Any(), "address", fixture.destinations).
+		Return(destination, nil)
+	fixture.destinations.Add(context.Background(), []string{"address"})
+
+	sendChannel := make(chan connect.SendRequest, 1)
+	destination.EXPECT().SendChannel().Return(sendChannel)
+
+	request := connect.SendRequest{
+		ErrorChannel: make(chan error, 1),
+		Key:          "key",
+	}
+	fixture.destinations.(connect.DestinationHash).Reroute(request)
+
+	assert.Equal(t, request, <-sendChannel)
+}
+
+func TestRerouteNoDestination(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestDestinations(ctrl, 30*time.Second)
+	errorChannel := make(chan error, 1)
+	fixture.destinations.(connect.DestinationHash).Reroute(connect.SendRequest{
+		ErrorChannel: errorChannel,
+		Key:          "key",
+	})
+
+	assert.Error(t, <-errorChannel)
+}
+
+func TestStatus(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger := logrus.New()
+	mockConnect := connect.NewMockConnect(ctrl)
+	handoffDestinations := destinations.Create(
+		mockConnect, destinations.NewConsistentHash(0), logrus.NewEntry(logger),
+		destinations.WithHandoff())
+
+	destination1 := connect.NewMockDestination(ctrl)
+	mockConnect.EXPECT().Connect(
+		gomock.Any(), "address1", handoffDestinations).
+		Return(destination1, nil)
+	destination2 := connect.NewMockDestination(ctrl)
+	mockConnect.EXPECT().Connect(
+		gomock.Any(), "address2", handoffDestinations).
+		Return(destination2, nil)
+	destination1.EXPECT().Status().Return(
+		connect.DestinationStatus{Address: "address1"})
+	destination2.EXPECT().Status().Return(
+		connect.DestinationStatus{Address: "address2"})
+
+	handoffDestinations.Update(context.Background(), []string{"address1"})
+	handoffDestinations.Update(context.Background(), []string{"address2"})
+
+	assert.Equal(t, []destinations.DestinationStatus{{
+		DestinationStatus: connect.DestinationStatus{Address: "address1"},
+		Membership:        "leaving",
+	}, {
+		DestinationStatus: connect.DestinationStatus{Address: "address2"},
+		Membership:        "joining",
+	}}, handoffDestinations.Status())
+
+	// Looking up a key doesn't count towards the destination's share.
+	address, err := handoffDestinations.Lookup("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "address1", address)
+	assert.Equal(t, map[string]int64{"address1": 0}, handoffDestinations.Shares())
+}
@@ -0,0 +1,335 @@
+package destinations
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+
+	"stathat.com/c/consistent"
+)
+
+// A strategy for choosing which destination handles a key. Get must not
+// change any state, so that a key's destination depends only on the members,
+// and looking a key up never changes where other keys go. Implementations
+// must be safe to call Get concurrently with itself, but not with Add, Remove
+// or Set.
+type Hash interface {
+	Add(member string)
+	Get(key string) (string, error)
+	Remove(member string)
+	Set(members []string)
+}
+
+const (
+	HashStrategyBoundedLoad = "bounded-load"
+	HashStrategyConsistent  = "consistent"
+	HashStrategyJump        = "jump"
+	HashStrategyRendezvous  = "rendezvous"
+
+	DefaultHashReplicas   = 20
+	DefaultHashLoadFactor = 1.25
+)
+
+// Creates a hash for the named strategy. If unset, replicas defaults to
+// DefaultHashReplicas and loadFactor defaults to DefaultHashLoadFactor.
+func NewHash(
+	strategy string, replicas int, loadFactor float64,
+) (Hash, error) {
+	switch strategy {
+	case "", HashStrategyConsistent:
+		return NewConsistentHash(replicas), nil
+	case HashStrategyBoundedLoad:
+		if loadFactor != 0 && loadFactor <= 1 {
+			return nil, fmt.Errorf(
+				"load factor must be greater than 1: %v", loadFactor)
+		}
+		return NewBoundedLoadHash(replicas, loadFactor), nil
+	case HashStrategyJump:
+		return NewJumpHash(), nil
+	case HashStrategyRendezvous:
+		return NewRendezvousHash(), nil
+	default:
+		return nil, fmt.Errorf("unknown hash strategy: %s", strategy)
+	}
+}
+
+func hashString(value string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(value))
+	return hash.Sum64()
+}
+
+// A salt that makes KeyFraction independent of the member chosen for a key.
+const fractionSalt = 0x5d8a7c3e9b1f2d64
+
+// Returns a number in [0, 1) derived from a key, so that a fraction of keys,
+// and every metric of the series they identify, can be sampled consistently.
+func KeyFraction(key string) float64 {
+	return float64(mix(hashString(key)^fractionSalt)>>11) / (1 << 53)
+}
+
+// Consistent hashing, with each member placed on the ring `replicas` times.
+type consistentHash struct {
+	*consistent.Consistent
+}
+
+func NewConsistentHash(replicas int) Hash {
+	if replicas <= 0 {
+		replicas = DefaultHashReplicas
+	}
+	ring := consistent.New()
+	ring.NumberOfReplicas = replicas
+	return consistentHash{ring}
+}
+
+// Consistent hashing with bounded loads. Each member is placed on the ring
+// `replicas` times, and each arc of the ring would normally belong to the
+// member at its end. Walking the ring in order, an arc that would take its
+// member past `loadFactor` times the average share of the ring goes to the
+// next member after it that has room instead. Since arcs are assigned from
+// the members alone, every proxy sends a key to the same member, whatever
+// order it sees keys or members in.
+type boundedLoadHash struct {
+	arcs       []boundedLoadArc
+	loadFactor float64
+	members    map[string]struct{}
+	replicas   int
+}
+
+// An arc of the ring, ending at a point placed by one of the members and
+// starting after the previous point.
+type boundedLoadArc struct {
+	end    uint64
+	member string
+}
+
+func NewBoundedLoadHash(replicas int, loadFactor float64) Hash {
+	if replicas <= 0 {
+		replicas = DefaultHashReplicas
+	}
+	if loadFactor <= 1 {
+		loadFactor = DefaultHashLoadFactor
+	}
+	return &boundedLoadHash{
+		loadFactor: loadFactor,
+		members:    map[string]struct{}{},
+		replicas:   replicas,
+	}
+}
+
+func (h *boundedLoadHash) Add(member string) {
+	h.members[member] = struct{}{}
+	h.assign()
+}
+
+func (h *boundedLoadHash) Remove(member string) {
+	delete(h.members, member)
+	h.assign()
+}
+
+func (h *boundedLoadHash) Set(members []string) {
+	h.members = make(map[string]struct{}, len(members))
+	for _, member := range members {
+		h.members[member] = struct{}{}
+	}
+	h.assign()
+}
+
+// Places the members on the ring, and assigns each arc to a member.
+func (h *boundedLoadHash) assign() {
+	points := make([]boundedLoadArc, 0, len(h.members)*h.replicas)
+	for member := range h.members {
+		for replica := 0; replica < h.replicas; replica++ {
+			points = append(points, boundedLoadArc{
+				end:    mix(hashString(member + "-" + strconv.Itoa(replica))),
+				member: member,
+			})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].end != points[j].end {
+			return points[i].end < points[j].end
+		}
+		return points[i].member < points[j].member
+	})
+
+	capacity := h.loadFactor / float64(len(h.members))
+	loads := make(map[string]float64, len(h.members))
+	for member := range h.members {
+		loads[member] = 0
+	}
+	h.arcs = make([]boundedLoadArc, len(points))
+	for index, point := range points {
+		// The first arc wraps around from the last point; the subtraction
+		// wraps around with it.
+		previous := points[(index+len(points)-1)%len(points)].end
+		length := float64(point.end-previous) / math.Exp2(64)
+		if len(points) == 1 {
+			length = 1
+		}
+
+		member := ""
+		for offset := 0; offset < len(points); offset++ {
+			candidate := points[(index+offset)%len(points)].member
+			if loads[candidate]+length <= capacity {
+				member = candidate
+				break
+			}
+		}
+		if member == "" {
+			// No member has room for the whole arc, so it goes to the least
+			// loaded one.
+			for candidate := range loads {
+				if member == "" || loads[candidate] < loads[member] ||
+					(loads[candidate] == loads[member] && candidate < member) {
+					member = candidate
+				}
+			}
+		}
+		loads[member] += length
+		h.arcs[index] = boundedLoadArc{end: point.end, member: member}
+	}
+}
+
+func (h *boundedLoadHash) Get(key string) (string, error) {
+	if len(h.arcs) == 0 {
+		return "", consistent.ErrEmptyCircle
+	}
+	position := mix(hashString(key))
+	index := sort.Search(len(h.arcs), func(i int) bool {
+		return h.arcs[i].end >= position
+	})
+	if index == len(h.arcs) {
+		index = 0
+	}
+	return h.arcs[index].member, nil
+}
+
+// Rendezvous, or highest random weight, hashing: each key goes to the member
+// with the highest hash of the member and key combined. Only the keys of a
+// removed member move, and keys spread evenly without virtual nodes, at the
+// cost of hashing against every member.
+type rendezvousHash struct {
+	members []rendezvousMember
+}
+
+type rendezvousMember struct {
+	hash uint64
+	name string
+}
+
+func NewRendezvousHash() Hash {
+	return &rendezvousHash{}
+}
+
+func (h *rendezvousHash) Add(member string) {
+	for _, existing := range h.members {
+		if existing.name == member {
+			return
+		}
+	}
+	h.members = append(h.members, rendezvousMember{
+		hash: hashString(member),
+		name: member,
+	})
+}
+
+func (h *rendezvousHash) Remove(member string) {
+	for index, existing := range h.members {
+		if existing.name == member {
+			h.members = append(h.members[:index], h.members[index+1:]...)
+			return
+		}
+	}
+}
+
+func (h *rendezvousHash) Set(members []string) {
+	h.members = nil
+	for _, member := range members {
+		h.Add(member)
+	}
+}
+
+func (h *rendezvousHash) Get(key string) (string, error) {
+	if len(h.members) == 0 {
+		return "", consistent.ErrEmptyCircle
+	}
+	keyHash := hashString(key)
+	var best rendezvousMember
+	var bestScore uint64
+	for index, member := range h.members {
+		score := mix(keyHash ^ member.hash)
+		if index == 0 || score > bestScore ||
+			(score == bestScore && member.name < best.name) {
+			best = member
+			bestScore = score
+		}
+	}
+	return best.name, nil
+}
+
+// The SplitMix64 finalizer, which spreads the bits of its input.
+func mix(value uint64) uint64 {
+	value ^= value >> 30
+	value *= 0xbf58476d1ce4e5b9
+	value ^= value >> 27
+	value *= 0x94d049bb133111eb
+	value ^= value >> 31
+	return value
+}
+
+// Jump consistent hashing, as described by Lamping and Veach. Keys are spread
+// evenly across the members, sorted by name, with no memory overhead. Adding
+// or removing the last member moves only the keys that must move, but
+// changing any other member moves keys between the members after it.
+type jumpHash struct {
+	members []string
+}
+
+func NewJumpHash() Hash {
+	return &jumpHash{}
+}
+
+func (h *jumpHash) Add(member string) {
+	index := sort.SearchStrings(h.members, member)
+	if index < len(h.members) && h.members[index] == member {
+		return
+	}
+	h.members = append(h.members, "")
+	copy(h.members[index+1:], h.members[index:])
+	h.members[index] = member
+}
+
+func (h *jumpHash) Remove(member string) {
+	index := sort.SearchStrings(h.members, member)
+	if index < len(h.members) && h.members[index] == member {
+		h.members = append(h.members[:index], h.members[index+1:]...)
+	}
+}
+
+func (h *jumpHash) Set(members []string) {
+	h.members = nil
+	for _, member := range members {
+		h.Add(member)
+	}
+}
+
+func (h *jumpHash) Get(key string) (string, error) {
+	if len(h.members) == 0 {
+		return "", consistent.ErrEmptyCircle
+	}
+	return h.members[jump(hashString(key), len(h.members))], nil
+}
+
+func jump(key uint64, buckets int) int {
+	var bucket, next int64 = -1, 0
+	for next < int64(buckets) {
+		bucket = next
+		key = key*2862933555777941757 + 1
+		next = int64(float64(bucket+1) *
+			(float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(bucket)
+}
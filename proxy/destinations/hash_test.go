@@ -0,0 +1,154 @@
+package destinations_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14/proxy/destinations"
+)
+
+var hashStrategies = []string{
+	destinations.HashStrategyBoundedLoad,
+	destinations.HashStrategyConsistent,
+	destinations.HashStrategyJump,
+	destinations.HashStrategyRendezvous,
+}
+
+func hashMembers(count int) []string {
+	members := make([]string, count)
+	for index := range members {
+		members[index] = fmt.Sprintf("10.0.0.%d:8128", index)
+	}
+	return members
+}
+
+func hashKeys(count int) []string {
+	keys := make([]string, count)
+	for index := range keys {
+		keys[index] = fmt.Sprintf("metric.%dcountertag:%d", index, index%7)
+	}
+	return keys
+}
+
+func TestNewHashInvalid(t *testing.T) {
+	_, err := destinations.NewHash("unknown", 0, 0)
+	assert.Error(t, err)
+
+	_, err = destinations.NewHash(
+		destinations.HashStrategyBoundedLoad, 0, 0.5)
+	assert.Error(t, err)
+}
+
+func TestHashEmpty(t *testing.T) {
+	for _, strategy := range hashStrategies {
+		t.Run(strategy, func(t *testing.T) {
+			hash, err := destinations.NewHash(strategy, 0, 0)
+			require.NoError(t, err)
+
+			_, err = hash.Get("key")
+			if assert.Error(t, err) {
+				assert.Equal(t, "empty circle", err.Error())
+			}
+		})
+	}
+}
+
+func TestHashStable(t *testing.T) {
+	for _, strategy := range hashStrategies {
+		t.Run(strategy, func(t *testing.T) {
+			hash, err := destinations.NewHash(strategy, 0, 0)
+			require.NoError(t, err)
+			hash.Set(hashMembers(5))
+
+			for _, key := range hashKeys(1000) {
+				first, err := hash.Get(key)
+				require.NoError(t, err)
+				second, err := hash.Get(key)
+				require.NoError(t, err)
+				assert.Equal(t, first, second)
+			}
+		})
+	}
+}
+
+func TestHashDeterministic(t *testing.T) {
+	// Every proxy must choose the same destination for a key, regardless of
+	// the order in which it discovered the destinations.
+	for _, strategy := range hashStrategies {
+		t.Run(strategy, func(t *testing.T) {
+			members := hashMembers(5)
+			forward, err := destinations.NewHash(strategy, 0, 0)
+			require.NoError(t, err)
+			backward, err := destinations.NewHash(strategy, 0, 0)
+			require.NoError(t, err)
+			for index := range members {
+				forward.Add(members[index])
+				backward.Add(members[len(members)-index-1])
+			}
+
+			// A proxy that has seen other keys first makes the same choices.
+			for _, key := range hashKeys(2000)[1000:] {
+				_, err := backward.Get(key)
+				require.NoError(t, err)
+			}
+			for _, key := range hashKeys(1000) {
+				expected, err := forward.Get(key)
+				require.NoError(t, err)
+				actual, err := backward.Get(key)
+				require.NoError(t, err)
+				assert.Equal(t, expected, actual)
+			}
+		})
+	}
+}
+
+func TestHashRemove(t *testing.T) {
+	for _, strategy := range hashStrategies {
+		t.Run(strategy, func(t *testing.T) {
+			hash, err := destinations.NewHash(strategy, 0, 0)
+			require.NoError(t, err)
+			members := hashMembers(5)
+			hash.Set(members)
+			hash.Remove(members[4])
+
+			for _, key := range hashKeys(1000) {
+				member, err := hash.Get(key)
+				require.NoError(t, err)
+				assert.NotEqual(t, members[4], member)
+			}
+		})
+	}
+}
+
+func TestHashBalance(t *testing.T) {
+	tests := map[string]float64{
+		destinations.HashStrategyBoundedLoad: 1.25,
+		destinations.HashStrategyJump:        1.1,
+		destinations.HashStrategyRendezvous:  1.1,
+	}
+	for strategy, maxRatio := range tests {
+		t.Run(strategy, func(t *testing.T) {
+			hash, err := destinations.NewHash(strategy, 0, 0)
+			require.NoError(t, err)
+			members := hashMembers(10)
+			hash.Set(members)
+
+			keys := hashKeys(20000)
+			loads := map[string]int{}
+			for _, key := range keys {
+				member, err := hash.Get(key)
+				require.NoError(t, err)
+				loads[member] += 1
+			}
+
+			assert.Len(t, loads, len(members))
+			average := float64(len(keys)) / float64(len(members))
+			for member, load := range loads {
+				assert.LessOrEqual(t, float64(load), maxRatio*average,
+					"%s has too many keys", member)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	encodingJson "encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,9 +15,14 @@ import (
 	"github.com/stripe/veneur/v14/proxy/connect"
 	"github.com/stripe/veneur/v14/proxy/destinations"
 	"github.com/stripe/veneur/v14/proxy/json"
+	"github.com/stripe/veneur/v14/proxy/limits"
 	"github.com/stripe/veneur/v14/samplers/metricpb"
 	"github.com/stripe/veneur/v14/scopedstatsd"
+	"github.com/stripe/veneur/v14/ssf"
 	"github.com/stripe/veneur/v14/util/matcher"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -24,8 +30,43 @@ type Handlers struct {
 	Destinations       destinations.Destinations
 	HealthcheckContext context.Context
 	IgnoreTags         []matcher.TagMatcher
-	Logger             *logrus.Entry
-	Statsd             scopedstatsd.Client
+	// Per-tenant limits on the metrics forwarded, or nil.
+	Limiter *limits.Limiter
+	Logger  *logrus.Entry
+	// A shadow pool that receives a copy of some metrics, or nil.
+	Mirror *Mirror
+	// Redundant pools that receive a copy of every metric, in addition to
+	// Destinations.
+	Replicas []Replica
+	// How long to wait for room in a destination's send channel before
+	// dropping a metric. While waiting, no more metrics are read from the
+	// sender.
+	SendTimeout time.Duration
+	Statsd      scopedstatsd.Client
+}
+
+// How many destinations a metric is offered to, in case they are removed
+// concurrently.
+const maxEnqueueAttempts = 3
+
+// The reason reported for metrics dropped because their tenant is over its
+// limits. Unlike other drops, these shouldn't be retried.
+const reasonLimit = "limit"
+
+type Replica struct {
+	Destinations destinations.Destinations
+	Name         string
+}
+
+type Mirror struct {
+	Destinations destinations.Destinations
+	// Only metrics that match one of these are mirrored. If empty, all
+	// metrics are.
+	Match []matcher.Matcher
+	// The fraction of series that are mirrored, chosen by the hash of their
+	// key so that every metric of a series is mirrored or none is. Zero
+	// mirrors every series.
+	SampleRate float64
 }
 
 func (proxy *Handlers) HandleHealthcheck(
@@ -71,7 +112,7 @@ func (proxy *Handlers) HandleJsonMetrics(
 			convertErrorCount += 1
 			continue
 		}
-		proxy.handleMetric(metric)
+		proxy.handleMetric(request.Context(), metric)
 	}
 	if convertErrorCount > 0 {
 		proxy.Statsd.Count(
@@ -96,7 +137,7 @@ func (proxy *Handlers) SendMetrics(
 		"veneur_proxy.ingest.metrics_count",
 		int64(len(metricList.Metrics)), []string{"protocol:grpc-single"}, 1.0)
 	for _, metric := range metricList.Metrics {
-		proxy.handleMetric(metric)
+		proxy.handleMetric(ctx, metric)
 	}
 
 	return &emptypb.Empty{}, nil
@@ -134,63 +175,420 @@ func (proxy *Handlers) SendMetricsV2(
 		proxy.Statsd.Count(
 			"veneur_proxy.ingest.metrics_count",
 			int64(1), []string{"protocol:grpc-stream"}, 1.0)
-		proxy.handleMetric(metric)
+		proxy.handleMetric(server.Context(), metric)
 	}
 }
 
-// Forwards metrics to downstream global Veneur instances.
-func (proxy *Handlers) handleMetric(metric *metricpb.Metric) {
-	tags := []string{}
-tagLoop:
-	for _, tag := range metric.Tags {
-		for _, matcher := range proxy.IgnoreTags {
-			if matcher.Match(tag) {
-				continue tagLoop
+// Receives and handles batches of metrics via gRPC streaming, acknowledging
+// each batch once its metrics are accepted for forwarding, i.e. queued to be
+// sent to a destination, rather than once they're delivered. Metrics that
+// couldn't be queued are reported as dropped, so that the sender can retry
+// them.
+func (proxy *Handlers) SendMetricsV3(
+	server forwardrpc.Forward_SendMetricsV3Server,
+) error {
+	proxy.Statsd.Count(
+		"veneur_proxy.ingest.request_count", 1,
+		[]string{"protocol:grpc-stream-v3"}, 1.0)
+	requestStart := time.Now()
+	defer func() {
+		proxy.Statsd.Timing(
+			"veneur_proxy.ingest.request_latency_ms", time.Since(requestStart),
+			[]string{"protocol:grpc-stream-v3"}, 1.0)
+	}()
+
+	for {
+		batch, err := server.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			proxy.Logger.WithError(err).Debug("error receiving metrics")
+			proxy.Statsd.Count(
+				"veneur_proxy.ingest.request_error_count", 1,
+				[]string{"protocol:grpc-stream-v3"}, 1.0)
+			return err
+		}
+		proxy.Statsd.Count(
+			"veneur_proxy.ingest.metrics_count",
+			int64(len(batch.Metrics)), []string{"protocol:grpc-stream-v3"}, 1.0)
+
+		ack := &forwardrpc.MetricBatchAck{
+			Id:      batch.Id,
+			Dropped: map[string]uint64{},
+		}
+		for index, metric := range batch.Metrics {
+			switch reason := proxy.handleMetric(server.Context(), metric); reason {
+			case "":
+				ack.Accepted++
+			case reasonLimit:
+				if ack.Rejected == nil {
+					ack.Rejected = map[string]uint64{}
+				}
+				ack.Rejected[reason]++
+			default:
+				ack.Dropped[reason]++
+				ack.Retry = append(ack.Retry, uint32(index))
 			}
 		}
-		tags = append(tags, tag)
+		err = server.Send(ack)
+		if err != nil {
+			proxy.Logger.WithError(err).Debug("error acknowledging metrics")
+			proxy.Statsd.Count(
+				"veneur_proxy.ingest.request_error_count", 1,
+				[]string{"protocol:grpc-stream-v3"}, 1.0)
+			return err
+		}
 	}
+}
 
-	key := fmt.Sprintf("%s%s%s",
-		metric.Name, strings.ToLower(metric.Type.String()), strings.Join(tags, ","))
-	destination, err := proxy.Destinations.Get(key)
-	if err != nil {
-		proxy.Logger.WithError(err).Debug("failed to get destination")
-		proxy.Statsd.Count(
-			"veneur_proxy.handle.metrics_count",
-			int64(1), []string{"error:destination"}, 1.0)
-		return
-	}
+// Spans are not proxied; local Veneurs should forward spans directly to the
+// global tier, using forward_service to hash them by trace.
+func (proxy *Handlers) SendSpans(
+	server forwardrpc.Forward_SendSpansServer,
+) error {
+	proxy.Statsd.Count(
+		"veneur_proxy.ingest.request_error_count", 1,
+		[]string{"protocol:grpc-spans"}, 1.0)
+	return status.Error(codes.Unimplemented, "veneur-proxy does not forward spans")
+}
 
+// Receives events and service checks, and forwards them to downstream global
+// Veneur instances. If any of them couldn't be forwarded, returns an
+// Unavailable error, so that the sender counts them as failed.
+func (proxy *Handlers) SendSamples(
+	ctx context.Context, sampleList *forwardrpc.SampleList,
+) (*empty.Empty, error) {
+	proxy.Statsd.Count(
+		"veneur_proxy.ingest.request_count", 1,
+		[]string{"protocol:grpc-samples"}, 1.0)
+	requestStart := time.Now()
 	defer func() {
-		recoverError := recover()
-		if recoverError != nil {
-			err = fmt.Errorf("%s", recoverError)
-			proxy.Logger.WithError(err).Debug("failed to forward metric")
-		}
+		proxy.Statsd.Timing(
+			"veneur_proxy.ingest.request_latency_ms", time.Since(requestStart),
+			[]string{"protocol:grpc-samples"}, 1.0)
 	}()
 
-	errorChannel := make(chan error)
-	select {
-	case destination.SendChannel() <- connect.SendRequest{
-		Metric:       metric,
-		ErrorChannel: errorChannel,
-	}:
-		err = <-errorChannel
+	proxy.Statsd.Count(
+		"veneur_proxy.ingest.samples_count",
+		int64(len(sampleList.Samples)), []string{"protocol:grpc-samples"}, 1.0)
+
+	failed := 0
+	var lastErr error
+	batches := map[*grpc.ClientConn][]*ssf.SSFSample{}
+	for _, sample := range sampleList.Samples {
+		destination, err := proxy.Destinations.Get(destinations.SampleKey(sample))
 		if err != nil {
+			proxy.Logger.WithError(err).Debug("failed to get destination")
 			proxy.Statsd.Count(
-				"veneur_proxy.handle.metrics_count",
-				int64(1), []string{"error:forward"}, 1.0)
+				"veneur_proxy.handle.samples_count",
+				int64(1), []string{"error:destination"}, 1.0)
+			failed += 1
+			lastErr = err
+			continue
+		}
+		connection := destination.Connection()
+		batches[connection] = append(batches[connection], sample)
+	}
+
+	for connection, batch := range batches {
+		_, err := forwardrpc.NewForwardClient(connection).SendSamples(
+			ctx, &forwardrpc.SampleList{Samples: batch})
+		if err != nil {
+			proxy.Logger.WithError(err).Debug("failed to forward samples")
+			proxy.Statsd.Count(
+				"veneur_proxy.handle.samples_count",
+				int64(len(batch)), []string{"error:forward"}, 1.0)
+			failed += len(batch)
+			lastErr = err
 		} else {
+			proxy.Statsd.Count(
+				"veneur_proxy.handle.samples_count",
+				int64(len(batch)), []string{"error:false"}, 1.0)
+		}
+	}
+
+	if failed > 0 {
+		return nil, status.Errorf(codes.Unavailable,
+			"failed to forward %d of %d samples: %v",
+			failed, len(sampleList.Samples), lastErr)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// Enqueues a metric to be sent asynchronously to its destination. If the
+// destination is removed concurrently, the metric is offered to the next
+// destination in the hash instead. Returns the reason the metric was dropped,
+// or an empty string if it was enqueued.
+func (proxy *Handlers) handleMetric(
+	ctx context.Context, metric *metricpb.Metric,
+) string {
+	key := proxy.metricKey(metric)
+	if !proxy.allowMetric(metric, key) {
+		return reasonLimit
+	}
+	proxy.replicateMetric(metric, key)
+	proxy.mirrorMetric(metric, key)
+
+	request := connect.SendRequest{
+		Metric: metric,
+		Key:    key,
+	}
+	var err error
+	for attempt := 0; attempt < maxEnqueueAttempts; attempt++ {
+		var destination connect.Destination
+		destination, err = proxy.Destinations.Get(key)
+		if err != nil {
+			proxy.Logger.WithError(err).Debug("failed to get destination")
 			proxy.Statsd.Count(
 				"veneur_proxy.handle.metrics_count",
-				int64(1), []string{"error:false"}, 1.0)
+				int64(1), []string{"error:destination"}, 1.0)
+			return "destination"
 		}
-		return
+		err = connect.Enqueue(ctx, destination, request, proxy.SendTimeout)
+		if err != connect.ErrDestinationClosed {
+			break
+		}
+	}
+
+	switch err {
+	case nil:
+		proxy.Destinations.Count(key)
+		proxy.Statsd.Count(
+			"veneur_proxy.handle.metrics_count",
+			int64(1), []string{"error:false"}, 1.0)
+		return ""
+	case connect.ErrDestinationClosed:
+		proxy.Logger.WithError(err).Debug("failed to forward metric")
+		proxy.Statsd.Count(
+			"veneur_proxy.handle.metrics_count",
+			int64(1), []string{"error:forward"}, 1.0)
+		return "forward"
 	default:
+		proxy.Logger.WithError(err).Debug("failed to enqueue metric")
 		proxy.Statsd.Count(
 			"veneur_proxy.handle.metrics_count",
 			int64(1), []string{"error:enqueue"}, 1.0)
+		return "enqueue"
+	}
+}
+
+// Checks a metric against the limits of its tenant, and counts the metrics
+// each tenant had accepted, dropped or downsampled. Returns whether the metric
+// is forwarded.
+func (proxy *Handlers) allowMetric(metric *metricpb.Metric, key string) bool {
+	if proxy.Limiter == nil {
+		return true
+	}
+	result := proxy.Limiter.Allow(metric.Tags, key)
+	tags := []string{"tenant:" + result.Tenant}
+	switch {
+	case result.Reason == "":
+		tags = append(tags, "status:accepted")
+	case result.Allowed:
+		tags = append(tags, "status:downsampled", "reason:"+result.Reason)
+	default:
+		tags = append(tags, "status:dropped", "reason:"+result.Reason)
+	}
+	proxy.Statsd.Count("veneur_proxy.limits.metrics_count", 1, tags, 1.0)
+	return result.Allowed
+}
+
+// Enqueues a copy of the metric to the mirror if it is selected, without
+// waiting for the result, so that the shadow pool never affects forwarding to
+// the primary destinations.
+func (proxy *Handlers) mirrorMetric(metric *metricpb.Metric, key string) {
+	if !proxy.mirrorsMetric(metric, key) {
+		return
+	}
+
+	destination, err := proxy.Mirror.Destinations.Get(key)
+	if err != nil {
+		proxy.Statsd.Count(
+			"veneur_proxy.handle.mirror_metrics_count",
+			int64(1), []string{"error:destination"}, 1.0)
+		return
+	}
+	err = connect.Enqueue(
+		context.Background(), destination,
+		connect.SendRequest{Metric: metric, Key: key}, 0)
+	if err == nil {
+		proxy.Mirror.Destinations.Count(key)
+		proxy.Statsd.Count(
+			"veneur_proxy.handle.mirror_metrics_count",
+			int64(1), []string{"error:false"}, 1.0)
+	} else {
+		proxy.Statsd.Count(
+			"veneur_proxy.handle.mirror_metrics_count",
+			int64(1), []string{"error:enqueue"}, 1.0)
+	}
+}
+
+// Returns whether a metric is selected to be mirrored.
+func (proxy *Handlers) mirrorsMetric(metric *metricpb.Metric, key string) bool {
+	if proxy.Mirror == nil {
+		return false
+	}
+	if len(proxy.Mirror.Match) > 0 &&
+		!matcher.Match(proxy.Mirror.Match, metric.Name, metric.Tags) {
+		return false
+	}
+	return proxy.Mirror.SampleRate <= 0 ||
+		destinations.KeyFraction(key) < proxy.Mirror.SampleRate
+}
+
+// Returns the key used to choose a destination for a metric, ignoring the tags
+// that match IgnoreTags.
+func (proxy *Handlers) metricKey(metric *metricpb.Metric) string {
+	tags := []string{}
+tagLoop:
+	for _, tag := range metric.Tags {
+		for _, matcher := range proxy.IgnoreTags {
+			if matcher.Match(tag) {
+				continue tagLoop
+			}
+		}
+		tags = append(tags, tag)
+	}
+	return destinations.MetricKey(metric, tags)
+}
+
+// Enqueues a copy of the metric to each replica without waiting for the
+// result, so that a slow or unavailable replica does not affect forwarding to
+// the primary destinations.
+func (proxy *Handlers) replicateMetric(metric *metricpb.Metric, key string) {
+	for _, replica := range proxy.Replicas {
+		replicaTag := "replica:" + replica.Name
+		destination, err := replica.Destinations.Get(key)
+		if err != nil {
+			proxy.Statsd.Count(
+				"veneur_proxy.handle.replica_metrics_count",
+				int64(1), []string{replicaTag, "error:destination"}, 1.0)
+			continue
+		}
+		err = connect.Enqueue(
+			context.Background(), destination,
+			connect.SendRequest{Metric: metric, Key: key}, 0)
+		if err == nil {
+			replica.Destinations.Count(key)
+			proxy.Statsd.Count(
+				"veneur_proxy.handle.replica_metrics_count",
+				int64(1), []string{replicaTag, "error:false"}, 1.0)
+		} else {
+			proxy.Statsd.Count(
+				"veneur_proxy.handle.replica_metrics_count",
+				int64(1), []string{replicaTag, "error:enqueue"}, 1.0)
+		}
+	}
+}
+
+type destinationsResponse struct {
+	Destinations []destinations.DestinationStatus            `json:"destinations"`
+	Mirror       []destinations.DestinationStatus            `json:"mirror,omitempty"`
+	Replicas     map[string][]destinations.DestinationStatus `json:"replicas,omitempty"`
+}
+
+// Lists the destinations of the primary pool, of each replica and of the
+// mirror, with the state of their connections, their queue depth and their
+// recent errors.
+func (proxy *Handlers) HandleDestinations(
+	writer http.ResponseWriter, request *http.Request,
+) {
+	response := destinationsResponse{
+		Destinations: proxy.Destinations.Status(),
+	}
+	if len(proxy.Replicas) > 0 {
+		response.Replicas =
+			make(map[string][]destinations.DestinationStatus, len(proxy.Replicas))
+		for _, replica := range proxy.Replicas {
+			response.Replicas[replica.Name] = replica.Destinations.Status()
+		}
+	}
+	if proxy.Mirror != nil {
+		response.Mirror = proxy.Mirror.Destinations.Status()
+	}
+	proxy.writeJson(writer, response)
+}
+
+type ownerResponse struct {
+	Destination string `json:"destination"`
+	Key         string `json:"key"`
+	// The destination in the mirror, if the series is mirrored.
+	Mirror   string            `json:"mirror,omitempty"`
+	Replicas map[string]string `json:"replicas,omitempty"`
+}
+
+// Returns the destination that a metric is forwarded to, given its name and
+// type, and its tags as repeated tag parameters, e.g.
+// "/admin/owner?name=api.requests&type=counter&tag=env:prod&tag=route:/". The
+// key is built the same way as for forwarded metrics, so tags matching
+// IgnoreTags don't change the result.
+func (proxy *Handlers) HandleOwner(
+	writer http.ResponseWriter, request *http.Request,
+) {
+	query := request.URL.Query()
+	name := query.Get("name")
+	if name == "" {
+		http.Error(writer, "missing metric name", http.StatusBadRequest)
+		return
+	}
+	metricType, err := parseMetricType(query.Get("type"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
 	}
+
+	metric := &metricpb.Metric{
+		Name: name,
+		Tags: query["tag"],
+		Type: metricType,
+	}
+	key := proxy.metricKey(metric)
+	address, err := proxy.Destinations.Lookup(key)
+	if err != nil {
+		http.Error(
+			writer, fmt.Sprintf("failed to get destination: %v", err),
+			http.StatusServiceUnavailable)
+		return
+	}
+	response := ownerResponse{
+		Destination: address,
+		Key:         key,
+	}
+	if len(proxy.Replicas) > 0 {
+		response.Replicas = make(map[string]string, len(proxy.Replicas))
+		for _, replica := range proxy.Replicas {
+			// A replica without destinations is left out.
+			address, err := replica.Destinations.Lookup(key)
+			if err == nil {
+				response.Replicas[replica.Name] = address
+			}
+		}
+	}
+	if proxy.mirrorsMetric(metric, key) {
+		address, err := proxy.Mirror.Destinations.Lookup(key)
+		if err == nil {
+			response.Mirror = address
+		}
+	}
+	proxy.writeJson(writer, response)
+}
+
+// Parses a metric type case-insensitively, as it appears in metric keys.
+func parseMetricType(name string) (metricpb.Type, error) {
+	for value, typeName := range metricpb.Type_name {
+		if strings.EqualFold(name, typeName) {
+			return metricpb.Type(value), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid metric type %q", name)
+}
+
+func (proxy *Handlers) writeJson(writer http.ResponseWriter, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	encoder := encodingJson.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(value)
+	if err != nil {
+		proxy.Logger.WithError(err).Debug("failed to write response")
+	}
 }
@@ -3,23 +3,33 @@ package handlers_test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stripe/veneur/v14/discovery"
 	"github.com/stripe/veneur/v14/forwardrpc"
+	"github.com/stripe/veneur/v14/internal/forwardtest"
 	"github.com/stripe/veneur/v14/proxy/connect"
 	"github.com/stripe/veneur/v14/proxy/destinations"
 	"github.com/stripe/veneur/v14/proxy/handlers"
+	"github.com/stripe/veneur/v14/proxy/limits"
 	"github.com/stripe/veneur/v14/samplers/metricpb"
 	"github.com/stripe/veneur/v14/scopedstatsd"
+	"github.com/stripe/veneur/v14/ssf"
 	"github.com/stripe/veneur/v14/util/matcher"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -56,6 +66,7 @@ func CreateTestHandlers(
 			Statsd:             mockStatsd,
 			HealthcheckContext: healthcheckContext,
 			IgnoreTags:         ignoreTags,
+			SendTimeout:        time.Second,
 		},
 		HealthcheckContext:       healthcheckContext,
 		HealthcheckContextCancel: healthcheckContextCancel,
@@ -138,6 +149,7 @@ func TestProxyJson(t *testing.T) {
 	fixture.Destinations.EXPECT().
 		Get("metric-namecountertag1:value1,tag2:value2").
 		Return(fixture.Destination, nil)
+	fixture.Destinations.EXPECT().Count("metric-namecountertag1:value1,tag2:value2")
 	sendChannel := make(chan connect.SendRequest)
 	fixture.Destination.EXPECT().SendChannel().Return(sendChannel)
 
@@ -150,7 +162,6 @@ func TestProxyJson(t *testing.T) {
 		close(handleJsonMetricsChannel)
 	}()
 	sendRequest := <-sendChannel
-	sendRequest.ErrorChannel <- nil
 	<-handleJsonMetricsChannel
 
 	assert.Equal(t, &metricpb.Metric{
@@ -249,6 +260,7 @@ func TestProxyGrpcSingle(t *testing.T) {
 	fixture.Destinations.EXPECT().
 		Get("metric-namecountertag1:value1,tag2:value2").
 		Return(fixture.Destination, nil)
+	fixture.Destinations.EXPECT().Count("metric-namecountertag1:value1,tag2:value2")
 	sendChannel := make(chan connect.SendRequest)
 	fixture.Destination.EXPECT().SendChannel().Return(sendChannel)
 
@@ -261,7 +273,6 @@ func TestProxyGrpcSingle(t *testing.T) {
 		sendMetricsChannel <- err
 	}()
 	sendRequest := <-sendChannel
-	sendRequest.ErrorChannel <- nil
 	err := <-sendMetricsChannel
 
 	assert.Equal(t, metric, sendRequest.Metric)
@@ -290,10 +301,12 @@ func TestProxyGrpcStream(t *testing.T) {
 	fixture.Destinations.EXPECT().
 		Get("metric-namecountertag1:value1,tag2:value2").
 		Return(fixture.Destination, nil)
+	fixture.Destinations.EXPECT().Count("metric-namecountertag1:value1,tag2:value2")
 	sendChannel := make(chan connect.SendRequest)
 	fixture.Destination.EXPECT().SendChannel().Return(sendChannel)
 
 	mockServer := forwardrpc.NewMockForward_SendMetricsV2Server(ctrl)
+	mockServer.EXPECT().Context().AnyTimes().Return(context.Background())
 	mockServer.EXPECT().Recv().Times(1).Return(metric, nil)
 	mockServer.EXPECT().Recv().Times(1).Return(nil, io.EOF)
 	mockServer.EXPECT().SendAndClose(&emptypb.Empty{}).Return(nil)
@@ -303,7 +316,6 @@ func TestProxyGrpcStream(t *testing.T) {
 		sendMetricsChannel <- fixture.Handlers.SendMetricsV2(mockServer)
 	}()
 	sendRequest := <-sendChannel
-	sendRequest.ErrorChannel <- nil
 	err := <-sendMetricsChannel
 
 	assert.Equal(t, metric, sendRequest.Metric)
@@ -335,10 +347,12 @@ func TestProxyGrpcStreamError(t *testing.T) {
 	fixture.Destinations.EXPECT().
 		Get("metric-namecountertag1:value1,tag2:value2").
 		Return(fixture.Destination, nil)
+	fixture.Destinations.EXPECT().Count("metric-namecountertag1:value1,tag2:value2")
 	sendChannel := make(chan connect.SendRequest)
 	fixture.Destination.EXPECT().SendChannel().Return(sendChannel)
 
 	mockServer := forwardrpc.NewMockForward_SendMetricsV2Server(ctrl)
+	mockServer.EXPECT().Context().AnyTimes().Return(context.Background())
 	mockServer.EXPECT().Recv().Times(1).Return(metric, nil)
 	mockServer.EXPECT().Recv().Times(1).Return(nil, errors.New("stream error"))
 	mockServer.EXPECT().SendAndClose(&emptypb.Empty{}).Return(nil)
@@ -348,7 +362,6 @@ func TestProxyGrpcStreamError(t *testing.T) {
 		sendMetricsChannel <- fixture.Handlers.SendMetricsV2(mockServer)
 	}()
 	sendRequest := <-sendChannel
-	sendRequest.ErrorChannel <- nil
 	err := <-sendMetricsChannel
 
 	assert.Equal(t, metric, sendRequest.Metric)
@@ -375,10 +388,12 @@ func TestProxyGrpcStreamIgnoreTags(t *testing.T) {
 	fixture.Destinations.EXPECT().
 		Get("metric-namecountertag2:value2").
 		Return(fixture.Destination, nil)
+	fixture.Destinations.EXPECT().Count("metric-namecountertag2:value2")
 	sendChannel := make(chan connect.SendRequest)
 	fixture.Destination.EXPECT().SendChannel().Return(sendChannel)
 
 	mockServer := forwardrpc.NewMockForward_SendMetricsV2Server(ctrl)
+	mockServer.EXPECT().Context().AnyTimes().Return(context.Background())
 	mockServer.EXPECT().Recv().Times(1).Return(metric, nil)
 	mockServer.EXPECT().Recv().Times(1).Return(nil, io.EOF)
 	mockServer.EXPECT().SendAndClose(&emptypb.Empty{}).Return(nil)
@@ -388,7 +403,66 @@ func TestProxyGrpcStreamIgnoreTags(t *testing.T) {
 		sendMetricsChannel <- fixture.Handlers.SendMetricsV2(mockServer)
 	}()
 	sendRequest := <-sendChannel
-	sendRequest.ErrorChannel <- nil
+	err := <-sendMetricsChannel
+
+	assert.Equal(t, metric, sendRequest.Metric)
+	assert.NoError(t, err)
+}
+
+func TestProxyGrpcStreamV3(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestHandlers(ctrl, []matcher.TagMatcher{})
+
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.ingest.request_count",
+		int64(1), []string{"protocol:grpc-stream-v3"}, 1.0)
+	fixture.Statsd.EXPECT().Timing(
+		"veneur_proxy.ingest.request_latency_ms",
+		gomock.Any(), []string{"protocol:grpc-stream-v3"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.ingest.metrics_count",
+		int64(2), []string{"protocol:grpc-stream-v3"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.handle.metrics_count",
+		int64(1), []string{"error:false"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.handle.metrics_count",
+		int64(1), []string{"error:destination"}, 1.0)
+
+	fixture.Destinations.EXPECT().
+		Get("metric-namecountertag1:value1,tag2:value2").
+		Return(fixture.Destination, nil)
+	fixture.Destinations.EXPECT().Count("metric-namecountertag1:value1,tag2:value2")
+	fixture.Destinations.EXPECT().
+		Get("other-metric-namecounter").
+		Return(nil, errors.New("no destination"))
+	sendChannel := make(chan connect.SendRequest)
+	fixture.Destination.EXPECT().SendChannel().Return(sendChannel)
+
+	mockServer := forwardrpc.NewMockForward_SendMetricsV3Server(ctrl)
+	mockServer.EXPECT().Context().AnyTimes().Return(context.Background())
+	mockServer.EXPECT().Recv().Times(1).Return(&forwardrpc.MetricBatch{
+		Id: 3,
+		Metrics: []*metricpb.Metric{metric, {
+			Name: "other-metric-name",
+			Type: metricpb.Type_Counter,
+		}},
+	}, nil)
+	mockServer.EXPECT().Send(&forwardrpc.MetricBatchAck{
+		Id:       3,
+		Accepted: 1,
+		Dropped:  map[string]uint64{"destination": 1},
+		Retry:    []uint32{1},
+	}).Return(nil)
+	mockServer.EXPECT().Recv().Times(1).Return(nil, io.EOF)
+
+	sendMetricsChannel := make(chan error)
+	go func() {
+		sendMetricsChannel <- fixture.Handlers.SendMetricsV3(mockServer)
+	}()
+	sendRequest := <-sendChannel
 	err := <-sendMetricsChannel
 
 	assert.Equal(t, metric, sendRequest.Metric)
@@ -419,6 +493,7 @@ func TestNoDestination(t *testing.T) {
 		Return(nil, errors.New("no destination"))
 
 	mockServer := forwardrpc.NewMockForward_SendMetricsV2Server(ctrl)
+	mockServer.EXPECT().Context().AnyTimes().Return(context.Background())
 	mockServer.EXPECT().Recv().Times(1).Return(metric, nil)
 	mockServer.EXPECT().Recv().Times(1).Return(nil, io.EOF)
 	mockServer.EXPECT().SendAndClose(&emptypb.Empty{}).Return(nil)
@@ -427,7 +502,7 @@ func TestNoDestination(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestForwardError(t *testing.T) {
+func TestDestinationClosed(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
@@ -446,27 +521,74 @@ func TestForwardError(t *testing.T) {
 		"veneur_proxy.handle.metrics_count",
 		int64(1), []string{"error:forward"}, 1.0)
 
+	// The destination is offered the metric three times, and is closed each
+	// time.
 	fixture.Destinations.EXPECT().
 		Get("metric-namecountertag1:value1,tag2:value2").
-		Return(fixture.Destination, nil)
+		Times(3).Return(fixture.Destination, nil)
 	sendChannel := make(chan connect.SendRequest)
-	fixture.Destination.EXPECT().SendChannel().Return(sendChannel)
+	close(sendChannel)
+	fixture.Destination.EXPECT().SendChannel().Times(3).Return(sendChannel)
 
 	mockServer := forwardrpc.NewMockForward_SendMetricsV2Server(ctrl)
+	mockServer.EXPECT().Context().AnyTimes().Return(context.Background())
 	mockServer.EXPECT().Recv().Times(1).Return(metric, nil)
 	mockServer.EXPECT().Recv().Times(1).Return(nil, io.EOF)
 	mockServer.EXPECT().SendAndClose(&emptypb.Empty{}).Return(nil)
 
-	sendMetricsChannel := make(chan error)
-	go func() {
-		sendMetricsChannel <- fixture.Handlers.SendMetricsV2(mockServer)
-	}()
-	sendRequest := <-sendChannel
-	sendRequest.ErrorChannel <- errors.New("forward error")
-	err := <-sendMetricsChannel
+	err := fixture.Handlers.SendMetricsV2(mockServer)
+	assert.NoError(t, err)
+}
 
-	assert.Equal(t, metric, sendRequest.Metric)
+func TestFailover(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestHandlers(ctrl, []matcher.TagMatcher{})
+
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.ingest.request_count",
+		int64(1), []string{"protocol:grpc-stream"}, 1.0)
+	fixture.Statsd.EXPECT().Timing(
+		"veneur_proxy.ingest.request_latency_ms",
+		gomock.Any(), []string{"protocol:grpc-stream"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.ingest.metrics_count",
+		int64(1), []string{"protocol:grpc-stream"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.handle.metrics_count",
+		int64(1), []string{"error:false"}, 1.0)
+
+	// The first destination is removed after it is chosen, so the metric goes
+	// to the next destination in the hash.
+	closedChannel := make(chan connect.SendRequest)
+	close(closedChannel)
+	fixture.Destination.EXPECT().SendChannel().Return(closedChannel)
+	nextDestination := connect.NewMockDestination(ctrl)
+	sendChannel := make(chan connect.SendRequest, 1)
+	nextDestination.EXPECT().SendChannel().Return(sendChannel)
+	gomock.InOrder(
+		fixture.Destinations.EXPECT().
+			Get("metric-namecountertag1:value1,tag2:value2").
+			Return(fixture.Destination, nil),
+		fixture.Destinations.EXPECT().
+			Get("metric-namecountertag1:value1,tag2:value2").
+			Return(nextDestination, nil))
+	fixture.Destinations.EXPECT().Count("metric-namecountertag1:value1,tag2:value2")
+
+	mockServer := forwardrpc.NewMockForward_SendMetricsV2Server(ctrl)
+	mockServer.EXPECT().Context().AnyTimes().Return(context.Background())
+	mockServer.EXPECT().Recv().Times(1).Return(metric, nil)
+	mockServer.EXPECT().Recv().Times(1).Return(nil, io.EOF)
+	mockServer.EXPECT().SendAndClose(&emptypb.Empty{}).Return(nil)
+
+	err := fixture.Handlers.SendMetricsV2(mockServer)
 	assert.NoError(t, err)
+
+	sendRequest := <-sendChannel
+	assert.Equal(t, metric, sendRequest.Metric)
+	assert.Equal(t, "metric-namecountertag1:value1,tag2:value2", sendRequest.Key)
+	assert.Nil(t, sendRequest.ErrorChannel)
 }
 
 func TestChannelBufferFull(t *testing.T) {
@@ -474,6 +596,7 @@ func TestChannelBufferFull(t *testing.T) {
 	defer ctrl.Finish()
 
 	fixture := CreateTestHandlers(ctrl, []matcher.TagMatcher{})
+	fixture.Handlers.SendTimeout = time.Millisecond
 
 	fixture.Statsd.EXPECT().Count(
 		"veneur_proxy.ingest.request_count",
@@ -495,6 +618,7 @@ func TestChannelBufferFull(t *testing.T) {
 	fixture.Destination.EXPECT().SendChannel().Return(sendChannel)
 
 	mockServer := forwardrpc.NewMockForward_SendMetricsV2Server(ctrl)
+	mockServer.EXPECT().Context().AnyTimes().Return(context.Background())
 	mockServer.EXPECT().Recv().Times(1).Return(metric, nil)
 	mockServer.EXPECT().Recv().Times(1).Return(nil, io.EOF)
 	mockServer.EXPECT().SendAndClose(&emptypb.Empty{}).Return(nil)
@@ -502,3 +626,516 @@ func TestChannelBufferFull(t *testing.T) {
 	err := fixture.Handlers.SendMetricsV2(mockServer)
 	assert.NoError(t, err)
 }
+
+func TestProxySamples(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestHandlers(ctrl, []matcher.TagMatcher{})
+
+	received := make(chan []*ssf.SSFSample, 1)
+	global := forwardtest.NewServer(func([]*metricpb.Metric) {})
+	global.SampleHandler = func(samples []*ssf.SSFSample) {
+		received <- samples
+	}
+	global.Start(t)
+	defer global.Stop()
+
+	connection, err := grpc.Dial(global.Addr().String(), grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer connection.Close()
+
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.ingest.request_count",
+		int64(1), []string{"protocol:grpc-samples"}, 1.0)
+	fixture.Statsd.EXPECT().Timing(
+		"veneur_proxy.ingest.request_latency_ms",
+		gomock.Any(), []string{"protocol:grpc-samples"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.ingest.samples_count",
+		int64(2), []string{"protocol:grpc-samples"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.handle.samples_count",
+		int64(2), []string{"error:false"}, 1.0)
+
+	fixture.Destinations.EXPECT().Get("event-name").
+		Times(2).Return(fixture.Destination, nil)
+	fixture.Destination.EXPECT().Connection().Times(2).Return(connection)
+
+	sample := &ssf.SSFSample{
+		Name:    "event-name",
+		Message: "event-message",
+		Tags:    map[string]string{"tag1": "value1"},
+	}
+	_, err = fixture.Handlers.SendSamples(
+		context.Background(), &forwardrpc.SampleList{
+			Samples: []*ssf.SSFSample{sample, sample},
+		})
+	assert.NoError(t, err)
+
+	select {
+	case samples := <-received:
+		if assert.Len(t, samples, 2) {
+			assert.Equal(t, sample, samples[0])
+			assert.Equal(t, sample, samples[1])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for samples to be forwarded")
+	}
+}
+
+func TestProxySamplesNoDestination(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestHandlers(ctrl, []matcher.TagMatcher{})
+
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.ingest.request_count",
+		int64(1), []string{"protocol:grpc-samples"}, 1.0)
+	fixture.Statsd.EXPECT().Timing(
+		"veneur_proxy.ingest.request_latency_ms",
+		gomock.Any(), []string{"protocol:grpc-samples"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.ingest.samples_count",
+		int64(1), []string{"protocol:grpc-samples"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.handle.samples_count",
+		int64(1), []string{"error:destination"}, 1.0)
+
+	fixture.Destinations.EXPECT().Get("event-name").
+		Return(nil, errors.New("no destination"))
+
+	_, err := fixture.Handlers.SendSamples(
+		context.Background(), &forwardrpc.SampleList{
+			Samples: []*ssf.SSFSample{{Name: "event-name"}},
+		})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestProxySamplesForwardError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestHandlers(ctrl, []matcher.TagMatcher{})
+
+	// nothing listens on the address once the listener is closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener.Close()
+	connection, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer connection.Close()
+
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.ingest.request_count",
+		int64(1), []string{"protocol:grpc-samples"}, 1.0)
+	fixture.Statsd.EXPECT().Timing(
+		"veneur_proxy.ingest.request_latency_ms",
+		gomock.Any(), []string{"protocol:grpc-samples"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.ingest.samples_count",
+		int64(2), []string{"protocol:grpc-samples"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.handle.samples_count",
+		int64(2), []string{"error:forward"}, 1.0)
+
+	fixture.Destinations.EXPECT().Get("event-name").
+		Times(2).Return(fixture.Destination, nil)
+	fixture.Destination.EXPECT().Connection().Times(2).Return(connection)
+
+	sample := &ssf.SSFSample{Name: "event-name"}
+	_, err = fixture.Handlers.SendSamples(
+		context.Background(), &forwardrpc.SampleList{
+			Samples: []*ssf.SSFSample{sample, sample},
+		})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestProxyGrpcReplicas(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestHandlers(ctrl, []matcher.TagMatcher{})
+	replicaDestinations := []*destinations.MockDestinations{
+		destinations.NewMockDestinations(ctrl),
+		destinations.NewMockDestinations(ctrl),
+		destinations.NewMockDestinations(ctrl),
+	}
+	fixture.Handlers.Replicas = []handlers.Replica{{
+		Destinations: replicaDestinations[0],
+		Name:         "a",
+	}, {
+		Destinations: replicaDestinations[1],
+		Name:         "b",
+	}, {
+		Destinations: replicaDestinations[2],
+		Name:         "c",
+	}}
+
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.ingest.request_count",
+		int64(1), []string{"protocol:grpc-single"}, 1.0)
+	fixture.Statsd.EXPECT().Timing(
+		"veneur_proxy.ingest.request_latency_ms",
+		gomock.Any(), []string{"protocol:grpc-single"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.ingest.metrics_count",
+		int64(1), []string{"protocol:grpc-single"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.handle.replica_metrics_count",
+		int64(1), []string{"replica:a", "error:false"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.handle.replica_metrics_count",
+		int64(1), []string{"replica:b", "error:enqueue"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.handle.replica_metrics_count",
+		int64(1), []string{"replica:c", "error:destination"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.handle.metrics_count",
+		int64(1), []string{"error:false"}, 1.0)
+
+	key := "metric-namecountertag1:value1,tag2:value2"
+	fixture.Destinations.EXPECT().Get(key).Return(fixture.Destination, nil)
+	fixture.Destinations.EXPECT().Count(key)
+	sendChannel := make(chan connect.SendRequest)
+	fixture.Destination.EXPECT().SendChannel().Return(sendChannel)
+
+	// The first replica has room in its buffer.
+	replicaDestination := connect.NewMockDestination(ctrl)
+	replicaDestinations[0].EXPECT().Get(key).Return(replicaDestination, nil)
+	replicaDestinations[0].EXPECT().Count(key)
+	replicaChannel := make(chan connect.SendRequest, 1)
+	replicaDestination.EXPECT().SendChannel().Return(replicaChannel)
+
+	// The second replica's buffer is full.
+	fullDestination := connect.NewMockDestination(ctrl)
+	replicaDestinations[1].EXPECT().Get(key).Return(fullDestination, nil)
+	fullDestination.EXPECT().SendChannel().Return(make(chan connect.SendRequest))
+
+	// The third replica has no destinations.
+	replicaDestinations[2].EXPECT().Get(key).
+		Return(nil, errors.New("no destinations"))
+
+	sendMetricsChannel := make(chan error)
+	go func() {
+		_, err := fixture.Handlers.SendMetrics(
+			context.Background(), &forwardrpc.MetricList{
+				Metrics: []*metricpb.Metric{metric},
+			})
+		sendMetricsChannel <- err
+	}()
+	sendRequest := <-sendChannel
+	err := <-sendMetricsChannel
+	assert.NoError(t, err)
+	assert.Equal(t, metric, sendRequest.Metric)
+
+	// Replicas do not block on the result of the send.
+	replicaRequest := <-replicaChannel
+	assert.Equal(t, metric, replicaRequest.Metric)
+}
+
+func TestHandleOwner(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestHandlers(ctrl, []matcher.TagMatcher{
+		matcher.CreateTagMatcher(&matcher.TagMatcherConfig{
+			Kind:  "prefix",
+			Unset: false,
+			Value: "host",
+		}),
+	})
+	replicaDestinations := destinations.NewMockDestinations(ctrl)
+	fixture.Handlers.Replicas = []handlers.Replica{{
+		Destinations: replicaDestinations,
+		Name:         "a",
+	}}
+
+	// Ignored tags are left out of the key, as when forwarding.
+	key := "metric-namecountertag1:value1,tag2:value2"
+	fixture.Destinations.EXPECT().Lookup(key).Return("10.0.0.1:8128", nil)
+	replicaDestinations.EXPECT().Lookup(key).Return("10.0.1.1:8128", nil)
+
+	recorder := httptest.NewRecorder()
+	fixture.Handlers.HandleOwner(recorder, httptest.NewRequest(
+		"GET", "/admin/owner?name=metric-name&type=Counter&tag=tag1:value1"+
+			"&tag=host:abc&tag=tag2:value2", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{
+		"destination": "10.0.0.1:8128",
+		"key": "metric-namecountertag1:value1,tag2:value2",
+		"replicas": {"a": "10.0.1.1:8128"}
+	}`, recorder.Body.String())
+}
+
+func TestHandleOwnerInvalidType(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestHandlers(ctrl, []matcher.TagMatcher{})
+
+	recorder := httptest.NewRecorder()
+	fixture.Handlers.HandleOwner(recorder, httptest.NewRequest(
+		"GET", "/admin/owner?name=metric-name&type=meter", nil))
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestHandleOwnerNoDestination(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestHandlers(ctrl, []matcher.TagMatcher{})
+	fixture.Destinations.EXPECT().Lookup("metric-namegauge").
+		Return("", errors.New("empty circle"))
+
+	recorder := httptest.NewRecorder()
+	fixture.Handlers.HandleOwner(recorder, httptest.NewRequest(
+		"GET", "/admin/owner?name=metric-name&type=gauge", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+}
+
+func TestHandleDestinations(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestHandlers(ctrl, []matcher.TagMatcher{})
+	replicaDestinations := destinations.NewMockDestinations(ctrl)
+	fixture.Handlers.Replicas = []handlers.Replica{{
+		Destinations: replicaDestinations,
+		Name:         "a",
+	}}
+
+	fixture.Destinations.EXPECT().Status().Return(
+		[]destinations.DestinationStatus{{
+			DestinationStatus: connect.DestinationStatus{
+				Address:         "10.0.0.1:8128",
+				ConnectionState: "READY",
+				Protocol:        "v3",
+				QueueCapacity:   100,
+				QueueLength:     5,
+				RecentErrors:    map[string]int64{"dropped": 2},
+				LastError:       "dropped: queue_full",
+			},
+			Membership: "active",
+		}})
+	replicaDestinations.EXPECT().Status().
+		Return([]destinations.DestinationStatus{})
+
+	recorder := httptest.NewRecorder()
+	fixture.Handlers.HandleDestinations(
+		recorder, httptest.NewRequest("GET", "/admin/destinations", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{
+		"destinations": [{
+			"address": "10.0.0.1:8128",
+			"closing": false,
+			"connection_state": "READY",
+			"last_error": "dropped: queue_full",
+			"membership": "active",
+			"pending_batches": 0,
+			"protocol": "v3",
+			"queue_capacity": 100,
+			"queue_length": 5,
+			"recent_errors": {"dropped": 2}
+		}],
+		"replicas": {"a": []}
+	}`, recorder.Body.String())
+}
+
+func TestProxyGrpcMirror(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestHandlers(ctrl, []matcher.TagMatcher{})
+	mirrorDestinations := destinations.NewMockDestinations(ctrl)
+	fixture.Handlers.Mirror = &handlers.Mirror{
+		Destinations: mirrorDestinations,
+		Match: []matcher.Matcher{{
+			Name: matcher.CreateNameMatcher(&matcher.NameMatcherConfig{
+				Kind:  "exact",
+				Value: "metric-name",
+			}),
+		}},
+	}
+	otherMetric := &metricpb.Metric{
+		Name: "other-metric",
+		Type: metricpb.Type_Counter,
+		Value: &metricpb.Metric_Counter{
+			Counter: &metricpb.CounterValue{Value: 1},
+		},
+	}
+
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.ingest.request_count",
+		int64(1), []string{"protocol:grpc-single"}, 1.0)
+	fixture.Statsd.EXPECT().Timing(
+		"veneur_proxy.ingest.request_latency_ms",
+		gomock.Any(), []string{"protocol:grpc-single"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.ingest.metrics_count",
+		int64(2), []string{"protocol:grpc-single"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.handle.mirror_metrics_count",
+		int64(1), []string{"error:enqueue"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.handle.metrics_count",
+		int64(1), []string{"error:false"}, 1.0).Times(2)
+
+	key := "metric-namecountertag1:value1,tag2:value2"
+	sendChannel := make(chan connect.SendRequest, 2)
+	fixture.Destinations.EXPECT().Get(key).Return(fixture.Destination, nil)
+	fixture.Destinations.EXPECT().Get("other-metriccounter").
+		Return(fixture.Destination, nil)
+	fixture.Destinations.EXPECT().Count(key)
+	fixture.Destinations.EXPECT().Count("other-metriccounter")
+	fixture.Destination.EXPECT().SendChannel().Return(sendChannel).Times(2)
+
+	// The mirror's buffer is full, which doesn't hold up the primary
+	// destinations. Metrics that don't match aren't mirrored.
+	mirrorDestination := connect.NewMockDestination(ctrl)
+	mirrorDestinations.EXPECT().Get(key).Return(mirrorDestination, nil)
+	mirrorDestination.EXPECT().SendChannel().
+		Return(make(chan connect.SendRequest))
+
+	_, err := fixture.Handlers.SendMetrics(
+		context.Background(), &forwardrpc.MetricList{
+			Metrics: []*metricpb.Metric{metric, otherMetric},
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, metric, (<-sendChannel).Metric)
+	assert.Equal(t, otherMetric, (<-sendChannel).Metric)
+}
+
+func TestProxyGrpcMirrorSampleRate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestHandlers(ctrl, []matcher.TagMatcher{})
+	mirrorDestinations := destinations.NewMockDestinations(ctrl)
+	fixture.Handlers.Mirror = &handlers.Mirror{
+		Destinations: mirrorDestinations,
+		SampleRate:   0.25,
+	}
+
+	fixture.Statsd.EXPECT().Count(
+		gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	fixture.Statsd.EXPECT().Timing(
+		gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	fixture.Destinations.EXPECT().Get(gomock.Any()).
+		Return(fixture.Destination, nil).AnyTimes()
+	fixture.Destinations.EXPECT().Count(gomock.Any()).AnyTimes()
+	sendChannel := make(chan connect.SendRequest, 1000)
+	fixture.Destination.EXPECT().SendChannel().Return(sendChannel).AnyTimes()
+
+	mirrorChannel := make(chan connect.SendRequest, 1000)
+	mirrorDestination := connect.NewMockDestination(ctrl)
+	mirrorDestinations.EXPECT().Get(gomock.Any()).
+		Return(mirrorDestination, nil).AnyTimes()
+	mirrorDestinations.EXPECT().Count(gomock.Any()).AnyTimes()
+	mirrorDestination.EXPECT().SendChannel().
+		Return(mirrorChannel).AnyTimes()
+
+	metrics := make([]*metricpb.Metric, 1000)
+	for index := range metrics {
+		metrics[index] = &metricpb.Metric{
+			Name: fmt.Sprintf("metric-%d", index),
+			Type: metricpb.Type_Counter,
+			Value: &metricpb.Metric_Counter{
+				Counter: &metricpb.CounterValue{Value: 1},
+			},
+		}
+	}
+	_, err := fixture.Handlers.SendMetrics(
+		context.Background(), &forwardrpc.MetricList{Metrics: metrics})
+	assert.NoError(t, err)
+	assert.Len(t, sendChannel, 1000)
+	assert.InDelta(t, 250, len(mirrorChannel), 50)
+
+	// The same series are mirrored each time.
+	mirrored := map[string]struct{}{}
+	for len(mirrorChannel) > 0 {
+		mirrored[(<-mirrorChannel).Metric.Name] = struct{}{}
+	}
+	for len(sendChannel) > 0 {
+		<-sendChannel
+	}
+	_, err = fixture.Handlers.SendMetrics(
+		context.Background(), &forwardrpc.MetricList{Metrics: metrics})
+	assert.NoError(t, err)
+	assert.Len(t, mirrorChannel, len(mirrored))
+	for len(mirrorChannel) > 0 {
+		assert.Contains(t, mirrored, (<-mirrorChannel).Metric.Name)
+	}
+}
+
+func TestProxyGrpcStreamV3Limits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fixture := CreateTestHandlers(ctrl, []matcher.TagMatcher{})
+	limiter, err := limits.New(limits.Config{
+		Default: limits.Limits{
+			SeriesPerInterval: 1,
+		},
+		TenantTag: "tag1",
+	})
+	assert.NoError(t, err)
+	fixture.Handlers.Limiter = limiter
+	otherMetric := &metricpb.Metric{
+		Name: "other-metric",
+		Tags: []string{"tag1:value1"},
+		Type: metricpb.Type_Counter,
+		Value: &metricpb.Metric_Counter{
+			Counter: &metricpb.CounterValue{Value: 1},
+		},
+	}
+
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.ingest.request_count",
+		int64(1), []string{"protocol:grpc-stream-v3"}, 1.0)
+	fixture.Statsd.EXPECT().Timing(
+		"veneur_proxy.ingest.request_latency_ms",
+		gomock.Any(), []string{"protocol:grpc-stream-v3"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.ingest.metrics_count",
+		int64(2), []string{"protocol:grpc-stream-v3"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.limits.metrics_count", int64(1),
+		[]string{"tenant:value1", "status:accepted"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.limits.metrics_count", int64(1),
+		[]string{"tenant:value1", "status:dropped", "reason:series"}, 1.0)
+	fixture.Statsd.EXPECT().Count(
+		"veneur_proxy.handle.metrics_count",
+		int64(1), []string{"error:false"}, 1.0)
+
+	sendChannel := make(chan connect.SendRequest, 1)
+	fixture.Destinations.EXPECT().Get("metric-namecountertag1:value1,tag2:value2").
+		Return(fixture.Destination, nil)
+	fixture.Destinations.EXPECT().Count("metric-namecountertag1:value1,tag2:value2")
+	fixture.Destination.EXPECT().SendChannel().Return(sendChannel)
+
+	// Metrics over their tenant's limits are rejected, so that they aren't
+	// sent again.
+	mockServer := forwardrpc.NewMockForward_SendMetricsV3Server(ctrl)
+	mockServer.EXPECT().Context().AnyTimes().Return(context.Background())
+	mockServer.EXPECT().Recv().Return(&forwardrpc.MetricBatch{
+		Id:      1,
+		Metrics: []*metricpb.Metric{metric, otherMetric},
+	}, nil)
+	mockServer.EXPECT().Send(&forwardrpc.MetricBatchAck{
+		Id:       1,
+		Accepted: 1,
+		Rejected: map[string]uint64{"limit": 1},
+		Dropped:  map[string]uint64{},
+	}).Return(nil)
+	mockServer.EXPECT().Recv().Return(nil, io.EOF)
+
+	assert.NoError(t, fixture.Handlers.SendMetricsV3(mockServer))
+	assert.Equal(t, metric, (<-sendChannel).Metric)
+}
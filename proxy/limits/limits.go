@@ -0,0 +1,235 @@
+package limits
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/veneur/v14/proxy/destinations"
+	"golang.org/x/time/rate"
+)
+
+const (
+	ActionDownsample = "downsample"
+	ActionDrop       = "drop"
+
+	// The fraction of series kept when downsampling, if downsample_rate isn't
+	// set.
+	defaultDownsampleRate = 0.1
+	// How often the unique series of each tenant are counted from zero, if
+	// interval isn't set.
+	defaultInterval = 10 * time.Second
+	// The tenant of metrics without the tenant tag.
+	untaggedTenant = "none"
+
+	ReasonRate   = "rate"
+	ReasonSeries = "series"
+)
+
+type Config struct {
+	// What to do with metrics over a limit: "drop" (the default) drops them,
+	// and "downsample" keeps DownsampleRate of the tenant's series.
+	Action         string  `yaml:"action"`
+	Default        Limits  `yaml:"default"`
+	DownsampleRate float64 `yaml:"downsample_rate"`
+	// How often the unique series of each tenant are counted from zero.
+	// Defaults to 10s.
+	Interval time.Duration `yaml:"interval"`
+	// Limits that replace Default for some tenants, by tenant.
+	Overrides map[string]Limits `yaml:"overrides"`
+	// The tag whose value identifies the tenant that sent a metric, such as
+	// "service" or "team".
+	TenantTag string `yaml:"tenant_tag"`
+}
+
+// Limits bounds the metrics that a tenant can send. Zero values are
+// unlimited.
+type Limits struct {
+	// The number of metrics that can be sent at once above MetricsPerSecond.
+	// Defaults to MetricsPerSecond.
+	Burst             int     `yaml:"burst"`
+	MetricsPerSecond  float64 `yaml:"metrics_per_second"`
+	SeriesPerInterval int     `yaml:"series_per_interval"`
+}
+
+// Returns whether limits are configured.
+func (config Config) Enabled() bool {
+	return config.TenantTag != ""
+}
+
+// Limiter enforces per-tenant limits on the rate of metrics and on the number
+// of unique series.
+type Limiter struct {
+	config Config
+	mutex  sync.Mutex
+	now    func() time.Time
+	// When tenants were last checked for eviction.
+	sweptAt  time.Time
+	tenantOf string
+	tenants  map[string]*tenant
+}
+
+type tenant struct {
+	limits Limits
+	// How long the tenant can go without sending a metric before it is
+	// evicted, and when it last sent one. Guarded by the limiter's mutex.
+	idleTimeout time.Duration
+	lastSeen    time.Time
+	// Nil if the rate of metrics is unlimited.
+	limiter *rate.Limiter
+	mutex   sync.Mutex
+	// The series seen since intervalStart.
+	series        map[string]struct{}
+	intervalStart time.Time
+}
+
+// Result is the outcome of checking a metric against its tenant's limits.
+type Result struct {
+	Tenant string
+	// Whether the metric is over a limit, and why.
+	Reason string
+	// Whether the metric is forwarded. A metric over a limit is still
+	// forwarded if its series is kept by downsampling.
+	Allowed bool
+}
+
+// Creates a new limiter, or returns an error if the config is invalid.
+func New(config Config) (*Limiter, error) {
+	switch config.Action {
+	case "":
+		config.Action = ActionDrop
+	case ActionDrop, ActionDownsample:
+	default:
+		return nil, fmt.Errorf("unknown limits action %q", config.Action)
+	}
+	if config.DownsampleRate < 0 || config.DownsampleRate > 1 {
+		return nil, fmt.Errorf(
+			"limits downsample_rate must be between 0 and 1, got %v",
+			config.DownsampleRate)
+	}
+	if config.DownsampleRate == 0 {
+		config.DownsampleRate = defaultDownsampleRate
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultInterval
+	}
+	return &Limiter{
+		config:   config,
+		now:      time.Now,
+		tenantOf: config.TenantTag + ":",
+		tenants:  map[string]*tenant{},
+	}, nil
+}
+
+// Checks a metric against the limits of the tenant in its tags. The key
+// identifies the metric's series.
+func (l *Limiter) Allow(tags []string, key string) Result {
+	name := untaggedTenant
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, l.tenantOf) {
+			name = tag[len(l.tenantOf):]
+			break
+		}
+	}
+	now := l.now()
+	reason := l.tenant(name, now).allow(key, now, l.config.Interval)
+	if reason == "" {
+		return Result{Tenant: name, Allowed: true}
+	}
+	if l.config.Action == ActionDownsample &&
+		destinations.KeyFraction(key) < l.config.DownsampleRate {
+		return Result{Tenant: name, Reason: reason, Allowed: true}
+	}
+	return Result{Tenant: name, Reason: reason}
+}
+
+// Returns the state of a tenant, creating it the first time it sends a
+// metric, or the first time after it was evicted.
+func (l *Limiter) tenant(name string, now time.Time) *tenant {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.sweptAt) >= l.config.Interval {
+		l.evictIdle(now)
+		l.sweptAt = now
+	}
+
+	t, ok := l.tenants[name]
+	if ok {
+		t.lastSeen = now
+		return t
+	}
+	limits, ok := l.config.Overrides[name]
+	if !ok {
+		limits = l.config.Default
+	}
+	t = &tenant{
+		limits:      limits,
+		idleTimeout: l.config.Interval,
+		lastSeen:    now,
+		series:      map[string]struct{}{},
+	}
+	if limits.MetricsPerSecond > 0 {
+		burst := limits.Burst
+		if burst <= 0 {
+			burst = int(limits.MetricsPerSecond)
+		}
+		if burst < 1 {
+			burst = 1
+		}
+		t.limiter = rate.NewLimiter(rate.Limit(limits.MetricsPerSecond), burst)
+		// Wait until the bucket has refilled, so that evicting the tenant
+		// doesn't give it more metrics than it would have had.
+		refill := time.Duration(
+			float64(burst) / limits.MetricsPerSecond * float64(time.Second))
+		if refill > t.idleTimeout {
+			t.idleTimeout = refill
+		}
+	}
+	l.tenants[name] = t
+	return t
+}
+
+// Forgets the tenants that haven't sent a metric in long enough that their
+// state is the same as a new tenant's, so that tenants that come and go don't
+// grow the limiter without bound. It must be called with mutex held.
+func (l *Limiter) evictIdle(now time.Time) {
+	for name, t := range l.tenants {
+		if now.Sub(t.lastSeen) >= t.idleTimeout {
+			delete(l.tenants, name)
+		}
+	}
+}
+
+// Returns the limit that a metric of the series is over, or an empty string
+// if it is within the tenant's limits. Metrics over a limit don't use up the
+// tenant's quota.
+func (t *tenant) allow(
+	key string, now time.Time, interval time.Duration,
+) string {
+	newSeries := false
+	if t.limits.SeriesPerInterval > 0 {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+
+		intervalStart := now.Truncate(interval)
+		if !t.intervalStart.Equal(intervalStart) {
+			t.intervalStart = intervalStart
+			t.series = map[string]struct{}{}
+		}
+		if _, ok := t.series[key]; !ok {
+			if len(t.series) >= t.limits.SeriesPerInterval {
+				return ReasonSeries
+			}
+			newSeries = true
+		}
+	}
+	if t.limiter != nil && !t.limiter.AllowN(now, 1) {
+		return ReasonRate
+	}
+	if newSeries {
+		t.series[key] = struct{}{}
+	}
+	return ""
+}
@@ -0,0 +1,44 @@
+package limits
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvictIdleTenants(t *testing.T) {
+	limiter, err := New(Config{
+		Default: Limits{
+			Burst:            10,
+			MetricsPerSecond: 1,
+		},
+		Interval:  time.Second,
+		Overrides: map[string]Limits{"web": {}},
+		TenantTag: "service",
+	})
+	require.NoError(t, err)
+	now := time.Unix(1000, 0)
+	limiter.now = func() time.Time { return now }
+
+	for _, tags := range [][]string{
+		{"service:api"}, {"service:web"}, {"service:batch"},
+	} {
+		assert.True(t, limiter.Allow(tags, "key").Allowed)
+	}
+	assert.Len(t, limiter.tenants, 3)
+
+	// Unlimited tenants are evicted after an interval, but rate limited
+	// tenants are kept until their bucket has refilled.
+	now = now.Add(5 * time.Second)
+	assert.True(t, limiter.Allow([]string{"service:api"}, "key").Allowed)
+	assert.Len(t, limiter.tenants, 2)
+	assert.Contains(t, limiter.tenants, "api")
+	assert.Contains(t, limiter.tenants, "batch")
+
+	now = now.Add(10 * time.Second)
+	assert.True(t, limiter.Allow([]string{"service:api"}, "key").Allowed)
+	assert.Len(t, limiter.tenants, 1)
+	assert.Contains(t, limiter.tenants, "api")
+}
@@ -0,0 +1,123 @@
+package limits_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14/proxy/limits"
+)
+
+func TestNewInvalidAction(t *testing.T) {
+	_, err := limits.New(limits.Config{
+		Action:    "throttle",
+		TenantTag: "service",
+	})
+	assert.Error(t, err)
+}
+
+func TestRateLimit(t *testing.T) {
+	limiter, err := limits.New(limits.Config{
+		Default: limits.Limits{
+			Burst:            10,
+			MetricsPerSecond: 0.001,
+		},
+		TenantTag: "service",
+	})
+	require.NoError(t, err)
+
+	for index := 0; index < 10; index++ {
+		result := limiter.Allow([]string{"service:api"}, "key")
+		assert.Equal(t, limits.Result{Tenant: "api", Allowed: true}, result)
+	}
+	assert.Equal(t, limits.Result{
+		Tenant: "api",
+		Reason: limits.ReasonRate,
+	}, limiter.Allow([]string{"service:api"}, "key"))
+
+	// Each tenant has its own bucket.
+	assert.Equal(t, limits.Result{Tenant: "web", Allowed: true},
+		limiter.Allow([]string{"env:prod", "service:web"}, "key"))
+	assert.Equal(t, limits.Result{Tenant: "none", Allowed: true},
+		limiter.Allow([]string{"env:prod"}, "key"))
+}
+
+func TestSeriesLimit(t *testing.T) {
+	limiter, err := limits.New(limits.Config{
+		Default: limits.Limits{
+			SeriesPerInterval: 2,
+		},
+		TenantTag: "team",
+	})
+	require.NoError(t, err)
+
+	tags := []string{"team:observability"}
+	assert.True(t, limiter.Allow(tags, "series1").Allowed)
+	assert.True(t, limiter.Allow(tags, "series2").Allowed)
+	assert.Equal(t, limits.Result{
+		Tenant: "observability",
+		Reason: limits.ReasonSeries,
+	}, limiter.Allow(tags, "series3"))
+
+	// Series that were already seen keep being accepted.
+	assert.True(t, limiter.Allow(tags, "series1").Allowed)
+	assert.True(t, limiter.Allow(tags, "series2").Allowed)
+}
+
+func TestOverrides(t *testing.T) {
+	limiter, err := limits.New(limits.Config{
+		Default: limits.Limits{
+			SeriesPerInterval: 1,
+		},
+		Overrides: map[string]limits.Limits{
+			"checkout": {SeriesPerInterval: 3},
+		},
+		TenantTag: "service",
+	})
+	require.NoError(t, err)
+
+	tags := []string{"service:checkout"}
+	for index := 0; index < 3; index++ {
+		assert.True(
+			t, limiter.Allow(tags, fmt.Sprintf("series%d", index)).Allowed)
+	}
+	assert.False(t, limiter.Allow(tags, "series3").Allowed)
+
+	tags = []string{"service:search"}
+	assert.True(t, limiter.Allow(tags, "series0").Allowed)
+	assert.False(t, limiter.Allow(tags, "series1").Allowed)
+}
+
+func TestDownsample(t *testing.T) {
+	limiter, err := limits.New(limits.Config{
+		Action: limits.ActionDownsample,
+		Default: limits.Limits{
+			SeriesPerInterval: 1,
+		},
+		DownsampleRate: 0.25,
+		TenantTag:      "service",
+	})
+	require.NoError(t, err)
+
+	tags := []string{"service:api"}
+	assert.Equal(t, limits.Result{Tenant: "api", Allowed: true},
+		limiter.Allow(tags, "series"))
+
+	// Over the limit, the same fraction of series is kept each time.
+	kept := map[string]bool{}
+	for index := 0; index < 1000; index++ {
+		key := fmt.Sprintf("series%d", index)
+		result := limiter.Allow(tags, key)
+		assert.Equal(t, limits.ReasonSeries, result.Reason)
+		kept[key] = result.Allowed
+	}
+	count := 0
+	for key, allowed := range kept {
+		if allowed {
+			count++
+		}
+		assert.Equal(t, allowed, limiter.Allow(tags, key).Allowed)
+	}
+	assert.InDelta(t, 250, count, 50)
+}
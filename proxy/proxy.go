@@ -2,9 +2,11 @@ package proxy
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -14,8 +16,10 @@ import (
 	"github.com/stripe/veneur/v14/proxy/destinations"
 	"github.com/stripe/veneur/v14/proxy/grpcstats"
 	"github.com/stripe/veneur/v14/proxy/handlers"
+	"github.com/stripe/veneur/v14/proxy/limits"
 	"github.com/stripe/veneur/v14/scopedstatsd"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
 )
 
@@ -25,8 +29,17 @@ type CreateParams struct {
 	Discoverer         discovery.Discoverer
 	HealthcheckContext context.Context
 	HttpHandler        *http.ServeMux
-	Logger             *logrus.Entry
-	Statsd             scopedstatsd.Client
+	// Enforces Config.Limits, or nil if limits are disabled.
+	Limiter *limits.Limiter
+	Logger  *logrus.Entry
+	// The shadow pool that Config.Mirror sends a copy of metrics to, or nil if
+	// mirroring is disabled.
+	MirrorDestinations destinations.Destinations
+	// The destinations for each of Config.ForwardReplicas, in the same order.
+	ReplicaDestinations []destinations.Destinations
+	// If set, secures connections to the gRPC server, e.g. with TLS.
+	ServerCredentials credentials.TransportCredentials
+	Statsd            scopedstatsd.Client
 }
 
 type Proxy struct {
@@ -34,49 +47,104 @@ type Proxy struct {
 	dialTimeout       time.Duration
 	discoverer        discovery.Discoverer
 	discoveryInterval time.Duration
-	forwardAddresses  []string
-	forwardService    string
-	grpcAddress       string
-	grpcListener      net.Listener
-	grpcServer        *grpc.Server
-	handlers          *handlers.Handlers
-	httpAddress       string
-	httpListener      net.Listener
-	httpServer        http.Server
-	logger            *logrus.Entry
-	ready             chan struct{}
-	shutdownTimeout   time.Duration
-	statsd            scopedstatsd.Client
+	// The result of the last discovery of each service, by service name.
+	discoveryResults map[string]DiscoveryResult
+	discoveryMutex   sync.Mutex
+	forwardAddresses []string
+	forwardService   string
+	grpcAddress      string
+	handoffInterval  time.Duration
+	grpcListener     net.Listener
+	grpcServer       *grpc.Server
+	handlers         *handlers.Handlers
+	httpAddress      string
+	httpListener     net.Listener
+	httpServer       http.Server
+	logger           *logrus.Entry
+	mirror           *replica
+	ready            chan struct{}
+	replicas         []replica
+	shareInterval    time.Duration
+	shutdownTimeout  time.Duration
+	statsd           scopedstatsd.Client
+}
+
+const (
+	// How often each destination's share of metrics is reported, if
+	// share_interval isn't set.
+	defaultShareInterval = 10 * time.Second
+	// How long to wait for room in a destination's send channel, if
+	// send_timeout isn't set.
+	defaultSendTimeout = time.Second
+)
+
+type replica struct {
+	config       ReplicaConfig
+	destinations destinations.Destinations
 }
 
 // Creates a new proxy server.
 func Create(params *CreateParams) *Proxy {
+	replicas := make([]replica, len(params.ReplicaDestinations))
+	handlerReplicas := make([]handlers.Replica, len(params.ReplicaDestinations))
+	for index, replicaDestinations := range params.ReplicaDestinations {
+		replicas[index] = replica{
+			config:       params.Config.ForwardReplicas[index],
+			destinations: replicaDestinations,
+		}
+		handlerReplicas[index] = handlers.Replica{
+			Destinations: replicaDestinations,
+			Name:         params.Config.ForwardReplicas[index].Name,
+		}
+	}
+
+	shareInterval := params.Config.ShareInterval
+	if shareInterval <= 0 {
+		shareInterval = defaultShareInterval
+	}
+	sendTimeout := params.Config.SendTimeout
+	if sendTimeout <= 0 {
+		sendTimeout = defaultSendTimeout
+	}
+
+	var mirror *replica
+	var handlerMirror *handlers.Mirror
+	if params.MirrorDestinations != nil {
+		mirror = &replica{
+			config: ReplicaConfig{
+				ForwardAddresses: params.Config.Mirror.ForwardAddresses,
+				ForwardService:   params.Config.Mirror.ForwardService,
+				Name:             "mirror",
+			},
+			destinations: params.MirrorDestinations,
+		}
+		handlerMirror = &handlers.Mirror{
+			Destinations: params.MirrorDestinations,
+			Match:        params.Config.Mirror.Match,
+			SampleRate:   params.Config.Mirror.SampleRate,
+		}
+	}
+
 	proxy := &Proxy{
 		destinations:      params.Destinations,
 		dialTimeout:       params.Config.DialTimeout,
 		discoverer:        params.Discoverer,
 		discoveryInterval: params.Config.DiscoveryInterval,
+		discoveryResults:  map[string]DiscoveryResult{},
 		forwardAddresses:  params.Config.ForwardAddresses,
 		forwardService:    params.Config.ForwardService,
 		grpcAddress:       params.Config.GrpcAddress,
-		grpcServer: grpc.NewServer(
-			grpc.ConnectionTimeout(params.Config.GrpcServer.ConnectionTimeout),
-			grpc.KeepaliveParams(keepalive.ServerParameters{
-				MaxConnectionIdle:     params.Config.GrpcServer.MaxConnectionIdle,
-				MaxConnectionAge:      params.Config.GrpcServer.MaxConnectionAge,
-				MaxConnectionAgeGrace: params.Config.GrpcServer.MaxConnectionAgeGrace,
-				Time:                  params.Config.GrpcServer.PingTimeout,
-				Timeout:               params.Config.GrpcServer.KeepaliveTimeout,
-			}),
-			grpc.StatsHandler(&grpcstats.StatsHandler{
-				IsClient: false,
-				Statsd:   params.Statsd,
-			})),
+		handoffInterval:   params.Config.HandoffInterval,
+		grpcServer:        grpc.NewServer(grpcServerOptions(params)...),
 		handlers: &handlers.Handlers{
 			Destinations:       params.Destinations,
 			HealthcheckContext: params.HealthcheckContext,
 			IgnoreTags:         params.Config.IgnoreTags,
+			Limiter:            params.Limiter,
 			Logger:             params.Logger,
+			Mirror:             handlerMirror,
+			Replicas:           handlerReplicas,
+			SendTimeout:        sendTimeout,
 			Statsd:             params.Statsd,
 		},
 		httpAddress: params.Config.HttpAddress,
@@ -84,7 +152,10 @@ func Create(params *CreateParams) *Proxy {
 			Handler: params.HttpHandler,
 		},
 		logger:          params.Logger,
+		mirror:          mirror,
 		ready:           make(chan struct{}),
+		replicas:        replicas,
+		shareInterval:   shareInterval,
 		shutdownTimeout: params.Config.ShutdownTimeout,
 		statsd:          params.Statsd,
 	}
@@ -92,11 +163,40 @@ func Create(params *CreateParams) *Proxy {
 	params.HttpHandler.HandleFunc(
 		"/healthcheck", proxy.handlers.HandleHealthcheck)
 	params.HttpHandler.HandleFunc("/import", proxy.handlers.HandleJsonMetrics)
+	if params.Config.Http.EnableAdmin {
+		params.HttpHandler.HandleFunc(
+			"/admin/destinations", proxy.handlers.HandleDestinations)
+		params.HttpHandler.HandleFunc(
+			"/admin/discovery", proxy.HandleDiscoveryStatus)
+		params.HttpHandler.HandleFunc("/admin/owner", proxy.handlers.HandleOwner)
+	}
 	forwardrpc.RegisterForwardServer(proxy.grpcServer, proxy.handlers)
 
 	return proxy
 }
 
+// Returns the options for the proxy's gRPC server.
+func grpcServerOptions(params *CreateParams) []grpc.ServerOption {
+	options := []grpc.ServerOption{
+		grpc.ConnectionTimeout(params.Config.GrpcServer.ConnectionTimeout),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle:     params.Config.GrpcServer.MaxConnectionIdle,
+			MaxConnectionAge:      params.Config.GrpcServer.MaxConnectionAge,
+			MaxConnectionAgeGrace: params.Config.GrpcServer.MaxConnectionAgeGrace,
+			Time:                  params.Config.GrpcServer.PingTimeout,
+			Timeout:               params.Config.GrpcServer.KeepaliveTimeout,
+		}),
+		grpc.StatsHandler(&grpcstats.StatsHandler{
+			IsClient: false,
+			Statsd:   params.Statsd,
+		}),
+	}
+	if params.ServerCredentials != nil {
+		options = append(options, grpc.Creds(params.ServerCredentials))
+	}
+	return options
+}
+
 // Starts discovery, and the HTTP and gRPC servers. This method stops polling
 // discovery and shuts down the servers when the provided context is cancelled
 // or if either server stops due to an error. This method attempts to shut down
@@ -119,14 +219,24 @@ func (proxy *Proxy) Start(ctx context.Context) error {
 
 	// Add static destinations
 	proxy.destinations.Add(ctx, proxy.forwardAddresses)
+	for _, replica := range proxy.replicas {
+		replica.destinations.Add(ctx, replica.config.ForwardAddresses)
+	}
+	if proxy.mirror != nil {
+		proxy.mirror.destinations.Add(ctx, proxy.mirror.config.ForwardAddresses)
+	}
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	// Poll discovery for available destinations.
-	if proxy.forwardService != "" {
+	if proxy.hasDiscovery() {
 		go proxy.pollDiscovery(ctx)
 	}
+	go proxy.pollShares(ctx)
+	if proxy.handoffInterval > 0 {
+		go proxy.pollHandoff(ctx)
+	}
 
 	waitGroup := sync.WaitGroup{}
 
@@ -239,7 +349,19 @@ func (proxy *Proxy) Start(ctx context.Context) error {
 	// Wait for shut down.
 	waitGroup.Wait()
 	proxy.destinations.Clear()
+	for _, replica := range proxy.replicas {
+		replica.destinations.Clear()
+	}
+	if proxy.mirror != nil {
+		proxy.mirror.destinations.Clear()
+	}
 	proxy.destinations.Wait()
+	for _, replica := range proxy.replicas {
+		replica.destinations.Wait()
+	}
+	if proxy.mirror != nil {
+		proxy.mirror.destinations.Wait()
+	}
 
 	httpErr := <-httpError
 	grpcErr := <-grpcError
@@ -275,15 +397,23 @@ func (proxy *Proxy) CloseGrpcListener() error {
 	return proxy.grpcListener.Close()
 }
 
-// Poll discovery immediately, and every `discoveryInterval`. This method stops
+// Poll discovery immediately, and every `discoveryInterval`, or as soon as
+// the discoverer reports a change if it watches for them. This method stops
 // polling and exits when the provided context is cancelled.
 func (proxy *Proxy) pollDiscovery(ctx context.Context) {
+	var changes <-chan struct{}
+	if notifier, ok := proxy.discoverer.(discovery.Notifier); ok {
+		changes = notifier.Changes()
+	}
+
 	proxy.HandleDiscovery(ctx)
 	discoveryTicker := time.NewTicker(proxy.discoveryInterval)
 	for {
 		select {
 		case <-discoveryTicker.C:
 			proxy.HandleDiscovery(ctx)
+		case <-changes:
+			proxy.HandleDiscovery(ctx)
 		case <-ctx.Done():
 			discoveryTicker.Stop()
 			return
@@ -291,32 +421,239 @@ func (proxy *Proxy) pollDiscovery(ctx context.Context) {
 	}
 }
 
-// Handles a single discovery query and updates the consistent hash.
+// Returns whether the primary destinations, any replica or the mirror are
+// discovered.
+func (proxy *Proxy) hasDiscovery() bool {
+	if proxy.forwardService != "" {
+		return true
+	}
+	for _, replica := range proxy.replicas {
+		if replica.config.ForwardService != "" {
+			return true
+		}
+	}
+	return proxy.mirror != nil && proxy.mirror.config.ForwardService != ""
+}
+
+// Handles a single discovery query for the primary destinations, each
+// discovered replica, and the mirror if it is discovered.
 func (proxy *Proxy) HandleDiscovery(ctx context.Context) {
+	if proxy.forwardService != "" {
+		proxy.discoverService(
+			ctx, proxy.forwardService, proxy.forwardAddresses, proxy.destinations,
+			[]string{})
+	}
+	for _, replica := range proxy.replicas {
+		if replica.config.ForwardService == "" {
+			continue
+		}
+		proxy.discoverService(
+			ctx, replica.config.ForwardService, replica.config.ForwardAddresses,
+			replica.destinations, []string{"replica:" + replica.config.Name})
+	}
+	if proxy.mirror != nil && proxy.mirror.config.ForwardService != "" {
+		proxy.discoverService(
+			ctx, proxy.mirror.config.ForwardService,
+			proxy.mirror.config.ForwardAddresses, proxy.mirror.destinations,
+			[]string{"mirror:true"})
+	}
+}
+
+// Handles a single discovery query and updates the consistent hash with the
+// discovered and static addresses. Destinations that are no longer discovered
+// are removed, unless discovery returns no destinations at all.
+func (proxy *Proxy) discoverService(
+	ctx context.Context, service string, staticAddresses []string,
+	serviceDestinations destinations.Destinations, tags []string,
+) {
 	proxy.logger.Debug("discovering destinations")
 	startTime := time.Now()
 
 	// Query the discovery service.
-	proxy.logger.WithField("service", proxy.forwardService).
+	proxy.logger.WithField("service", service).
 		Debug("discovering service")
 	newDestinations, err :=
-		proxy.discoverer.GetDestinationsForService(proxy.forwardService)
+		proxy.discoverer.GetDestinationsForService(service)
+	proxy.recordDiscovery(service, startTime, newDestinations, err)
 	if err != nil {
 		proxy.logger.WithField("error", err).Error("failed discover destinations")
 		proxy.statsd.Count(
-			"veneur_proxy.discovery.count", 1, []string{"status:fail"}, 1.0)
+			"veneur_proxy.discovery.count", 1,
+			append([]string{"status:fail"}, tags...), 1.0)
 		return
 	}
 	proxy.statsd.Gauge(
 		"veneur_proxy.discovery.destinations", float64(len(newDestinations)),
-		[]string{}, 1.0)
+		tags, 1.0)
 
 	// Update the consistent hash.
-	proxy.destinations.Add(ctx, newDestinations)
+	if len(newDestinations) == 0 {
+		proxy.logger.WithField("service", service).
+			Warn("discovered no destinations, keeping the current destinations")
+	} else {
+		serviceDestinations.Update(
+			ctx, append(newDestinations, staticAddresses...))
+	}
 
 	proxy.statsd.Count(
 		"veneur_proxy.discovery.duration_total_ms",
-		time.Since(startTime).Milliseconds(), []string{}, 1.0)
+		time.Since(startTime).Milliseconds(), tags, 1.0)
 	proxy.statsd.Count(
-		"veneur_proxy.discovery.count", 1, []string{"status:success"}, 1.0)
+		"veneur_proxy.discovery.count", 1,
+		append([]string{"status:success"}, tags...), 1.0)
+}
+
+// DiscoveryResult is the outcome of discovering a service.
+type DiscoveryResult struct {
+	Destinations []string  `json:"destinations"`
+	Error        string    `json:"error,omitempty"`
+	Service      string    `json:"service"`
+	Time         time.Time `json:"time"`
+}
+
+func (proxy *Proxy) recordDiscovery(
+	service string, startTime time.Time, destinations []string, err error,
+) {
+	result := DiscoveryResult{
+		Destinations: destinations,
+		Service:      service,
+		Time:         startTime,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	proxy.discoveryMutex.Lock()
+	defer proxy.discoveryMutex.Unlock()
+	proxy.discoveryResults[service] = result
+}
+
+// Returns the result of the last discovery of each service, sorted by service
+// name.
+func (proxy *Proxy) DiscoveryResults() []DiscoveryResult {
+	proxy.discoveryMutex.Lock()
+	results := make([]DiscoveryResult, 0, len(proxy.discoveryResults))
+	for _, result := range proxy.discoveryResults {
+		results = append(results, result)
+	}
+	proxy.discoveryMutex.Unlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Service < results[j].Service
+	})
+	return results
+}
+
+// Serves the result of the last discovery of each service as JSON.
+func (proxy *Proxy) HandleDiscoveryStatus(
+	writer http.ResponseWriter, request *http.Request,
+) {
+	writer.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(struct {
+		Services []DiscoveryResult `json:"services"`
+	}{
+		Services: proxy.DiscoveryResults(),
+	})
+	if err != nil {
+		proxy.logger.WithError(err).Debug("failed to write discovery status")
+	}
+}
+
+// Report the share of metrics sent to each destination every `shareInterval`.
+// This method stops reporting and exits when the provided context is
+// cancelled.
+func (proxy *Proxy) pollShares(ctx context.Context) {
+	shareTicker := time.NewTicker(proxy.shareInterval)
+	for {
+		select {
+		case <-shareTicker.C:
+			proxy.ReportShares()
+		case <-ctx.Done():
+			shareTicker.Stop()
+			return
+		}
+	}
+}
+
+// Reports the number and fraction of series sent to each destination of the
+// primary pool, each replica and the mirror since the last report, so that
+// the balance of the hash can be verified.
+func (proxy *Proxy) ReportShares() {
+	proxy.reportShares(proxy.destinations, []string{})
+	for _, replica := range proxy.replicas {
+		proxy.reportShares(
+			replica.destinations, []string{"replica:" + replica.config.Name})
+	}
+	if proxy.mirror != nil {
+		proxy.reportShares(proxy.mirror.destinations, []string{"mirror:true"})
+	}
+}
+
+func (proxy *Proxy) reportShares(
+	shareDestinations destinations.Destinations, tags []string,
+) {
+	shares := shareDestinations.Shares()
+	var total int64
+	for _, count := range shares {
+		total += count
+	}
+	for address, count := range shares {
+		destinationTags := append([]string{"destination:" + address}, tags...)
+		proxy.statsd.Gauge(
+			"veneur_proxy.series_by_destination", float64(count),
+			destinationTags, 1.0)
+		if total > 0 {
+			proxy.statsd.Gauge(
+				"veneur_proxy.share_by_destination",
+				float64(count)/float64(total), destinationTags, 1.0)
+		}
+	}
+}
+
+// Hands off between destinations at each multiple of `handoffInterval`, so
+// that destinations join and leave at the same time as the local Veneurs
+// flush. This method stops and exits when the provided context is cancelled.
+func (proxy *Proxy) pollHandoff(ctx context.Context) {
+	for {
+		now := time.Now()
+		handoffTimer := time.NewTimer(
+			now.Truncate(proxy.handoffInterval).Add(proxy.handoffInterval).Sub(now))
+		select {
+		case <-handoffTimer.C:
+			proxy.HandleHandoff()
+		case <-ctx.Done():
+			handoffTimer.Stop()
+			return
+		}
+	}
+}
+
+// Adds the destinations that joined since the last handoff, and drains and
+// removes the destinations that left.
+func (proxy *Proxy) HandleHandoff() {
+	proxy.handoff(proxy.destinations, []string{})
+	for _, replica := range proxy.replicas {
+		proxy.handoff(
+			replica.destinations, []string{"replica:" + replica.config.Name})
+	}
+	if proxy.mirror != nil {
+		proxy.handoff(proxy.mirror.destinations, []string{"mirror:true"})
+	}
+}
+
+func (proxy *Proxy) handoff(
+	handoffDestinations destinations.Destinations, tags []string,
+) {
+	joined, left := handoffDestinations.Handoff()
+	if joined > 0 {
+		proxy.statsd.Count(
+			"veneur_proxy.handoff.destinations_count", int64(joined),
+			append([]string{"status:joined"}, tags...), 1.0)
+	}
+	if left > 0 {
+		proxy.statsd.Count(
+			"veneur_proxy.handoff.destinations_count", int64(left),
+			append([]string{"status:left"}, tags...), 1.0)
+	}
 }
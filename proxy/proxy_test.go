@@ -2,14 +2,17 @@ package proxy_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stripe/veneur/v14/discovery"
 	"github.com/stripe/veneur/v14/proxy"
 	"github.com/stripe/veneur/v14/proxy/destinations"
@@ -143,7 +146,7 @@ func TestHandleDiscovery(t *testing.T) {
 	})
 	ctx := context.Background()
 
-	server.Destinations.EXPECT().Add(ctx, []string{"address1", "address2"})
+	server.Destinations.EXPECT().Update(ctx, []string{"address1", "address2"})
 	server.Discoverer.EXPECT().GetDestinationsForService("service-name").
 		Return([]string{"address1", "address2"}, nil)
 	server.Statsd.EXPECT().Count(
@@ -155,3 +158,281 @@ func TestHandleDiscovery(t *testing.T) {
 
 	server.HandleDiscovery(ctx)
 }
+
+func TestHandleDiscoveryReplicas(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger := logrus.New()
+	mockStatsd := scopedstatsd.NewMockClient(ctrl)
+	mockDestinations := destinations.NewMockDestinations(ctrl)
+	mockDiscoverer := discovery.NewMockDiscoverer(ctrl)
+	staticDestinations := destinations.NewMockDestinations(ctrl)
+	discoveredDestinations := destinations.NewMockDestinations(ctrl)
+
+	server := proxy.Create(&proxy.CreateParams{
+		Config: &proxy.Config{
+			ForwardAddresses: []string{},
+			ForwardReplicas: []proxy.ReplicaConfig{{
+				ForwardAddresses: []string{"address3"},
+				Name:             "static",
+			}, {
+				ForwardService: "replica-service",
+				Name:           "discovered",
+			}},
+			ShutdownTimeout: time.Second,
+		},
+		Destinations:       mockDestinations,
+		Discoverer:         mockDiscoverer,
+		HealthcheckContext: context.Background(),
+		HttpHandler:        http.NewServeMux(),
+		Logger:             logrus.NewEntry(logger),
+		ReplicaDestinations: []destinations.Destinations{
+			staticDestinations, discoveredDestinations,
+		},
+		Statsd: mockStatsd,
+	})
+	ctx := context.Background()
+
+	discoveredDestinations.EXPECT().Update(ctx, []string{"address1", "address2"})
+	mockDiscoverer.EXPECT().GetDestinationsForService("replica-service").
+		Return([]string{"address1", "address2"}, nil)
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.discovery.duration_total_ms", gomock.Any(),
+		[]string{"replica:discovered"}, 1.0)
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.discovery.count", int64(1),
+		[]string{"status:success", "replica:discovered"}, 1.0)
+	mockStatsd.EXPECT().Gauge(
+		"veneur_proxy.discovery.destinations", 2.0,
+		[]string{"replica:discovered"}, 1.0)
+
+	server.HandleDiscovery(ctx)
+}
+
+type notifyingDiscoverer struct {
+	*discovery.MockDiscoverer
+	*discovery.MockNotifier
+}
+
+func TestDiscoveryOnChange(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger := logrus.New()
+	mockStatsd := scopedstatsd.NewMockClient(ctrl)
+	mockDestinations := destinations.NewMockDestinations(ctrl)
+	mockDiscoverer := discovery.NewMockDiscoverer(ctrl)
+	mockNotifier := discovery.NewMockNotifier(ctrl)
+	changes := make(chan struct{})
+	mockNotifier.EXPECT().Changes().Return((<-chan struct{})(changes))
+
+	server := proxy.Create(&proxy.CreateParams{
+		Config: &proxy.Config{
+			DiscoveryInterval: time.Hour,
+			ForwardAddresses:  []string{},
+			ForwardService:    "service-name",
+			ShutdownTimeout:   time.Second,
+		},
+		Destinations: mockDestinations,
+		Discoverer: notifyingDiscoverer{
+			MockDiscoverer: mockDiscoverer,
+			MockNotifier:   mockNotifier,
+		},
+		HealthcheckContext: context.Background(),
+		HttpHandler:        http.NewServeMux(),
+		Logger:             logrus.NewEntry(logger),
+		Statsd:             mockStatsd,
+	})
+
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.discovery.duration_total_ms", gomock.Any(), []string{}, 1.0).
+		AnyTimes()
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.discovery.count", int64(1), []string{"status:success"}, 1.0).
+		AnyTimes()
+	mockStatsd.EXPECT().Gauge(
+		"veneur_proxy.discovery.destinations", gomock.Any(), []string{}, 1.0).
+		AnyTimes()
+	mockDestinations.EXPECT().Add(gomock.Any(), []string{})
+
+	// Discovery runs once at startup, and again as soon as the discoverer
+	// reports a change, well before the next poll.
+	discovered := make(chan struct{})
+	gomock.InOrder(
+		mockDiscoverer.EXPECT().GetDestinationsForService("service-name").
+			Return([]string{"address1"}, nil),
+		mockDestinations.EXPECT().Update(gomock.Any(), []string{"address1"}).
+			Do(func(context.Context, []string) {
+				discovered <- struct{}{}
+			}),
+		mockDiscoverer.EXPECT().GetDestinationsForService("service-name").
+			Return([]string{"address1", "address2"}, nil),
+		mockDestinations.EXPECT().
+			Update(gomock.Any(), []string{"address1", "address2"}).
+			Do(func(context.Context, []string) {
+				discovered <- struct{}{}
+			}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	proxyError := make(chan error)
+	go func() {
+		proxyError <- server.Start(ctx)
+	}()
+
+	<-discovered
+	changes <- struct{}{}
+	<-discovered
+
+	mockDestinations.EXPECT().Clear()
+	mockDestinations.EXPECT().Wait()
+	cancel()
+	assert.NoError(t, <-proxyError)
+}
+
+func TestReportShares(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	server := CreateTestServer(ctrl, &proxy.Config{
+		ForwardAddresses: []string{},
+		ShutdownTimeout:  time.Second,
+	})
+
+	server.Destinations.EXPECT().Shares().Return(map[string]int64{
+		"address1": 30,
+		"address2": 10,
+	})
+	server.Statsd.EXPECT().Gauge(
+		"veneur_proxy.series_by_destination", 30.0,
+		[]string{"destination:address1"}, 1.0)
+	server.Statsd.EXPECT().Gauge(
+		"veneur_proxy.share_by_destination", 0.75,
+		[]string{"destination:address1"}, 1.0)
+	server.Statsd.EXPECT().Gauge(
+		"veneur_proxy.series_by_destination", 10.0,
+		[]string{"destination:address2"}, 1.0)
+	server.Statsd.EXPECT().Gauge(
+		"veneur_proxy.share_by_destination", 0.25,
+		[]string{"destination:address2"}, 1.0)
+
+	server.ReportShares()
+}
+
+func TestHandleDiscoveryEmpty(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	server := CreateTestServer(ctrl, &proxy.Config{
+		ForwardAddresses: []string{},
+		ForwardService:   "service-name",
+		ShutdownTimeout:  time.Second,
+	})
+	ctx := context.Background()
+
+	// The current destinations are kept if discovery returns none.
+	server.Discoverer.EXPECT().GetDestinationsForService("service-name").
+		Return([]string{}, nil)
+	server.Statsd.EXPECT().Count(
+		"veneur_proxy.discovery.duration_total_ms", gomock.Any(), []string{}, 1.0)
+	server.Statsd.EXPECT().Count(
+		"veneur_proxy.discovery.count", int64(1), []string{"status:success"}, 1.0)
+	server.Statsd.EXPECT().Gauge(
+		"veneur_proxy.discovery.destinations", 0.0, []string{}, 1.0)
+
+	server.HandleDiscovery(ctx)
+}
+
+func TestHandleHandoff(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	server := CreateTestServer(ctrl, &proxy.Config{
+		ForwardAddresses: []string{},
+		HandoffInterval:  10 * time.Second,
+		ShutdownTimeout:  time.Second,
+	})
+
+	server.Destinations.EXPECT().Handoff().Return(2, 1)
+	server.Statsd.EXPECT().Count(
+		"veneur_proxy.handoff.destinations_count", int64(2),
+		[]string{"status:joined"}, 1.0)
+	server.Statsd.EXPECT().Count(
+		"veneur_proxy.handoff.destinations_count", int64(1),
+		[]string{"status:left"}, 1.0)
+
+	server.HandleHandoff()
+}
+
+func TestDiscoveryStatus(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	server := CreateTestServer(ctrl, &proxy.Config{
+		ForwardAddresses: []string{},
+		ForwardService:   "service-name",
+		ShutdownTimeout:  time.Second,
+	})
+	ctx := context.Background()
+
+	server.Discoverer.EXPECT().GetDestinationsForService("service-name").
+		Return(nil, errors.New("consul unavailable"))
+	server.Statsd.EXPECT().Count(
+		"veneur_proxy.discovery.count", int64(1), []string{"status:fail"}, 1.0)
+
+	startTime := time.Now()
+	server.HandleDiscovery(ctx)
+
+	results := server.DiscoveryResults()
+	require.Len(t, results, 1)
+	assert.Equal(t, "service-name", results[0].Service)
+	assert.Equal(t, "consul unavailable", results[0].Error)
+	assert.Empty(t, results[0].Destinations)
+	assert.False(t, results[0].Time.Before(startTime))
+
+	recorder := httptest.NewRecorder()
+	server.HandleDiscoveryStatus(
+		recorder, httptest.NewRequest("GET", "/admin/discovery", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"error": "consul unavailable"`)
+}
+
+func TestHandleDiscoveryMirror(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger := logrus.New()
+	mockStatsd := scopedstatsd.NewMockClient(ctrl)
+	mockDestinations := destinations.NewMockDestinations(ctrl)
+	mockDiscoverer := discovery.NewMockDiscoverer(ctrl)
+	mirrorDestinations := destinations.NewMockDestinations(ctrl)
+
+	server := proxy.Create(&proxy.CreateParams{
+		Config: &proxy.Config{
+			ForwardAddresses: []string{},
+			Mirror: proxy.MirrorConfig{
+				ForwardService: "shadow-service",
+				SampleRate:     0.1,
+			},
+			ShutdownTimeout: time.Second,
+		},
+		Destinations:       mockDestinations,
+		Discoverer:         mockDiscoverer,
+		HealthcheckContext: context.Background(),
+		HttpHandler:        http.NewServeMux(),
+		Logger:             logrus.NewEntry(logger),
+		MirrorDestinations: mirrorDestinations,
+		Statsd:             mockStatsd,
+	})
+	ctx := context.Background()
+
+	// A failure to discover the mirror doesn't affect the primary
+	// destinations.
+	mockDiscoverer.EXPECT().GetDestinationsForService("shadow-service").
+		Return(nil, errors.New("shadow pool unavailable"))
+	mockStatsd.EXPECT().Count(
+		"veneur_proxy.discovery.count", int64(1),
+		[]string{"status:fail", "mirror:true"}, 1.0)
+
+	server.HandleDiscovery(ctx)
+}
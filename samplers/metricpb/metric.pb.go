@@ -61,6 +61,7 @@ const (
 	Type_Histogram Type = 2
 	Type_Set       Type = 3
 	Type_Timer     Type = 4
+	Type_Status    Type = 5
 )
 
 var Type_name = map[int32]string{
@@ -69,6 +70,7 @@ var Type_name = map[int32]string{
 	2: "Histogram",
 	3: "Set",
 	4: "Timer",
+	5: "Status",
 }
 
 var Type_value = map[string]int32{
@@ -77,6 +79,7 @@ var Type_value = map[string]int32{
 	"Histogram": 2,
 	"Set":       3,
 	"Timer":     4,
+	"Status":    5,
 }
 
 func (x Type) String() string {
@@ -99,8 +102,13 @@ type Metric struct {
 	//	*Metric_Gauge
 	//	*Metric_Histogram
 	//	*Metric_Set
+	//	*Metric_Status
 	Value isMetric_Value `protobuf_oneof:"value"`
 	Scope Scope          `protobuf:"varint,9,opt,name=scope,proto3,enum=metricpb.Scope" json:"scope,omitempty"`
+	// timestamp is the Unix time of the interval a metric was flushed in,
+	// for metrics that a local Veneur replays from its forward buffer. It's
+	// zero for metrics flushed in the current interval.
+	Timestamp int64 `protobuf:"varint,11,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
 }
 
 func (m *Metric) Reset()         { *m = Metric{} }
@@ -154,11 +162,15 @@ type Metric_Histogram struct {
 type Metric_Set struct {
 	Set *SetValue `protobuf:"bytes,8,opt,name=set,proto3,oneof"`
 }
+type Metric_Status struct {
+	Status *StatusValue `protobuf:"bytes,10,opt,name=status,proto3,oneof"`
+}
 
 func (*Metric_Counter) isMetric_Value()   {}
 func (*Metric_Gauge) isMetric_Value()     {}
 func (*Metric_Histogram) isMetric_Value() {}
 func (*Metric_Set) isMetric_Value()       {}
+func (*Metric_Status) isMetric_Value()    {}
 
 func (m *Metric) GetValue() isMetric_Value {
 	if m != nil {
@@ -216,6 +228,13 @@ func (m *Metric) GetSet() *SetValue {
 	return nil
 }
 
+func (m *Metric) GetStatus() *StatusValue {
+	if x, ok := m.GetValue().(*Metric_Status); ok {
+		return x.Status
+	}
+	return nil
+}
+
 func (m *Metric) GetScope() Scope {
 	if m != nil {
 		return m.Scope
@@ -223,6 +242,13 @@ func (m *Metric) GetScope() Scope {
 	return Scope_Mixed
 }
 
+func (m *Metric) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
 // XXX_OneofFuncs is for the internal use of the proto package.
 func (*Metric) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), func(msg proto.Message) (n int), []interface{}) {
 	return _Metric_OneofMarshaler, _Metric_OneofUnmarshaler, _Metric_OneofSizer, []interface{}{
@@ -230,6 +256,7 @@ func (*Metric) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error,
 		(*Metric_Gauge)(nil),
 		(*Metric_Histogram)(nil),
 		(*Metric_Set)(nil),
+		(*Metric_Status)(nil),
 	}
 }
 
@@ -257,6 +284,11 @@ func _Metric_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
 		if err := b.EncodeMessage(x.Set); err != nil {
 			return err
 		}
+	case *Metric_Status:
+		_ = b.EncodeVarint(10<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Status); err != nil {
+			return err
+		}
 	case nil:
 	default:
 		return fmt.Errorf("Metric.Value has unexpected type %T", x)
@@ -299,6 +331,14 @@ func _Metric_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer)
 		err := b.DecodeMessage(msg)
 		m.Value = &Metric_Set{msg}
 		return true, err
+	case 10: // value.status
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(StatusValue)
+		err := b.DecodeMessage(msg)
+		m.Value = &Metric_Status{msg}
+		return true, err
 	default:
 		return false, nil
 	}
@@ -328,6 +368,11 @@ func _Metric_OneofSizer(msg proto.Message) (n int) {
 		n += 1 // tag and wire
 		n += proto.SizeVarint(uint64(s))
 		n += s
+	case *Metric_Status:
+		s := proto.Size(x.Status)
+		n += 1 // tag and wire
+		n += proto.SizeVarint(uint64(s))
+		n += s
 	case nil:
 	default:
 		panic(fmt.Sprintf("proto: unexpected type %T in oneof", x))
@@ -425,10 +470,12 @@ func (m *GaugeValue) GetValue() float64 {
 	return 0
 }
 
-// HistogramValue for now just includes the t-digest.  This can be expanded
-// to include the other values such as the sum, average, etc.
+// HistogramValue includes the t-digest and the weighted sum of the squares of
+// the samples, which the t-digest does not track. This can be expanded to
+// include the other values such as the sum, average, etc.
 type HistogramValue struct {
-	TDigest *tdigest.MergingDigestData `protobuf:"bytes,1,opt,name=t_digest,json=tDigest,proto3" json:"t_digest,omitempty"`
+	TDigest    *tdigest.MergingDigestData `protobuf:"bytes,1,opt,name=t_digest,json=tDigest,proto3" json:"t_digest,omitempty"`
+	SumSquares float64                    `protobuf:"fixed64,2,opt,name=sum_squares,json=sumSquares,proto3" json:"sum_squares,omitempty"`
 }
 
 func (m *HistogramValue) Reset()         { *m = HistogramValue{} }
@@ -471,6 +518,13 @@ func (m *HistogramValue) GetTDigest() *tdigest.MergingDigestData {
 	return nil
 }
 
+func (m *HistogramValue) GetSumSquares() float64 {
+	if m != nil {
+		return m.SumSquares
+	}
+	return 0
+}
+
 // SetValue contains a binary-encoded HyperLogLog
 type SetValue struct {
 	HyperLogLog []byte `protobuf:"bytes,1,opt,name=hyper_log_log,json=hyperLogLog,proto3" json:"hyper_log_log,omitempty"`
@@ -516,6 +570,85 @@ func (m *SetValue) GetHyperLogLog() []byte {
 	return nil
 }
 
+// StatusValue counts how many hosts reported each status for a service
+// check, so that checks from many hosts can be combined on the global tier.
+type StatusValue struct {
+	Ok       int64 `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	Warning  int64 `protobuf:"varint,2,opt,name=warning,proto3" json:"warning,omitempty"`
+	Critical int64 `protobuf:"varint,3,opt,name=critical,proto3" json:"critical,omitempty"`
+	Unknown  int64 `protobuf:"varint,4,opt,name=unknown,proto3" json:"unknown,omitempty"`
+	// message is the message that accompanied the most severe status.
+	Message string `protobuf:"bytes,5,opt,name=message,proto3" json:"message,omitempty"`
+}
+
+func (m *StatusValue) Reset()         { *m = StatusValue{} }
+func (m *StatusValue) String() string { return proto.CompactTextString(m) }
+func (*StatusValue) ProtoMessage()    {}
+func (*StatusValue) Descriptor() ([]byte, []int) {
+	return fileDescriptor_95975e4c0ef795ab, []int{5}
+}
+func (m *StatusValue) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *StatusValue) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_StatusValue.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *StatusValue) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StatusValue.Merge(m, src)
+}
+func (m *StatusValue) XXX_Size() int {
+	return m.Size()
+}
+func (m *StatusValue) XXX_DiscardUnknown() {
+	xxx_messageInfo_StatusValue.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StatusValue proto.InternalMessageInfo
+
+func (m *StatusValue) GetOk() int64 {
+	if m != nil {
+		return m.Ok
+	}
+	return 0
+}
+
+func (m *StatusValue) GetWarning() int64 {
+	if m != nil {
+		return m.Warning
+	}
+	return 0
+}
+
+func (m *StatusValue) GetCritical() int64 {
+	if m != nil {
+		return m.Critical
+	}
+	return 0
+}
+
+func (m *StatusValue) GetUnknown() int64 {
+	if m != nil {
+		return m.Unknown
+	}
+	return 0
+}
+
+func (m *StatusValue) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterEnum("metricpb.Scope", Scope_name, Scope_value)
 	proto.RegisterEnum("metricpb.Type", Type_name, Type_value)
@@ -524,40 +657,49 @@ func init() {
 	proto.RegisterType((*GaugeValue)(nil), "metricpb.GaugeValue")
 	proto.RegisterType((*HistogramValue)(nil), "metricpb.HistogramValue")
 	proto.RegisterType((*SetValue)(nil), "metricpb.SetValue")
+	proto.RegisterType((*StatusValue)(nil), "metricpb.StatusValue")
 }
 
 func init() { proto.RegisterFile("samplers/metricpb/metric.proto", fileDescriptor_95975e4c0ef795ab) }
 
 var fileDescriptor_95975e4c0ef795ab = []byte{
-	// 446 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x54, 0x92, 0x41, 0x6b, 0xdb, 0x40,
-	0x10, 0x85, 0xb5, 0x96, 0x65, 0x59, 0xe3, 0xc4, 0x15, 0x43, 0x5a, 0x96, 0x1c, 0x84, 0x11, 0x6d,
-	0x71, 0x43, 0x51, 0xc0, 0xa5, 0xd0, 0x6b, 0xd3, 0x80, 0x73, 0xb0, 0x2f, 0x4a, 0xe8, 0x35, 0xac,
-	0x9d, 0x65, 0x23, 0x90, 0xbc, 0x42, 0x5a, 0x97, 0xfa, 0x5f, 0xf4, 0x67, 0xe5, 0x98, 0x63, 0x8f,
-	0xc5, 0xfe, 0x23, 0x65, 0x57, 0xda, 0x28, 0x3e, 0x08, 0xcd, 0xbe, 0xf7, 0x3d, 0x89, 0xa7, 0x11,
-	0x44, 0x35, 0x2b, 0xca, 0x9c, 0x57, 0xf5, 0x65, 0xc1, 0x55, 0x95, 0xad, 0xcb, 0x55, 0x3b, 0x24,
-	0x65, 0x25, 0x95, 0xc4, 0xa1, 0x95, 0xcf, 0xdf, 0xaa, 0x87, 0x4c, 0xf0, 0x5a, 0x5d, 0xb6, 0xf7,
-	0x06, 0x88, 0x9f, 0x7a, 0x30, 0x58, 0x1a, 0x06, 0x11, 0xfa, 0x1b, 0x56, 0x70, 0x4a, 0x26, 0x64,
-	0x1a, 0xa4, 0x66, 0xd6, 0x9a, 0x62, 0xa2, 0xa6, 0xbd, 0x89, 0xab, 0x35, 0x3d, 0x63, 0x0c, 0x7d,
-	0xb5, 0x2b, 0x39, 0x75, 0x27, 0x64, 0x3a, 0x9e, 0x8d, 0x13, 0xfb, 0x8a, 0xe4, 0x6e, 0x57, 0xf2,
-	0xd4, 0x78, 0x38, 0x03, 0x7f, 0x2d, 0xb7, 0x1b, 0xc5, 0x2b, 0xea, 0x4d, 0xc8, 0x74, 0x34, 0x7b,
-	0xd7, 0x61, 0x3f, 0x1a, 0xe3, 0x27, 0xcb, 0xb7, 0xfc, 0xc6, 0x49, 0x2d, 0x88, 0x9f, 0xc1, 0x13,
-	0x6c, 0x2b, 0x38, 0x1d, 0x98, 0xc4, 0x59, 0x97, 0x98, 0x6b, 0xd9, 0xf2, 0x0d, 0x84, 0xdf, 0x20,
-	0x78, 0xcc, 0x6a, 0x25, 0x45, 0xc5, 0x0a, 0xea, 0x9b, 0x04, 0xed, 0x12, 0x37, 0xd6, 0xb2, 0xa9,
-	0x0e, 0xc6, 0x8f, 0xe0, 0xd6, 0x5c, 0xd1, 0xa1, 0xc9, 0x60, 0x97, 0xb9, 0xe5, 0xca, 0xd2, 0x1a,
-	0xc0, 0x0f, 0xe0, 0xd5, 0x6b, 0x59, 0x72, 0x1a, 0x98, 0xa2, 0x6f, 0x5e, 0x91, 0x5a, 0x4e, 0x1b,
-	0xf7, 0xca, 0x07, 0xef, 0x97, 0x8e, 0xc5, 0xef, 0xe1, 0xe4, 0x75, 0x35, 0x3c, 0x6b, 0x0d, 0xf3,
-	0x41, 0xdd, 0xb4, 0xa5, 0x62, 0x80, 0xae, 0xce, 0x31, 0x43, 0x2c, 0x33, 0x87, 0xf1, 0x71, 0x01,
-	0xfc, 0x0a, 0x43, 0x75, 0xdf, 0x2c, 0xce, 0xa0, 0xa3, 0xd9, 0x79, 0x62, 0x17, 0xb9, 0xe4, 0x95,
-	0xc8, 0x36, 0xe2, 0xda, 0x9c, 0xae, 0x99, 0x62, 0xa9, 0xaf, 0x9a, 0x43, 0x9c, 0xc0, 0xd0, 0xb6,
-	0xc2, 0x18, 0x4e, 0x1f, 0x77, 0x25, 0xaf, 0xee, 0x73, 0x29, 0xf4, 0x65, 0x9e, 0x73, 0x92, 0x8e,
-	0x8c, 0xb8, 0x90, 0x62, 0x21, 0xc5, 0xc5, 0x27, 0xf0, 0x4c, 0x37, 0x0c, 0xc0, 0x5b, 0x66, 0xbf,
-	0xf9, 0x43, 0xe8, 0xe8, 0x71, 0x21, 0xd7, 0x2c, 0x0f, 0x09, 0x02, 0x0c, 0xe6, 0xb9, 0x5c, 0xb1,
-	0x3c, 0xec, 0x5d, 0x7c, 0x87, 0xbe, 0xde, 0x37, 0x8e, 0xc0, 0x6f, 0x5b, 0x37, 0xac, 0x29, 0x17,
-	0x12, 0x3c, 0x85, 0xe0, 0xa5, 0x43, 0xd8, 0x43, 0x1f, 0xdc, 0x5b, 0xae, 0x42, 0x57, 0x23, 0x77,
-	0x59, 0xc1, 0xab, 0xb0, 0x7f, 0x45, 0x9f, 0xf6, 0x11, 0x79, 0xde, 0x47, 0xe4, 0xdf, 0x3e, 0x22,
-	0x7f, 0x0e, 0x91, 0xf3, 0x7c, 0x88, 0x9c, 0xbf, 0x87, 0xc8, 0x59, 0x0d, 0xcc, 0xcf, 0xf9, 0xe5,
-	0x7f, 0x00, 0x00, 0x00, 0xff, 0xff, 0x50, 0xd7, 0x77, 0x2f, 0xdf, 0x02, 0x00, 0x00,
+	// 573 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x54, 0x93, 0xc1, 0x4e, 0xdb, 0x40,
+	0x10, 0x86, 0xe3, 0x38, 0x8e, 0xe3, 0x31, 0xa4, 0xd6, 0x08, 0xaa, 0x15, 0xaa, 0xdc, 0xc8, 0x6a,
+	0xab, 0x14, 0x55, 0x41, 0x4a, 0x55, 0xa9, 0x67, 0x8a, 0x04, 0x07, 0x72, 0x31, 0xa8, 0x57, 0xb4,
+	0x84, 0xd5, 0x62, 0x61, 0x7b, 0x5d, 0xef, 0xba, 0x94, 0x27, 0xe8, 0xb5, 0xb7, 0xbe, 0x52, 0x8f,
+	0x1c, 0x7b, 0xac, 0xe0, 0x45, 0xaa, 0x5d, 0x7b, 0x71, 0x38, 0x44, 0x99, 0xf9, 0xe7, 0xfb, 0x3d,
+	0xf2, 0xcc, 0x18, 0x62, 0x49, 0x8b, 0x2a, 0x67, 0xb5, 0x3c, 0x28, 0x98, 0xaa, 0xb3, 0x75, 0x75,
+	0xd9, 0x05, 0x8b, 0xaa, 0x16, 0x4a, 0xe0, 0xc4, 0xca, 0x7b, 0xbb, 0xea, 0x2a, 0xe3, 0x4c, 0xaa,
+	0x83, 0xee, 0xbf, 0x05, 0x92, 0xdf, 0x2e, 0x8c, 0x57, 0x86, 0x41, 0x84, 0x51, 0x49, 0x0b, 0x46,
+	0x9c, 0x99, 0x33, 0x0f, 0x52, 0x13, 0x6b, 0x4d, 0x51, 0x2e, 0xc9, 0x70, 0xe6, 0x6a, 0x4d, 0xc7,
+	0x98, 0xc0, 0x48, 0xdd, 0x55, 0x8c, 0xb8, 0x33, 0x67, 0x3e, 0x5d, 0x4e, 0x17, 0xb6, 0xc5, 0xe2,
+	0xfc, 0xae, 0x62, 0xa9, 0xa9, 0xe1, 0x12, 0xfc, 0xb5, 0x68, 0x4a, 0xc5, 0x6a, 0xe2, 0xcd, 0x9c,
+	0x79, 0xb8, 0x7c, 0xd9, 0x63, 0x5f, 0xda, 0xc2, 0x57, 0x9a, 0x37, 0xec, 0x64, 0x90, 0x5a, 0x10,
+	0x3f, 0x80, 0xc7, 0x69, 0xc3, 0x19, 0x19, 0x1b, 0xc7, 0x4e, 0xef, 0x38, 0xd6, 0xb2, 0xe5, 0x5b,
+	0x08, 0x3f, 0x43, 0x70, 0x9d, 0x49, 0x25, 0x78, 0x4d, 0x0b, 0xe2, 0x1b, 0x07, 0xe9, 0x1d, 0x27,
+	0xb6, 0x64, 0x5d, 0x3d, 0x8c, 0xef, 0xc0, 0x95, 0x4c, 0x91, 0x89, 0xf1, 0x60, 0xef, 0x39, 0x63,
+	0xca, 0xd2, 0x1a, 0xc0, 0x03, 0x18, 0x4b, 0x45, 0x55, 0x23, 0x09, 0x18, 0x74, 0x77, 0x03, 0x35,
+	0xba, 0xa5, 0x3b, 0x0c, 0xdf, 0x82, 0x27, 0xd7, 0xa2, 0x62, 0x24, 0x30, 0x93, 0x79, 0xb1, 0xc1,
+	0x6b, 0x39, 0x6d, 0xab, 0xf8, 0x0a, 0x02, 0x95, 0x15, 0x4c, 0x2a, 0x5a, 0x54, 0x24, 0x9c, 0x39,
+	0x73, 0x37, 0xed, 0x85, 0x43, 0x1f, 0xbc, 0xef, 0xfa, 0xb9, 0xc9, 0x1b, 0xd8, 0xda, 0x9c, 0x14,
+	0xee, 0x74, 0x05, 0xb3, 0x1f, 0x37, 0xed, 0xa8, 0x04, 0xa0, 0x9f, 0xce, 0x73, 0xc6, 0xb1, 0xcc,
+	0x35, 0x4c, 0x9f, 0xcf, 0x03, 0x3f, 0xc1, 0x44, 0x5d, 0xb4, 0x77, 0x60, 0xd0, 0x70, 0xb9, 0xb7,
+	0xb0, 0x77, 0xb1, 0x62, 0x35, 0xcf, 0x4a, 0x7e, 0x64, 0xb2, 0x23, 0xaa, 0x68, 0xea, 0xab, 0x36,
+	0xc1, 0xd7, 0x10, 0xca, 0xa6, 0xb8, 0x90, 0xdf, 0x1a, 0x5a, 0x33, 0x7d, 0x14, 0xba, 0x09, 0xc8,
+	0xa6, 0x38, 0x6b, 0x95, 0x64, 0x01, 0x13, 0x3b, 0x45, 0x4c, 0x60, 0xfb, 0xfa, 0xae, 0x62, 0xf5,
+	0x45, 0x2e, 0xb8, 0xfe, 0x99, 0x46, 0x5b, 0x69, 0x68, 0xc4, 0x53, 0xc1, 0x4f, 0x05, 0x4f, 0x7e,
+	0x3a, 0x10, 0x6e, 0xcc, 0x12, 0xa7, 0x30, 0x14, 0x37, 0xdd, 0x0b, 0x0e, 0xc5, 0x0d, 0x12, 0xf0,
+	0x6f, 0x69, 0x5d, 0x66, 0x25, 0x37, 0xcd, 0xdc, 0xd4, 0xa6, 0xb8, 0x07, 0x93, 0x75, 0x9d, 0xa9,
+	0x6c, 0x4d, 0x73, 0x73, 0x88, 0x6e, 0xfa, 0x94, 0x6b, 0x57, 0x53, 0xde, 0x94, 0xe2, 0xb6, 0x24,
+	0xa3, 0xd6, 0xd5, 0xa5, 0xba, 0x52, 0x30, 0x29, 0x29, 0x67, 0xe6, 0x2c, 0x83, 0xd4, 0xa6, 0xfb,
+	0xef, 0xc1, 0x33, 0x4b, 0xc2, 0x00, 0xbc, 0x55, 0xf6, 0x83, 0x5d, 0x45, 0x03, 0x1d, 0x9e, 0x8a,
+	0x35, 0xcd, 0x23, 0x07, 0x01, 0xc6, 0xc7, 0xb9, 0xb8, 0xa4, 0x79, 0x34, 0xdc, 0x5f, 0xc1, 0x48,
+	0x5f, 0x3a, 0x86, 0xe0, 0x77, 0x0b, 0x6a, 0x59, 0xb3, 0x87, 0xc8, 0xc1, 0x6d, 0x08, 0x9e, 0xc6,
+	0x1d, 0x0d, 0xd1, 0x07, 0xf7, 0x8c, 0xa9, 0xc8, 0xd5, 0xc8, 0x79, 0x56, 0xb0, 0x3a, 0x1a, 0xe9,
+	0xc7, 0xb5, 0xaf, 0x1d, 0x79, 0x87, 0xe4, 0xcf, 0x43, 0xec, 0xdc, 0x3f, 0xc4, 0xce, 0xbf, 0x87,
+	0xd8, 0xf9, 0xf5, 0x18, 0x0f, 0xee, 0x1f, 0xe3, 0xc1, 0xdf, 0xc7, 0x78, 0x70, 0x39, 0x36, 0x9f,
+	0xe8, 0xc7, 0xff, 0x03, 0x00, 0x0d, 0x7a, 0x28, 0xaa, 0xe5, 0x03, 0x00, 0x00,
 }
 
 func (m *Metric) Marshal() (dAtA []byte, err error) {
@@ -613,6 +755,11 @@ func (m *Metric) MarshalTo(dAtA []byte) (int, error) {
 		i++
 		i = encodeVarintMetric(dAtA, i, uint64(m.Scope))
 	}
+	if m.Timestamp != 0 {
+		dAtA[i] = 0x58
+		i++
+		i = encodeVarintMetric(dAtA, i, uint64(m.Timestamp))
+	}
 	return i, nil
 }
 
@@ -672,6 +819,20 @@ func (m *Metric_Set) MarshalTo(dAtA []byte) (int, error) {
 	}
 	return i, nil
 }
+func (m *Metric_Status) MarshalTo(dAtA []byte) (int, error) {
+	i := 0
+	if m.Status != nil {
+		dAtA[i] = 0x52
+		i++
+		i = encodeVarintMetric(dAtA, i, uint64(m.Status.Size()))
+		n6, err := m.Status.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n6
+	}
+	return i, nil
+}
 func (m *CounterValue) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -738,11 +899,17 @@ func (m *HistogramValue) MarshalTo(dAtA []byte) (int, error) {
 		dAtA[i] = 0xa
 		i++
 		i = encodeVarintMetric(dAtA, i, uint64(m.TDigest.Size()))
-		n6, err := m.TDigest.MarshalTo(dAtA[i:])
+		n7, err := m.TDigest.MarshalTo(dAtA[i:])
 		if err != nil {
 			return 0, err
 		}
-		i += n6
+		i += n7
+	}
+	if m.SumSquares != 0 {
+		dAtA[i] = 0x11
+		i++
+		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.SumSquares))))
+		i += 8
 	}
 	return i, nil
 }
@@ -771,6 +938,50 @@ func (m *SetValue) MarshalTo(dAtA []byte) (int, error) {
 	return i, nil
 }
 
+func (m *StatusValue) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *StatusValue) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.Ok != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintMetric(dAtA, i, uint64(m.Ok))
+	}
+	if m.Warning != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintMetric(dAtA, i, uint64(m.Warning))
+	}
+	if m.Critical != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintMetric(dAtA, i, uint64(m.Critical))
+	}
+	if m.Unknown != 0 {
+		dAtA[i] = 0x20
+		i++
+		i = encodeVarintMetric(dAtA, i, uint64(m.Unknown))
+	}
+	if len(m.Message) > 0 {
+		dAtA[i] = 0x2a
+		i++
+		i = encodeVarintMetric(dAtA, i, uint64(len(m.Message)))
+		i += copy(dAtA[i:], m.Message)
+	}
+	return i, nil
+}
+
 func encodeVarintMetric(dAtA []byte, offset int, v uint64) int {
 	for v >= 1<<7 {
 		dAtA[offset] = uint8(v&0x7f | 0x80)
@@ -805,6 +1016,9 @@ func (m *Metric) Size() (n int) {
 	if m.Scope != 0 {
 		n += 1 + sovMetric(uint64(m.Scope))
 	}
+	if m.Timestamp != 0 {
+		n += 1 + sovMetric(uint64(m.Timestamp))
+	}
 	return n
 }
 
@@ -856,6 +1070,18 @@ func (m *Metric_Set) Size() (n int) {
 	}
 	return n
 }
+func (m *Metric_Status) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Status != nil {
+		l = m.Status.Size()
+		n += 1 + l + sovMetric(uint64(l))
+	}
+	return n
+}
 func (m *CounterValue) Size() (n int) {
 	if m == nil {
 		return 0
@@ -890,6 +1116,9 @@ func (m *HistogramValue) Size() (n int) {
 		l = m.TDigest.Size()
 		n += 1 + l + sovMetric(uint64(l))
 	}
+	if m.SumSquares != 0 {
+		n += 9
+	}
 	return n
 }
 
@@ -906,6 +1135,31 @@ func (m *SetValue) Size() (n int) {
 	return n
 }
 
+func (m *StatusValue) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Ok != 0 {
+		n += 1 + sovMetric(uint64(m.Ok))
+	}
+	if m.Warning != 0 {
+		n += 1 + sovMetric(uint64(m.Warning))
+	}
+	if m.Critical != 0 {
+		n += 1 + sovMetric(uint64(m.Critical))
+	}
+	if m.Unknown != 0 {
+		n += 1 + sovMetric(uint64(m.Unknown))
+	}
+	l = len(m.Message)
+	if l > 0 {
+		n += 1 + l + sovMetric(uint64(l))
+	}
+	return n
+}
+
 func sovMetric(x uint64) (n int) {
 	for {
 		n++
@@ -1190,6 +1444,60 @@ func (m *Metric) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Status", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMetric
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthMetric
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthMetric
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			v := &StatusValue{}
+			if err := v.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			m.Value = &Metric_Status{v}
+			iNdEx = postIndex
+		case 11:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Timestamp", wireType)
+			}
+			m.Timestamp = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMetric
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Timestamp |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipMetric(dAtA[iNdEx:])
@@ -1415,6 +1723,17 @@ func (m *HistogramValue) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 2:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SumSquares", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.SumSquares = float64(math.Float64frombits(v))
 		default:
 			iNdEx = preIndex
 			skippy, err := skipMetric(dAtA[iNdEx:])
@@ -1526,6 +1845,167 @@ func (m *SetValue) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *StatusValue) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowMetric
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: StatusValue: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: StatusValue: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Ok", wireType)
+			}
+			m.Ok = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMetric
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Ok |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Warning", wireType)
+			}
+			m.Warning = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMetric
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Warning |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Critical", wireType)
+			}
+			m.Critical = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMetric
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Critical |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Unknown", wireType)
+			}
+			m.Unknown = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMetric
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Unknown |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Message", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMetric
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthMetric
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthMetric
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Message = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipMetric(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthMetric
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthMetric
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func skipMetric(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
@@ -7,6 +7,7 @@ import (
 
 	"github.com/axiomhq/hyperloglog"
 	"github.com/stripe/veneur/v14/samplers/metricpb"
+	"github.com/stripe/veneur/v14/ssf"
 	"github.com/stripe/veneur/v14/tdigest"
 )
 
@@ -56,8 +57,18 @@ const (
 	AggregateCount
 	AggregateSum
 	AggregateHarmonicMean
+	AggregateFractionBelow
+	AggregateCountAbove
+	AggregateApdex
+	AggregateStdDev
+	AggregateVariance
+	AggregateRate
 )
 
+// ThresholdAggregates are the aggregates that are computed from a histogram's
+// cumulative distribution, using the HistogramThresholds configured for it.
+const ThresholdAggregates = AggregateFractionBelow | AggregateCountAbove | AggregateApdex
+
 var AggregatesLookup = map[string]Aggregate{
 	"min":    AggregateMin,
 	"max":    AggregateMax,
@@ -66,6 +77,14 @@ var AggregatesLookup = map[string]Aggregate{
 	"count":  AggregateCount,
 	"sum":    AggregateSum,
 	"hmean":  AggregateHarmonicMean,
+
+	"fraction_below": AggregateFractionBelow,
+	"count_above":    AggregateCountAbove,
+	"apdex":          AggregateApdex,
+
+	"stddev":   AggregateStdDev,
+	"variance": AggregateVariance,
+	"rate":     AggregateRate,
 }
 
 type HistogramAggregates struct {
@@ -81,6 +100,27 @@ var aggregates = [...]string{
 	AggregateCount:        "count",
 	AggregateSum:          "sum",
 	AggregateHarmonicMean: "hmean",
+
+	AggregateFractionBelow: "fraction_below",
+	AggregateCountAbove:    "count_above",
+	AggregateApdex:         "apdex",
+
+	AggregateStdDev:   "stddev",
+	AggregateVariance: "variance",
+	AggregateRate:     "rate",
+}
+
+// HistogramThresholds are the thresholds used to compute the threshold
+// aggregates of a histogram or timer. They are in the same unit as the
+// histogram's samples.
+type HistogramThresholds struct {
+	// Threshold is used by the fraction_below and count_above aggregates.
+	Threshold float64
+	// ApdexSatisfied and ApdexTolerating are the upper bounds of the
+	// "satisfied" and "tolerating" zones of the apdex aggregate. Samples
+	// above ApdexTolerating are "frustrated".
+	ApdexSatisfied  float64
+	ApdexTolerating float64
 }
 
 // JSONMetric is used to represent a metric that can be remarshaled with its
@@ -209,6 +249,12 @@ func NewGauge(Name string, Tags []string) *Gauge {
 // StatusCheck retains whatever the last value was.
 type StatusCheck struct {
 	InterMetric
+
+	// these are only used by globally aggregated status checks. sampled is
+	// set once a sample came through this veneur instance, and merged holds
+	// the number of hosts that reported each status to us over gRPC.
+	sampled bool
+	merged  metricpb.StatusValue
 }
 
 // Sample takes on whatever value is passed in as a sample.
@@ -216,6 +262,7 @@ func (s *StatusCheck) Sample(sample float64, sampleRate float32, message string,
 	s.Value = sample
 	s.Message = message
 	s.HostName = hostname
+	s.sampled = true
 }
 
 // Flush generates an InterMetric from the current state of this status check.
@@ -225,9 +272,154 @@ func (s *StatusCheck) Flush() []InterMetric {
 	return []InterMetric{s.InterMetric}
 }
 
+// GetName returns the name of the status check.
+func (s *StatusCheck) GetName() string {
+	return s.Name
+}
+
+// Metric returns a protobuf-compatible metricpb.Metric with values set
+// at the time this function was called.  This should be used to export
+// a StatusCheck for forwarding. The exported value counts this host once,
+// under the status it reported last.
+func (s *StatusCheck) Metric() (*metricpb.Metric, error) {
+	value := &metricpb.StatusValue{Message: s.Message}
+	if err := addStatus(value, ssf.SSFSample_Status(s.Value), 1); err != nil {
+		return nil, err
+	}
+
+	return &metricpb.Metric{
+		Name: s.Name,
+		Tags: s.Tags,
+		Type: metricpb.Type_Status,
+		Value: &metricpb.Metric_Status{
+			Status: value,
+		},
+	}, nil
+}
+
+// Merge adds the per-status host counts of the input StatusValue to this
+// status check, keeping the message that accompanied the most severe status.
+func (s *StatusCheck) Merge(v *metricpb.StatusValue) {
+	before := worstStatus(&s.merged)
+	s.merged.Ok += v.Ok
+	s.merged.Warning += v.Warning
+	s.merged.Critical += v.Critical
+	s.merged.Unknown += v.Unknown
+	if v.Message != "" && (s.merged.Message == "" ||
+		statusSeverity(worstStatus(v)) >= statusSeverity(before)) {
+		s.merged.Message = v.Message
+	}
+}
+
+// StatusAggregation describes how a status check that was reported by many
+// hosts is combined into a single check on the global tier.
+type StatusAggregation struct {
+	// CriticalQuorum, if non-zero, is the fraction of hosts that must report
+	// CRITICAL before the combined check is CRITICAL. Below the quorum,
+	// CRITICAL hosts only count as WARNING. If it is zero, the most severe
+	// status reported by any host wins.
+	CriticalQuorum float64
+}
+
+// FlushGlobal generates a single fleet-level InterMetric from every host
+// that reported this status check, combining their statuses according to
+// the given StatusAggregation. The message lists how many hosts reported
+// each status.
+func (s *StatusCheck) FlushGlobal(aggregation StatusAggregation) []InterMetric {
+	counts := s.merged
+	if s.sampled {
+		// samples that came through this instance directly count as one
+		// more host
+		addStatus(&counts, ssf.SSFSample_Status(s.Value), 1)
+	}
+	total := counts.Ok + counts.Warning + counts.Critical + counts.Unknown
+	if total == 0 {
+		return []InterMetric{}
+	}
+
+	var status ssf.SSFSample_Status
+	if aggregation.CriticalQuorum > 0 &&
+		float64(counts.Critical)/float64(total) <= aggregation.CriticalQuorum {
+		demoted := counts
+		demoted.Warning += demoted.Critical
+		demoted.Critical = 0
+		status = worstStatus(&demoted)
+	} else {
+		status = worstStatus(&counts)
+	}
+
+	message := fmt.Sprintf("ok=%d warning=%d critical=%d unknown=%d",
+		counts.Ok, counts.Warning, counts.Critical, counts.Unknown)
+	worstMessage := s.merged.Message
+	if s.sampled && s.Message != "" && (worstMessage == "" ||
+		statusSeverity(ssf.SSFSample_Status(s.Value)) >= statusSeverity(worstStatus(&s.merged))) {
+		worstMessage = s.Message
+	}
+	if worstMessage != "" {
+		message = message + ": " + worstMessage
+	}
+
+	tags := make([]string, len(s.Tags))
+	copy(tags, s.Tags)
+	return []InterMetric{{
+		Name:      s.Name,
+		Timestamp: time.Now().Unix(),
+		Value:     float64(status),
+		Tags:      tags,
+		Type:      StatusMetric,
+		Message:   message,
+	}}
+}
+
+// statusSeverity orders statuses from least to most severe: OK, UNKNOWN,
+// WARNING, CRITICAL.
+func statusSeverity(status ssf.SSFSample_Status) int {
+	switch status {
+	case ssf.SSFSample_OK:
+		return 0
+	case ssf.SSFSample_UNKNOWN:
+		return 1
+	case ssf.SSFSample_WARNING:
+		return 2
+	case ssf.SSFSample_CRITICAL:
+		return 3
+	}
+	return -1
+}
+
+// worstStatus returns the most severe status with a non-zero host count,
+// or OK if no host reported anything.
+func worstStatus(v *metricpb.StatusValue) ssf.SSFSample_Status {
+	switch {
+	case v.Critical > 0:
+		return ssf.SSFSample_CRITICAL
+	case v.Warning > 0:
+		return ssf.SSFSample_WARNING
+	case v.Unknown > 0:
+		return ssf.SSFSample_UNKNOWN
+	}
+	return ssf.SSFSample_OK
+}
+
+func addStatus(v *metricpb.StatusValue, status ssf.SSFSample_Status, n int64) error {
+	switch status {
+	case ssf.SSFSample_OK:
+		v.Ok += n
+	case ssf.SSFSample_WARNING:
+		v.Warning += n
+	case ssf.SSFSample_CRITICAL:
+		v.Critical += n
+	case ssf.SSFSample_UNKNOWN:
+		v.Unknown += n
+	default:
+		return fmt.Errorf("unknown status %v", status)
+	}
+	return nil
+}
+
 // NewStatusCheck generates an empty (valueless) StatusCheck
 func NewStatusCheck(Name string, Tags []string) *StatusCheck {
-	return &StatusCheck{InterMetric{Name: Name, Tags: Tags}}
+	return &StatusCheck{InterMetric: InterMetric{Name: Name, Tags: Tags}}
 }
 
 // Set is a list of unique values seen.
@@ -326,6 +518,11 @@ type Histo struct {
 	LocalMax           float64
 	LocalSum           float64
 	LocalReciprocalSum float64
+	LocalSumSquares    float64
+	// SumSquares is the weighted sum of the squares of every sample in the
+	// histogram, including those merged from elsewhere. The t-digest doesn't
+	// track it, so it is forwarded alongside the t-digest.
+	SumSquares float64
 }
 
 // Sample adds the supplied value to the histogram.
@@ -339,6 +536,9 @@ func (h *Histo) Sample(sample float64, sampleRate float32) {
 	h.LocalSum += sample * weight
 
 	h.LocalReciprocalSum += (1 / sample) * weight
+
+	h.LocalSumSquares += sample * sample * weight
+	h.SumSquares += sample * sample * weight
 }
 
 // NewHist generates a new Histo and returns it.
@@ -494,6 +694,32 @@ func (h *Histo) Flush(interval time.Duration, percentiles []float64, aggregates
 		})
 	}
 
+	if (aggregates.Value&(AggregateStdDev|AggregateVariance)) != 0 && (global || h.LocalWeight != 0) {
+		variance := weightedVariance(h.LocalWeight, h.LocalSum, h.LocalSumSquares)
+		if global {
+			variance = weightedVariance(h.Value.Count(), h.Value.Sum(), h.SumSquares)
+		}
+		metrics = append(metrics, h.varianceMetrics(aggregates, variance, now)...)
+	}
+
+	if (aggregates.Value&AggregateRate) == AggregateRate && (h.LocalWeight != 0 || global) {
+		// like count, leave this sparse if we haven't received any local
+		// samples
+		tags := make([]string, len(h.Tags))
+		copy(tags, h.Tags)
+		val := float64(h.LocalWeight)
+		if global {
+			val = h.Value.Count()
+		}
+		metrics = append(metrics, InterMetric{
+			Name:      fmt.Sprintf("%s.rate", h.Name),
+			Timestamp: now,
+			Value:     val / interval.Seconds(),
+			Tags:      tags,
+			Type:      GaugeMetric,
+		})
+	}
+
 	for _, p := range percentiles {
 		tags := make([]string, len(h.Tags))
 		copy(tags, h.Tags)
@@ -513,6 +739,117 @@ func (h *Histo) Flush(interval time.Duration, percentiles []float64, aggregates
 	return metrics
 }
 
+// weightedVariance returns the population variance of samples with the given
+// total weight, weighted sum and weighted sum of squares.
+func weightedVariance(weight, sum, sumSquares float64) float64 {
+	if weight == 0 {
+		return 0
+	}
+	mean := sum / weight
+	// floating point error can push this slightly below zero when all the
+	// samples are (nearly) equal
+	return math.Max(sumSquares/weight-mean*mean, 0)
+}
+
+// FlushMergedVariance generates the stddev and variance aggregates of the
+// Histo from every sample merged into it, using the sums of the samples and
+// of their squares that were forwarded along with its digest. Unlike the
+// other aggregates, these can be merged exactly, so the global Veneur reports
+// them for mixed scope histograms as well.
+func (h *Histo) FlushMergedVariance(aggregates HistogramAggregates) []InterMetric {
+	if h.Value.Count() == 0 {
+		return nil
+	}
+	variance := weightedVariance(h.Value.Count(), h.Value.Sum(), h.SumSquares)
+	return h.varianceMetrics(aggregates, variance, time.Now().Unix())
+}
+
+// varianceMetrics generates the stddev and variance aggregates that are
+// enabled, from the variance of the Histo's samples.
+func (h *Histo) varianceMetrics(aggregates HistogramAggregates, variance float64, now int64) []InterMetric {
+	metrics := []InterMetric{}
+	if (aggregates.Value & AggregateStdDev) == AggregateStdDev {
+		tags := make([]string, len(h.Tags))
+		copy(tags, h.Tags)
+		metrics = append(metrics, InterMetric{
+			Name:      fmt.Sprintf("%s.stddev", h.Name),
+			Timestamp: now,
+			Value:     math.Sqrt(variance),
+			Tags:      tags,
+			Type:      GaugeMetric,
+		})
+	}
+	if (aggregates.Value & AggregateVariance) == AggregateVariance {
+		tags := make([]string, len(h.Tags))
+		copy(tags, h.Tags)
+		metrics = append(metrics, InterMetric{
+			Name:      fmt.Sprintf("%s.variance", h.Name),
+			Timestamp: now,
+			Value:     variance,
+			Tags:      tags,
+			Type:      GaugeMetric,
+		})
+	}
+	return metrics
+}
+
+// FlushThresholds generates InterMetrics for the aggregates of the Histo
+// that are computed from the cumulative distribution of its t-digest: the
+// fraction of samples below a threshold, the count of samples above it, and
+// an Apdex score. They need the full distribution, so like percentiles they
+// are only accurate where the histogram is aggregated globally.
+func (h *Histo) FlushThresholds(aggregates HistogramAggregates, thresholds HistogramThresholds) []InterMetric {
+	now := time.Now().Unix()
+	metrics := []InterMetric{}
+
+	below := h.Value.CDF(thresholds.Threshold)
+	if math.IsNaN(below) {
+		// the digest is empty, so there is no distribution to report on
+		return metrics
+	}
+
+	if (aggregates.Value & AggregateFractionBelow) == AggregateFractionBelow {
+		tags := make([]string, len(h.Tags))
+		copy(tags, h.Tags)
+		metrics = append(metrics, InterMetric{
+			Name:      fmt.Sprintf("%s.fraction_below", h.Name),
+			Timestamp: now,
+			Value:     below,
+			Tags:      tags,
+			Type:      GaugeMetric,
+		})
+	}
+
+	if (aggregates.Value & AggregateCountAbove) == AggregateCountAbove {
+		tags := make([]string, len(h.Tags))
+		copy(tags, h.Tags)
+		metrics = append(metrics, InterMetric{
+			Name:      fmt.Sprintf("%s.count_above", h.Name),
+			Timestamp: now,
+			Value:     math.Round(h.Value.Count() * (1 - below)),
+			Tags:      tags,
+			Type:      CounterMetric,
+		})
+	}
+
+	if (aggregates.Value&AggregateApdex) == AggregateApdex && thresholds.ApdexTolerating > 0 {
+		// apdex counts satisfied samples fully and tolerating samples as half
+		satisfied := h.Value.CDF(thresholds.ApdexSatisfied)
+		tolerating := h.Value.CDF(thresholds.ApdexTolerating) - satisfied
+		tags := make([]string, len(h.Tags))
+		copy(tags, h.Tags)
+		metrics = append(metrics, InterMetric{
+			Name:      fmt.Sprintf("%s.apdex", h.Name),
+			Timestamp: now,
+			Value:     satisfied + tolerating/2,
+			Tags:      tags,
+			Type:      GaugeMetric,
+		})
+	}
+
+	return metrics
+}
+
 // GetName returns the name of the Histo.
 func (h *Histo) GetName() string {
 	return h.Name
@@ -528,16 +865,18 @@ func (h *Histo) Metric() (*metricpb.Metric, error) {
 		Type: metricpb.Type_Histogram,
 		Value: &metricpb.Metric_Histogram{
 			Histogram: &metricpb.HistogramValue{
-				TDigest: h.Value.Data(),
+				TDigest:    h.Value.Data(),
+				SumSquares: h.SumSquares,
 			},
 		},
 	}, nil
 }
 
-// Merge merges the t-digests of the two histograms and mutates the state
-// of this one.
+// Merge merges the t-digests and sums of squares of the two histograms and
+// mutates the state of this one.
 func (h *Histo) Merge(v *metricpb.HistogramValue) {
 	if v.TDigest != nil {
 		h.Value.Merge(tdigest.NewMergingFromData(v.TDigest))
 	}
+	h.SumSquares += v.SumSquares
 }
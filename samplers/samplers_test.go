@@ -171,6 +171,47 @@ func digest(values []float64) *tdigest.MergingDigest {
 	return td
 }
 
+// Test the Metric and Merge function on StatusCheck
+func TestStatusCheckMergeMetric(t *testing.T) {
+	statuses := []ssf.SSFSample_Status{
+		ssf.SSFSample_OK, ssf.SSFSample_OK, ssf.SSFSample_OK,
+		ssf.SSFSample_WARNING, ssf.SSFSample_CRITICAL,
+	}
+	global := NewStatusCheck("a.b.c", []string{"tag:val"})
+	for i, status := range statuses {
+		s := NewStatusCheck("a.b.c", []string{"tag:val"})
+		s.Sample(float64(status), 1.0, fmt.Sprintf("host %d says %s", i, status), fmt.Sprintf("host%d", i))
+		m, err := s.Metric()
+		assert.NoError(t, err, "should have created a metric from a status check")
+		global.Merge(m.GetStatus())
+	}
+
+	metrics := global.FlushGlobal(StatusAggregation{})
+	if assert.Len(t, metrics, 1) {
+		assert.Equal(t, float64(ssf.SSFSample_CRITICAL), metrics[0].Value,
+			"the worst status should win")
+		assert.Equal(t, StatusMetric, metrics[0].Type)
+		assert.Equal(t, "ok=3 warning=1 critical=1 unknown=0: host 4 says CRITICAL", metrics[0].Message)
+		assert.Equal(t, []string{"tag:val"}, metrics[0].Tags)
+		assert.Empty(t, metrics[0].HostName)
+	}
+
+	metrics = global.FlushGlobal(StatusAggregation{CriticalQuorum: 0.2})
+	if assert.Len(t, metrics, 1) {
+		assert.Equal(t, float64(ssf.SSFSample_WARNING), metrics[0].Value,
+			"20% of hosts being CRITICAL should not meet a quorum of 20%")
+	}
+
+	metrics = global.FlushGlobal(StatusAggregation{CriticalQuorum: 0.1})
+	if assert.Len(t, metrics, 1) {
+		assert.Equal(t, float64(ssf.SSFSample_CRITICAL), metrics[0].Value,
+			"20% of hosts being CRITICAL should meet a quorum of 10%")
+	}
+
+	assert.Empty(t, NewStatusCheck("a.b.c", nil).FlushGlobal(StatusAggregation{}),
+		"a status check no host reported should not be flushed")
+}
+
 // ensure histogram with no local samples flush aggregates for global flushes
 // but no aggregates for non-global (mixed scope) flushes.
 func TestGlobalHistoFlushBehavior(t *testing.T) {
@@ -376,6 +417,103 @@ func TestHistoHMeanOnly(t *testing.T) {
 	assert.Equal(t, expected, m5.Value, "Value")
 }
 
+func TestHistoThresholds(t *testing.T) {
+	h := NewHist("a.b.c", []string{"a:b"})
+
+	for i := 1; i <= 100; i++ {
+		h.Sample(float64(i), 1.0)
+	}
+
+	var aggregates HistogramAggregates
+	aggregates.Value = AggregateFractionBelow | AggregateCountAbove | AggregateApdex
+	aggregates.Count = 3
+
+	metrics := h.FlushThresholds(aggregates, HistogramThresholds{
+		Threshold:       80,
+		ApdexSatisfied:  50,
+		ApdexTolerating: 90,
+	})
+	assert.Len(t, metrics, 3, "Flushed metrics length")
+
+	below := metrics[0]
+	assert.Equal(t, "a.b.c.fraction_below", below.Name, "Name")
+	assert.Equal(t, GaugeMetric, below.Type, "Type")
+	assert.Equal(t, []string{"a:b"}, below.Tags, "Tags")
+	assert.InDelta(t, 0.8, below.Value, 0.02, "Value")
+
+	above := metrics[1]
+	assert.Equal(t, "a.b.c.count_above", above.Name, "Name")
+	assert.Equal(t, CounterMetric, above.Type, "Type")
+	assert.InDelta(t, 20, above.Value, 2, "Value")
+
+	// half the samples are satisfied and 40% are tolerating
+	apdex := metrics[2]
+	assert.Equal(t, "a.b.c.apdex", apdex.Name, "Name")
+	assert.Equal(t, GaugeMetric, apdex.Type, "Type")
+	assert.InDelta(t, 0.7, apdex.Value, 0.02, "Value")
+
+	// apdex is skipped without a tolerating threshold
+	metrics = h.FlushThresholds(aggregates, HistogramThresholds{Threshold: 80})
+	assert.Len(t, metrics, 2, "Flushed metrics length")
+
+	// nothing is reported for an empty histogram
+	metrics = NewHist("a.b.c", nil).FlushThresholds(aggregates, HistogramThresholds{Threshold: 80})
+	assert.Empty(t, metrics, "Flushed metrics")
+}
+
+func TestHistoStdDevVarianceRate(t *testing.T) {
+	h := NewHist("a.b.c", []string{"a:b"})
+
+	h.Sample(2, 1.0)
+	h.Sample(4, 1.0)
+	h.Sample(4, 0.5)
+	h.Sample(5, 1.0)
+	h.Sample(5, 1.0)
+	h.Sample(7, 1.0)
+	h.Sample(9, 1.0)
+
+	var aggregates HistogramAggregates
+	aggregates.Value = AggregateStdDev | AggregateVariance | AggregateRate
+	aggregates.Count = 3
+
+	// the samples are 2, 4, 4, 4, 5, 5, 7 and 9, which have a mean of 5 and
+	// a variance of 4
+	for _, global := range []bool{false, true} {
+		metrics := h.Flush(10*time.Second, []float64{}, aggregates, global)
+		assert.Len(t, metrics, aggregates.Count, "Flushed metrics length")
+
+		stddev := metrics[0]
+		assert.Equal(t, "a.b.c.stddev", stddev.Name, "Name")
+		assert.Equal(t, GaugeMetric, stddev.Type, "Type")
+		assert.Equal(t, []string{"a:b"}, stddev.Tags, "Tags")
+		assert.InDelta(t, 2, stddev.Value, 1e-9, "Value")
+
+		variance := metrics[1]
+		assert.Equal(t, "a.b.c.variance", variance.Name, "Name")
+		assert.Equal(t, GaugeMetric, variance.Type, "Type")
+		assert.InDelta(t, 4, variance.Value, 1e-9, "Value")
+
+		rate := metrics[2]
+		assert.Equal(t, "a.b.c.rate", rate.Name, "Name")
+		assert.Equal(t, GaugeMetric, rate.Type, "Type")
+		assert.InDelta(t, 0.8, rate.Value, 1e-9, "Value")
+	}
+
+	// merged histograms only report the spread of everything they've merged
+	m, err := h.Metric()
+	assert.NoError(t, err)
+	h2 := NewHist("a.b.c", []string{"a:b"})
+	h2.Merge(m.GetHistogram())
+	h2.Merge(m.GetHistogram())
+	assert.Empty(t, h2.Flush(10*time.Second, []float64{}, aggregates, false), "local flush of merged histogram")
+
+	metrics := h2.Flush(10*time.Second, []float64{}, aggregates, true)
+	assert.Len(t, metrics, aggregates.Count, "Flushed metrics length")
+	assert.InDelta(t, 2, metrics[0].Value, 1e-9, "stddev")
+	assert.InDelta(t, 4, metrics[1].Value, 1e-9, "variance")
+	assert.InDelta(t, 1.6, metrics[2].Value, 1e-9, "rate")
+}
+
 func TestHistoSampleRate(t *testing.T) {
 	h := NewHist("a.b.c", []string{"a:b"})
 
@@ -422,6 +560,8 @@ func TestHistoMergeMetric(t *testing.T) {
 	h2.Merge(m.GetHistogram())
 	assert.InEpsilon(t, h.Value.Quantile(0.5), h2.Value.Quantile(0.5), 0.02, "50th percentiles did not match after merging")
 	assert.InDelta(t, 0, h2.LocalWeight, 0.02, "merged histogram should have count of zero")
+	assert.Equal(t, h.SumSquares, h2.SumSquares, "sums of squares did not match after merging")
+	assert.Zero(t, h2.LocalSumSquares, "merged histogram should have local sum of squares of zero")
 	assert.True(t, math.IsInf(h2.LocalMin, +1), "merged histogram should have local minimum of +inf")
 	assert.True(t, math.IsInf(h2.LocalMax, -1), "merged histogram should have local minimum of -inf")
 
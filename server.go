@@ -5,7 +5,6 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"crypto/x509/pkix"
 	"errors"
 	"fmt"
@@ -28,10 +27,15 @@ import (
 	"github.com/zenazn/goji/bind"
 	"github.com/zenazn/goji/graceful"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/pkg/profile"
 
+	"github.com/stripe/veneur/v14/discovery"
+	"github.com/stripe/veneur/v14/forwardbuffer"
 	"github.com/stripe/veneur/v14/protocol"
+	"github.com/stripe/veneur/v14/proxy/connect"
+	"github.com/stripe/veneur/v14/proxy/destinations"
 	"github.com/stripe/veneur/v14/samplers"
 	"github.com/stripe/veneur/v14/samplers/metricpb"
 	"github.com/stripe/veneur/v14/scopedstatsd"
@@ -45,6 +49,7 @@ import (
 	"github.com/stripe/veneur/v14/trace/metrics"
 	"github.com/stripe/veneur/v14/util/build"
 	"github.com/stripe/veneur/v14/util/matcher"
+	"github.com/stripe/veneur/v14/util/tlsconfig"
 )
 
 var profileStartOnce = sync.Once{}
@@ -94,6 +99,7 @@ type HttpCustomHandlers = map[string]func(w http.ResponseWriter, r *http.Request
 // Config used to create a new server.
 type ServerConfig struct {
 	Config             Config
+	Discoverer         discovery.Discoverer
 	Logger             *logrus.Logger
 	MetricSinkTypes    MetricSinkTypes
 	SourceTypes        SourceTypes
@@ -108,6 +114,7 @@ type Server struct {
 	Workers               []*Worker
 	EventWorker           *EventWorker
 	SpanChan              chan *ssf.SSFSpan
+	forwardedSpanChan     chan *ssf.SSFSpan
 	SpanWorker            *SpanWorker
 	SpanWorkerGoroutines  int
 	CountUniqueTimeseries bool
@@ -140,6 +147,9 @@ type Server struct {
 	traceMaxLengthBytes int
 
 	tlsConfig      *tls.Config
+	tlsReloader    *tlsconfig.Reloader
+	tlsForward     bool
+	tlsImport      bool
 	tcpReadTimeout time.Duration
 
 	// closed when the server is shutting down gracefully
@@ -151,6 +161,7 @@ type Server struct {
 	enableProfiling bool
 
 	HistogramAggregates samplers.HistogramAggregates
+	StatusAggregation   samplers.StatusAggregation
 
 	sources     []internalSource
 	spanSinks   []sinks.SpanSink
@@ -167,6 +178,18 @@ type Server struct {
 
 	// gRPC forward clients
 	grpcForwardConn *grpc.ClientConn
+	forwardBuffer   *forwardbuffer.Buffer
+	forwardEvents   bool
+
+	// sharded forwarding to a discovered pool of global instances
+	forwardService           string
+	forwardDiscoverer        discovery.Discoverer
+	closeForwardDiscoverer   func()
+	forwardDiscoveryInterval time.Duration
+	forwardDestinations      destinations.Destinations
+
+	// additional global tiers that every interval is forwarded to
+	forwardReplicas []*forwardReplica
 
 	stuckIntervals int
 	lastFlushUnix  int64
@@ -338,6 +361,23 @@ func (ingest *ingest) IngestMetric(metric *samplers.UDPMetric) {
 }
 
 func (ingest *ingest) IngestMetricProto(metric *metricpb.Metric) {
+	ingest.importWorker(metric).ImportMetricChan <- metric
+}
+
+func (ingest *ingest) TryIngestMetricProto(
+	ctx context.Context, metric *metricpb.Metric,
+) bool {
+	select {
+	case ingest.importWorker(metric).ImportMetricChan <- metric:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// importWorker adds the source's tags to a metric, and returns the worker
+// that imports it.
+func (ingest *ingest) importWorker(metric *metricpb.Metric) *Worker {
 	metric.Tags = append(metric.Tags, ingest.tags...)
 
 	// Compute a 32-bit hash from the input metric based on its name, type, and
@@ -351,7 +391,18 @@ func (ingest *ingest) IngestMetricProto(metric *metricpb.Metric) {
 	}
 
 	workerIndex := h % uint32(len(ingest.server.Workers))
-	ingest.server.Workers[workerIndex].ImportMetricChan <- metric
+	return ingest.server.Workers[workerIndex]
+}
+
+// IngestSpan handles a span forwarded by a local Veneur.
+func (ingest *ingest) IngestSpan(span *ssf.SSFSpan) {
+	ingest.server.recordSSF(span, "forwarded", SSF_GRPC)
+	ingest.server.forwardedSpanChan <- span
+}
+
+// IngestSample handles an event or service check forwarded by a local Veneur.
+func (ingest *ingest) IngestSample(sample *ssf.SSFSample) {
+	ingest.server.EventWorker.sampleChan <- *sample
 }
 
 func (server *Server) createSources(
@@ -472,6 +523,7 @@ func NewFromConfig(config ServerConfig) (*Server, error) {
 		// workers with state from *Server.IsWorker.
 		enableProfiling:      conf.EnableProfiling,
 		ForwardAddr:          conf.ForwardAddress,
+		forwardService:       conf.ForwardService,
 		grpcListenAddress:    conf.GrpcAddress,
 		Hostname:             conf.Hostname,
 		HistogramPercentiles: conf.Percentiles,
@@ -498,18 +550,49 @@ func NewFromConfig(config ServerConfig) (*Server, error) {
 		TagsAsMap:           tagging.ParseTagSliceToMap(conf.Tags),
 		traceMaxLengthBytes: conf.TraceMaxLengthBytes,
 		SpanChan:            make(chan *ssf.SSFSpan, conf.SpanChannelCapacity),
+		forwardedSpanChan:   make(chan *ssf.SSFSpan, conf.SpanChannelCapacity),
 		stuckIntervals:      conf.FlushWatchdogMissedFlushes,
 		synchronizeInterval: conf.SynchronizeWithInterval,
 		// Allocate the slice, we'll fill it with workers later.
 		Workers: make([]*Worker, max(1, conf.NumWorkers)),
 	}
 
+	// Tag everything flushed by a replicated global tier with its name, so
+	// that sinks can tell the copies of each aggregate apart
+	if conf.ReplicaName != "" {
+		ret.Tags = append(append([]string{}, conf.Tags...),
+			replicaTagKey+":"+conf.ReplicaName)
+		ret.TagsAsMap = tagging.ParseTagSliceToMap(ret.Tags)
+	}
+
 	ret.HistogramAggregates.Value = 0
 	for _, agg := range conf.Aggregates {
 		ret.HistogramAggregates.Value += samplers.AggregatesLookup[agg]
 	}
 	ret.HistogramAggregates.Count = len(conf.Aggregates)
 
+	for _, thresholds := range conf.HistogramThresholds {
+		if thresholds.ApdexTolerating < thresholds.ApdexSatisfied {
+			return ret, fmt.Errorf(
+				"histogram_thresholds: apdex_tolerating (%v) must not be below apdex_satisfied (%v)",
+				thresholds.ApdexTolerating, thresholds.ApdexSatisfied)
+		}
+	}
+
+	switch conf.StatusChecks.AggregationPolicy {
+	case "", "worst":
+	case "quorum":
+		if conf.StatusChecks.CriticalQuorum <= 0 || conf.StatusChecks.CriticalQuorum >= 1 {
+			return ret, fmt.Errorf(
+				"status_checks.critical_quorum must be between 0 and 1, got %v",
+				conf.StatusChecks.CriticalQuorum)
+		}
+		ret.StatusAggregation.CriticalQuorum = conf.StatusChecks.CriticalQuorum
+	default:
+		return ret, fmt.Errorf("unknown status_checks.aggregation_policy %q",
+			conf.StatusChecks.AggregationPolicy)
+	}
+
 	stats, err := statsd.New(conf.StatsAddress, statsd.WithoutTelemetry(), statsd.WithMaxMessagesPerPayload(4096))
 	if err != nil {
 		return ret, err
@@ -522,6 +605,85 @@ func NewFromConfig(config ServerConfig) (*Server, error) {
 	}
 	ret.Statsd = scopedstatsd.NewClient(stats, conf.VeneurMetricsAdditionalTags, scopes)
 
+	tlsConf := tlsconfig.Config{
+		Certificate:     conf.TLSCertificate,
+		CertificateFile: conf.TLSCertificateFile,
+		Key:             conf.TLSKey.Value,
+		KeyFile:         conf.TLSKeyFile,
+		Authority:       conf.TLSAuthorityCertificate,
+		AuthorityFile:   conf.TLSAuthorityCertificateFile,
+		ClientAuth:      conf.TLSClientAuth,
+	}
+	if (tlsConf.Key != "" || tlsConf.KeyFile != "") &&
+		tlsConf.Certificate == "" && tlsConf.CertificateFile == "" {
+		err = errors.New("tls_key is set; must set tls_certificate")
+		logger.WithError(err).Error("Improper TLS configuration")
+		return ret, err
+	}
+	if tlsConf.HasCertificate() || conf.TLSForward {
+		// load the TLS key, certificate and authority; they're reloaded
+		// whenever the files they're read from change
+		ret.tlsReloader, err = tlsconfig.New(tlsConf)
+		if err != nil {
+			logger.WithError(err).Error("Improper TLS configuration")
+			return ret, err
+		}
+		if tlsConf.HasCertificate() {
+			ret.tlsConfig = ret.tlsReloader.ServerConfig()
+		}
+	}
+	if conf.TLSImport && ret.tlsConfig == nil {
+		err = errors.New("tls_import is set; must set tls_certificate and tls_key")
+		logger.WithError(err).Error("Improper TLS configuration")
+		return ret, err
+	}
+	ret.tlsImport = conf.TLSImport
+	ret.tlsForward = conf.TLSForward
+
+	discoverer := config.Discoverer
+	if discoverer == nil && usesForwardDiscovery(conf) {
+		discoverer, ret.closeForwardDiscoverer, err =
+			discovery.New(conf.ForwardDiscovery, ret.logger, ret.Statsd, "")
+		if err != nil {
+			logger.WithError(err).Error("Failed to create the forward discoverer")
+			return ret, err
+		}
+	}
+
+	if conf.ForwardService != "" {
+		if conf.ForwardAddress != "" {
+			return ret, errors.New("forward_address and forward_service can't both be set")
+		}
+		ret.forwardDiscoverer = discoverer
+		ret.forwardDiscoveryInterval = conf.ForwardDiscoveryInterval
+		ret.forwardDestinations = destinations.Create(
+			connect.Create(conf.Interval, ret.logger, forwardSendBufferSize, ret.Statsd,
+				ret.forwardTransportCredentials()),
+			destinations.NewConsistentHash(0), ret.logger)
+	}
+
+	if len(conf.ForwardReplicas) > 0 {
+		if !ret.IsLocal() {
+			return ret, errors.New(
+				"forward_replicas requires forward_address or forward_service")
+		}
+		ret.forwardDiscoverer = discoverer
+		ret.forwardDiscoveryInterval = conf.ForwardDiscoveryInterval
+		ret.forwardReplicas, err = ret.newForwardReplicas(
+			conf.ForwardReplicas, discoverer != nil)
+		if err != nil {
+			return ret, err
+		}
+	}
+
+	if ret.IsLocal() && conf.ForwardBuffer.Directory != "" {
+		ret.forwardBuffer, err = forwardbuffer.New(conf.ForwardBuffer.Directory,
+			conf.ForwardBuffer.MaxSizeBytes, conf.ForwardBuffer.MaxAge)
+		if err != nil {
+			return ret, err
+		}
+	}
+
 	ret.TraceClient, err = trace.NewChannelClient(ret.SpanChan,
 		trace.ReportStatistics(stats, 1*time.Second, []string{"ssf_format:internal"}),
 		normalizeSpans(conf),
@@ -555,6 +717,7 @@ func NewFromConfig(config ServerConfig) (*Server, error) {
 	logger.WithField("number", len(ret.Workers)).Info("Preparing workers")
 	for i := range ret.Workers {
 		ret.Workers[i] = NewWorker(i+1, ret.IsLocal(), ret.CountUniqueTimeseries, ret.TraceClient, logger, ret.Statsd)
+		ret.Workers[i].interval = int64(ret.Interval / time.Second)
 		// do not close over loop index
 		go func(w *Worker) {
 			defer func() {
@@ -590,42 +753,6 @@ func NewFromConfig(config ServerConfig) (*Server, error) {
 		ret.GRPCListenAddrs = append(ret.GRPCListenAddrs, addr)
 	}
 
-	if conf.TLSKey.Value != "" {
-		if conf.TLSCertificate == "" {
-			err = errors.New("tls_key is set; must set tls_certificate")
-			logger.WithError(err).Error("Improper TLS configuration")
-			return ret, err
-		}
-
-		// load the TLS key and certificate
-		var cert tls.Certificate
-		cert, err = tls.X509KeyPair([]byte(conf.TLSCertificate), []byte(conf.TLSKey.Value))
-		if err != nil {
-			logger.WithError(err).Error("Improper TLS configuration")
-			return ret, err
-		}
-
-		clientAuthMode := tls.NoClientCert
-		var clientCAs *x509.CertPool
-		if conf.TLSAuthorityCertificate != "" {
-			// load the authority; require clients to present certificated signed by this authority
-			clientAuthMode = tls.RequireAndVerifyClientCert
-			clientCAs = x509.NewCertPool()
-			ok := clientCAs.AppendCertsFromPEM([]byte(conf.TLSAuthorityCertificate))
-			if !ok {
-				err = errors.New("tls_authority_certificate: Could not load any certificates")
-				logger.WithError(err).Error("Improper TLS configuration")
-				return ret, err
-			}
-		}
-
-		ret.tlsConfig = &tls.Config{
-			Certificates: []tls.Certificate{cert},
-			ClientAuth:   clientAuthMode,
-			ClientCAs:    clientCAs,
-		}
-	}
-
 	// Configure tracing sinks if we are listening for ssf
 	if len(conf.SsfListenAddresses) > 0 || len(conf.GrpcListenAddresses) > 0 {
 		trace.Enable()
@@ -663,6 +790,25 @@ func NewFromConfig(config ServerConfig) (*Server, error) {
 	}
 	ret.spanSinks = append(ret.spanSinks, metricSink)
 
+	// Forward spans to the global tier, which runs the span sinks instead
+	if conf.ForwardSpans {
+		if !ret.IsLocal() {
+			return ret, errors.New(
+				"forward_spans requires forward_address or forward_service")
+		}
+		ret.spanSinks = append(ret.spanSinks, newSpanForwardSink(ret))
+	}
+
+	// Forward events and service checks to the global tier, which flushes them
+	// to its metric sinks instead
+	if conf.ForwardEvents {
+		if !ret.IsLocal() {
+			return ret, errors.New(
+				"forward_events requires forward_address or forward_service")
+		}
+		ret.forwardEvents = true
+	}
+
 	// After all sinks are initialized, set the list of tags to exclude
 	ret.setSinkExcludedTags(conf.TagsExclude, ret.metricSinks, ret.spanSinks)
 
@@ -678,9 +824,13 @@ func NewFromConfig(config ServerConfig) (*Server, error) {
 
 	// Setup the grpc server if it was configured
 	if ret.grpcListenAddress != "" {
+		proxyOptions := []proxy.Option{proxy.WithTraceClient(ret.TraceClient)}
+		if ret.tlsImport {
+			proxyOptions = append(proxyOptions,
+				proxy.WithTransportCredentials(credentials.NewTLS(ret.tlsReloader.GRPCServerConfig())))
+		}
 		ret.grpcServer = proxy.New(ret.grpcListenAddress,
-			config.Logger.WithField("source", "proxy"),
-			proxy.WithTraceClient(ret.TraceClient))
+			config.Logger.WithField("source", "proxy"), proxyOptions...)
 
 		ret.sources = append(ret.sources, internalSource{
 			source: ret.grpcServer,
@@ -716,6 +866,7 @@ func (s *Server) Start() {
 	// Use the pre-allocated Workers slice to know how many to start.
 	s.SpanWorker = NewSpanWorker(
 		s.spanSinks, s.TraceClient, s.Statsd, s.SpanChan, s.TagsAsMap, s.logger)
+	s.SpanWorker.forwardedSpanChan = s.forwardedSpanChan
 
 	go func() {
 		s.logger.Info("Starting Event worker")
@@ -816,13 +967,24 @@ func (s *Server) Start() {
 
 	// Initialize a gRPC connection for forwarding
 	var err error
-	s.grpcForwardConn, err = grpc.Dial(s.ForwardAddr, grpc.WithInsecure())
+	s.grpcForwardConn, err = grpc.Dial(s.ForwardAddr, s.forwardTransportOption())
 	if err != nil {
 		s.logger.WithError(err).WithFields(logrus.Fields{
 			"forwardAddr": s.ForwardAddr,
 		}).Fatal("Failed to initialize a gRPC connection for forwarding")
 	}
 
+	err = s.connectForwardReplicas()
+	if err != nil {
+		s.logger.WithError(err).
+			Fatal("Failed to initialize a gRPC connection for forwarding to a replica")
+	}
+
+	// Discover the pool of global instances to shard forwarded metrics across
+	if s.hasDiscoveredForwarding() {
+		go s.pollForwardDiscovery()
+	}
+
 	// Flush every Interval forever!
 	go func() {
 		defer func() {
@@ -1041,6 +1203,12 @@ func (s *Server) HandleTracePacket(packet []byte, protocolType ProtocolType) {
 }
 
 func (s *Server) handleSSF(span *ssf.SSFSpan, ssfFormat string, protocolType ProtocolType) {
+	s.recordSSF(span, ssfFormat, protocolType)
+	s.SpanChan <- span
+}
+
+// recordSSF updates the internal metrics about received spans.
+func (s *Server) recordSSF(span *ssf.SSFSpan, ssfFormat string, protocolType ProtocolType) {
 	// 1/internalMetricSampleRate packets will be chosen
 	const internalMetricSampleRate = 1000
 
@@ -1085,8 +1253,6 @@ func (s *Server) handleSSF(span *ssf.SSFSpan, ssfFormat string, protocolType Pro
 	if !s.IsLocal() {
 		incrementListeningProtocol(s, protocolType)
 	}
-
-	s.SpanChan <- span
 }
 
 // ReadMetricSocket listens for available packets to handle.
@@ -1429,13 +1595,97 @@ func (s *Server) Shutdown() {
 	if s.grpcForwardConn != nil {
 		s.grpcForwardConn.Close()
 	}
+	if s.forwardDestinations != nil {
+		s.forwardDestinations.Clear()
+	}
+	s.closeForwardReplicas()
+	if s.closeForwardDiscoverer != nil {
+		s.closeForwardDiscoverer()
+	}
+}
+
+// pollForwardDiscovery queries service discovery for the global instances of
+// forward_service immediately, and then every forward_discovery_interval, or
+// as soon as the discoverer reports a change if it watches for them, until
+// the server shuts down.
+func (s *Server) pollForwardDiscovery() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var changes <-chan struct{}
+	if notifier, ok := s.forwardDiscoverer.(discovery.Notifier); ok {
+		changes = notifier.Changes()
+	}
+
+	s.handleForwardDiscovery(ctx)
+	ticker := time.NewTicker(s.forwardDiscoveryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.handleForwardDiscovery(ctx)
+		case <-changes:
+			s.handleForwardDiscovery(ctx)
+		case <-s.shutdown:
+			return
+		}
+	}
+}
+
+// handleForwardDiscovery connects to any newly discovered global instances,
+// of forward_service and of the replicas that are discovered. Instances are
+// removed from the hash ring once their connection closes.
+func (s *Server) handleForwardDiscovery(ctx context.Context) {
+	if s.forwardDestinations != nil {
+		s.discoverForwardDestinations(ctx, s.forwardService, s.forwardDestinations, nil)
+	}
+	for _, replica := range s.forwardReplicas {
+		if replica.destinations != nil {
+			s.discoverForwardDestinations(ctx, replica.service, replica.destinations,
+				[]string{"replica:" + replica.name})
+		}
+	}
+}
+
+func (s *Server) discoverForwardDestinations(
+	ctx context.Context, service string,
+	forwardDestinations destinations.Destinations, tags []string,
+) {
+	addresses, err := s.forwardDiscoverer.GetDestinationsForService(service)
+	if err != nil {
+		s.logger.WithError(err).WithField("service", service).
+			Error("Failed to discover global instances to forward to")
+		s.Statsd.Count("forward.discovery_total", 1, append([]string{"status:fail"}, tags...), 1.0)
+		return
+	}
+	forwardDestinations.Add(ctx, addresses)
+	s.Statsd.Gauge("forward.discovery.destinations", float64(forwardDestinations.Size()), tags, 1.0)
+	s.Statsd.Count("forward.discovery_total", 1, append([]string{"status:success"}, tags...), 1.0)
+}
+
+// forwardTransportCredentials returns the credentials used to forward to the
+// global tier, or nil to forward insecurely.
+func (s *Server) forwardTransportCredentials() credentials.TransportCredentials {
+	if !s.tlsForward {
+		return nil
+	}
+	return credentials.NewTLS(s.tlsReloader.ClientConfig())
+}
+
+// forwardTransportOption returns the dial option that secures connections
+// used to forward to the global tier.
+func (s *Server) forwardTransportOption() grpc.DialOption {
+	if creds := s.forwardTransportCredentials(); creds != nil {
+		return grpc.WithTransportCredentials(creds)
+	}
+	return grpc.WithInsecure()
 }
 
 // IsLocal indicates whether veneur is running as a local instance
 // (forwarding non-local data to a global veneur instance) or is running as a global
 // instance (sending all data directly to the final destination).
 func (s *Server) IsLocal() bool {
-	return s.ForwardAddr != ""
+	return s.ForwardAddr != "" || s.forwardService != ""
 }
 
 // IsListeningHTTP returns if the Server is currently listening over HTTP
@@ -39,6 +39,8 @@ import (
 	"github.com/stripe/veneur/v14/util"
 	"github.com/zenazn/goji/graceful"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -350,6 +352,10 @@ func TestLocalServerMixedMetrics(t *testing.T) {
 	go globalVeneur.Serve(listener)
 
 	metricsChannel := make(chan *metricpb.Metric, 1)
+	// The global instance predates SendMetricsV3, so the local instance
+	// falls back to SendMetricsV2.
+	server.EXPECT().SendMetricsV3(gomock.Any()).AnyTimes().
+		Return(status.Error(codes.Unimplemented, "unknown method"))
 	server.EXPECT().SendMetricsV2(gomock.Any()).AnyTimes().
 		Do(func(server forwardrpc.Forward_SendMetricsV2Server) {
 			for {
@@ -497,6 +503,17 @@ func TestTCPConfig(t *testing.T) {
 		t.Error("key without certificate is a config error")
 	}
 
+	config.TLSKey = util.StringSecret{}
+	config.TLSImport = true
+	_, err = NewFromConfig(ServerConfig{
+		Logger: logger,
+		Config: config,
+	})
+	if err == nil {
+		t.Error("tls_import without a certificate is a config error")
+	}
+	config.TLSImport = false
+
 	pems, err := readTestKeysCerts()
 	if err != nil {
 		t.Fatal("could not read test keys/certs:", err)
@@ -520,6 +537,20 @@ func TestTCPConfig(t *testing.T) {
 	if err != nil {
 		t.Error("expected valid config")
 	}
+
+	config.TLSKey = util.StringSecret{}
+	config.TLSKeyFile = filepath.Join("testdata", "serverkey.pem")
+	config.TLSCertificate = ""
+	config.TLSCertificateFile = filepath.Join("testdata", "servercert.pem")
+	config.TLSAuthorityCertificateFile = filepath.Join("testdata", "cacert.pem")
+	config.TLSImport = true
+	_, err = NewFromConfig(ServerConfig{
+		Logger: logger,
+		Config: config,
+	})
+	if err != nil {
+		t.Error("expected valid config with certificate files:", err)
+	}
 }
 
 func sendTCPMetrics(a *net.TCPAddr, tlsConfig *tls.Config, f *fixture) error {
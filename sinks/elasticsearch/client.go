@@ -0,0 +1,371 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/v14/util"
+	"github.com/stripe/veneur/v14/util/build"
+	"github.com/stripe/veneur/v14/util/retry"
+)
+
+const (
+	// The maximum size in bytes of each bulk request, if batch_bytes isn't
+	// set.
+	defaultBatchBytes = 5 * 1024 * 1024
+	// The number of documents in each bulk request, if batch_size isn't set.
+	defaultBatchSize = 1000
+	// The layout of the date appended to index names, if index_date_format
+	// isn't set.
+	defaultIndexDateFormat = "2006.01.02"
+	// How long to wait for each request, if timeout isn't set.
+	defaultTimeout = 10 * time.Second
+
+	// The index_date_format that writes every document to the same index.
+	indexDateFormatNone = "none"
+)
+
+// ClientConfig is the config shared by the Elasticsearch span and event
+// sinks.
+type ClientConfig struct {
+	// Documents that Elasticsearch rejected with a retryable error are
+	// retried like failed requests.
+	retry.Config `yaml:",squash"`
+	// An API key to authenticate with, as the base64 encoding of
+	// "id:api_key" that Elasticsearch returns when the key is created.
+	ApiKey util.StringSecret `yaml:"api_key"`
+	// The maximum size in bytes of each bulk request. Defaults to 5MiB.
+	BatchBytes int `yaml:"batch_bytes"`
+	// The maximum number of documents in each bulk request. Defaults to
+	// 1000.
+	BatchSize int `yaml:"batch_size"`
+	// The names of the fields documents are written with, by the fields of
+	// the sink. Fields that are mapped to "" are left out.
+	Fields map[string]string `yaml:"fields"`
+	// The name of the index documents are written to, before the date.
+	Index string `yaml:"index"`
+	// The Go time layout of the date of each document appended to the index
+	// name, e.g. "veneur-spans-2021.04.01". Defaults to "2006.01.02"; set to
+	// "none" to write every document to the index itself.
+	IndexDateFormat string `yaml:"index_date_format"`
+	// The username and password to authenticate with, if api_key isn't set.
+	Password util.StringSecret `yaml:"password"`
+	// How long to wait for each request. Defaults to 10s.
+	Timeout time.Duration `yaml:"timeout"`
+	// The URL of the Elasticsearch or OpenSearch cluster, e.g.
+	// "https://localhost:9200".
+	URL      string            `yaml:"url"`
+	Username util.StringSecret `yaml:"username"`
+}
+
+// Validates the config, and sets the defaults of fields that aren't set.
+// defaultFields holds the fields of the sink and the names they are written
+// with by default.
+func (config *ClientConfig) validate(
+	defaultIndex string, defaultFields map[string]string,
+) error {
+	if config.URL == "" {
+		return errors.New("elasticsearch sink needs a url")
+	}
+	bulkUrl, err := url.Parse(config.URL)
+	if err != nil {
+		return err
+	}
+	if bulkUrl.Scheme != "http" && bulkUrl.Scheme != "https" {
+		return fmt.Errorf(
+			"elasticsearch url must be an http or https URL, got %q",
+			config.URL)
+	}
+	fields := make(map[string]string, len(defaultFields))
+	for field, name := range defaultFields {
+		fields[field] = name
+	}
+	for field, name := range config.Fields {
+		if _, ok := defaultFields[field]; !ok {
+			return fmt.Errorf("unknown elasticsearch field %q", field)
+		}
+		fields[field] = name
+	}
+	config.Fields = fields
+	if config.Index == "" {
+		config.Index = defaultIndex
+	}
+	if config.IndexDateFormat == "" {
+		config.IndexDateFormat = defaultIndexDateFormat
+	}
+	if config.BatchBytes <= 0 {
+		config.BatchBytes = defaultBatchBytes
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	config.Config.SetDefaults()
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	return nil
+}
+
+// A document to be indexed.
+type document struct {
+	index string
+	// The ID of the document, or "" to let Elasticsearch generate one.
+	id     string
+	source []byte
+}
+
+// Returns the index a document with a timestamp is written to.
+func (config *ClientConfig) indexName(timestamp time.Time) string {
+	if config.IndexDateFormat == indexDateFormatNone {
+		return config.Index
+	}
+	return config.Index + "-" + timestamp.UTC().Format(config.IndexDateFormat)
+}
+
+// Returns the name of the field a document field is written with, or false
+// if it's left out.
+func (config *ClientConfig) fieldName(field string) (string, bool) {
+	name := config.Fields[field]
+	return name, name != ""
+}
+
+// bulkClient indexes documents through the _bulk API.
+type bulkClient struct {
+	bulkUrl string
+	client  *http.Client
+	config  ClientConfig
+	logger  *logrus.Entry
+}
+
+func newBulkClient(
+	config ClientConfig, logger *logrus.Entry,
+) (*bulkClient, error) {
+	bulkUrl, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	bulkUrl.Path = strings.TrimSuffix(bulkUrl.Path, "/") + "/_bulk"
+	return &bulkClient{
+		bulkUrl: bulkUrl.String(),
+		client:  &http.Client{Timeout: config.Timeout},
+		config:  config,
+		logger:  logger,
+	}, nil
+}
+
+// Encodes the action and source lines of a document.
+func (d document) encode() []byte {
+	action := struct {
+		Index struct {
+			Index string `json:"_index"`
+			Id    string `json:"_id,omitempty"`
+		} `json:"index"`
+	}{}
+	action.Index.Index = d.index
+	action.Index.Id = d.id
+	actionLine, _ := json.Marshal(action)
+
+	var buffer bytes.Buffer
+	buffer.Grow(len(actionLine) + len(d.source) + 2)
+	buffer.Write(actionLine)
+	buffer.WriteByte('\n')
+	buffer.Write(d.source)
+	buffer.WriteByte('\n')
+	return buffer.Bytes()
+}
+
+// Indexes documents in bulk requests of at most batch_size documents and
+// batch_bytes bytes, and returns the number of documents indexed. Every
+// request is attempted, and the last error is returned.
+func (c *bulkClient) index(
+	ctx context.Context, documents []document,
+) (int, error) {
+	indexed := 0
+	var lastErr error
+	send := func(batch [][]byte) {
+		if len(batch) == 0 {
+			return
+		}
+		count, err := c.bulk(ctx, batch)
+		indexed += count
+		if err != nil {
+			c.logger.WithError(err).
+				WithField("documents", len(batch)-count).
+				Error("failed to index documents")
+			lastErr = err
+		}
+	}
+
+	batch := [][]byte{}
+	batchBytes := 0
+	for _, document := range documents {
+		encoded := document.encode()
+		// A document bigger than batch_bytes is sent on its own.
+		if len(batch) > 0 && batchBytes+len(encoded) > c.config.BatchBytes {
+			send(batch)
+			batch = [][]byte{}
+			batchBytes = 0
+		}
+		batch = append(batch, encoded)
+		batchBytes += len(encoded)
+		if len(batch) >= c.config.BatchSize {
+			send(batch)
+			batch = [][]byte{}
+			batchBytes = 0
+		}
+	}
+	send(batch)
+	return indexed, lastErr
+}
+
+// A bulk request whose response couldn't be understood.
+type invalidResponseError struct {
+	err error
+}
+
+func (err invalidResponseError) Error() string {
+	return "invalid elasticsearch response: " + err.err.Error()
+}
+
+// Some of the documents of a bulk request failed with a retryable error.
+type retryDocumentsError struct {
+	documents int
+}
+
+func (err retryDocumentsError) Error() string {
+	return fmt.Sprintf(
+		"elasticsearch failed to index %d documents", err.documents)
+}
+
+// Returns whether a failed bulk request can be retried. A request whose
+// response couldn't be understood isn't, since its documents may have been
+// indexed, and documents without an ID would be indexed twice.
+func retryable(err error) bool {
+	var invalidError invalidResponseError
+	if errors.As(err, &invalidError) {
+		return false
+	}
+	return retry.Retryable(err)
+}
+
+// The response to a bulk request, with the result of each document in the
+// order of the request.
+type bulkResponse struct {
+	Errors bool                  `json:"errors"`
+	Items  []map[string]bulkItem `json:"items"`
+}
+
+type bulkItem struct {
+	Status int `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// Sends a bulk request, and returns the number of documents indexed. If the
+// request fails with a retryable error, it's retried with exponential
+// backoff; if some of its documents fail with a retryable error, only those
+// documents are retried. Documents that fail with another error are dropped.
+func (c *bulkClient) bulk(ctx context.Context, batch [][]byte) (int, error) {
+	indexed := 0
+	rejected := 0
+	err := retry.Do(ctx, c.config.Config, retryable,
+		func() (time.Duration, error) {
+			retryAfter, response, err := c.post(ctx, batch)
+			if err != nil {
+				return retryAfter, err
+			}
+			var retryBatch [][]byte
+			var failures []string
+			for index, item := range response.Items {
+				for _, result := range item {
+					if result.Status >= 200 && result.Status < 300 {
+						indexed += 1
+					} else if retry.RetryableStatus(result.Status) {
+						retryBatch = append(retryBatch, batch[index])
+					} else if result.Error != nil {
+						failures = append(failures, fmt.Sprintf(
+							"%s: %s", result.Error.Type, result.Error.Reason))
+					} else {
+						failures = append(
+							failures, "status "+strconv.Itoa(result.Status))
+					}
+				}
+			}
+			if len(failures) > 0 {
+				c.logger.WithFields(logrus.Fields{
+					"documents":   len(failures),
+					"first_error": failures[0],
+				}).Error("elasticsearch rejected documents")
+				rejected += len(failures)
+			}
+			if len(retryBatch) == 0 {
+				return 0, nil
+			}
+			batch = retryBatch
+			return 0, retryDocumentsError{documents: len(retryBatch)}
+		})
+	if err == nil && rejected > 0 {
+		err = fmt.Errorf("elasticsearch rejected %d documents", rejected)
+	}
+	return indexed, err
+}
+
+// Posts a bulk request. If the response is an error that asks the client to
+// wait before retrying, returns how long to wait.
+func (c *bulkClient) post(
+	ctx context.Context, batch [][]byte,
+) (time.Duration, *bulkResponse, error) {
+	body := bytes.Join(batch, nil)
+	request, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, c.bulkUrl, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	request.Header.Set("User-Agent", "veneur/"+build.VERSION)
+	if c.config.ApiKey.Value != "" {
+		request.Header.Set("Authorization", "ApiKey "+c.config.ApiKey.Value)
+	} else if c.config.Username.Value != "" {
+		request.SetBasicAuth(c.config.Username.Value, c.config.Password.Value)
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer response.Body.Close()
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return 0, nil, invalidResponseError{err}
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return retry.After(response.Header), nil, retry.StatusError{
+			Endpoint:   "elasticsearch",
+			StatusCode: response.StatusCode,
+			Body:       string(responseBody),
+		}
+	}
+
+	bulkResponse := &bulkResponse{}
+	err = json.Unmarshal(responseBody, bulkResponse)
+	if err != nil {
+		return 0, nil, invalidResponseError{err}
+	}
+	if len(bulkResponse.Items) != len(batch) {
+		return 0, nil, invalidResponseError{fmt.Errorf(
+			"%d items for %d documents", len(bulkResponse.Items), len(batch))}
+	}
+	return 0, bulkResponse, nil
+}
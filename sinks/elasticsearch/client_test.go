@@ -0,0 +1,261 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14/testhelpers"
+	"github.com/stripe/veneur/v14/util"
+	"github.com/stripe/veneur/v14/util/build"
+	"github.com/stripe/veneur/v14/util/retry"
+)
+
+// A document received in a bulk request.
+type bulkDocument struct {
+	Index  string
+	Id     string
+	Source map[string]interface{}
+}
+
+// Creates a fake Elasticsearch cluster. The first invalidResponses requests
+// that don't fail get a response that isn't JSON, and the documents of the
+// following requests get the statuses in itemStatuses in turn, or 201.
+func newFakeElasticsearch(
+	invalidResponses int, itemStatuses [][]int,
+) *testhelpers.Endpoint {
+	return &testhelpers.Endpoint{
+		Respond: func(w http.ResponseWriter, body []byte) error {
+			documents, err := readBulkRequest(body)
+			if err != nil {
+				return err
+			}
+			if invalidResponses > 0 {
+				invalidResponses -= 1
+				w.Write([]byte("<html>"))
+				return nil
+			}
+			var statuses []int
+			if len(itemStatuses) > 0 {
+				statuses = itemStatuses[0]
+				itemStatuses = itemStatuses[1:]
+			}
+			response := bulkResponse{}
+			for index := range documents {
+				item := bulkItem{Status: http.StatusCreated}
+				if index < len(statuses) {
+					item.Status = statuses[index]
+				}
+				if item.Status >= 300 {
+					response.Errors = true
+					item.Error = &struct {
+						Type   string `json:"type"`
+						Reason string `json:"reason"`
+					}{"mapper_parsing_exception", "failed to parse"}
+				}
+				response.Items = append(
+					response.Items, map[string]bulkItem{"index": item})
+			}
+			w.Header().Set("Content-Type", "application/json")
+			return json.NewEncoder(w).Encode(response)
+		},
+	}
+}
+
+// Returns the documents of each bulk request a fake cluster received.
+func bulkDocuments(
+	t *testing.T, es *testhelpers.Endpoint,
+) [][]bulkDocument {
+	requests := [][]bulkDocument{}
+	for _, body := range es.Bodies() {
+		documents, err := readBulkRequest(body)
+		require.NoError(t, err)
+		requests = append(requests, documents)
+	}
+	return requests
+}
+
+// Decodes the documents of a bulk request.
+func readBulkRequest(body []byte) ([]bulkDocument, error) {
+	documents := []bulkDocument{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		action := map[string]struct {
+			Index string `json:"_index"`
+			Id    string `json:"_id"`
+		}{}
+		err := json.Unmarshal(scanner.Bytes(), &action)
+		if err != nil {
+			return nil, err
+		}
+		if !scanner.Scan() {
+			return nil, errors.New("action without a source")
+		}
+		document := bulkDocument{
+			Index: action["index"].Index,
+			Id:    action["index"].Id,
+		}
+		err = json.Unmarshal(scanner.Bytes(), &document.Source)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+	return documents, nil
+}
+
+func createClient(t *testing.T, config ClientConfig) *bulkClient {
+	require.NoError(t, config.validate("veneur", map[string]string{}))
+	client, err := newBulkClient(config, logrus.NewEntry(logrus.New()))
+	require.NoError(t, err)
+	return client
+}
+
+func testDocuments(count int) []document {
+	documents := make([]document, count)
+	for index := range documents {
+		documents[index] = document{
+			index:  "veneur",
+			source: []byte(`{"message":"0123456789"}`),
+		}
+	}
+	return documents
+}
+
+func TestValidateConfig(t *testing.T) {
+	config := ClientConfig{
+		URL:    "http://localhost:9200",
+		Fields: map[string]string{"timestamp": "time", "host": ""},
+	}
+	require.NoError(t, config.validate("veneur", map[string]string{
+		"host":      "host",
+		"text":      "text",
+		"timestamp": "@timestamp",
+	}))
+	assert.Equal(t, map[string]string{
+		"host":      "",
+		"text":      "text",
+		"timestamp": "time",
+	}, config.Fields)
+	// Dates are in UTC.
+	assert.Equal(t, "veneur-2021.04.02", config.indexName(
+		time.Date(2021, 4, 1, 23, 0, 0, 0, time.FixedZone("", -3600))))
+	config.IndexDateFormat = "none"
+	assert.Equal(t, "veneur", config.indexName(time.Now()))
+
+	for name, config := range map[string]ClientConfig{
+		"no url":        {},
+		"not http":      {URL: "localhost:9200"},
+		"unknown field": {URL: "http://localhost:9200", Fields: map[string]string{"message": "message"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, config.validate("veneur", map[string]string{}))
+		})
+	}
+}
+
+func TestIndexBatches(t *testing.T) {
+	es := newFakeElasticsearch(0, nil)
+	server := httptest.NewServer(es)
+	defer server.Close()
+	defer es.AssertNoErrors(t)
+
+	document := testDocuments(1)[0]
+	client := createClient(t, ClientConfig{
+		BatchBytes: 2 * len(document.encode()),
+		BatchSize:  3,
+		Password:   util.StringSecret{Value: "hunter2"},
+		URL:        server.URL + "/es/",
+		Username:   util.StringSecret{Value: "veneur"},
+	})
+	indexed, err := client.index(context.Background(), testDocuments(5))
+	require.NoError(t, err)
+	assert.Equal(t, 5, indexed)
+
+	// Batches are limited to two documents by their size.
+	requests := bulkDocuments(t, es)
+	require.Len(t, requests, 3)
+	assert.Len(t, requests[0], 2)
+	assert.Len(t, requests[1], 2)
+	assert.Len(t, requests[2], 1)
+	request := es.Requests()[0]
+	assert.Equal(t, "/es/_bulk", request.URL.Path)
+	assert.Equal(t, "application/x-ndjson", request.Header.Get("Content-Type"))
+	assert.Equal(t, "veneur/"+build.VERSION, request.Header.Get("User-Agent"))
+	username, password, ok := request.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "veneur", username)
+	assert.Equal(t, "hunter2", password)
+	assert.Equal(t, "0123456789", requests[0][0].Source["message"])
+}
+
+func TestIndexApiKey(t *testing.T) {
+	es := newFakeElasticsearch(0, nil)
+	server := httptest.NewServer(es)
+	defer server.Close()
+	defer es.AssertNoErrors(t)
+
+	client := createClient(t, ClientConfig{
+		ApiKey:   util.StringSecret{Value: "c2VjcmV0"},
+		Password: util.StringSecret{Value: "hunter2"},
+		URL:      server.URL,
+		Username: util.StringSecret{Value: "veneur"},
+	})
+	_, err := client.index(context.Background(), testDocuments(1))
+	require.NoError(t, err)
+	require.Len(t, es.Requests(), 1)
+	assert.Equal(t, "ApiKey c2VjcmV0", es.Requests()[0].Header.Get("Authorization"))
+}
+
+func TestIndexRetryDocuments(t *testing.T) {
+	es := newFakeElasticsearch(0, [][]int{
+		{http.StatusCreated, http.StatusTooManyRequests, http.StatusBadRequest},
+	})
+	server := httptest.NewServer(es)
+	defer server.Close()
+	defer es.AssertNoErrors(t)
+
+	client := createClient(t, ClientConfig{
+		Config: retry.Config{RetryBackoff: time.Millisecond},
+		URL:    server.URL,
+	})
+	documents := testDocuments(3)
+	documents[1].id = "retried"
+	indexed, err := client.index(context.Background(), documents)
+	assert.Error(t, err)
+	assert.Equal(t, 2, indexed)
+
+	// Only the document rejected with a 429 is retried; the one rejected
+	// with a 400 is dropped.
+	requests := bulkDocuments(t, es)
+	require.Len(t, requests, 2)
+	assert.Len(t, requests[0], 3)
+	require.Len(t, requests[1], 1)
+	assert.Equal(t, "retried", requests[1][0].Id)
+}
+
+func TestIndexInvalidResponse(t *testing.T) {
+	es := newFakeElasticsearch(1, nil)
+	server := httptest.NewServer(es)
+	defer server.Close()
+	defer es.AssertNoErrors(t)
+
+	client := createClient(t, ClientConfig{
+		Config: retry.Config{RetryBackoff: time.Millisecond},
+		URL:    server.URL,
+	})
+	indexed, err := client.index(context.Background(), testDocuments(2))
+	assert.Error(t, err)
+	assert.Equal(t, 0, indexed)
+	// The documents may have been indexed, so they aren't sent again.
+	assert.Len(t, es.Requests(), 1)
+}
@@ -0,0 +1,218 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/v14"
+	"github.com/stripe/veneur/v14/protocol/dogstatsd"
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/util"
+)
+
+// The index events are written to, if index isn't set.
+const defaultEventIndex = "veneur-events"
+
+// The fields of event documents, and the names they are written with by
+// default.
+var defaultEventFields = map[string]string{
+	"aggregation_key": "aggregation_key",
+	"alert_type":      "alert_type",
+	"host":            "host",
+	"priority":        "priority",
+	"source_type":     "source_type",
+	"tags":            "tags",
+	"text":            "text",
+	"timestamp":       "@timestamp",
+	"title":           "title",
+}
+
+type ElasticsearchEventSinkConfig struct {
+	ClientConfig `yaml:",squash"`
+}
+
+// ElasticsearchEventSink indexes events in Elasticsearch. It's a metric sink,
+// since events are flushed to metric sinks, but it doesn't write metrics.
+type ElasticsearchEventSink struct {
+	client      *bulkClient
+	config      ClientConfig
+	hostname    string
+	logger      *logrus.Entry
+	name        string
+	tags        map[string]string
+	traceClient *trace.Client
+}
+
+var _ sinks.MetricSink = (*ElasticsearchEventSink)(nil)
+
+// ParseEventConfig decodes and validates the config of an Elasticsearch
+// event sink.
+func ParseEventConfig(
+	name string, config interface{},
+) (veneur.MetricSinkConfig, error) {
+	elasticsearchConfig := ElasticsearchEventSinkConfig{}
+	err := util.DecodeConfig(name, config, &elasticsearchConfig)
+	if err != nil {
+		return nil, err
+	}
+	err = elasticsearchConfig.ClientConfig.validate(
+		defaultEventIndex, defaultEventFields)
+	if err != nil {
+		return nil, err
+	}
+	return elasticsearchConfig, nil
+}
+
+// CreateEventSink creates a new Elasticsearch event sink.
+func CreateEventSink(
+	server *veneur.Server, name string, logger *logrus.Entry,
+	config veneur.Config, sinkConfig veneur.MetricSinkConfig,
+) (sinks.MetricSink, error) {
+	elasticsearchConfig, ok := sinkConfig.(ElasticsearchEventSinkConfig)
+	if !ok {
+		return nil, errors.New("invalid sink config type")
+	}
+	logger = logger.WithFields(logrus.Fields{
+		"sink_name": name,
+		"sink_kind": "elasticsearch",
+	})
+	client, err := newBulkClient(elasticsearchConfig.ClientConfig, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &ElasticsearchEventSink{
+		client:      client,
+		config:      elasticsearchConfig.ClientConfig,
+		hostname:    config.Hostname,
+		logger:      logger,
+		name:        name,
+		tags:        server.TagsAsMap,
+		traceClient: server.TraceClient,
+	}, nil
+}
+
+// Name returns the sink name.
+func (s *ElasticsearchEventSink) Name() string {
+	return s.name
+}
+
+// Kind returns the sink kind.
+func (s *ElasticsearchEventSink) Kind() string {
+	return "elasticsearch"
+}
+
+// Start sets the trace client.
+func (s *ElasticsearchEventSink) Start(traceClient *trace.Client) error {
+	s.traceClient = traceClient
+	return nil
+}
+
+// Flush skips metrics, which this sink doesn't write. Use
+// metric_sink_routing to route no metrics to it.
+func (s *ElasticsearchEventSink) Flush(
+	ctx context.Context, metrics []samplers.InterMetric,
+) (sinks.MetricFlushResult, error) {
+	span, _ := trace.StartSpanFromContext(ctx, "")
+	defer span.ClientFinish(s.traceClient)
+
+	span.Add(ssf.Count(
+		sinks.MetricKeyTotalMetricsSkipped, float32(len(metrics)),
+		map[string]string{"sink_name": s.Name(), "sink_type": s.Kind()}))
+	return sinks.MetricFlushResult{MetricsSkipped: len(metrics)}, nil
+}
+
+// FlushOtherSamples indexes events. Status checks are ignored.
+func (s *ElasticsearchEventSink) FlushOtherSamples(
+	ctx context.Context, samples []ssf.SSFSample,
+) {
+	span, _ := trace.StartSpanFromContext(ctx, "")
+	defer span.ClientFinish(s.traceClient)
+
+	documents := []document{}
+	for _, sample := range samples {
+		if _, ok := sample.Tags[dogstatsd.EventIdentifierKey]; !ok {
+			continue
+		}
+		document, err := s.eventDocument(sample)
+		if err != nil {
+			s.logger.WithError(err).Warn("failed to encode event")
+			continue
+		}
+		documents = append(documents, document)
+	}
+	if len(documents) == 0 {
+		return
+	}
+
+	indexed, err := s.client.index(ctx, documents)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to index events")
+	}
+	if indexed > 0 {
+		span.Add(ssf.Count(sinks.EventReportedCount, float32(indexed),
+			map[string]string{"sink": s.Name(), "results": "success"}))
+	}
+	if indexed < len(documents) {
+		span.Add(ssf.Count(
+			sinks.EventReportedCount, float32(len(documents)-indexed),
+			map[string]string{"sink": s.Name(), "results": "failure"}))
+	}
+}
+
+// Converts an event to a document. The attributes of the event that
+// dogstatsd encodes as tags are fields of their own, and its other tags,
+// along with Veneur's common tags, are in the tags field.
+func (s *ElasticsearchEventSink) eventDocument(
+	sample ssf.SSFSample,
+) (document, error) {
+	timestamp := time.Unix(sample.Timestamp, 0).UTC()
+	hostname := s.hostname
+
+	source := map[string]interface{}{}
+	set := func(field string, value interface{}) {
+		if name, ok := s.config.fieldName(field); ok {
+			source[name] = value
+		}
+	}
+	tags := map[string]string{}
+	for key, value := range sample.Tags {
+		switch key {
+		case dogstatsd.EventIdentifierKey:
+		case dogstatsd.EventAggregationKeyTagKey:
+			set("aggregation_key", value)
+		case dogstatsd.EventAlertTypeTagKey:
+			set("alert_type", value)
+		case dogstatsd.EventHostnameTagKey:
+			hostname = value
+		case dogstatsd.EventPriorityTagKey:
+			set("priority", value)
+		case dogstatsd.EventSourceTypeTagKey:
+			set("source_type", value)
+		default:
+			tags[key] = value
+		}
+	}
+	for key, value := range s.tags {
+		tags[key] = value
+	}
+	set("host", hostname)
+	set("tags", tags)
+	set("text", sample.Message)
+	set("timestamp", timestamp.Format(time.RFC3339Nano))
+	set("title", sample.Name)
+
+	encoded, err := json.Marshal(source)
+	if err != nil {
+		return document{}, err
+	}
+	return document{
+		index:  s.config.indexName(timestamp),
+		source: encoded,
+	}, nil
+}
@@ -0,0 +1,93 @@
+package elasticsearch
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14"
+	"github.com/stripe/veneur/v14/protocol/dogstatsd"
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+)
+
+func createEventSink(
+	t *testing.T, config map[string]interface{},
+) *ElasticsearchEventSink {
+	sinkConfig, err := ParseEventConfig("elasticsearch", config)
+	require.NoError(t, err)
+	sink, err := CreateEventSink(
+		&veneur.Server{
+			TagsAsMap: map[string]string{"region": "us-west-2"},
+		},
+		"elasticsearch", logrus.NewEntry(logrus.New()),
+		veneur.Config{Hostname: "host-a"}, sinkConfig)
+	require.NoError(t, err)
+	return sink.(*ElasticsearchEventSink)
+}
+
+func TestFlushSkipsMetrics(t *testing.T) {
+	sink := createEventSink(t, map[string]interface{}{
+		"url": "http://localhost:9200",
+	})
+	result, err := sink.Flush(context.Background(), []samplers.InterMetric{{
+		Name: "requests", Value: 1, Type: samplers.CounterMetric,
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, sinks.MetricFlushResult{MetricsSkipped: 1}, result)
+}
+
+func TestFlushEvents(t *testing.T) {
+	es := newFakeElasticsearch(0, nil)
+	server := httptest.NewServer(es)
+	defer server.Close()
+	defer es.AssertNoErrors(t)
+
+	sink := createEventSink(t, map[string]interface{}{
+		"fields": map[string]interface{}{
+			"priority": "",
+		},
+		"index":             "deploys",
+		"index_date_format": "2006.01",
+		"url":               server.URL,
+	})
+	sink.FlushOtherSamples(context.Background(), []ssf.SSFSample{{
+		Name:      "Deploy finished",
+		Message:   "Deployed version 2",
+		Timestamp: 1617235200,
+		Tags: map[string]string{
+			dogstatsd.EventIdentifierKey:   "",
+			dogstatsd.EventAlertTypeTagKey: "success",
+			dogstatsd.EventHostnameTagKey:  "deployer",
+			dogstatsd.EventPriorityTagKey:  "normal",
+			"service":                      "api",
+		},
+	}, {
+		Metric: ssf.SSFSample_STATUS,
+		Name:   "api.health",
+		Status: ssf.SSFSample_WARNING,
+	}})
+
+	// Status checks aren't indexed.
+	requests := bulkDocuments(t, es)
+	require.Len(t, requests, 1)
+	require.Len(t, requests[0], 1)
+	document := requests[0][0]
+	assert.Equal(t, "deploys-2021.04", document.Index)
+	assert.Empty(t, document.Id)
+	assert.Equal(t, map[string]interface{}{
+		"@timestamp": "2021-04-01T00:00:00Z",
+		"alert_type": "success",
+		"host":       "deployer",
+		"tags": map[string]interface{}{
+			"region":  "us-west-2",
+			"service": "api",
+		},
+		"text":  "Deployed version 2",
+		"title": "Deploy finished",
+	}, document.Source)
+}
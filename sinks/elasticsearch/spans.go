@@ -0,0 +1,233 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/v14"
+	"github.com/stripe/veneur/v14/protocol"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/trace/metrics"
+	"github.com/stripe/veneur/v14/util"
+)
+
+const (
+	// The number of spans buffered between flushes, if buffer_size isn't set.
+	defaultBufferSize = 16384
+	// The index spans are written to, if index isn't set.
+	defaultSpanIndex = "veneur-spans"
+)
+
+// The fields of span documents, and the names they are written with by
+// default.
+var defaultSpanFields = map[string]string{
+	"duration_ns":     "duration_ns",
+	"end_timestamp":   "end_timestamp",
+	"error":           "error",
+	"host":            "host",
+	"id":              "span_id",
+	"indicator":       "indicator",
+	"name":            "name",
+	"parent_id":       "parent_id",
+	"service":         "service",
+	"start_timestamp": "@timestamp",
+	"tags":            "tags",
+	"trace_id":        "trace_id",
+}
+
+type ElasticsearchSpanSinkConfig struct {
+	ClientConfig `yaml:",squash"`
+	// The maximum number of spans buffered between flushes. Spans ingested
+	// while the buffer is full are dropped. Defaults to 16384.
+	BufferSize int `yaml:"buffer_size"`
+}
+
+// ElasticsearchSpanSink buffers spans as they are ingested, and indexes them
+// in Elasticsearch when flushed.
+type ElasticsearchSpanSink struct {
+	bufferSize  int
+	client      *bulkClient
+	config      ClientConfig
+	hostname    string
+	logger      *logrus.Entry
+	name        string
+	tags        map[string]string
+	traceClient *trace.Client
+
+	mutex sync.Mutex
+	// The documents of the spans ingested since the last flush.
+	buffer []document
+	// The number of spans dropped since the last flush.
+	dropped int64
+}
+
+var _ sinks.SpanSink = (*ElasticsearchSpanSink)(nil)
+
+// ParseSpanConfig decodes and validates the config of an Elasticsearch span
+// sink.
+func ParseSpanConfig(
+	name string, config interface{},
+) (veneur.SpanSinkConfig, error) {
+	elasticsearchConfig := ElasticsearchSpanSinkConfig{}
+	err := util.DecodeConfig(name, config, &elasticsearchConfig)
+	if err != nil {
+		return nil, err
+	}
+	err = elasticsearchConfig.ClientConfig.validate(
+		defaultSpanIndex, defaultSpanFields)
+	if err != nil {
+		return nil, err
+	}
+	if elasticsearchConfig.BufferSize <= 0 {
+		elasticsearchConfig.BufferSize = defaultBufferSize
+	}
+	return elasticsearchConfig, nil
+}
+
+// CreateSpanSink creates a new Elasticsearch span sink.
+func CreateSpanSink(
+	server *veneur.Server, name string, logger *logrus.Entry,
+	config veneur.Config, sinkConfig veneur.SpanSinkConfig,
+) (sinks.SpanSink, error) {
+	elasticsearchConfig, ok := sinkConfig.(ElasticsearchSpanSinkConfig)
+	if !ok {
+		return nil, errors.New("invalid sink config type")
+	}
+	logger = logger.WithFields(logrus.Fields{
+		"sink_name": name,
+		"sink_kind": "elasticsearch",
+	})
+	client, err := newBulkClient(elasticsearchConfig.ClientConfig, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &ElasticsearchSpanSink{
+		bufferSize:  elasticsearchConfig.BufferSize,
+		client:      client,
+		config:      elasticsearchConfig.ClientConfig,
+		hostname:    config.Hostname,
+		logger:      logger,
+		name:        name,
+		tags:        server.TagsAsMap,
+		traceClient: server.TraceClient,
+	}, nil
+}
+
+// Name returns the sink name.
+func (s *ElasticsearchSpanSink) Name() string {
+	return s.name
+}
+
+// Start sets the trace client.
+func (s *ElasticsearchSpanSink) Start(traceClient *trace.Client) error {
+	s.traceClient = traceClient
+	return nil
+}
+
+// Ingest converts a span to a document and buffers it until the next flush.
+// If the buffer is full, the span is dropped.
+func (s *ElasticsearchSpanSink) Ingest(span *ssf.SSFSpan) error {
+	if err := protocol.ValidateTrace(span); err != nil {
+		return err
+	}
+	document, err := s.spanDocument(span)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.buffer) >= s.bufferSize {
+		atomic.AddInt64(&s.dropped, 1)
+		return nil
+	}
+	s.buffer = append(s.buffer, document)
+	return nil
+}
+
+// Converts a span to a document. The ID of the document is made of the trace
+// and span IDs, so a span that is indexed twice is only stored once.
+func (s *ElasticsearchSpanSink) spanDocument(
+	span *ssf.SSFSpan,
+) (document, error) {
+	start := time.Unix(0, span.StartTimestamp).UTC()
+	end := time.Unix(0, span.EndTimestamp).UTC()
+
+	tags := make(map[string]string, len(span.Tags)+len(s.tags))
+	for key, value := range span.Tags {
+		tags[key] = value
+	}
+	for key, value := range s.tags {
+		tags[key] = value
+	}
+
+	source := map[string]interface{}{}
+	set := func(field string, value interface{}) {
+		if name, ok := s.config.fieldName(field); ok {
+			source[name] = value
+		}
+	}
+	set("duration_ns", span.EndTimestamp-span.StartTimestamp)
+	set("end_timestamp", end.Format(time.RFC3339Nano))
+	set("error", span.Error)
+	set("host", s.hostname)
+	set("id", span.Id)
+	set("indicator", span.Indicator)
+	set("name", span.Name)
+	if span.ParentId != 0 {
+		set("parent_id", span.ParentId)
+	}
+	set("service", span.Service)
+	set("start_timestamp", start.Format(time.RFC3339Nano))
+	set("tags", tags)
+	set("trace_id", span.TraceId)
+
+	encoded, err := json.Marshal(source)
+	if err != nil {
+		return document{}, err
+	}
+	return document{
+		index: s.config.indexName(start),
+		id: strconv.FormatInt(span.TraceId, 10) + "-" +
+			strconv.FormatInt(span.Id, 10),
+		source: encoded,
+	}, nil
+}
+
+// Flush indexes the buffered spans, and reports how many spans were indexed
+// and dropped.
+func (s *ElasticsearchSpanSink) Flush() {
+	s.mutex.Lock()
+	buffer := s.buffer
+	s.buffer = nil
+	s.mutex.Unlock()
+
+	flushed := 0
+	if len(buffer) > 0 {
+		var err error
+		flushed, err = s.client.index(context.Background(), buffer)
+		if err != nil {
+			s.logger.WithError(err).Error("failed to index spans")
+		}
+		atomic.AddInt64(&s.dropped, int64(len(buffer)-flushed))
+	}
+
+	dropped := atomic.SwapInt64(&s.dropped, 0)
+	s.logger.WithFields(logrus.Fields{
+		"flushed_spans": flushed,
+		"dropped_spans": dropped,
+	}).Debug("Flushed spans")
+	metrics.ReportBatch(s.traceClient, []*ssf.SSFSample{
+		ssf.Count(sinks.MetricKeyTotalSpansFlushed, float32(flushed), map[string]string{"sink": s.Name()}),
+		ssf.Count(sinks.MetricKeyTotalSpansDropped, float32(dropped), map[string]string{"sink": s.Name()}),
+	})
+}
@@ -0,0 +1,117 @@
+package elasticsearch
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14"
+	"github.com/stripe/veneur/v14/ssf"
+)
+
+func createSpanSink(
+	t *testing.T, config map[string]interface{},
+) *ElasticsearchSpanSink {
+	sinkConfig, err := ParseSpanConfig("elasticsearch", config)
+	require.NoError(t, err)
+	sink, err := CreateSpanSink(
+		&veneur.Server{
+			TagsAsMap: map[string]string{"region": "us-west-2"},
+		},
+		"elasticsearch", logrus.NewEntry(logrus.New()),
+		veneur.Config{Hostname: "host-a"}, sinkConfig)
+	require.NoError(t, err)
+	return sink.(*ElasticsearchSpanSink)
+}
+
+func testSpan(id int64) *ssf.SSFSpan {
+	return &ssf.SSFSpan{
+		TraceId:        1,
+		Id:             id,
+		StartTimestamp: 1617235200000000000,
+		EndTimestamp:   1617235200250000000,
+		Service:        "api",
+		Name:           "request",
+	}
+}
+
+func TestIngestInvalidSpan(t *testing.T) {
+	sink := createSpanSink(t, map[string]interface{}{
+		"url": "http://localhost:9200",
+	})
+	assert.Error(t, sink.Ingest(&ssf.SSFSpan{}))
+}
+
+func TestFlushSpans(t *testing.T) {
+	es := newFakeElasticsearch(0, nil)
+	server := httptest.NewServer(es)
+	defer server.Close()
+	defer es.AssertNoErrors(t)
+
+	sink := createSpanSink(t, map[string]interface{}{
+		"fields": map[string]interface{}{
+			"host":    "",
+			"service": "service.name",
+		},
+		"url": server.URL,
+	})
+	span := testSpan(2)
+	span.ParentId = 1
+	span.Error = true
+	span.Tags = map[string]string{"path": "/", "region": "ignored"}
+	require.NoError(t, sink.Ingest(span))
+	require.NoError(t, sink.Ingest(testSpan(1)))
+	sink.Flush()
+
+	requests := bulkDocuments(t, es)
+	require.Len(t, requests, 1)
+	require.Len(t, requests[0], 2)
+	document := requests[0][0]
+	assert.Equal(t, "veneur-spans-2021.04.01", document.Index)
+	assert.Equal(t, "1-2", document.Id)
+	assert.Equal(t, map[string]interface{}{
+		"@timestamp":    "2021-04-01T00:00:00Z",
+		"duration_ns":   float64(250000000),
+		"end_timestamp": "2021-04-01T00:00:00.25Z",
+		"error":         true,
+		"indicator":     false,
+		"name":          "request",
+		"parent_id":     float64(1),
+		"service.name":  "api",
+		"span_id":       float64(2),
+		"tags": map[string]interface{}{
+			"path":   "/",
+			"region": "us-west-2",
+		},
+		"trace_id": float64(1),
+	}, document.Source)
+	assert.NotContains(t, requests[0][1].Source, "parent_id")
+
+	// The buffer is empty after a flush.
+	sink.Flush()
+	assert.Len(t, es.Requests(), 1)
+}
+
+func TestIngestFullBuffer(t *testing.T) {
+	es := newFakeElasticsearch(0, nil)
+	server := httptest.NewServer(es)
+	defer server.Close()
+	defer es.AssertNoErrors(t)
+
+	sink := createSpanSink(t, map[string]interface{}{
+		"buffer_size": 2,
+		"url":         server.URL,
+	})
+	for index := 0; index < 3; index++ {
+		require.NoError(t, sink.Ingest(testSpan(int64(index+1))))
+	}
+	assert.Equal(t, int64(1), sink.dropped)
+
+	sink.Flush()
+	assert.Equal(t, int64(0), sink.dropped)
+	requests := bulkDocuments(t, es)
+	require.Len(t, requests, 1)
+	assert.Len(t, requests[0], 2)
+}
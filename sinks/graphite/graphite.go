@@ -0,0 +1,517 @@
+package graphite
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/v14"
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/util"
+)
+
+const (
+	// FormatPath writes each metric to the path rendered by the template.
+	FormatPath = "path"
+	// FormatTagged writes each metric as a Graphite 1.1 tagged series.
+	FormatTagged = "tagged"
+
+	ProtocolPickle    = "pickle"
+	ProtocolPlaintext = "plaintext"
+
+	// The number of metrics in each pickle message, if batch_size isn't set.
+	defaultBatchSize = 500
+	// The longest wait between attempts to connect, if max_reconnect_backoff
+	// isn't set.
+	defaultMaxReconnectBackoff = 30 * time.Second
+	// The wait after the first failed attempt to connect, if
+	// reconnect_backoff isn't set.
+	defaultReconnectBackoff = time.Second
+	// The template of metric paths, if template isn't set.
+	defaultTemplate = "{{.Name}}"
+	// How long to wait to connect and to write, if timeout isn't set.
+	defaultTimeout = 10 * time.Second
+)
+
+type GraphiteMetricSinkConfig struct {
+	// The host and port of Carbon's plaintext or pickle receiver.
+	Address string `yaml:"address"`
+	// The maximum number of metrics in each pickle message. Defaults to 500.
+	BatchSize int `yaml:"batch_size"`
+	// "path" (the default) writes metrics to the path rendered by Template,
+	// and "tagged" writes them as tagged series, e.g. "name;tag=value".
+	Format string `yaml:"format"`
+	// The longest wait between attempts to connect. Defaults to 30s.
+	MaxReconnectBackoff time.Duration `yaml:"max_reconnect_backoff"`
+	// "plaintext" (the default) or "pickle".
+	Protocol string `yaml:"protocol"`
+	// How long to wait after the first failed attempt to connect before
+	// trying again, which doubles for each following attempt. Defaults to 1s.
+	ReconnectBackoff time.Duration `yaml:"reconnect_backoff"`
+	// A text/template that renders the path of a metric from its .Name, its
+	// .Tags by key, and its .Type, e.g. "{{.Tags.env}}.{{.Name}}". Defaults to
+	// "{{.Name}}".
+	Template string `yaml:"template"`
+	// How long to wait to connect and to write. Defaults to 10s.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// GraphiteMetricSink writes metrics to Carbon over a persistent TCP
+// connection, in the plaintext or pickle protocol.
+type GraphiteMetricSink struct {
+	config      GraphiteMetricSinkConfig
+	hostname    string
+	logger      *logrus.Entry
+	name        string
+	tags        map[string]string
+	template    *template.Template
+	traceClient *trace.Client
+
+	mutex sync.Mutex
+	// The connection to Carbon, or nil if it isn't connected.
+	conn net.Conn
+	// How long to wait after the next failed attempt to connect.
+	backoff time.Duration
+	// When the next attempt to connect can be made.
+	nextDial time.Time
+}
+
+// The data a path template is rendered with.
+type templateData struct {
+	Name string
+	Tags map[string]string
+	Type string
+}
+
+var _ sinks.MetricSink = (*GraphiteMetricSink)(nil)
+
+// ParseConfig decodes and validates the config of a Graphite sink.
+func ParseConfig(
+	name string, config interface{},
+) (veneur.MetricSinkConfig, error) {
+	graphiteConfig := GraphiteMetricSinkConfig{}
+	err := util.DecodeConfig(name, config, &graphiteConfig)
+	if err != nil {
+		return nil, err
+	}
+	if graphiteConfig.Address == "" {
+		return nil, errors.New("graphite sink needs an address")
+	}
+	switch graphiteConfig.Format {
+	case "":
+		graphiteConfig.Format = FormatPath
+	case FormatPath, FormatTagged:
+	default:
+		return nil, fmt.Errorf(
+			"unknown graphite format %q", graphiteConfig.Format)
+	}
+	switch graphiteConfig.Protocol {
+	case "":
+		graphiteConfig.Protocol = ProtocolPlaintext
+	case ProtocolPickle, ProtocolPlaintext:
+	default:
+		return nil, fmt.Errorf(
+			"unknown graphite protocol %q", graphiteConfig.Protocol)
+	}
+	if graphiteConfig.Template == "" {
+		graphiteConfig.Template = defaultTemplate
+	}
+	if graphiteConfig.BatchSize <= 0 {
+		graphiteConfig.BatchSize = defaultBatchSize
+	}
+	if graphiteConfig.ReconnectBackoff <= 0 {
+		graphiteConfig.ReconnectBackoff = defaultReconnectBackoff
+	}
+	if graphiteConfig.MaxReconnectBackoff <= 0 {
+		graphiteConfig.MaxReconnectBackoff = defaultMaxReconnectBackoff
+	}
+	if graphiteConfig.Timeout <= 0 {
+		graphiteConfig.Timeout = defaultTimeout
+	}
+	return graphiteConfig, nil
+}
+
+// Create creates a new Graphite sink. It connects to Carbon on the first
+// flush.
+func Create(
+	server *veneur.Server, name string, logger *logrus.Entry,
+	config veneur.Config, sinkConfig veneur.MetricSinkConfig,
+) (sinks.MetricSink, error) {
+	graphiteConfig, ok := sinkConfig.(GraphiteMetricSinkConfig)
+	if !ok {
+		return nil, errors.New("invalid sink config type")
+	}
+	pathTemplate, err := template.New("path").
+		Option("missingkey=zero").Parse(graphiteConfig.Template)
+	if err != nil {
+		return nil, err
+	}
+	return &GraphiteMetricSink{
+		backoff:  graphiteConfig.ReconnectBackoff,
+		config:   graphiteConfig,
+		hostname: config.Hostname,
+		logger: logger.WithFields(logrus.Fields{
+			"sink_name": name,
+			"sink_kind": "graphite",
+		}),
+		name:        name,
+		tags:        server.TagsAsMap,
+		template:    pathTemplate,
+		traceClient: server.TraceClient,
+	}, nil
+}
+
+// Name returns the sink name.
+func (s *GraphiteMetricSink) Name() string {
+	return s.name
+}
+
+// Kind returns the sink kind.
+func (s *GraphiteMetricSink) Kind() string {
+	return "graphite"
+}
+
+// Start sets the trace client.
+func (s *GraphiteMetricSink) Start(traceClient *trace.Client) error {
+	s.traceClient = traceClient
+	return nil
+}
+
+// A metric converted to Graphite's data model.
+type datapoint struct {
+	path      string
+	timestamp int64
+	value     float64
+}
+
+// Flush writes metrics to Carbon. If the connection fails, the sink
+// reconnects and writes the metrics once more; if it can't connect, the
+// metrics are dropped, and it waits with exponential backoff before trying to
+// connect again.
+func (s *GraphiteMetricSink) Flush(
+	ctx context.Context, metrics []samplers.InterMetric,
+) (sinks.MetricFlushResult, error) {
+	span, _ := trace.StartSpanFromContext(ctx, "")
+	defer span.ClientFinish(s.traceClient)
+
+	result := sinks.MetricFlushResult{}
+	metricKeyTags := map[string]string{"sink_name": s.Name(), "sink_type": s.Kind()}
+	defer func() {
+		span.Add(ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(result.MetricsFlushed), metricKeyTags))
+		span.Add(ssf.Count(sinks.MetricKeyTotalMetricsDropped, float32(result.MetricsDropped), metricKeyTags))
+		span.Add(ssf.Count(sinks.MetricKeyTotalMetricsSkipped, float32(result.MetricsSkipped), metricKeyTags))
+	}()
+
+	datapoints := make([]datapoint, 0, len(metrics))
+	for _, metric := range metrics {
+		point, ok := s.datapoint(metric)
+		if !ok {
+			result.MetricsSkipped += 1
+			continue
+		}
+		datapoints = append(datapoints, point)
+	}
+	if len(datapoints) == 0 {
+		return result, nil
+	}
+
+	var payload []byte
+	if s.config.Protocol == ProtocolPickle {
+		payload = encodePickle(datapoints, s.config.BatchSize)
+	} else {
+		payload = encodePlaintext(datapoints)
+	}
+
+	err := s.write(payload)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to write to graphite")
+		result.MetricsDropped = len(datapoints)
+		return result, err
+	}
+	result.MetricsFlushed = len(datapoints)
+	return result, nil
+}
+
+// Converts a metric to a datapoint, or returns false if it can't be written
+// because it has no path or its value is NaN or infinite.
+func (s *GraphiteMetricSink) datapoint(
+	metric samplers.InterMetric,
+) (datapoint, bool) {
+	if math.IsNaN(metric.Value) || math.IsInf(metric.Value, 0) {
+		return datapoint{}, false
+	}
+	tags := make(map[string]string, len(metric.Tags)+len(s.tags)+1)
+	for _, tag := range metric.Tags {
+		kv := strings.SplitN(tag, ":", 2)
+		if len(kv) < 2 || kv[1] == "" {
+			continue
+		}
+		tags[kv[0]] = kv[1]
+	}
+	for key, value := range s.tags {
+		tags[key] = value
+	}
+	hostname := metric.HostName
+	if hostname == "" {
+		hostname = s.hostname
+	}
+	if hostname != "" {
+		tags["host"] = hostname
+	}
+
+	var path string
+	if s.config.Format == FormatTagged {
+		path = taggedPath(metric.Name, tags)
+	} else {
+		var err error
+		path, err = s.templatePath(metric, tags)
+		if err != nil {
+			s.logger.WithError(err).WithField("metric", metric.Name).
+				Debug("failed to render graphite path")
+			return datapoint{}, false
+		}
+	}
+	if path == "" {
+		return datapoint{}, false
+	}
+	return datapoint{
+		path:      path,
+		timestamp: metric.Timestamp,
+		value:     metric.Value,
+	}, true
+}
+
+// Renders the path of a metric with the template. Tag values are sanitised
+// into a single path node, and empty nodes are removed.
+func (s *GraphiteMetricSink) templatePath(
+	metric samplers.InterMetric, tags map[string]string,
+) (string, error) {
+	sanitisedTags := make(map[string]string, len(tags))
+	for key, value := range tags {
+		sanitisedTags[key] = sanitiseNode(value)
+	}
+	metricType := "gauge"
+	switch metric.Type {
+	case samplers.CounterMetric:
+		metricType = "counter"
+	case samplers.StatusMetric:
+		metricType = "status"
+	}
+
+	var buffer bytes.Buffer
+	err := s.template.Execute(&buffer, templateData{
+		Name: sanitisePath(metric.Name),
+		Tags: sanitisedTags,
+		Type: metricType,
+	})
+	if err != nil {
+		return "", err
+	}
+	return sanitisePath(buffer.String()), nil
+}
+
+// Formats a tagged series, e.g. "name;env=prod;host=a", with its tags sorted
+// by key.
+func taggedPath(name string, tags map[string]string) string {
+	name = sanitisePath(name)
+	if name == "" {
+		return ""
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	builder.WriteString(name)
+	for _, key := range keys {
+		sanitisedKey := sanitiseTagKey(key)
+		value := sanitiseTagValue(tags[key])
+		if sanitisedKey == "" || value == "" {
+			continue
+		}
+		builder.WriteByte(';')
+		builder.WriteString(sanitisedKey)
+		builder.WriteByte('=')
+		builder.WriteString(value)
+	}
+	return builder.String()
+}
+
+// Replaces the characters which are not in the set [a-zA-Z0-9_-] with
+// underscores, so that the value is a single path node.
+func sanitiseNode(value string) string {
+	return strings.Map(func(r rune) rune {
+		if isNodeRune(r) {
+			return r
+		}
+		return '_'
+	}, value)
+}
+
+// Replaces the characters which are not in the set [a-zA-Z0-9_.-] with
+// underscores, and removes empty nodes, e.g. "a..b." becomes "a.b".
+func sanitisePath(path string) string {
+	nodes := strings.Split(path, ".")
+	kept := nodes[:0]
+	for _, node := range nodes {
+		if node != "" {
+			kept = append(kept, sanitiseNode(node))
+		}
+	}
+	return strings.Join(kept, ".")
+}
+
+// Replaces the characters that can't be in a tag key, ";!^=", as well as
+// whitespace, with underscores.
+func sanitiseTagKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ';', '!', '^', '=', ' ', '\t', '\n':
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// Replaces the characters that can't be in a tag value, ";" and whitespace,
+// with underscores. Values can't start with "~".
+func sanitiseTagValue(value string) string {
+	value = strings.Map(func(r rune) rune {
+		switch r {
+		case ';', ' ', '\t', '\n':
+			return '_'
+		}
+		return r
+	}, value)
+	return strings.TrimLeft(value, "~")
+}
+
+func isNodeRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+		r >= '0' && r <= '9' || r == '_' || r == '-'
+}
+
+// Encodes datapoints in the plaintext protocol, one "path value timestamp"
+// line each.
+func encodePlaintext(datapoints []datapoint) []byte {
+	var buffer bytes.Buffer
+	for _, point := range datapoints {
+		buffer.WriteString(point.path)
+		buffer.WriteByte(' ')
+		buffer.WriteString(strconv.FormatFloat(point.value, 'f', -1, 64))
+		buffer.WriteByte(' ')
+		buffer.WriteString(strconv.FormatInt(point.timestamp, 10))
+		buffer.WriteByte('\n')
+	}
+	return buffer.Bytes()
+}
+
+// Encodes datapoints in the pickle protocol, as messages of at most batchSize
+// datapoints. Each message is a 4-byte big-endian length, followed by a list
+// of (path, (timestamp, value)) tuples pickled with protocol 2.
+func encodePickle(datapoints []datapoint, batchSize int) []byte {
+	var buffer bytes.Buffer
+	for start := 0; start < len(datapoints); start += batchSize {
+		end := start + batchSize
+		if end > len(datapoints) {
+			end = len(datapoints)
+		}
+
+		var message bytes.Buffer
+		// PROTO 2, EMPTY_LIST, MARK
+		message.Write([]byte{0x80, 0x02, ']', '('})
+		for _, point := range datapoints[start:end] {
+			// BINUNICODE
+			message.WriteByte('X')
+			binary.Write(&message, binary.LittleEndian, uint32(len(point.path)))
+			message.WriteString(point.path)
+			// BINFLOAT, BINFLOAT, TUPLE2, TUPLE2
+			message.WriteByte('G')
+			binary.Write(&message, binary.BigEndian, float64(point.timestamp))
+			message.WriteByte('G')
+			binary.Write(&message, binary.BigEndian, point.value)
+			message.Write([]byte{0x86, 0x86})
+		}
+		// APPENDS, STOP
+		message.Write([]byte{'e', '.'})
+
+		binary.Write(&buffer, binary.BigEndian, uint32(message.Len()))
+		buffer.Write(message.Bytes())
+	}
+	return buffer.Bytes()
+}
+
+// Writes a payload to Carbon, connecting first if needed. If the write fails,
+// reconnects and writes the payload once more.
+func (s *GraphiteMetricSink) write(payload []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		err = s.connect()
+		if err != nil {
+			return err
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(s.config.Timeout))
+		_, err = s.conn.Write(payload)
+		if err == nil {
+			return nil
+		}
+		s.logger.WithError(err).Warn("lost connection to graphite")
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+// Connects to Carbon if the sink isn't connected. After a failed attempt,
+// further attempts fail without connecting until the backoff has passed. The
+// mutex must be held.
+func (s *GraphiteMetricSink) connect() error {
+	if s.conn != nil {
+		return nil
+	}
+	now := time.Now()
+	if now.Before(s.nextDial) {
+		return fmt.Errorf(
+			"not reconnecting to graphite for another %v",
+			s.nextDial.Sub(now).Round(time.Millisecond))
+	}
+	conn, err := net.DialTimeout("tcp", s.config.Address, s.config.Timeout)
+	if err != nil {
+		s.nextDial = now.Add(s.backoff)
+		s.backoff *= 2
+		if s.backoff > s.config.MaxReconnectBackoff {
+			s.backoff = s.config.MaxReconnectBackoff
+		}
+		return err
+	}
+	s.conn = conn
+	s.backoff = s.config.ReconnectBackoff
+	s.nextDial = time.Time{}
+	return nil
+}
+
+// FlushOtherSamples is a no-op; Graphite can't store events or status check
+// messages.
+func (s *GraphiteMetricSink) FlushOtherSamples(
+	ctx context.Context, samples []ssf.SSFSample,
+) {
+}
@@ -0,0 +1,267 @@
+package graphite
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14"
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
+)
+
+// A fake Carbon receiver that records what it reads from each connection.
+type fakeCarbon struct {
+	listener net.Listener
+
+	mutex sync.Mutex
+	conns []net.Conn
+	data  bytes.Buffer
+}
+
+func newFakeCarbon(t *testing.T) *fakeCarbon {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	carbon := &fakeCarbon{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			carbon.mutex.Lock()
+			carbon.conns = append(carbon.conns, conn)
+			carbon.mutex.Unlock()
+			go carbon.read(conn)
+		}
+	}()
+	return carbon
+}
+
+func (carbon *fakeCarbon) read(conn net.Conn) {
+	buffer := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buffer)
+		carbon.mutex.Lock()
+		carbon.data.Write(buffer[:n])
+		carbon.mutex.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (carbon *fakeCarbon) Close() {
+	carbon.listener.Close()
+	carbon.mutex.Lock()
+	defer carbon.mutex.Unlock()
+	for _, conn := range carbon.conns {
+		conn.Close()
+	}
+}
+
+// Waits until the receiver has read length bytes, and returns them.
+func (carbon *fakeCarbon) waitFor(t *testing.T, length int) string {
+	require.Eventually(t, func() bool {
+		carbon.mutex.Lock()
+		defer carbon.mutex.Unlock()
+		return carbon.data.Len() >= length
+	}, 5*time.Second, time.Millisecond)
+	carbon.mutex.Lock()
+	defer carbon.mutex.Unlock()
+	return carbon.data.String()
+}
+
+func createSink(
+	t *testing.T, config map[string]interface{},
+) *GraphiteMetricSink {
+	sinkConfig, err := ParseConfig("graphite", config)
+	require.NoError(t, err)
+	sink, err := Create(
+		&veneur.Server{
+			TagsAsMap: map[string]string{"region": "us-west-2"},
+		},
+		"graphite", logrus.NewEntry(logrus.New()),
+		veneur.Config{Hostname: "host-a"}, sinkConfig)
+	require.NoError(t, err)
+	return sink.(*GraphiteMetricSink)
+}
+
+var testMetrics = []samplers.InterMetric{{
+	Name:      "api.requests",
+	Timestamp: 100,
+	Value:     3,
+	Tags:      []string{"env:prod", "path:/a b", "cached"},
+	Type:      samplers.CounterMetric,
+}, {
+	Name:      "temperature (C)",
+	Timestamp: 100,
+	Value:     21.5,
+	Tags:      []string{"region:ignored"},
+	HostName:  "sensor.1",
+	Type:      samplers.GaugeMetric,
+}, {
+	Name:  "broken",
+	Value: math.NaN(),
+	Type:  samplers.GaugeMetric,
+}}
+
+func TestParseConfigInvalid(t *testing.T) {
+	for name, config := range map[string]map[string]interface{}{
+		"no address": {},
+		"unknown format": {
+			"address": "localhost:2003",
+			"format":  "json",
+		},
+		"unknown protocol": {
+			"address":  "localhost:2003",
+			"protocol": "udp",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseConfig("graphite", config)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestFlushPath(t *testing.T) {
+	carbon := newFakeCarbon(t)
+	defer carbon.Close()
+
+	sink := createSink(t, map[string]interface{}{
+		"address":  carbon.listener.Addr().String(),
+		"template": "veneur.{{.Tags.env}}.{{.Tags.host}}.{{.Type}}.{{.Name}}",
+	})
+	result, err := sink.Flush(context.Background(), testMetrics)
+	require.NoError(t, err)
+	assert.Equal(t, sinks.MetricFlushResult{
+		MetricsFlushed: 2,
+		MetricsSkipped: 1,
+	}, result)
+
+	expected := "veneur.prod.host-a.counter.api.requests 3 100\n" +
+		"veneur.sensor_1.gauge.temperature__C_ 21.5 100\n"
+	assert.Equal(t, expected, carbon.waitFor(t, len(expected)))
+}
+
+func TestFlushTagged(t *testing.T) {
+	carbon := newFakeCarbon(t)
+	defer carbon.Close()
+
+	sink := createSink(t, map[string]interface{}{
+		"address": carbon.listener.Addr().String(),
+		"format":  "tagged",
+	})
+	_, err := sink.Flush(context.Background(), testMetrics)
+	require.NoError(t, err)
+
+	expected := "api.requests;env=prod;host=host-a;path=/a_b;region=us-west-2 3 100\n" +
+		"temperature__C_;host=sensor.1;region=us-west-2 21.5 100\n"
+	assert.Equal(t, expected, carbon.waitFor(t, len(expected)))
+}
+
+func TestFlushPickle(t *testing.T) {
+	carbon := newFakeCarbon(t)
+	defer carbon.Close()
+
+	sink := createSink(t, map[string]interface{}{
+		"address":    carbon.listener.Addr().String(),
+		"batch_size": 1,
+		"protocol":   "pickle",
+	})
+	_, err := sink.Flush(context.Background(), testMetrics)
+	require.NoError(t, err)
+
+	expected := encodePickle([]datapoint{{
+		path: "api.requests", timestamp: 100, value: 3,
+	}, {
+		path: "temperature__C_", timestamp: 100, value: 21.5,
+	}}, 1)
+	data := carbon.waitFor(t, len(expected))
+	assert.Equal(t, string(expected), data)
+
+	// Each datapoint is in its own message.
+	reader := bytes.NewReader([]byte(data))
+	var length uint32
+	require.NoError(t, binary.Read(reader, binary.BigEndian, &length))
+	message := make([]byte, length)
+	_, err = io.ReadFull(reader, message)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x80, 0x02, ']', '(',
+		'X', 12, 0, 0, 0,
+		'a', 'p', 'i', '.', 'r', 'e', 'q', 'u', 'e', 's', 't', 's',
+		'G', 0x40, 0x59, 0, 0, 0, 0, 0, 0,
+		'G', 0x40, 0x08, 0, 0, 0, 0, 0, 0,
+		0x86, 0x86, 'e', '.',
+	}, message)
+}
+
+func TestFlushReconnect(t *testing.T) {
+	carbon := newFakeCarbon(t)
+	defer carbon.Close()
+
+	sink := createSink(t, map[string]interface{}{
+		"address": carbon.listener.Addr().String(),
+	})
+	metrics := []samplers.InterMetric{{
+		Name: "a", Timestamp: 100, Value: 1, Type: samplers.GaugeMetric,
+	}}
+	_, err := sink.Flush(context.Background(), metrics)
+	require.NoError(t, err)
+	carbon.waitFor(t, len("a 1 100\n"))
+
+	// Carbon closing the connection makes the next writes fail, after which
+	// the sink reconnects.
+	carbon.mutex.Lock()
+	carbon.conns[0].Close()
+	carbon.mutex.Unlock()
+	require.Eventually(t, func() bool {
+		_, err := sink.Flush(context.Background(), metrics)
+		require.NoError(t, err)
+		carbon.mutex.Lock()
+		defer carbon.mutex.Unlock()
+		return len(carbon.conns) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestFlushBackoff(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	sink := createSink(t, map[string]interface{}{
+		"address":               address,
+		"max_reconnect_backoff": "1h",
+		"reconnect_backoff":     "1h",
+	})
+	metrics := []samplers.InterMetric{{
+		Name: "a", Timestamp: 100, Value: 1, Type: samplers.GaugeMetric,
+	}}
+	result, err := sink.Flush(context.Background(), metrics)
+	assert.Error(t, err)
+	assert.Equal(t, sinks.MetricFlushResult{MetricsDropped: 1}, result)
+
+	// Once Carbon is up, the sink waits for the backoff before connecting.
+	listener, err = net.Listen("tcp", address)
+	require.NoError(t, err)
+	defer listener.Close()
+	_, err = sink.Flush(context.Background(), metrics)
+	assert.Error(t, err)
+
+	sink.nextDial = time.Time{}
+	_, err = sink.Flush(context.Background(), metrics)
+	assert.NoError(t, err)
+	assert.Equal(t, sink.config.ReconnectBackoff, sink.backoff)
+}
@@ -0,0 +1,555 @@
+package influxdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/v14"
+	"github.com/stripe/veneur/v14/protocol/dogstatsd"
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/util"
+	"github.com/stripe/veneur/v14/util/build"
+	"github.com/stripe/veneur/v14/util/retry"
+)
+
+const (
+	ApiV1 = "v1"
+	ApiV2 = "v2"
+
+	PrecisionNanoseconds = "ns"
+	PrecisionSeconds     = "s"
+
+	// The number of lines written in each request, if batch_size isn't set.
+	defaultBatchSize = 5000
+	// The measurement events are written to, if event_measurement isn't set.
+	defaultEventMeasurement = "events"
+	// The measurement status checks are written to, if
+	// status_check_measurement isn't set.
+	defaultStatusCheckMeasurement = "status_checks"
+	// How long to wait for each request, if timeout isn't set.
+	defaultTimeout = 10 * time.Second
+
+	// The tag that holds the type of each metric.
+	metricTypeTag = "metric_type"
+)
+
+type InfluxDBMetricSinkConfig struct {
+	retry.Config `yaml:",squash"`
+	// "v2" (the default) writes to /api/v2/write, and "v1" writes to /write.
+	Api string `yaml:"api"`
+	// The maximum number of lines written in each request. Defaults to 5000.
+	BatchSize int `yaml:"batch_size"`
+	// The bucket to write to. With the v1 API, the database.
+	Bucket string `yaml:"bucket"`
+	// The measurement events are written to. Defaults to "events".
+	EventMeasurement string `yaml:"event_measurement"`
+	// Whether to compress requests with gzip.
+	Gzip bool `yaml:"gzip"`
+	// The organization that owns the bucket. Only used with the v2 API.
+	Org string `yaml:"org"`
+	// The username and password to authenticate with instead of a token.
+	// Only used with the v1 API.
+	Password util.StringSecret `yaml:"password"`
+	// The precision of timestamps: "s" (the default) or "ns".
+	Precision string `yaml:"precision"`
+	// The retention policy to write to. Only used with the v1 API.
+	RetentionPolicy string `yaml:"retention_policy"`
+	// The measurement status checks are written to. Defaults to
+	// "status_checks".
+	StatusCheckMeasurement string `yaml:"status_check_measurement"`
+	// How long to wait for each request. Defaults to 10s.
+	Timeout time.Duration `yaml:"timeout"`
+	// The API token to authenticate with.
+	Token util.StringSecret `yaml:"token"`
+	// The URL of the InfluxDB server, e.g. "http://localhost:8086".
+	URL      string            `yaml:"url"`
+	Username util.StringSecret `yaml:"username"`
+}
+
+// InfluxDBMetricSink writes metrics, status checks and events to InfluxDB in
+// the line protocol.
+type InfluxDBMetricSink struct {
+	client      *http.Client
+	config      InfluxDBMetricSinkConfig
+	hostname    string
+	logger      *logrus.Entry
+	name        string
+	tags        map[string]string
+	traceClient *trace.Client
+	// The URL that lines are posted to, including the query parameters.
+	writeUrl string
+}
+
+var _ sinks.MetricSink = (*InfluxDBMetricSink)(nil)
+
+// ParseConfig decodes and validates the config of an InfluxDB sink.
+func ParseConfig(
+	name string, config interface{},
+) (veneur.MetricSinkConfig, error) {
+	influxConfig := InfluxDBMetricSinkConfig{}
+	err := util.DecodeConfig(name, config, &influxConfig)
+	if err != nil {
+		return nil, err
+	}
+	if influxConfig.URL == "" {
+		return nil, errors.New("influxdb sink needs a url")
+	}
+	if influxConfig.Bucket == "" {
+		return nil, errors.New("influxdb sink needs a bucket")
+	}
+	switch influxConfig.Api {
+	case "":
+		influxConfig.Api = ApiV2
+	case ApiV1, ApiV2:
+	default:
+		return nil, fmt.Errorf("unknown influxdb api %q", influxConfig.Api)
+	}
+	if influxConfig.Api == ApiV2 && influxConfig.Org == "" {
+		return nil, errors.New("influxdb sink needs an org with the v2 api")
+	}
+	switch influxConfig.Precision {
+	case "":
+		influxConfig.Precision = PrecisionSeconds
+	case PrecisionNanoseconds, PrecisionSeconds:
+	default:
+		return nil, fmt.Errorf(
+			"unknown influxdb precision %q", influxConfig.Precision)
+	}
+	if influxConfig.BatchSize <= 0 {
+		influxConfig.BatchSize = defaultBatchSize
+	}
+	if influxConfig.EventMeasurement == "" {
+		influxConfig.EventMeasurement = defaultEventMeasurement
+	}
+	if influxConfig.StatusCheckMeasurement == "" {
+		influxConfig.StatusCheckMeasurement = defaultStatusCheckMeasurement
+	}
+	influxConfig.Config.SetDefaults()
+	if influxConfig.Timeout <= 0 {
+		influxConfig.Timeout = defaultTimeout
+	}
+	return influxConfig, nil
+}
+
+// Create creates a new InfluxDB sink.
+func Create(
+	server *veneur.Server, name string, logger *logrus.Entry,
+	config veneur.Config, sinkConfig veneur.MetricSinkConfig,
+) (sinks.MetricSink, error) {
+	influxConfig, ok := sinkConfig.(InfluxDBMetricSinkConfig)
+	if !ok {
+		return nil, errors.New("invalid sink config type")
+	}
+	writeUrl, err := buildWriteUrl(influxConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &InfluxDBMetricSink{
+		client:   &http.Client{Timeout: influxConfig.Timeout},
+		config:   influxConfig,
+		hostname: config.Hostname,
+		logger: logger.WithFields(logrus.Fields{
+			"sink_name": name,
+			"sink_kind": "influxdb",
+		}),
+		name:        name,
+		tags:        server.TagsAsMap,
+		traceClient: server.TraceClient,
+		writeUrl:    writeUrl,
+	}, nil
+}
+
+// Returns the URL of the write endpoint of the configured API.
+func buildWriteUrl(config InfluxDBMetricSinkConfig) (string, error) {
+	writeUrl, err := url.Parse(config.URL)
+	if err != nil {
+		return "", err
+	}
+	if writeUrl.Scheme != "http" && writeUrl.Scheme != "https" {
+		return "", fmt.Errorf(
+			"influxdb url must be an http or https URL, got %q", config.URL)
+	}
+	query := url.Values{}
+	if config.Api == ApiV2 {
+		writeUrl.Path = strings.TrimSuffix(writeUrl.Path, "/") + "/api/v2/write"
+		query.Set("org", config.Org)
+		query.Set("bucket", config.Bucket)
+		query.Set("precision", config.Precision)
+	} else {
+		writeUrl.Path = strings.TrimSuffix(writeUrl.Path, "/") + "/write"
+		query.Set("db", config.Bucket)
+		if config.RetentionPolicy != "" {
+			query.Set("rp", config.RetentionPolicy)
+		}
+		// The v1 API calls nanosecond precision "n".
+		precision := config.Precision
+		if precision == PrecisionNanoseconds {
+			precision = "n"
+		}
+		query.Set("precision", precision)
+	}
+	writeUrl.RawQuery = query.Encode()
+	return writeUrl.String(), nil
+}
+
+// Name returns the sink name.
+func (s *InfluxDBMetricSink) Name() string {
+	return s.name
+}
+
+// Kind returns the sink kind.
+func (s *InfluxDBMetricSink) Kind() string {
+	return "influxdb"
+}
+
+// Start sets the trace client.
+func (s *InfluxDBMetricSink) Start(traceClient *trace.Client) error {
+	s.traceClient = traceClient
+	return nil
+}
+
+// Flush writes metrics in batches of at most batch_size lines. Status checks
+// are written to the status check measurement. Metrics whose value can't be
+// written, such as NaN, are skipped.
+func (s *InfluxDBMetricSink) Flush(
+	ctx context.Context, metrics []samplers.InterMetric,
+) (sinks.MetricFlushResult, error) {
+	span, _ := trace.StartSpanFromContext(ctx, "")
+	defer span.ClientFinish(s.traceClient)
+
+	result := sinks.MetricFlushResult{}
+	metricKeyTags := map[string]string{"sink_name": s.Name(), "sink_type": s.Kind()}
+	defer func() {
+		span.Add(ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(result.MetricsFlushed), metricKeyTags))
+		span.Add(ssf.Count(sinks.MetricKeyTotalMetricsDropped, float32(result.MetricsDropped), metricKeyTags))
+		span.Add(ssf.Count(sinks.MetricKeyTotalMetricsSkipped, float32(result.MetricsSkipped), metricKeyTags))
+	}()
+
+	lines := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		line, ok := s.metricLine(metric)
+		if !ok {
+			result.MetricsSkipped += 1
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	written, err := s.writeLines(ctx, lines)
+	result.MetricsFlushed = written
+	result.MetricsDropped = len(lines) - written
+	return result, err
+}
+
+// Converts a metric to a line, or returns false if its value can't be
+// written.
+func (s *InfluxDBMetricSink) metricLine(
+	metric samplers.InterMetric,
+) (string, bool) {
+	if math.IsNaN(metric.Value) || math.IsInf(metric.Value, 0) {
+		return "", false
+	}
+	tags := s.lineTags(metric.Tags, metric.HostName)
+	if metric.Type == samplers.StatusMetric {
+		tags["check"] = metric.Name
+		return formatLine(
+			s.config.StatusCheckMeasurement, tags, []field{
+				{"message", stringFieldValue(metric.Message)},
+				{"status", strconv.Itoa(int(metric.Value)) + "i"},
+			}, s.timestamp(metric.Timestamp)), true
+	}
+
+	metricType := "gauge"
+	if metric.Type == samplers.CounterMetric {
+		metricType = "counter"
+	}
+	tags[metricTypeTag] = metricType
+	return formatLine(metric.Name, tags, []field{
+		{"value", strconv.FormatFloat(metric.Value, 'g', -1, 64)},
+	}, s.timestamp(metric.Timestamp)), true
+}
+
+// Merges "key:value" tags with the common tags and the host tag. Tags
+// without a value are dropped, since InfluxDB doesn't accept empty tag
+// values, and common tags take precedence over tags of the same key.
+func (s *InfluxDBMetricSink) lineTags(
+	tags []string, hostname string,
+) map[string]string {
+	lineTags := make(map[string]string, len(tags)+len(s.tags)+2)
+	for _, tag := range tags {
+		kv := strings.SplitN(tag, ":", 2)
+		if len(kv) < 2 || kv[1] == "" {
+			continue
+		}
+		lineTags[kv[0]] = kv[1]
+	}
+	for key, value := range s.tags {
+		lineTags[key] = value
+	}
+	if hostname == "" {
+		hostname = s.hostname
+	}
+	if hostname != "" {
+		lineTags["host"] = hostname
+	}
+	return lineTags
+}
+
+// Converts a Unix timestamp in seconds to the configured precision.
+func (s *InfluxDBMetricSink) timestamp(seconds int64) int64 {
+	if s.config.Precision == PrecisionNanoseconds {
+		return seconds * int64(time.Second)
+	}
+	return seconds
+}
+
+// FlushOtherSamples writes events to the event measurement and status checks
+// to the status check measurement.
+func (s *InfluxDBMetricSink) FlushOtherSamples(
+	ctx context.Context, samples []ssf.SSFSample,
+) {
+	span, _ := trace.StartSpanFromContext(ctx, "")
+	defer span.ClientFinish(s.traceClient)
+
+	lines := []string{}
+	events := 0
+	for _, sample := range samples {
+		if _, ok := sample.Tags[dogstatsd.EventIdentifierKey]; ok {
+			lines = append(lines, s.eventLine(sample))
+			events += 1
+		} else if sample.Metric == ssf.SSFSample_STATUS {
+			lines = append(lines, s.statusCheckLine(sample))
+		} else {
+			s.logger.Warn("Received an SSF Sample that wasn't an event or service check, ack!")
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	written, err := s.writeLines(ctx, lines)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to write events and status checks")
+	}
+	if events == 0 {
+		return
+	}
+	if written == len(lines) {
+		span.Add(ssf.Count(sinks.EventReportedCount, float32(events),
+			map[string]string{"sink": s.Name(), "results": "success"}))
+	} else {
+		span.Add(ssf.Count(sinks.EventReportedCount, float32(events),
+			map[string]string{"sink": s.Name(), "results": "failure"}))
+	}
+}
+
+// Converts an event to a line. The title and text of the event are fields,
+// and its other attributes are tags.
+func (s *InfluxDBMetricSink) eventLine(sample ssf.SSFSample) string {
+	tags := map[string]string{}
+	hostname := ""
+	for key, value := range sample.Tags {
+		switch key {
+		case dogstatsd.EventIdentifierKey:
+		case dogstatsd.EventAggregationKeyTagKey:
+			tags["aggregation_key"] = value
+		case dogstatsd.EventAlertTypeTagKey:
+			tags["alert_type"] = value
+		case dogstatsd.EventHostnameTagKey:
+			hostname = value
+		case dogstatsd.EventPriorityTagKey:
+			tags["priority"] = value
+		case dogstatsd.EventSourceTypeTagKey:
+			tags["source_type"] = value
+		default:
+			tags[key] = value
+		}
+	}
+	lineTags := s.lineTags(nil, hostname)
+	for key, value := range tags {
+		if _, ok := lineTags[key]; !ok && value != "" {
+			lineTags[key] = value
+		}
+	}
+	return formatLine(s.config.EventMeasurement, lineTags, []field{
+		{"text", stringFieldValue(sample.Message)},
+		{"title", stringFieldValue(sample.Name)},
+	}, s.timestamp(sample.Timestamp))
+}
+
+// Converts a status check to a line.
+func (s *InfluxDBMetricSink) statusCheckLine(sample ssf.SSFSample) string {
+	lineTags := s.lineTags(nil, "")
+	for key, value := range sample.Tags {
+		if _, ok := lineTags[key]; !ok && value != "" {
+			lineTags[key] = value
+		}
+	}
+	lineTags["check"] = sample.Name
+	return formatLine(s.config.StatusCheckMeasurement, lineTags, []field{
+		{"message", stringFieldValue(sample.Message)},
+		{"status", strconv.Itoa(int(sample.Status)) + "i"},
+	}, s.timestamp(sample.Timestamp))
+}
+
+type field struct {
+	key string
+	// The formatted value, e.g. `1.5`, `2i` or `"text"`.
+	value string
+}
+
+var (
+	measurementReplacer = strings.NewReplacer(
+		",", `\,`, " ", `\ `, "\n", `\ `)
+	tagReplacer = strings.NewReplacer(
+		",", `\,`, "=", `\=`, " ", `\ `, "\n", `\ `)
+	stringFieldReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+func stringFieldValue(value string) string {
+	return `"` + stringFieldReplacer.Replace(value) + `"`
+}
+
+// Formats a line of the line protocol, with its tags sorted by key.
+func formatLine(
+	measurement string, tags map[string]string, fields []field,
+	timestamp int64,
+) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	builder.WriteString(measurementReplacer.Replace(measurement))
+	for _, key := range keys {
+		builder.WriteByte(',')
+		builder.WriteString(tagReplacer.Replace(key))
+		builder.WriteByte('=')
+		builder.WriteString(tagReplacer.Replace(tags[key]))
+	}
+	for index, field := range fields {
+		if index == 0 {
+			builder.WriteByte(' ')
+		} else {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(tagReplacer.Replace(field.key))
+		builder.WriteByte('=')
+		builder.WriteString(field.value)
+	}
+	if timestamp != 0 {
+		builder.WriteByte(' ')
+		builder.WriteString(strconv.FormatInt(timestamp, 10))
+	}
+	return builder.String()
+}
+
+// Writes lines in batches of at most batch_size lines, and returns the number
+// of lines written. Every batch is attempted, and the last error is returned.
+func (s *InfluxDBMetricSink) writeLines(
+	ctx context.Context, lines []string,
+) (int, error) {
+	written := 0
+	var lastErr error
+	for start := 0; start < len(lines); start += s.config.BatchSize {
+		end := start + s.config.BatchSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+		err := s.writeBatch(ctx, lines[start:end])
+		if err != nil {
+			s.logger.WithError(err).
+				WithField("lines", end-start).
+				Error("failed to write to influxdb")
+			lastErr = err
+			continue
+		}
+		written += end - start
+	}
+	return written, lastErr
+}
+
+// Writes a batch of lines, retrying with exponential backoff while it fails
+// with a retryable error and the context isn't done.
+func (s *InfluxDBMetricSink) writeBatch(
+	ctx context.Context, lines []string,
+) error {
+	var body bytes.Buffer
+	if s.config.Gzip {
+		writer := gzip.NewWriter(&body)
+		for _, line := range lines {
+			writer.Write([]byte(line))
+			writer.Write([]byte{'\n'})
+		}
+		err := writer.Close()
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, line := range lines {
+			body.WriteString(line)
+			body.WriteByte('\n')
+		}
+	}
+
+	return retry.Do(ctx, s.config.Config, retry.Retryable,
+		func() (time.Duration, error) {
+			return s.post(ctx, body.Bytes())
+		})
+}
+
+// Posts a request body to the write endpoint. If the response is an error
+// that asks the client to wait before retrying, returns how long to wait.
+func (s *InfluxDBMetricSink) post(
+	ctx context.Context, body []byte,
+) (time.Duration, error) {
+	request, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, s.writeUrl, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	request.Header.Set("User-Agent", "veneur/"+build.VERSION)
+	if s.config.Gzip {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+	if s.config.Token.Value != "" {
+		request.Header.Set("Authorization", "Token "+s.config.Token.Value)
+	} else if s.config.Username.Value != "" {
+		request.SetBasicAuth(
+			s.config.Username.Value, s.config.Password.Value)
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	responseBody, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return 0, nil
+	}
+
+	return retry.After(response.Header), retry.StatusError{
+		Endpoint:   "influxdb",
+		StatusCode: response.StatusCode,
+		Body:       string(responseBody),
+	}
+}
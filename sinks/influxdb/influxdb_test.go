@@ -0,0 +1,203 @@
+package influxdb
+
+import (
+	"context"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14"
+	"github.com/stripe/veneur/v14/protocol/dogstatsd"
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/testhelpers"
+	"github.com/stripe/veneur/v14/util/build"
+)
+
+func createSink(
+	t *testing.T, config map[string]interface{},
+) *InfluxDBMetricSink {
+	sinkConfig, err := ParseConfig("influxdb", config)
+	require.NoError(t, err)
+	sink, err := Create(
+		&veneur.Server{
+			TagsAsMap: map[string]string{"region": "us-west-2"},
+		},
+		"influxdb", logrus.NewEntry(logrus.New()),
+		veneur.Config{Hostname: "host-a"}, sinkConfig)
+	require.NoError(t, err)
+	return sink.(*InfluxDBMetricSink)
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	for name, config := range map[string]map[string]interface{}{
+		"no url": {
+			"bucket": "metrics",
+			"org":    "org",
+		},
+		"no bucket": {
+			"org": "org",
+			"url": "http://localhost:8086",
+		},
+		"no org with v2": {
+			"bucket": "metrics",
+			"url":    "http://localhost:8086",
+		},
+		"unknown precision": {
+			"api":       "v1",
+			"bucket":    "metrics",
+			"precision": "ms",
+			"url":       "http://localhost:8086",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseConfig("influxdb", config)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestFlushV2(t *testing.T) {
+	influx := &testhelpers.Endpoint{}
+	server := httptest.NewServer(influx)
+	defer server.Close()
+	defer influx.AssertNoErrors(t)
+
+	sink := createSink(t, map[string]interface{}{
+		"batch_size": 2,
+		"bucket":     "metrics",
+		"gzip":       true,
+		"org":        "iot",
+		"token":      "secret",
+		"url":        server.URL,
+	})
+	result, err := sink.Flush(context.Background(), []samplers.InterMetric{{
+		Name:      "requests",
+		Timestamp: 100,
+		Value:     3,
+		Tags:      []string{"path:/a b,c", "cached"},
+		Type:      samplers.CounterMetric,
+	}, {
+		Name:      "temperature",
+		Timestamp: 100,
+		Value:     21.5,
+		Tags:      []string{"region:ignored"},
+		HostName:  "sensor-1",
+		Type:      samplers.GaugeMetric,
+	}, {
+		Name:      "disk.check",
+		Timestamp: 100,
+		Value:     float64(ssf.SSFSample_CRITICAL),
+		Message:   `disk "/" is full`,
+		Type:      samplers.StatusMetric,
+	}, {
+		Name:  "broken",
+		Value: math.NaN(),
+		Type:  samplers.GaugeMetric,
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, sinks.MetricFlushResult{
+		MetricsFlushed: 3,
+		MetricsSkipped: 1,
+	}, result)
+
+	require.Len(t, influx.Requests(), 2)
+	request := influx.Requests()[0]
+	assert.Equal(t, "/api/v2/write", request.URL.Path)
+	assert.Equal(t, "iot", request.URL.Query().Get("org"))
+	assert.Equal(t, "metrics", request.URL.Query().Get("bucket"))
+	assert.Equal(t, "s", request.URL.Query().Get("precision"))
+	assert.Equal(t, "Token secret", request.Header.Get("Authorization"))
+	assert.Equal(t, "gzip", request.Header.Get("Content-Encoding"))
+	assert.Equal(t, "veneur/"+build.VERSION, request.Header.Get("User-Agent"))
+
+	assert.Equal(t, strings.Join([]string{
+		`requests,host=host-a,metric_type=counter,path=/a\ b\,c,region=us-west-2 value=3 100`,
+		`temperature,host=sensor-1,metric_type=gauge,region=us-west-2 value=21.5 100`,
+		``,
+	}, "\n"), string(influx.Bodies()[0]))
+	assert.Equal(t,
+		`status_checks,check=disk.check,host=host-a,region=us-west-2 message="disk \"/\" is full",status=2i 100`+"\n",
+		string(influx.Bodies()[1]))
+}
+
+func TestFlushV1(t *testing.T) {
+	influx := &testhelpers.Endpoint{}
+	server := httptest.NewServer(influx)
+	defer server.Close()
+	defer influx.AssertNoErrors(t)
+
+	sink := createSink(t, map[string]interface{}{
+		"api":              "v1",
+		"bucket":           "telegraf",
+		"password":         "hunter2",
+		"precision":        "ns",
+		"retention_policy": "autogen",
+		"username":         "veneur",
+		"url":              server.URL + "/influx/",
+	})
+	_, err := sink.Flush(context.Background(), []samplers.InterMetric{{
+		Name:      "requests",
+		Timestamp: 100,
+		Value:     1,
+		Type:      samplers.CounterMetric,
+	}})
+	require.NoError(t, err)
+
+	require.Len(t, influx.Requests(), 1)
+	request := influx.Requests()[0]
+	assert.Equal(t, "/influx/write", request.URL.Path)
+	assert.Equal(t, "telegraf", request.URL.Query().Get("db"))
+	assert.Equal(t, "autogen", request.URL.Query().Get("rp"))
+	assert.Equal(t, "n", request.URL.Query().Get("precision"))
+	username, password, ok := request.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "veneur", username)
+	assert.Equal(t, "hunter2", password)
+	assert.Equal(t,
+		"requests,host=host-a,metric_type=counter,region=us-west-2 value=1 100000000000\n",
+		string(influx.Bodies()[0]))
+}
+
+func TestFlushOtherSamples(t *testing.T) {
+	influx := &testhelpers.Endpoint{}
+	server := httptest.NewServer(influx)
+	defer server.Close()
+	defer influx.AssertNoErrors(t)
+
+	sink := createSink(t, map[string]interface{}{
+		"bucket":            "metrics",
+		"event_measurement": "deploys",
+		"org":               "iot",
+		"url":               server.URL,
+	})
+	sink.FlushOtherSamples(context.Background(), []ssf.SSFSample{{
+		Name:      "Deploy finished",
+		Message:   "Deployed version 2",
+		Timestamp: 100,
+		Tags: map[string]string{
+			dogstatsd.EventIdentifierKey:   "",
+			dogstatsd.EventAlertTypeTagKey: "success",
+			dogstatsd.EventHostnameTagKey:  "deployer",
+			"service":                      "api",
+		},
+	}, {
+		Metric:    ssf.SSFSample_STATUS,
+		Name:      "api.health",
+		Status:    ssf.SSFSample_WARNING,
+		Timestamp: 100,
+		Tags:      map[string]string{"service": "api"},
+	}})
+
+	require.Len(t, influx.Bodies(), 1)
+	assert.Equal(t, strings.Join([]string{
+		`deploys,alert_type=success,host=deployer,region=us-west-2,service=api text="Deployed version 2",title="Deploy finished" 100`,
+		`status_checks,check=api.health,host=host-a,region=us-west-2,service=api message="",status=1i 100`,
+		``,
+	}, "\n"), string(influx.Bodies()[0]))
+}
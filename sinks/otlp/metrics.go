@@ -0,0 +1,398 @@
+package otlp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/v14"
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/util"
+	"github.com/stripe/veneur/v14/util/build"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+)
+
+const (
+	TemporalityCumulative = "cumulative"
+	TemporalityDelta      = "delta"
+
+	// The number of data points sent in each request, if batch_size isn't
+	// set.
+	defaultBatchSize = 1000
+	// The number of flushes a cumulative counter series is kept for after it
+	// was last flushed, if stale_intervals isn't set.
+	defaultStaleIntervals = 5
+	// The name of the instrumentation library of exported metrics and spans.
+	instrumentationLibrary = "veneur"
+)
+
+type OtlpMetricSinkConfig struct {
+	ExporterConfig `yaml:",squash"`
+	// The maximum number of data points sent in each request. Defaults to
+	// 1000.
+	BatchSize int `yaml:"batch_size"`
+	// The number of flushes a cumulative counter series is kept for after it
+	// was last flushed. If it's flushed again after that, it starts over
+	// from zero with a new start time. Defaults to 5.
+	StaleIntervals int `yaml:"stale_intervals"`
+	// How counters are reported: "cumulative" (the default) adds up the
+	// values flushed for each series since it was first flushed, and "delta"
+	// reports the value of each flush.
+	Temporality string `yaml:"temporality"`
+}
+
+// OtlpMetricSink exports metrics to an OTLP endpoint. Counters are exported as
+// monotonic sums, and gauges and status checks as gauges.
+type OtlpMetricSink struct {
+	batchSize int
+	// The start time of each cumulative counter series, and its value so far.
+	counters map[counterKey]*cumulativeCounter
+	exporter *exporter
+	// The number of calls to Flush so far.
+	flushes        uint64
+	interval       time.Duration
+	logger         *logrus.Entry
+	name           string
+	resource       *resourcepb.Resource
+	staleIntervals uint64
+	temporality    metricspb.AggregationTemporality
+	traceClient    *trace.Client
+}
+
+type counterKey struct {
+	name string
+	tags string
+}
+
+type cumulativeCounter struct {
+	// The flush the series was last seen in.
+	lastFlush uint64
+	startTime uint64
+	value     float64
+}
+
+var _ sinks.MetricSink = (*OtlpMetricSink)(nil)
+
+func ParseMetricConfig(
+	name string, config interface{},
+) (veneur.MetricSinkConfig, error) {
+	otlpConfig := OtlpMetricSinkConfig{}
+	err := util.DecodeConfig(name, config, &otlpConfig)
+	if err != nil {
+		return nil, err
+	}
+	err = otlpConfig.ExporterConfig.validate()
+	if err != nil {
+		return nil, err
+	}
+	switch otlpConfig.Temporality {
+	case "":
+		otlpConfig.Temporality = TemporalityCumulative
+	case TemporalityCumulative, TemporalityDelta:
+	default:
+		return nil, fmt.Errorf(
+			"unknown otlp temporality %q", otlpConfig.Temporality)
+	}
+	if otlpConfig.BatchSize <= 0 {
+		otlpConfig.BatchSize = defaultBatchSize
+	}
+	if otlpConfig.StaleIntervals < 0 {
+		return nil, errors.New("otlp stale_intervals must be positive")
+	}
+	if otlpConfig.StaleIntervals == 0 {
+		otlpConfig.StaleIntervals = defaultStaleIntervals
+	}
+	return otlpConfig, nil
+}
+
+func CreateMetricSink(
+	server *veneur.Server, name string, logger *logrus.Entry,
+	config veneur.Config, sinkConfig veneur.MetricSinkConfig,
+) (sinks.MetricSink, error) {
+	otlpConfig, ok := sinkConfig.(OtlpMetricSinkConfig)
+	if !ok {
+		return nil, errors.New("invalid sink config type")
+	}
+	exporter, err := newExporter(otlpConfig.ExporterConfig, "/v1/metrics")
+	if err != nil {
+		return nil, err
+	}
+
+	temporality :=
+		metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	if otlpConfig.Temporality == TemporalityDelta {
+		temporality =
+			metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	}
+	return &OtlpMetricSink{
+		batchSize: otlpConfig.BatchSize,
+		counters:  map[counterKey]*cumulativeCounter{},
+		exporter:  exporter,
+		interval:  config.Interval,
+		logger: logger.WithFields(logrus.Fields{
+			"sink_name": name,
+			"sink_kind": "otlp",
+		}),
+		name: name,
+		resource: &resourcepb.Resource{
+			Attributes: keyValues(
+				resourceAttributes(server.TagsAsMap, config.Hostname)),
+		},
+		staleIntervals: uint64(otlpConfig.StaleIntervals),
+		temporality:    temporality,
+		traceClient:    server.TraceClient,
+	}, nil
+}
+
+// Returns the attributes of the resource that metrics and spans are exported
+// from: the common tags, and the host name.
+func resourceAttributes(
+	tags map[string]string, hostname string,
+) map[string]string {
+	attributes := make(map[string]string, len(tags)+2)
+	for key, value := range tags {
+		attributes[key] = value
+	}
+	if hostname != "" {
+		attributes["host.name"] = hostname
+	}
+	return attributes
+}
+
+// Converts a map to attributes, sorted by key.
+func keyValues(attributes map[string]string) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	keyValues := make([]*commonpb.KeyValue, len(keys))
+	for index, key := range keys {
+		keyValues[index] = stringKeyValue(key, attributes[key])
+	}
+	return keyValues
+}
+
+func stringKeyValue(key string, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key: key,
+		Value: &commonpb.AnyValue{
+			Value: &commonpb.AnyValue_StringValue{StringValue: value},
+		},
+	}
+}
+
+// Converts "key:value" tags to attributes. A tag without a value becomes an
+// attribute with an empty value.
+func tagAttributes(tags []string) []*commonpb.KeyValue {
+	attributes := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		kv := strings.SplitN(tag, ":", 2)
+		if len(kv) < 2 {
+			attributes[kv[0]] = ""
+			continue
+		}
+		attributes[kv[0]] = kv[1]
+	}
+	return keyValues(attributes)
+}
+
+func (s *OtlpMetricSink) Name() string {
+	return s.name
+}
+
+func (s *OtlpMetricSink) Kind() string {
+	return "otlp"
+}
+
+func (s *OtlpMetricSink) Start(traceClient *trace.Client) error {
+	s.traceClient = traceClient
+	return nil
+}
+
+// Flush exports metrics in batches of at most batch_size data points.
+// Cumulative counter series that weren't flushed in the last stale_intervals
+// flushes are forgotten.
+func (s *OtlpMetricSink) Flush(
+	ctx context.Context, interMetrics []samplers.InterMetric,
+) (sinks.MetricFlushResult, error) {
+	span, _ := trace.StartSpanFromContext(ctx, "")
+	defer span.ClientFinish(s.traceClient)
+
+	s.flushes += 1
+	defer s.expireCounters()
+
+	flushedMetrics := 0
+	droppedMetrics := 0
+	metricKeyTags := map[string]string{"sink_name": s.Name(), "sink_type": s.Kind()}
+	defer func() {
+		span.Add(ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(flushedMetrics), metricKeyTags))
+		span.Add(ssf.Count(sinks.MetricKeyTotalMetricsDropped, float32(droppedMetrics), metricKeyTags))
+	}()
+
+	var lastErr error
+	for start := 0; start < len(interMetrics); start += s.batchSize {
+		end := start + s.batchSize
+		if end > len(interMetrics) {
+			end = len(interMetrics)
+		}
+		batch := interMetrics[start:end]
+		request := s.exportRequest(batch)
+		err := s.exporter.export(ctx, request, func(
+			ctx context.Context, conn *grpc.ClientConn,
+			options ...grpc.CallOption,
+		) error {
+			_, err := collectormetrics.NewMetricsServiceClient(conn).
+				Export(ctx, request, options...)
+			return err
+		})
+		if err != nil {
+			s.logger.WithError(err).
+				WithField("metrics", len(batch)).
+				Error("failed to export metrics")
+			droppedMetrics += len(batch)
+			lastErr = err
+			continue
+		}
+		flushedMetrics += len(batch)
+	}
+	return sinks.MetricFlushResult{
+		MetricsFlushed: flushedMetrics,
+		MetricsDropped: droppedMetrics,
+	}, lastErr
+}
+
+// Converts metrics to a request, grouping the data points of metrics with
+// the same name and type.
+func (s *OtlpMetricSink) exportRequest(
+	interMetrics []samplers.InterMetric,
+) *collectormetrics.ExportMetricsServiceRequest {
+	metrics := []*metricspb.Metric{}
+	type metricKey struct {
+		name       string
+		metricType samplers.MetricType
+	}
+	metricIndex := map[metricKey]int{}
+
+	for _, interMetric := range interMetrics {
+		dataPoint := s.dataPoint(interMetric)
+		key := metricKey{interMetric.Name, interMetric.Type}
+		index, ok := metricIndex[key]
+		if !ok {
+			index = len(metrics)
+			metricIndex[key] = index
+			metrics = append(metrics, s.newMetric(interMetric))
+		}
+		switch data := metrics[index].Data.(type) {
+		case *metricspb.Metric_Sum:
+			data.Sum.DataPoints = append(data.Sum.DataPoints, dataPoint)
+		case *metricspb.Metric_Gauge:
+			data.Gauge.DataPoints = append(data.Gauge.DataPoints, dataPoint)
+		}
+	}
+
+	return &collectormetrics.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: s.resource,
+			InstrumentationLibraryMetrics: []*metricspb.InstrumentationLibraryMetrics{{
+				InstrumentationLibrary: &commonpb.InstrumentationLibrary{
+					Name:    instrumentationLibrary,
+					Version: build.VERSION,
+				},
+				Metrics: metrics,
+			}},
+		}},
+	}
+}
+
+// Creates an empty metric of the metric's name and type.
+func (s *OtlpMetricSink) newMetric(
+	interMetric samplers.InterMetric,
+) *metricspb.Metric {
+	metric := &metricspb.Metric{Name: interMetric.Name}
+	if interMetric.Type == samplers.CounterMetric {
+		metric.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: s.temporality,
+			IsMonotonic:            true,
+		}}
+	} else {
+		metric.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}}
+	}
+	return metric
+}
+
+// Converts a metric to a data point. For cumulative counters, the value is
+// added to the series' value so far.
+func (s *OtlpMetricSink) dataPoint(
+	interMetric samplers.InterMetric,
+) *metricspb.NumberDataPoint {
+	timestamp := uint64(time.Unix(interMetric.Timestamp, 0).UnixNano())
+	tags := interMetric.Tags
+	if interMetric.HostName != "" {
+		tags = append(tags[:len(tags):len(tags)], "host.name:"+interMetric.HostName)
+	}
+	dataPoint := &metricspb.NumberDataPoint{
+		Attributes:   tagAttributes(tags),
+		TimeUnixNano: timestamp,
+		Value: &metricspb.NumberDataPoint_AsDouble{
+			AsDouble: interMetric.Value,
+		},
+	}
+	if interMetric.Type != samplers.CounterMetric {
+		return dataPoint
+	}
+
+	if s.temporality ==
+		metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA {
+		dataPoint.StartTimeUnixNano = timestamp - uint64(s.interval)
+		return dataPoint
+	}
+	sortedTags := append([]string{}, tags...)
+	sort.Strings(sortedTags)
+	key := counterKey{
+		name: interMetric.Name,
+		tags: strings.Join(sortedTags, ","),
+	}
+	counter, ok := s.counters[key]
+	if !ok {
+		counter = &cumulativeCounter{
+			startTime: timestamp - uint64(s.interval),
+		}
+		s.counters[key] = counter
+	}
+	counter.lastFlush = s.flushes
+	counter.value += interMetric.Value
+	dataPoint.StartTimeUnixNano = counter.startTime
+	dataPoint.Value = &metricspb.NumberDataPoint_AsDouble{
+		AsDouble: counter.value,
+	}
+	return dataPoint
+}
+
+// Removes the cumulative counter series that weren't flushed in the last
+// staleIntervals flushes, so that series that stop being flushed don't grow
+// the sink without bound.
+func (s *OtlpMetricSink) expireCounters() {
+	for key, counter := range s.counters {
+		if s.flushes-counter.lastFlush >= s.staleIntervals {
+			delete(s.counters, key)
+		}
+	}
+}
+
+// FlushOtherSamples is a no-op; events and service checks aren't exported.
+func (s *OtlpMetricSink) FlushOtherSamples(
+	ctx context.Context, samples []ssf.SSFSample,
+) {
+}
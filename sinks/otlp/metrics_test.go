@@ -0,0 +1,332 @@
+package otlp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14"
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/testhelpers"
+	"github.com/stripe/veneur/v14/util"
+	"github.com/stripe/veneur/v14/util/retry"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+func parseConfig(
+	t *testing.T, config map[string]interface{},
+) OtlpMetricSinkConfig {
+	sinkConfig, err := ParseMetricConfig("otlp", config)
+	require.NoError(t, err)
+	return sinkConfig.(OtlpMetricSinkConfig)
+}
+
+func createMetricSink(
+	t *testing.T, config OtlpMetricSinkConfig,
+) *OtlpMetricSink {
+	sink, err := CreateMetricSink(
+		&veneur.Server{
+			TagsAsMap: map[string]string{"region": "us-west-2"},
+		},
+		"otlp", logrus.NewEntry(logrus.New()),
+		veneur.Config{Hostname: "host-a", Interval: 10 * time.Second},
+		config)
+	require.NoError(t, err)
+	return sink.(*OtlpMetricSink)
+}
+
+func TestParseMetricConfig(t *testing.T) {
+	config := parseConfig(t, map[string]interface{}{
+		"endpoint": "http://localhost:4318",
+		"headers": map[string]interface{}{
+			"Authorization": "Bearer token",
+		},
+		"protocol":    "http",
+		"temporality": "delta",
+	})
+	assert.Equal(t, OtlpMetricSinkConfig{
+		ExporterConfig: ExporterConfig{
+			Config: retry.Config{
+				MaxRetries:   3,
+				RetryBackoff: time.Second,
+			},
+			Compression: CompressionGzip,
+			Endpoint:    "http://localhost:4318",
+			Headers: map[string]util.StringSecret{
+				"Authorization": {Value: "Bearer token"},
+			},
+			Protocol: ProtocolHttp,
+			Timeout:  defaultTimeout,
+		},
+		BatchSize:      defaultBatchSize,
+		StaleIntervals: defaultStaleIntervals,
+		Temporality:    TemporalityDelta,
+	}, config)
+}
+
+func TestParseMetricConfigInvalid(t *testing.T) {
+	for name, config := range map[string]map[string]interface{}{
+		"no endpoint": {},
+		"unknown protocol": {
+			"endpoint": "localhost:4317",
+			"protocol": "thrift",
+		},
+		"http endpoint without scheme": {
+			"endpoint": "localhost:4318",
+			"protocol": "http",
+		},
+		"unknown temporality": {
+			"endpoint":    "localhost:4317",
+			"temporality": "sometimes",
+		},
+		"negative stale intervals": {
+			"endpoint":        "localhost:4317",
+			"stale_intervals": -1,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseMetricConfig("otlp", config)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestFlushHttp(t *testing.T) {
+	endpoint := &testhelpers.Endpoint{}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+	defer endpoint.AssertNoErrors(t)
+
+	sink := createMetricSink(t, parseConfig(t, map[string]interface{}{
+		"batch_size": 2,
+		"endpoint":   server.URL,
+		"headers": map[string]interface{}{
+			"Authorization": "Bearer token",
+		},
+		"protocol": "http",
+	}))
+	result, err := sink.Flush(context.Background(), []samplers.InterMetric{{
+		Name:      "requests",
+		Timestamp: 100,
+		Value:     2,
+		Tags:      []string{"status:200"},
+		Type:      samplers.CounterMetric,
+	}, {
+		Name:      "requests",
+		Timestamp: 100,
+		Value:     3,
+		Tags:      []string{"status:500"},
+		Type:      samplers.CounterMetric,
+	}, {
+		Name:      "temperature",
+		Timestamp: 100,
+		Value:     21.5,
+		Tags:      []string{"room:kitchen", "heated"},
+		Type:      samplers.GaugeMetric,
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, sinks.MetricFlushResult{MetricsFlushed: 3}, result)
+
+	require.Len(t, endpoint.Requests(), 2)
+	assert.Equal(t, "/v1/metrics", endpoint.Requests()[0].URL.Path)
+	assert.Equal(t, "gzip", endpoint.Requests()[0].Header.Get("Content-Encoding"))
+	assert.Equal(t, "Bearer token", endpoint.Requests()[0].Header.Get("Authorization"))
+
+	request := &collectormetrics.ExportMetricsServiceRequest{}
+	require.NoError(t, proto.Unmarshal(endpoint.Bodies()[0], request))
+	require.Len(t, request.ResourceMetrics, 1)
+	resource := request.ResourceMetrics[0].Resource
+	require.Len(t, resource.Attributes, 2)
+	assert.Equal(t, "host.name", resource.Attributes[0].Key)
+	assert.Equal(t, "host-a", resource.Attributes[0].Value.GetStringValue())
+	assert.Equal(t, "region", resource.Attributes[1].Key)
+
+	metrics := request.ResourceMetrics[0].InstrumentationLibraryMetrics[0].Metrics
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "requests", metrics[0].Name)
+	sum := metrics[0].GetSum()
+	require.NotNil(t, sum)
+	assert.True(t, sum.IsMonotonic)
+	assert.Equal(t,
+		metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+		sum.AggregationTemporality)
+	require.Len(t, sum.DataPoints, 2)
+	assert.Equal(t, 2.0, sum.DataPoints[0].GetAsDouble())
+	assert.Equal(t, uint64(100*time.Second), sum.DataPoints[0].TimeUnixNano)
+	assert.Equal(t, uint64(90*time.Second), sum.DataPoints[0].StartTimeUnixNano)
+	assert.Equal(t, "status", sum.DataPoints[0].Attributes[0].Key)
+	assert.Equal(t, "200", sum.DataPoints[0].Attributes[0].Value.GetStringValue())
+
+	request = &collectormetrics.ExportMetricsServiceRequest{}
+	require.NoError(t, proto.Unmarshal(endpoint.Bodies()[1], request))
+	metrics = request.ResourceMetrics[0].InstrumentationLibraryMetrics[0].Metrics
+	require.Len(t, metrics, 1)
+	gauge := metrics[0].GetGauge()
+	require.NotNil(t, gauge)
+	require.Len(t, gauge.DataPoints, 1)
+	assert.Equal(t, 21.5, gauge.DataPoints[0].GetAsDouble())
+	attributes := gauge.DataPoints[0].Attributes
+	require.Len(t, attributes, 2)
+	assert.Equal(t, "heated", attributes[0].Key)
+	assert.Equal(t, "", attributes[0].Value.GetStringValue())
+}
+
+func TestFlushHttpRetry(t *testing.T) {
+	endpoint := &testhelpers.Endpoint{
+		Failures:   2,
+		StatusCode: http.StatusServiceUnavailable,
+	}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+	defer endpoint.AssertNoErrors(t)
+
+	sink := createMetricSink(t, parseConfig(t, map[string]interface{}{
+		"endpoint":      server.URL,
+		"protocol":      "http",
+		"retry_backoff": "1ms",
+	}))
+	result, err := sink.Flush(context.Background(), []samplers.InterMetric{{
+		Name: "requests", Value: 1, Type: samplers.CounterMetric,
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, sinks.MetricFlushResult{MetricsFlushed: 1}, result)
+	assert.Len(t, endpoint.Requests(), 3)
+}
+
+func TestFlushHttpNotRetryable(t *testing.T) {
+	endpoint := &testhelpers.Endpoint{
+		Failures:   1,
+		StatusCode: http.StatusBadRequest,
+	}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+	defer endpoint.AssertNoErrors(t)
+
+	sink := createMetricSink(t, parseConfig(t, map[string]interface{}{
+		"endpoint":      server.URL,
+		"protocol":      "http",
+		"retry_backoff": "1ms",
+	}))
+	result, err := sink.Flush(context.Background(), []samplers.InterMetric{{
+		Name: "requests", Value: 1, Type: samplers.CounterMetric,
+	}})
+	assert.Error(t, err)
+	assert.Equal(t, sinks.MetricFlushResult{MetricsDropped: 1}, result)
+	assert.Len(t, endpoint.Requests(), 1)
+}
+
+// A fake OTLP/gRPC endpoint that records the requests it receives.
+type fakeMetricsService struct {
+	collectormetrics.UnimplementedMetricsServiceServer
+	mutex    sync.Mutex
+	metadata []metadata.MD
+	requests []*collectormetrics.ExportMetricsServiceRequest
+}
+
+func (service *fakeMetricsService) Export(
+	ctx context.Context, request *collectormetrics.ExportMetricsServiceRequest,
+) (*collectormetrics.ExportMetricsServiceResponse, error) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	service.metadata = append(service.metadata, md)
+	service.requests = append(service.requests, request)
+	return &collectormetrics.ExportMetricsServiceResponse{}, nil
+}
+
+func TestFlushGrpcCounterTemporality(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	service := &fakeMetricsService{}
+	server := grpc.NewServer()
+	collectormetrics.RegisterMetricsServiceServer(server, service)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	for _, temporality := range []string{TemporalityCumulative, TemporalityDelta} {
+		t.Run(temporality, func(t *testing.T) {
+			service.requests = nil
+			sink := createMetricSink(t, parseConfig(t, map[string]interface{}{
+				"endpoint": listener.Addr().String(),
+				"headers": map[string]interface{}{
+					"x-api-key": "secret",
+				},
+				"insecure":    true,
+				"temporality": temporality,
+			}))
+			for _, value := range []float64{2, 3} {
+				_, err := sink.Flush(context.Background(), []samplers.InterMetric{{
+					Name:      "requests",
+					Timestamp: 100,
+					Value:     value,
+					Type:      samplers.CounterMetric,
+				}})
+				require.NoError(t, err)
+			}
+
+			require.Len(t, service.requests, 2)
+			assert.Equal(t, []string{"secret"}, service.metadata[0].Get("x-api-key"))
+			sum := service.requests[1].ResourceMetrics[0].
+				InstrumentationLibraryMetrics[0].Metrics[0].GetSum()
+			require.NotNil(t, sum)
+			if temporality == TemporalityCumulative {
+				assert.Equal(t, 5.0, sum.DataPoints[0].GetAsDouble())
+			} else {
+				assert.Equal(t,
+					metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+					sum.AggregationTemporality)
+				assert.Equal(t, 3.0, sum.DataPoints[0].GetAsDouble())
+			}
+		})
+	}
+}
+
+func TestCumulativeCountersExpire(t *testing.T) {
+	sink := createMetricSink(t, parseConfig(t, map[string]interface{}{
+		"endpoint":        "localhost:4317",
+		"stale_intervals": 2,
+	}))
+	counter := func(name string, timestamp int64) samplers.InterMetric {
+		return samplers.InterMetric{
+			Name:      name,
+			Timestamp: timestamp,
+			Value:     1,
+			Type:      samplers.CounterMetric,
+		}
+	}
+
+	sink.flushes += 1
+	first := sink.dataPoint(counter("requests", 100))
+	sink.expireCounters()
+	sink.flushes += 1
+	sink.dataPoint(counter("errors", 110))
+	sink.expireCounters()
+	assert.Len(t, sink.counters, 2)
+
+	// requests wasn't flushed in the last two flushes
+	sink.flushes += 1
+	sink.dataPoint(counter("errors", 120))
+	sink.expireCounters()
+	assert.Len(t, sink.counters, 1)
+
+	// so it starts over, with a new start time
+	sink.flushes += 1
+	restarted := sink.dataPoint(counter("requests", 130))
+	assert.Equal(t, 1.0, restarted.GetAsDouble())
+	assert.Greater(t, restarted.StartTimeUnixNano, first.StartTimeUnixNano)
+	assert.Equal(t,
+		uint64(time.Unix(120, 0).UnixNano()), restarted.StartTimeUnixNano)
+}
@@ -0,0 +1,250 @@
+// Package otlp contains sinks that export metrics and spans with the
+// OpenTelemetry protocol (OTLP), over gRPC or HTTP, to an OpenTelemetry
+// collector or any backend that accepts OTLP.
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/stripe/veneur/v14/util"
+	"github.com/stripe/veneur/v14/util/build"
+	"github.com/stripe/veneur/v14/util/retry"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	grpcGzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	CompressionGzip = "gzip"
+	CompressionNone = "none"
+
+	ProtocolGrpc = "grpc"
+	ProtocolHttp = "http"
+
+	// How long to wait for each request, if timeout isn't set.
+	defaultTimeout = 10 * time.Second
+)
+
+// ExporterConfig configures how a sink connects to an OTLP endpoint, and is
+// shared by the metric and span sinks.
+type ExporterConfig struct {
+	retry.Config `yaml:",squash"`
+	// "gzip" (the default) or "none".
+	Compression string `yaml:"compression"`
+	// For gRPC, the host and port of the endpoint, e.g. "localhost:4317". For
+	// HTTP, the URL of the endpoint, e.g. "http://localhost:4318"; the path
+	// of the signal, e.g. /v1/metrics, is added if the URL has no path.
+	Endpoint string                       `yaml:"endpoint"`
+	Headers  map[string]util.StringSecret `yaml:"headers"`
+	// Whether to connect to a gRPC endpoint without TLS.
+	Insecure bool `yaml:"insecure"`
+	// "grpc" (the default) or "http".
+	Protocol string `yaml:"protocol"`
+	// How long to wait for each request. Defaults to 10s.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Validates the config and fills in defaults.
+func (config *ExporterConfig) validate() error {
+	if config.Endpoint == "" {
+		return errors.New("otlp sink needs an endpoint")
+	}
+	switch config.Protocol {
+	case "":
+		config.Protocol = ProtocolGrpc
+	case ProtocolGrpc, ProtocolHttp:
+	default:
+		return fmt.Errorf("unknown otlp protocol %q", config.Protocol)
+	}
+	switch config.Compression {
+	case "":
+		config.Compression = CompressionGzip
+	case CompressionGzip, CompressionNone:
+	default:
+		return fmt.Errorf("unknown otlp compression %q", config.Compression)
+	}
+	if config.Protocol == ProtocolHttp {
+		endpoint, err := url.Parse(config.Endpoint)
+		if err != nil {
+			return err
+		}
+		if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+			return fmt.Errorf(
+				"otlp http endpoint must be an http or https URL, got %q",
+				config.Endpoint)
+		}
+	}
+	config.Config.SetDefaults()
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	return nil
+}
+
+// exporter sends OTLP requests of one signal to an endpoint, retrying the
+// requests that fail with a retryable error.
+type exporter struct {
+	config     ExporterConfig
+	grpcConn   *grpc.ClientConn
+	httpClient *http.Client
+	// The URL requests are posted to over HTTP.
+	httpUrl string
+}
+
+// A function that sends a request over a gRPC connection.
+type grpcExportFunc func(
+	ctx context.Context, conn *grpc.ClientConn, options ...grpc.CallOption,
+) error
+
+// Creates an exporter for the signal whose OTLP/HTTP path is signalPath, e.g.
+// "/v1/metrics". For gRPC, the connection is established lazily.
+func newExporter(config ExporterConfig, signalPath string) (*exporter, error) {
+	e := &exporter{config: config}
+	switch config.Protocol {
+	case ProtocolGrpc:
+		transport := grpc.WithInsecure()
+		if !config.Insecure {
+			transport = grpc.WithTransportCredentials(
+				credentials.NewTLS(&tls.Config{}))
+		}
+		conn, err := grpc.Dial(config.Endpoint, transport)
+		if err != nil {
+			return nil, err
+		}
+		e.grpcConn = conn
+	case ProtocolHttp:
+		endpoint, err := url.Parse(config.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if endpoint.Path == "" || endpoint.Path == "/" {
+			endpoint.Path = signalPath
+		}
+		e.httpUrl = endpoint.String()
+		e.httpClient = &http.Client{}
+	}
+	return e, nil
+}
+
+// Sends a request, retrying with exponential backoff while it fails with a
+// retryable error and the context isn't done.
+func (e *exporter) export(
+	ctx context.Context, request proto.Message, grpcExport grpcExportFunc,
+) error {
+	return retry.Do(ctx, e.config.Config, retryable,
+		func() (time.Duration, error) {
+			if e.config.Protocol == ProtocolGrpc {
+				return 0, e.exportGrpc(ctx, grpcExport)
+			}
+			return e.exportHttp(ctx, request)
+		})
+}
+
+func (e *exporter) exportGrpc(
+	ctx context.Context, grpcExport grpcExportFunc,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, e.config.Timeout)
+	defer cancel()
+
+	for key, value := range e.config.Headers {
+		ctx = metadata.AppendToOutgoingContext(ctx, key, value.Value)
+	}
+	options := []grpc.CallOption{}
+	if e.config.Compression == CompressionGzip {
+		options = append(options, grpc.UseCompressor(grpcGzip.Name))
+	}
+	return grpcExport(ctx, e.grpcConn, options...)
+}
+
+// Posts a request over HTTP. If the response is an error that asks the
+// client to wait before retrying, returns how long to wait.
+func (e *exporter) exportHttp(
+	ctx context.Context, request proto.Message,
+) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.config.Timeout)
+	defer cancel()
+
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return 0, err
+	}
+	var body bytes.Buffer
+	if e.config.Compression == CompressionGzip {
+		writer := gzip.NewWriter(&body)
+		writer.Write(data)
+		err = writer.Close()
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		body.Write(data)
+	}
+
+	httpRequest, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, e.httpUrl, &body)
+	if err != nil {
+		return 0, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/x-protobuf")
+	httpRequest.Header.Set("User-Agent", "veneur/"+build.VERSION)
+	if e.config.Compression == CompressionGzip {
+		httpRequest.Header.Set("Content-Encoding", "gzip")
+	}
+	for key, value := range e.config.Headers {
+		httpRequest.Header.Set(key, value.Value)
+	}
+
+	response, err := e.httpClient.Do(httpRequest)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	responseBody, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return 0, nil
+	}
+
+	return retry.After(response.Header), retry.StatusError{
+		Endpoint:   "otlp endpoint",
+		StatusCode: response.StatusCode,
+		Body:       string(responseBody),
+	}
+}
+
+// Returns whether a failed request can be retried, following the OTLP
+// specification, which only retries a few of the statuses that
+// retry.Retryable does.
+func retryable(err error) bool {
+	var statusError retry.StatusError
+	if errors.As(err, &statusError) {
+		switch statusError.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	responseStatus, ok := status.FromError(err)
+	if !ok {
+		return retry.Retryable(err)
+	}
+	switch responseStatus.Code() {
+	case codes.Canceled, codes.DeadlineExceeded, codes.ResourceExhausted,
+		codes.Aborted, codes.OutOfRange, codes.Unavailable, codes.DataLoss:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,290 @@
+package otlp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/v14"
+	"github.com/stripe/veneur/v14/protocol"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/trace/metrics"
+	"github.com/stripe/veneur/v14/util"
+	"github.com/stripe/veneur/v14/util/build"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+)
+
+const (
+	// The number of spans buffered between flushes, if buffer_size isn't set.
+	defaultBufferSize = 16384
+	// The attribute set on spans that are indicator spans.
+	indicatorAttribute = "indicator"
+)
+
+type OtlpSpanSinkConfig struct {
+	ExporterConfig `yaml:",squash"`
+	// The maximum number of spans sent in each request. Defaults to 1000.
+	BatchSize int `yaml:"batch_size"`
+	// The maximum number of spans buffered between flushes. Spans ingested
+	// while the buffer is full are dropped. Defaults to 16384.
+	BufferSize int `yaml:"buffer_size"`
+}
+
+// OtlpSpanSink buffers spans as they are ingested, and exports them to an
+// OTLP endpoint when flushed.
+type OtlpSpanSink struct {
+	batchSize  int
+	bufferSize int
+	exporter   *exporter
+	logger     *logrus.Entry
+	name       string
+	// The attributes of the resource of every span, without the service.
+	resourceAttributes map[string]string
+	traceClient        *trace.Client
+
+	mutex sync.Mutex
+	// The spans ingested since the last flush, by service.
+	buffer       map[string][]*tracepb.Span
+	bufferLength int
+	// The number of spans dropped since the last flush.
+	dropped int64
+}
+
+var _ sinks.SpanSink = (*OtlpSpanSink)(nil)
+
+func ParseSpanConfig(
+	name string, config interface{},
+) (veneur.SpanSinkConfig, error) {
+	otlpConfig := OtlpSpanSinkConfig{}
+	err := util.DecodeConfig(name, config, &otlpConfig)
+	if err != nil {
+		return nil, err
+	}
+	err = otlpConfig.ExporterConfig.validate()
+	if err != nil {
+		return nil, err
+	}
+	if otlpConfig.BatchSize <= 0 {
+		otlpConfig.BatchSize = defaultBatchSize
+	}
+	if otlpConfig.BufferSize <= 0 {
+		otlpConfig.BufferSize = defaultBufferSize
+	}
+	return otlpConfig, nil
+}
+
+func CreateSpanSink(
+	server *veneur.Server, name string, logger *logrus.Entry,
+	config veneur.Config, sinkConfig veneur.SpanSinkConfig,
+) (sinks.SpanSink, error) {
+	otlpConfig, ok := sinkConfig.(OtlpSpanSinkConfig)
+	if !ok {
+		return nil, errors.New("invalid sink config type")
+	}
+	exporter, err := newExporter(otlpConfig.ExporterConfig, "/v1/traces")
+	if err != nil {
+		return nil, err
+	}
+
+	return &OtlpSpanSink{
+		batchSize:  otlpConfig.BatchSize,
+		bufferSize: otlpConfig.BufferSize,
+		exporter:   exporter,
+		logger: logger.WithFields(logrus.Fields{
+			"sink_name": name,
+			"sink_kind": "otlp",
+		}),
+		name: name,
+		resourceAttributes: resourceAttributes(
+			server.TagsAsMap, config.Hostname),
+		traceClient: server.TraceClient,
+		buffer:      map[string][]*tracepb.Span{},
+	}, nil
+}
+
+func (s *OtlpSpanSink) Name() string {
+	return s.name
+}
+
+func (s *OtlpSpanSink) Start(traceClient *trace.Client) error {
+	s.traceClient = traceClient
+	return nil
+}
+
+// Ingest converts a span and buffers it until the next flush. If the buffer
+// is full, the span is dropped.
+func (s *OtlpSpanSink) Ingest(ssfSpan *ssf.SSFSpan) error {
+	if err := protocol.ValidateTrace(ssfSpan); err != nil {
+		return err
+	}
+	span := convertSpan(ssfSpan)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.bufferLength >= s.bufferSize {
+		atomic.AddInt64(&s.dropped, 1)
+		return nil
+	}
+	s.buffer[ssfSpan.Service] = append(s.buffer[ssfSpan.Service], span)
+	s.bufferLength += 1
+	return nil
+}
+
+// Converts an SSF span to an OTLP span:
+//   - The 64-bit trace ID is the low half of the 128-bit trace ID, and the
+//     span and parent IDs are encoded as big-endian 64-bit IDs. A span with
+//     no parent is a root span.
+//   - Tags become string attributes, and indicator spans have an
+//     "indicator" attribute set to true.
+//   - Spans with an error have an error status, and the others have an unset
+//     status.
+//
+// The service of a span becomes the service.name attribute of its resource.
+func convertSpan(ssfSpan *ssf.SSFSpan) *tracepb.Span {
+	traceId := make([]byte, 16)
+	binary.BigEndian.PutUint64(traceId[8:], uint64(ssfSpan.TraceId))
+	span := &tracepb.Span{
+		TraceId:           traceId,
+		SpanId:            spanId(ssfSpan.Id),
+		Name:              ssfSpan.Name,
+		StartTimeUnixNano: uint64(ssfSpan.StartTimestamp),
+		EndTimeUnixNano:   uint64(ssfSpan.EndTimestamp),
+		Attributes:        keyValues(ssfSpan.Tags),
+		Status:            &tracepb.Status{},
+	}
+	if ssfSpan.ParentId != 0 {
+		span.ParentSpanId = spanId(ssfSpan.ParentId)
+	}
+	if ssfSpan.Indicator {
+		span.Attributes = append(span.Attributes, &commonpb.KeyValue{
+			Key: indicatorAttribute,
+			Value: &commonpb.AnyValue{
+				Value: &commonpb.AnyValue_BoolValue{BoolValue: true},
+			},
+		})
+	}
+	if ssfSpan.Error {
+		span.Status.Code = tracepb.Status_STATUS_CODE_ERROR
+		// Receivers of older versions of OTLP only read the deprecated code.
+		span.Status.DeprecatedCode =
+			tracepb.Status_DEPRECATED_STATUS_CODE_UNKNOWN_ERROR
+	}
+	return span
+}
+
+func spanId(id int64) []byte {
+	bytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(bytes, uint64(id))
+	return bytes
+}
+
+// Flush exports the buffered spans in batches of at most batch_size spans,
+// and reports how many spans were exported and dropped.
+func (s *OtlpSpanSink) Flush() {
+	s.mutex.Lock()
+	buffer := s.buffer
+	s.buffer = map[string][]*tracepb.Span{}
+	s.bufferLength = 0
+	s.mutex.Unlock()
+
+	services := make([]string, 0, len(buffer))
+	for service := range buffer {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	flushed := 0
+	request := &collectortrace.ExportTraceServiceRequest{}
+	requestLength := 0
+	send := func() {
+		if requestLength == 0 {
+			return
+		}
+		err := s.export(request)
+		if err != nil {
+			s.logger.WithError(err).
+				WithField("spans", requestLength).
+				Error("failed to export spans")
+			atomic.AddInt64(&s.dropped, int64(requestLength))
+		} else {
+			flushed += requestLength
+		}
+		request = &collectortrace.ExportTraceServiceRequest{}
+		requestLength = 0
+	}
+	for _, service := range services {
+		spans := buffer[service]
+		for len(spans) > 0 {
+			count := s.batchSize - requestLength
+			if count > len(spans) {
+				count = len(spans)
+			}
+			request.ResourceSpans = append(
+				request.ResourceSpans, s.resourceSpans(service, spans[:count]))
+			requestLength += count
+			spans = spans[count:]
+			if requestLength >= s.batchSize {
+				send()
+			}
+		}
+	}
+	send()
+
+	dropped := atomic.SwapInt64(&s.dropped, 0)
+	s.logger.WithFields(logrus.Fields{
+		"flushed_spans": flushed,
+		"dropped_spans": dropped,
+	}).Debug("Flushed spans")
+	metrics.ReportBatch(s.traceClient, []*ssf.SSFSample{
+		ssf.Count(sinks.MetricKeyTotalSpansFlushed, float32(flushed), map[string]string{"sink": s.Name()}),
+		ssf.Count(sinks.MetricKeyTotalSpansDropped, float32(dropped), map[string]string{"sink": s.Name()}),
+	})
+}
+
+// Groups the spans of a service under its resource.
+func (s *OtlpSpanSink) resourceSpans(
+	service string, spans []*tracepb.Span,
+) *tracepb.ResourceSpans {
+	attributes := make(map[string]string, len(s.resourceAttributes)+1)
+	for key, value := range s.resourceAttributes {
+		attributes[key] = value
+	}
+	if service != "" {
+		attributes["service.name"] = service
+	}
+	return &tracepb.ResourceSpans{
+		Resource: &resourcepb.Resource{
+			Attributes: keyValues(attributes),
+		},
+		InstrumentationLibrarySpans: []*tracepb.InstrumentationLibrarySpans{{
+			InstrumentationLibrary: &commonpb.InstrumentationLibrary{
+				Name:    instrumentationLibrary,
+				Version: build.VERSION,
+			},
+			Spans: spans,
+		}},
+	}
+}
+
+func (s *OtlpSpanSink) export(
+	request *collectortrace.ExportTraceServiceRequest,
+) error {
+	return s.exporter.export(context.Background(), request, func(
+		ctx context.Context, conn *grpc.ClientConn, options ...grpc.CallOption,
+	) error {
+		_, err := collectortrace.NewTraceServiceClient(conn).
+			Export(ctx, request, options...)
+		return err
+	})
+}
@@ -0,0 +1,170 @@
+package otlp
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/testhelpers"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func createSpanSink(
+	t *testing.T, config map[string]interface{},
+) *OtlpSpanSink {
+	sinkConfig, err := ParseSpanConfig("otlp", config)
+	require.NoError(t, err)
+	sink, err := CreateSpanSink(
+		&veneur.Server{
+			TagsAsMap: map[string]string{"region": "us-west-2"},
+		},
+		"otlp", logrus.NewEntry(logrus.New()),
+		veneur.Config{Hostname: "host-a"}, sinkConfig)
+	require.NoError(t, err)
+	return sink.(*OtlpSpanSink)
+}
+
+func testSpan(id int64, service string) *ssf.SSFSpan {
+	return &ssf.SSFSpan{
+		TraceId:        1,
+		Id:             id,
+		StartTimestamp: 1000,
+		EndTimestamp:   2000,
+		Service:        service,
+		Name:           "request",
+	}
+}
+
+func unmarshalTraceRequest(
+	t *testing.T, data []byte,
+) *collectortrace.ExportTraceServiceRequest {
+	request := &collectortrace.ExportTraceServiceRequest{}
+	require.NoError(t, proto.Unmarshal(data, request))
+	return request
+}
+
+func TestConvertSpan(t *testing.T) {
+	span := convertSpan(&ssf.SSFSpan{
+		TraceId:        0x0102030405060708,
+		Id:             0x1112131415161718,
+		ParentId:       0x2122232425262728,
+		StartTimestamp: 1000,
+		EndTimestamp:   2000,
+		Error:          true,
+		Service:        "api",
+		Tags:           map[string]string{"path": "/", "method": "GET"},
+		Indicator:      true,
+		Name:           "request",
+	})
+
+	assert.Equal(t, []byte{
+		0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8,
+	}, span.TraceId)
+	assert.Equal(t, []byte{
+		0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18,
+	}, span.SpanId)
+	assert.Equal(t, []byte{
+		0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27, 0x28,
+	}, span.ParentSpanId)
+	assert.Equal(t, "request", span.Name)
+	assert.Equal(t, uint64(1000), span.StartTimeUnixNano)
+	assert.Equal(t, uint64(2000), span.EndTimeUnixNano)
+	assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, span.Status.Code)
+
+	require.Len(t, span.Attributes, 3)
+	assert.Equal(t, "method", span.Attributes[0].Key)
+	assert.Equal(t, "GET", span.Attributes[0].Value.GetStringValue())
+	assert.Equal(t, "path", span.Attributes[1].Key)
+	assert.Equal(t, "indicator", span.Attributes[2].Key)
+	assert.True(t, span.Attributes[2].Value.GetBoolValue())
+
+	rootSpan := convertSpan(testSpan(1, "api"))
+	assert.Nil(t, rootSpan.ParentSpanId)
+	assert.Equal(t, tracepb.Status_STATUS_CODE_UNSET, rootSpan.Status.Code)
+}
+
+func TestIngestInvalidSpan(t *testing.T) {
+	sink := createSpanSink(t, map[string]interface{}{
+		"endpoint": "localhost:4317",
+	})
+	assert.Error(t, sink.Ingest(&ssf.SSFSpan{}))
+}
+
+func TestFlushSpans(t *testing.T) {
+	endpoint := &testhelpers.Endpoint{}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+	defer endpoint.AssertNoErrors(t)
+
+	sink := createSpanSink(t, map[string]interface{}{
+		"batch_size": 2,
+		"endpoint":   server.URL,
+		"protocol":   "http",
+	})
+	for index, service := range []string{"web", "api", "web"} {
+		require.NoError(t, sink.Ingest(testSpan(int64(index+1), service)))
+	}
+	sink.Flush()
+
+	require.Len(t, endpoint.Requests(), 2)
+	assert.Equal(t, "/v1/traces", endpoint.Requests()[0].URL.Path)
+
+	// Spans are grouped by service, and split into batches.
+	request := unmarshalTraceRequest(t, endpoint.Bodies()[0])
+	require.Len(t, request.ResourceSpans, 2)
+	attributes := request.ResourceSpans[0].Resource.Attributes
+	require.Len(t, attributes, 3)
+	assert.Equal(t, "host.name", attributes[0].Key)
+	assert.Equal(t, "region", attributes[1].Key)
+	assert.Equal(t, "service.name", attributes[2].Key)
+	assert.Equal(t, "api", attributes[2].Value.GetStringValue())
+	assert.Len(t,
+		request.ResourceSpans[0].InstrumentationLibrarySpans[0].Spans, 1)
+	assert.Equal(t, "web", request.ResourceSpans[1].Resource.Attributes[2].
+		Value.GetStringValue())
+	assert.Len(t,
+		request.ResourceSpans[1].InstrumentationLibrarySpans[0].Spans, 1)
+
+	request = unmarshalTraceRequest(t, endpoint.Bodies()[1])
+	require.Len(t, request.ResourceSpans, 1)
+	assert.Len(t,
+		request.ResourceSpans[0].InstrumentationLibrarySpans[0].Spans, 1)
+
+	// The buffer is empty after a flush.
+	sink.Flush()
+	assert.Len(t, endpoint.Requests(), 2)
+}
+
+func TestIngestFullBuffer(t *testing.T) {
+	endpoint := &testhelpers.Endpoint{}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+	defer endpoint.AssertNoErrors(t)
+
+	sink := createSpanSink(t, map[string]interface{}{
+		"buffer_size": 2,
+		"endpoint":    server.URL,
+		"protocol":    "http",
+	})
+	for index := 0; index < 3; index++ {
+		require.NoError(t, sink.Ingest(testSpan(int64(index+1), "api")))
+	}
+	assert.Equal(t, int64(1), sink.dropped)
+
+	sink.Flush()
+	assert.Equal(t, int64(0), sink.dropped)
+	require.Len(t, endpoint.Requests(), 1)
+	request := unmarshalTraceRequest(t, endpoint.Bodies()[0])
+	assert.Len(t,
+		request.ResourceSpans[0].InstrumentationLibrarySpans[0].Spans, 2)
+
+	// Ingesting resumes once the buffer is flushed.
+	require.NoError(t, sink.Ingest(testSpan(4, "api")))
+	assert.Equal(t, int64(0), sink.dropped)
+}
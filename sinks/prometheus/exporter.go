@@ -0,0 +1,422 @@
+package prometheus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/v14"
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/util"
+)
+
+const (
+	// The path metrics are served on, if path isn't set.
+	defaultExporterPath = "/metrics"
+	// The number of flushes a series is served for after it was last flushed,
+	// if stale_intervals isn't set.
+	defaultStaleIntervals = 5
+
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	textContentType        = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+type PrometheusExporterSinkConfig struct {
+	// The address the HTTP server listens on, e.g. ":9102".
+	ListenAddress string `yaml:"listen_address"`
+	// The path metrics are served on. Defaults to /metrics.
+	Path string `yaml:"path"`
+	// The number of flushes a series is served for after it was last flushed.
+	// Defaults to 5.
+	StaleIntervals int `yaml:"stale_intervals"`
+}
+
+// PrometheusExporterSink keeps the metrics from recent flushes and serves them
+// over HTTP, to be scraped by Prometheus. Unlike PrometheusMetricSink, it
+// doesn't need a statsd exporter.
+type PrometheusExporterSink struct {
+	name           string
+	listenAddress  string
+	path           string
+	staleIntervals uint64
+	// Common tags added to every series, which take precedence over the tags
+	// of a metric.
+	tags        map[string]string
+	logger      *logrus.Entry
+	traceClient *trace.Client
+
+	mutex sync.RWMutex
+	// The number of calls to Flush so far.
+	flushes  uint64
+	families map[string]*exportedFamily
+}
+
+// exportedFamily is the set of series that share a name and type.
+type exportedFamily struct {
+	name       string
+	metricType samplers.MetricType
+	series     map[string]*exportedSeries
+}
+
+type exportedSeries struct {
+	// The rendered labels, e.g. `{env="prod",host="a"}`.
+	labels string
+	value  float64
+	// The flush the series was last seen in.
+	lastFlush uint64
+}
+
+var _ sinks.MetricSink = (*PrometheusExporterSink)(nil)
+var _ http.Handler = (*PrometheusExporterSink)(nil)
+
+func ParseExporterConfig(
+	name string, config interface{},
+) (veneur.MetricSinkConfig, error) {
+	exporterConfig := PrometheusExporterSinkConfig{}
+	err := util.DecodeConfig(name, config, &exporterConfig)
+	if err != nil {
+		return nil, err
+	}
+	if exporterConfig.Path == "" {
+		exporterConfig.Path = defaultExporterPath
+	}
+	if exporterConfig.StaleIntervals == 0 {
+		exporterConfig.StaleIntervals = defaultStaleIntervals
+	}
+	return exporterConfig, nil
+}
+
+func CreateExporterSink(
+	server *veneur.Server, name string, logger *logrus.Entry,
+	config veneur.Config, sinkConfig veneur.MetricSinkConfig,
+) (sinks.MetricSink, error) {
+	exporterConfig, ok := sinkConfig.(PrometheusExporterSinkConfig)
+	if !ok {
+		return nil, errors.New("invalid sink config type")
+	}
+	if exporterConfig.ListenAddress == "" {
+		return nil, errors.New("prometheus exporter needs a listen_address")
+	}
+	if !strings.HasPrefix(exporterConfig.Path, "/") {
+		return nil, errors.New("prometheus exporter path must start with /")
+	}
+	if exporterConfig.StaleIntervals < 0 {
+		return nil, errors.New("prometheus exporter stale_intervals must be positive")
+	}
+
+	tags := make(map[string]string, len(server.TagsAsMap)+1)
+	for key, value := range server.TagsAsMap {
+		tags[key] = value
+	}
+	if config.Hostname != "" {
+		tags["host"] = config.Hostname
+	}
+
+	return NewPrometheusExporterSink(
+		name, exporterConfig, tags, server.TraceClient, logger), nil
+}
+
+// NewPrometheusExporterSink creates a sink that serves metrics with the given
+// common tags. It doesn't listen for scrapes until it is started.
+func NewPrometheusExporterSink(
+	name string, config PrometheusExporterSinkConfig, tags map[string]string,
+	traceClient *trace.Client, logger *logrus.Entry,
+) *PrometheusExporterSink {
+	staleIntervals := config.StaleIntervals
+	if staleIntervals <= 0 {
+		staleIntervals = defaultStaleIntervals
+	}
+	return &PrometheusExporterSink{
+		name:           name,
+		listenAddress:  config.ListenAddress,
+		path:           config.Path,
+		staleIntervals: uint64(staleIntervals),
+		tags:           tags,
+		logger:         logger,
+		traceClient:    traceClient,
+		families:       map[string]*exportedFamily{},
+	}
+}
+
+func (sink *PrometheusExporterSink) Name() string {
+	return sink.name
+}
+
+func (sink *PrometheusExporterSink) Kind() string {
+	return "prometheus_exporter"
+}
+
+// Start listens on the configured address, and serves metrics on the
+// configured path.
+func (sink *PrometheusExporterSink) Start(traceClient *trace.Client) error {
+	sink.traceClient = traceClient
+
+	listener, err := net.Listen("tcp", sink.listenAddress)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(sink.path, sink)
+	go func() {
+		err := http.Serve(listener, mux)
+		if err != nil {
+			sink.logger.WithError(err).Error("prometheus exporter stopped")
+		}
+	}()
+	sink.logger.WithField("address", listener.Addr().String()).
+		Info("Serving prometheus metrics")
+	return nil
+}
+
+// Flush stores metrics to be served on the next scrapes. Counters are added to
+// the value of their series, so that they increase monotonically. Series that
+// weren't flushed in the last stale_intervals flushes are removed.
+func (sink *PrometheusExporterSink) Flush(
+	ctx context.Context, interMetrics []samplers.InterMetric,
+) (sinks.MetricFlushResult, error) {
+	span, _ := trace.StartSpanFromContext(ctx, "")
+	defer span.ClientFinish(sink.traceClient)
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	sink.flushes += 1
+	for _, metric := range interMetrics {
+		sink.store(metric)
+	}
+	expired := sink.expire()
+
+	metricKeyTags := map[string]string{
+		"sink_name": sink.Name(), "sink_type": sink.Kind(),
+	}
+	span.Add(ssf.Count(
+		sinks.MetricKeyTotalMetricsFlushed, float32(len(interMetrics)),
+		metricKeyTags))
+	if expired > 0 {
+		sink.logger.WithField("series", expired).Debug("Expired stale series")
+	}
+	return sinks.MetricFlushResult{MetricsFlushed: len(interMetrics)}, nil
+}
+
+// Stores a metric's value in its series. The mutex must be held.
+func (sink *PrometheusExporterSink) store(metric samplers.InterMetric) {
+	name := sanitiseMetricName(metric.Name)
+	if metric.Type == samplers.CounterMetric &&
+		!strings.HasSuffix(name, "_total") {
+		name += "_total"
+	}
+
+	family, ok := sink.families[name]
+	if !ok || family.metricType != metric.Type {
+		// A name can only have one type, so a metric of a different type
+		// replaces the series that were served under that name.
+		family = &exportedFamily{
+			name:       name,
+			metricType: metric.Type,
+			series:     map[string]*exportedSeries{},
+		}
+		sink.families[name] = family
+	}
+
+	labels := renderLabels(metric.Tags, sink.tags)
+	series, ok := family.series[labels]
+	if !ok {
+		series = &exportedSeries{labels: labels}
+		family.series[labels] = series
+	}
+	if metric.Type == samplers.CounterMetric {
+		series.value += metric.Value
+	} else {
+		series.value = metric.Value
+	}
+	series.lastFlush = sink.flushes
+}
+
+// Removes the series that weren't flushed in the last staleIntervals flushes,
+// and returns how many were removed. The mutex must be held.
+func (sink *PrometheusExporterSink) expire() int {
+	expired := 0
+	for name, family := range sink.families {
+		for labels, series := range family.series {
+			if sink.flushes-series.lastFlush >= sink.staleIntervals {
+				delete(family.series, labels)
+				expired += 1
+			}
+		}
+		if len(family.series) == 0 {
+			delete(sink.families, name)
+		}
+	}
+	return expired
+}
+
+// FlushOtherSamples is a no-op; events and service checks can't be scraped.
+func (sink *PrometheusExporterSink) FlushOtherSamples(
+	ctx context.Context, samples []ssf.SSFSample,
+) {
+}
+
+// ServeHTTP writes the stored metrics in the OpenMetrics format if the
+// request accepts it, and in the Prometheus text format otherwise.
+func (sink *PrometheusExporterSink) ServeHTTP(
+	w http.ResponseWriter, r *http.Request,
+) {
+	openMetrics := strings.Contains(
+		r.Header.Get("Accept"), "application/openmetrics-text")
+	body := sink.render(openMetrics)
+	if openMetrics {
+		w.Header().Set("Content-Type", openMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", textContentType)
+	}
+	w.Write(body)
+}
+
+// Renders the stored metrics, sorted by name and labels.
+func (sink *PrometheusExporterSink) render(openMetrics bool) []byte {
+	sink.mutex.RLock()
+	defer sink.mutex.RUnlock()
+
+	names := make([]string, 0, len(sink.families))
+	for name := range sink.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buffer bytes.Buffer
+	for _, name := range names {
+		family := sink.families[name]
+		familyName, typeName := family.name, "gauge"
+		if family.metricType == samplers.CounterMetric {
+			typeName = "counter"
+			// In OpenMetrics, the _total suffix is only part of the sample
+			// name.
+			if openMetrics {
+				familyName = strings.TrimSuffix(family.name, "_total")
+			}
+		}
+		buffer.WriteString("# TYPE ")
+		buffer.WriteString(familyName)
+		buffer.WriteByte(' ')
+		buffer.WriteString(typeName)
+		buffer.WriteByte('\n')
+
+		labels := make([]string, 0, len(family.series))
+		for label := range family.series {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			buffer.WriteString(family.name)
+			buffer.WriteString(label)
+			buffer.WriteByte(' ')
+			buffer.WriteString(formatValue(family.series[label].value))
+			buffer.WriteByte('\n')
+		}
+	}
+	if openMetrics {
+		buffer.WriteString("# EOF\n")
+	}
+	return buffer.Bytes()
+}
+
+// Renders a metric's tags and the common tags as a sorted set of labels.
+// Tags that aren't in "key:value" format are dropped, and the last value of a
+// duplicate label wins, with common tags taking precedence.
+func renderLabels(tags []string, commonTags map[string]string) string {
+	labels := make(map[string]string, len(tags)+len(commonTags))
+	for _, tag := range tags {
+		kv := strings.SplitN(tag, ":", 2)
+		if len(kv) < 2 {
+			continue
+		}
+		labels[sanitiseLabelName(kv[0])] = kv[1]
+	}
+	for key, value := range commonTags {
+		labels[sanitiseLabelName(key)] = value
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	builder.WriteByte('{')
+	for index, name := range names {
+		if index > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(name)
+		builder.WriteString(`="`)
+		builder.WriteString(labelValueReplacer.Replace(labels[name]))
+		builder.WriteByte('"')
+	}
+	builder.WriteByte('}')
+	return builder.String()
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// Replaces the characters which are not in the set [a-zA-Z0-9_:] with
+// underscores, and prefixes the name with an underscore if it starts with a
+// digit.
+func sanitiseMetricName(name string) string {
+	return sanitiseName(name, true)
+}
+
+// Replaces the characters which are not in the set [a-zA-Z0-9_] with
+// underscores, and prefixes the name with an underscore if it starts with a
+// digit.
+func sanitiseLabelName(name string) string {
+	return sanitiseName(name, false)
+}
+
+func sanitiseName(name string, allowColon bool) string {
+	if name == "" {
+		return "_"
+	}
+	output := []byte(name)
+	for index := 0; index < len(output); index++ {
+		c := output[index]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9':
+		case c == ':' && allowColon:
+		default:
+			output[index] = '_'
+		}
+	}
+	if output[0] >= '0' && output[0] <= '9' {
+		return "_" + string(output)
+	}
+	return string(output)
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,151 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14"
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+func newTestExporter(t *testing.T, staleIntervals int) *PrometheusExporterSink {
+	sink, err := CreateExporterSink(
+		&veneur.Server{
+			TagsAsMap: map[string]string{"region": "us-west-2"},
+		},
+		"prometheus_exporter",
+		testLogger(),
+		veneur.Config{Hostname: "host-a"},
+		PrometheusExporterSinkConfig{
+			ListenAddress:  "127.0.0.1:0",
+			Path:           "/metrics",
+			StaleIntervals: staleIntervals,
+		})
+	require.NoError(t, err)
+	return sink.(*PrometheusExporterSink)
+}
+
+func scrape(t *testing.T, sink *PrometheusExporterSink, accept string) (string, string) {
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if accept != "" {
+		request.Header.Set("Accept", accept)
+	}
+	recorder := httptest.NewRecorder()
+	sink.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	return recorder.Header().Get("Content-Type"), recorder.Body.String()
+}
+
+func TestCreateExporterSinkInvalidConfig(t *testing.T) {
+	for name, config := range map[string]PrometheusExporterSinkConfig{
+		"no listen address": {Path: "/metrics"},
+		"relative path":     {ListenAddress: ":9102", Path: "metrics"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := CreateExporterSink(
+				&veneur.Server{}, "prometheus_exporter", testLogger(),
+				veneur.Config{}, config)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestExporterText(t *testing.T) {
+	sink := newTestExporter(t, 0)
+	_, err := sink.Flush(context.Background(), []samplers.InterMetric{{
+		Name:  "api.requests",
+		Value: 3,
+		Tags:  []string{"status-code:200", "path:/a\"b", "untagged"},
+		Type:  samplers.CounterMetric,
+	}, {
+		Name:  "1queue.depth",
+		Value: 1.5,
+		Tags:  []string{"host:ignored"},
+		Type:  samplers.GaugeMetric,
+	}})
+	require.NoError(t, err)
+
+	contentType, body := scrape(t, sink, "")
+	assert.Equal(t, textContentType, contentType)
+	assert.Equal(t, `# TYPE _1queue_depth gauge
+_1queue_depth{host="host-a",region="us-west-2"} 1.5
+# TYPE api_requests_total counter
+api_requests_total{host="host-a",path="/a\"b",region="us-west-2",status_code="200"} 3
+`, body)
+}
+
+func TestExporterOpenMetrics(t *testing.T) {
+	sink := newTestExporter(t, 0)
+	_, err := sink.Flush(context.Background(), []samplers.InterMetric{{
+		Name:  "jobs_total",
+		Value: 2,
+		Type:  samplers.CounterMetric,
+	}, {
+		Name:  "temperature",
+		Value: -4,
+		Type:  samplers.GaugeMetric,
+	}})
+	require.NoError(t, err)
+
+	contentType, body := scrape(
+		t, sink, "application/openmetrics-text;version=1.0.0,*/*;q=0.1")
+	assert.Equal(t, openMetricsContentType, contentType)
+	assert.Equal(t, `# TYPE jobs counter
+jobs_total{host="host-a",region="us-west-2"} 2
+# TYPE temperature gauge
+temperature{host="host-a",region="us-west-2"} -4
+# EOF
+`, body)
+}
+
+func TestExporterCountersAccumulate(t *testing.T) {
+	sink := newTestExporter(t, 0)
+	for _, value := range []float64{2, 3, 5} {
+		_, err := sink.Flush(context.Background(), []samplers.InterMetric{{
+			Name:  "requests",
+			Value: value,
+			Tags:  []string{"env:prod"},
+			Type:  samplers.CounterMetric,
+		}, {
+			Name:  "in_flight",
+			Value: value,
+			Type:  samplers.GaugeMetric,
+		}})
+		require.NoError(t, err)
+	}
+
+	_, body := scrape(t, sink, "")
+	assert.Equal(t, `# TYPE in_flight gauge
+in_flight{host="host-a",region="us-west-2"} 5
+# TYPE requests_total counter
+requests_total{env="prod",host="host-a",region="us-west-2"} 10
+`, body)
+}
+
+func TestExporterExpiresStaleSeries(t *testing.T) {
+	sink := newTestExporter(t, 2)
+	flush := func(metrics ...samplers.InterMetric) {
+		_, err := sink.Flush(context.Background(), metrics)
+		require.NoError(t, err)
+	}
+	old := samplers.InterMetric{
+		Name: "old", Value: 1, Type: samplers.GaugeMetric,
+	}
+	current := samplers.InterMetric{
+		Name: "current", Value: 1, Type: samplers.GaugeMetric,
+	}
+
+	flush(old, current)
+	flush(current)
+	_, body := scrape(t, sink, "")
+	assert.Contains(t, body, "old{")
+
+	flush(current)
+	_, body = scrape(t, sink, "")
+	assert.NotContains(t, body, "old")
+	assert.Contains(t, body, "current{")
+}
@@ -5,12 +5,14 @@
 package mock
 
 import (
+	context "context"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
 	samplers "github.com/stripe/veneur/v14/samplers"
 	metricpb "github.com/stripe/veneur/v14/samplers/metricpb"
 	sources "github.com/stripe/veneur/v14/sources"
+	ssf "github.com/stripe/veneur/v14/ssf"
 )
 
 // MockSourceConfig is a mock of SourceConfig interface.
@@ -145,3 +147,41 @@ func (mr *MockIngestMockRecorder) IngestMetricProto(metric interface{}) *gomock.
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IngestMetricProto", reflect.TypeOf((*MockIngest)(nil).IngestMetricProto), metric)
 }
+
+// IngestSample mocks base method.
+func (m *MockIngest) IngestSample(sample *ssf.SSFSample) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "IngestSample", sample)
+}
+
+// IngestSample indicates an expected call of IngestSample.
+func (mr *MockIngestMockRecorder) IngestSample(sample interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IngestSample", reflect.TypeOf((*MockIngest)(nil).IngestSample), sample)
+}
+
+// IngestSpan mocks base method.
+func (m *MockIngest) IngestSpan(span *ssf.SSFSpan) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "IngestSpan", span)
+}
+
+// IngestSpan indicates an expected call of IngestSpan.
+func (mr *MockIngestMockRecorder) IngestSpan(span interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IngestSpan", reflect.TypeOf((*MockIngest)(nil).IngestSpan), span)
+}
+
+// TryIngestMetricProto mocks base method.
+func (m *MockIngest) TryIngestMetricProto(ctx context.Context, metric *metricpb.Metric) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TryIngestMetricProto", ctx, metric)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// TryIngestMetricProto indicates an expected call of TryIngestMetricProto.
+func (mr *MockIngestMockRecorder) TryIngestMetricProto(ctx, metric interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TryIngestMetricProto", reflect.TypeOf((*MockIngest)(nil).TryIngestMetricProto), ctx, metric)
+}
@@ -1,6 +1,12 @@
 package proxy
 
-import "github.com/stripe/veneur/v14/trace"
+import (
+	"time"
+
+	"google.golang.org/grpc/credentials"
+
+	"github.com/stripe/veneur/v14/trace"
+)
 
 // WithTraceClient sets the trace client for the server.  Otherwise it uses
 // trace.DefaultClient.
@@ -9,3 +15,19 @@ func WithTraceClient(c *trace.Client) Option {
 		opts.traceClient = c
 	}
 }
+
+// WithTransportCredentials sets the credentials the server uses to secure
+// incoming connections, such as TLS. Otherwise connections are insecure.
+func WithTransportCredentials(c credentials.TransportCredentials) Option {
+	return func(opts *options) {
+		opts.transportCredentials = c
+	}
+}
+
+// WithImportTimeout sets how long SendMetricsV3 waits for the workers to
+// accept the metrics of a batch. Otherwise it uses DefaultImportTimeout.
+func WithImportTimeout(timeout time.Duration) Option {
+	return func(opts *options) {
+		opts.importTimeout = timeout
+	}
+}
@@ -16,9 +16,11 @@ import (
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/stripe/veneur/v14/forwardrpc"
+	"github.com/stripe/veneur/v14/samplers/metricpb"
 	"github.com/stripe/veneur/v14/sources"
 	"github.com/stripe/veneur/v14/trace"
 )
@@ -40,9 +42,15 @@ type Server struct {
 var _ sources.Source = &Server{}
 
 type options struct {
-	traceClient *trace.Client
+	importTimeout        time.Duration
+	traceClient          *trace.Client
+	transportCredentials credentials.TransportCredentials
 }
 
+// DefaultImportTimeout is how long SendMetricsV3 waits for the workers to
+// accept the metrics of a batch, before dropping the rest of the batch.
+const DefaultImportTimeout = time.Second
+
 // Option is returned by functions that serve as options to New, like
 // "With..."
 type Option func(*options)
@@ -53,8 +61,7 @@ func New(address string, logger *logrus.Entry, opts ...Option) *Server {
 	res := &Server{
 		address:      address,
 		logger:       logger,
-		opts:         &options{},
-		server:       grpc.NewServer(),
+		opts:         &options{importTimeout: DefaultImportTimeout},
 		readyChannel: make(chan struct{}),
 	}
 
@@ -62,6 +69,12 @@ func New(address string, logger *logrus.Entry, opts ...Option) *Server {
 		opt(res.opts)
 	}
 
+	var serverOpts []grpc.ServerOption
+	if res.opts.transportCredentials != nil {
+		serverOpts = append(serverOpts, grpc.Creds(res.opts.transportCredentials))
+	}
+	res.server = grpc.NewServer(serverOpts...)
+
 	if res.opts.traceClient == nil {
 		res.opts.traceClient = trace.DefaultClient
 	}
@@ -160,3 +173,115 @@ func (s *Server) SendMetricsV2(
 	}
 	return err
 }
+
+// SendMetricsV3 receives batches of metrics, and acknowledges each batch with
+// the number of metrics that were ingested, rejected as invalid, or dropped
+// because the workers couldn't keep up.
+func (s *Server) SendMetricsV3(server forwardrpc.Forward_SendMetricsV3Server) error {
+	for {
+		batch, err := server.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			s.logger.WithError(err).Error("error recieving metrics")
+			return err
+		}
+		err = server.Send(s.ingestBatch(server.Context(), batch))
+		if err != nil {
+			s.logger.WithError(err).Error("error acknowledging metrics")
+			return err
+		}
+	}
+}
+
+func (s *Server) ingestBatch(
+	ctx context.Context, batch *forwardrpc.MetricBatch,
+) *forwardrpc.MetricBatchAck {
+	ack := &forwardrpc.MetricBatchAck{
+		Id:       batch.Id,
+		Rejected: map[string]uint64{},
+		Dropped:  map[string]uint64{},
+	}
+	if len(batch.Metrics) == 0 {
+		return ack
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.opts.importTimeout)
+	defer cancel()
+	for index, metric := range batch.Metrics {
+		if reason := validateMetric(metric); reason != "" {
+			ack.Rejected[reason]++
+			continue
+		}
+		if !s.ingest.TryIngestMetricProto(ctx, metric) {
+			ack.Dropped["queue_full"]++
+			ack.Retry = append(ack.Retry, uint32(index))
+			continue
+		}
+		ack.Accepted++
+	}
+	return ack
+}
+
+// validateMetric returns the reason a forwarded metric can't be imported, or
+// an empty string if it can be.
+func validateMetric(metric *metricpb.Metric) string {
+	if metric.Name == "" {
+		return "empty_name"
+	}
+	if metric.Scope == metricpb.Scope_Local {
+		return "local_scope"
+	}
+
+	var valid bool
+	switch metric.GetValue().(type) {
+	case nil:
+		return "missing_value"
+	case *metricpb.Metric_Counter:
+		valid = metric.Type == metricpb.Type_Counter
+	case *metricpb.Metric_Gauge:
+		valid = metric.Type == metricpb.Type_Gauge
+	case *metricpb.Metric_Histogram:
+		valid = metric.Type == metricpb.Type_Histogram ||
+			metric.Type == metricpb.Type_Timer
+	case *metricpb.Metric_Set:
+		valid = metric.Type == metricpb.Type_Set
+	case *metricpb.Metric_Status:
+		valid = metric.Type == metricpb.Type_Status
+	}
+	if !valid {
+		return "type_mismatch"
+	}
+	return ""
+}
+
+// SendSpans receives spans forwarded by local Veneurs, and passes them on to
+// the span sinks.
+func (s *Server) SendSpans(server forwardrpc.Forward_SendSpansServer) error {
+	for {
+		span, err := server.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			s.logger.WithError(err).Error("error recieving spans")
+			return err
+		}
+		s.ingest.IngestSpan(span)
+	}
+	err := server.SendAndClose(&emptypb.Empty{})
+	if err != nil {
+		s.logger.WithError(err).Error("error closing stream")
+	}
+	return err
+}
+
+// SendSamples receives events and service checks forwarded by local Veneurs,
+// and passes them on to be flushed by the metric sinks.
+func (s *Server) SendSamples(
+	_ context.Context, sampleList *forwardrpc.SampleList,
+) (*empty.Empty, error) {
+	for _, sample := range sampleList.Samples {
+		s.ingest.IngestSample(sample)
+	}
+	return &emptypb.Empty{}, nil
+}
@@ -2,15 +2,18 @@ package proxy_test
 
 import (
 	"context"
+	"io"
 	"testing"
 
 	"github.com/golang/mock/gomock"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stripe/veneur/v14/forwardrpc"
+	"github.com/stripe/veneur/v14/protocol/dogstatsd"
 	"github.com/stripe/veneur/v14/samplers/metricpb"
 	"github.com/stripe/veneur/v14/sources/mock"
 	"github.com/stripe/veneur/v14/sources/proxy"
+	"github.com/stripe/veneur/v14/ssf"
 	"google.golang.org/grpc"
 )
 
@@ -80,6 +83,107 @@ func TestSendMetricsV2(t *testing.T) {
 	server.Stop()
 }
 
+func TestSendMetricsV3(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockIngest := mock.NewMockIngest(ctrl)
+	metric := metricpb.Metric{
+		Name: "test-metric",
+		Tags: []string{"tag1:value1", "tag2:value2"},
+		Type: metricpb.Type_Counter,
+		Value: &metricpb.Metric_Counter{
+			Counter: &metricpb.CounterValue{
+				Value: 10,
+			},
+		},
+	}
+	dropped := metricpb.Metric{
+		Name: "dropped-metric",
+		Type: metricpb.Type_Gauge,
+		Value: &metricpb.Metric_Gauge{
+			Gauge: &metricpb.GaugeValue{
+				Value: 1,
+			},
+		},
+	}
+	mismatched := metric
+	mismatched.Type = metricpb.Type_Set
+
+	logger := logrus.NewEntry(logrus.New())
+	server := proxy.New("localhost:0", logger)
+
+	go server.Start(mockIngest)
+	<-server.Ready()
+	defer server.Stop()
+
+	connection, err := grpc.Dial(server.GetAddress(), grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer connection.Close()
+	client := forwardrpc.NewForwardClient(connection)
+
+	mockIngest.EXPECT().TryIngestMetricProto(gomock.Any(), &metric).
+		Times(2).Return(true)
+	mockIngest.EXPECT().TryIngestMetricProto(gomock.Any(), &dropped).
+		Times(1).Return(false)
+
+	sendClient, err := client.SendMetricsV3(context.Background())
+	assert.NoError(t, err)
+
+	err = sendClient.Send(&forwardrpc.MetricBatch{
+		Id: 7,
+		Metrics: []*metricpb.Metric{
+			&metric, {Name: "no-value"}, &dropped, &mismatched, &metric,
+		},
+	})
+	assert.NoError(t, err)
+
+	ack, err := sendClient.Recv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint64(7), ack.Id)
+		assert.Equal(t, uint64(2), ack.Accepted)
+		assert.Equal(t, map[string]uint64{
+			"missing_value": 1,
+			"type_mismatch": 1,
+		}, ack.Rejected)
+		assert.Equal(t, map[string]uint64{"queue_full": 1}, ack.Dropped)
+		assert.Equal(t, []uint32{2}, ack.Retry)
+	}
+
+	assert.NoError(t, sendClient.CloseSend())
+	_, err = sendClient.Recv()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestSendMetricsV3Probe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockIngest := mock.NewMockIngest(ctrl)
+
+	logger := logrus.NewEntry(logrus.New())
+	server := proxy.New("localhost:0", logger)
+
+	go server.Start(mockIngest)
+	<-server.Ready()
+	defer server.Stop()
+
+	connection, err := grpc.Dial(server.GetAddress(), grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer connection.Close()
+	client := forwardrpc.NewForwardClient(connection)
+
+	sendClient, err := client.SendMetricsV3(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, sendClient.Send(&forwardrpc.MetricBatch{}))
+
+	ack, err := sendClient.Recv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint64(0), ack.Accepted)
+		assert.Empty(t, ack.Retry)
+	}
+}
+
 func TestSendMetricsV2ContextCancelled(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -105,3 +209,74 @@ func TestSendMetricsV2ContextCancelled(t *testing.T) {
 
 	server.Stop()
 }
+
+func TestSendSpans(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockIngest := mock.NewMockIngest(ctrl)
+	span := ssf.SSFSpan{
+		TraceId: 1,
+		Id:      2,
+		Name:    "test-span",
+		Service: "test-service",
+	}
+
+	logger := logrus.NewEntry(logrus.New())
+	server := proxy.New("localhost:0", logger)
+
+	go server.Start(mockIngest)
+	<-server.Ready()
+
+	connection, err := grpc.Dial(server.GetAddress(), grpc.WithInsecure())
+	assert.NoError(t, err)
+	client := forwardrpc.NewForwardClient(connection)
+
+	mockIngest.EXPECT().IngestSpan(&span).Times(5)
+
+	sendClient, err := client.SendSpans(context.Background())
+	assert.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		err = sendClient.Send(&span)
+		assert.NoError(t, err)
+	}
+
+	_, err = sendClient.CloseAndRecv()
+	assert.NoError(t, err)
+
+	server.Stop()
+}
+
+func TestSendSamples(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockIngest := mock.NewMockIngest(ctrl)
+	event := ssf.SSFSample{
+		Name:    "test-event",
+		Message: "something happened",
+		Tags: map[string]string{
+			dogstatsd.EventIdentifierKey: "",
+		},
+	}
+
+	logger := logrus.NewEntry(logrus.New())
+	server := proxy.New("localhost:0", logger)
+
+	go server.Start(mockIngest)
+	<-server.Ready()
+
+	connection, err := grpc.Dial(server.GetAddress(), grpc.WithInsecure())
+	assert.NoError(t, err)
+	client := forwardrpc.NewForwardClient(connection)
+
+	mockIngest.EXPECT().IngestSample(&event).Times(3)
+
+	_, err = client.SendSamples(context.Background(), &forwardrpc.SampleList{
+		Samples: []*ssf.SSFSample{&event, &event, &event},
+	})
+	assert.NoError(t, err)
+
+	server.Stop()
+}
@@ -1,8 +1,11 @@
 package sources
 
 import (
+	"context"
+
 	"github.com/stripe/veneur/v14/samplers"
 	"github.com/stripe/veneur/v14/samplers/metricpb"
+	"github.com/stripe/veneur/v14/ssf"
 )
 
 type SourceConfig interface{}
@@ -16,4 +19,9 @@ type Source interface {
 type Ingest interface {
 	IngestMetric(metric *samplers.UDPMetric)
 	IngestMetricProto(metric *metricpb.Metric)
+	// TryIngestMetricProto blocks until the metric is queued for ingestion or
+	// ctx is done, and returns whether the metric was queued.
+	TryIngestMetricProto(ctx context.Context, metric *metricpb.Metric) bool
+	IngestSpan(span *ssf.SSFSpan)
+	IngestSample(sample *ssf.SSFSample)
 }
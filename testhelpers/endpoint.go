@@ -0,0 +1,90 @@
+package testhelpers
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Endpoint is a fake HTTP endpoint for the sinks that post to one. It
+// records the requests it receives, and fails the first Failures of them
+// with StatusCode. The other requests get the response written by Respond,
+// or an empty 200 if it's nil.
+type Endpoint struct {
+	Failures   int
+	StatusCode int
+	// Writes the response to a request, given its body after decompressing
+	// it. An error fails the request with a 400.
+	Respond func(w http.ResponseWriter, body []byte) error
+
+	mutex    sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	// Errors reading requests, which are checked by the test rather than
+	// failing it from the server's goroutine.
+	errors []error
+}
+
+func (endpoint *Endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+
+	body := r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			endpoint.errors = append(endpoint.errors, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = reader
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		endpoint.errors = append(endpoint.errors, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	endpoint.requests = append(endpoint.requests, r)
+	endpoint.bodies = append(endpoint.bodies, data)
+
+	if endpoint.Failures > 0 {
+		endpoint.Failures -= 1
+		w.WriteHeader(endpoint.StatusCode)
+		return
+	}
+	if endpoint.Respond == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	err = endpoint.Respond(w, data)
+	if err != nil {
+		endpoint.errors = append(endpoint.errors, err)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// Requests returns the requests received so far.
+func (endpoint *Endpoint) Requests() []*http.Request {
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	return endpoint.requests
+}
+
+// Bodies returns the decompressed bodies of the requests received so far.
+func (endpoint *Endpoint) Bodies() [][]byte {
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	return endpoint.bodies
+}
+
+// AssertNoErrors fails the test if any request couldn't be read.
+func (endpoint *Endpoint) AssertNoErrors(t *testing.T) {
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	assert.Empty(t, endpoint.errors)
+}
@@ -0,0 +1,115 @@
+// Package retry retries the requests that sinks send to remote endpoints,
+// with exponential backoff, and honoring the Retry-After header of HTTP
+// responses.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// The number of times a request is retried, if max_retries isn't set.
+	defaultMaxRetries = 3
+	// How long to wait before the first retry, if retry_backoff isn't set.
+	defaultRetryBackoff = time.Second
+)
+
+// Config configures how a request that failed with a retryable error is
+// retried. It's meant to be embedded in a sink's config with
+// `yaml:",squash"`.
+type Config struct {
+	// The number of times a request that failed with a retryable error is
+	// retried. Defaults to 3; set to -1 to disable retries.
+	MaxRetries int `yaml:"max_retries"`
+	// How long to wait before the first retry, which doubles for each
+	// following retry. Defaults to 1s.
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+}
+
+// SetDefaults fills in the defaults of the fields that aren't set, and
+// disables retries if MaxRetries is negative.
+func (config *Config) SetDefaults() {
+	if config.MaxRetries == 0 {
+		config.MaxRetries = defaultMaxRetries
+	} else if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = defaultRetryBackoff
+	}
+}
+
+// Do calls send until it succeeds, fails with an error that isn't retryable,
+// has been retried MaxRetries times, or ctx is done, and returns the last
+// error. Along with its error, send returns how long the endpoint asked to
+// wait before retrying, or zero; the wait is the longer of that and the
+// backoff.
+func Do(
+	ctx context.Context, config Config, retryable func(error) bool,
+	send func() (time.Duration, error),
+) error {
+	backoff := config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := send()
+		if err == nil || attempt >= config.MaxRetries || !retryable(err) {
+			return err
+		}
+		wait := backoff
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// After returns how long the Retry-After header of a response asks to wait,
+// or zero if it isn't set to a number of seconds.
+func After(header http.Header) time.Duration {
+	seconds, err := strconv.Atoi(header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// StatusError is an error response to an HTTP request.
+type StatusError struct {
+	// The name of the endpoint that responded, e.g. "influxdb".
+	Endpoint   string
+	StatusCode int
+	Body       string
+}
+
+func (err StatusError) Error() string {
+	return fmt.Sprintf(
+		"%s returned status %d: %s", err.Endpoint, err.StatusCode, err.Body)
+}
+
+// RetryableStatus returns whether a request that failed with an HTTP status
+// can be retried: a 429 or a 5xx means the endpoint is overloaded or
+// unavailable, while another 4xx means the request is malformed or too big,
+// and fails again if retried.
+func RetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// Retryable returns whether a failed HTTP request can be retried. Error
+// responses are retryable according to RetryableStatus; other errors, such
+// as failures to connect, are transient.
+func Retryable(err error) bool {
+	var statusError StatusError
+	if errors.As(err, &statusError) {
+		return RetryableStatus(statusError.StatusCode)
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/veneur/v14/util/retry"
+)
+
+var errTransient = errors.New("transient")
+
+func retryable(err error) bool {
+	return err == errTransient
+}
+
+func TestSetDefaults(t *testing.T) {
+	config := retry.Config{}
+	config.SetDefaults()
+	assert.Equal(t, retry.Config{
+		MaxRetries:   3,
+		RetryBackoff: time.Second,
+	}, config)
+
+	config = retry.Config{MaxRetries: -1}
+	config.SetDefaults()
+	assert.Equal(t, 0, config.MaxRetries)
+}
+
+func TestDoRetries(t *testing.T) {
+	attempts := 0
+	err := retry.Do(context.Background(), retry.Config{
+		MaxRetries:   3,
+		RetryBackoff: time.Millisecond,
+	}, retryable, func() (time.Duration, error) {
+		attempts++
+		if attempts < 3 {
+			return 0, errTransient
+		}
+		return 0, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestDoGivesUp(t *testing.T) {
+	attempts := 0
+	err := retry.Do(context.Background(), retry.Config{
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+	}, retryable, func() (time.Duration, error) {
+		attempts++
+		return 0, errTransient
+	})
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestDoNotRetryable(t *testing.T) {
+	permanent := errors.New("permanent")
+	attempts := 0
+	err := retry.Do(context.Background(), retry.Config{
+		MaxRetries:   3,
+		RetryBackoff: time.Millisecond,
+	}, retryable, func() (time.Duration, error) {
+		attempts++
+		return 0, permanent
+	})
+	assert.Equal(t, permanent, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestDoWaitsForRetryAfter(t *testing.T) {
+	attempts := 0
+	start := time.Now()
+	err := retry.Do(context.Background(), retry.Config{
+		MaxRetries:   1,
+		RetryBackoff: time.Millisecond,
+	}, retryable, func() (time.Duration, error) {
+		attempts++
+		if attempts == 1 {
+			return 50 * time.Millisecond, errTransient
+		}
+		return 0, nil
+	})
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(50*time.Millisecond))
+}
+
+func TestDoStopsWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts := 0
+	err := retry.Do(ctx, retry.Config{
+		MaxRetries:   3,
+		RetryBackoff: time.Hour,
+	}, retryable, func() (time.Duration, error) {
+		attempts++
+		return 0, errTransient
+	})
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestAfter(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"":                              0,
+		"2":                             2 * time.Second,
+		"-1":                            0,
+		"Wed, 21 Oct 2015 07:28:00 GMT": 0,
+	} {
+		header := http.Header{}
+		if value != "" {
+			header.Set("Retry-After", value)
+		}
+		assert.Equal(t, expected, retry.After(header), value)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for statusCode, expected := range map[int]bool{
+		http.StatusBadRequest:            false,
+		http.StatusRequestEntityTooLarge: false,
+		http.StatusTooManyRequests:       true,
+		http.StatusInternalServerError:   true,
+		http.StatusServiceUnavailable:    true,
+	} {
+		err := fmt.Errorf("failed to write: %w", retry.StatusError{
+			Endpoint: "endpoint", StatusCode: statusCode,
+		})
+		assert.Equal(t, expected, retry.Retryable(err), statusCode)
+	}
+	assert.True(t, retry.Retryable(errors.New("connection refused")))
+}
@@ -0,0 +1,286 @@
+// Package tlsconfig builds TLS configurations for Veneur's gRPC clients and
+// servers, from PEM contents given inline in the config or read from files.
+//
+// Certificates read from files are reloaded whenever the files change, so
+// that rotated certificates are picked up without restarting Veneur.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// The values of Config.ClientAuth.
+const (
+	// ClientAuthRequire requires clients to present a certificate signed by
+	// the authority. This is the default when an authority is configured.
+	ClientAuthRequire = "require"
+	// ClientAuthOptional verifies client certificates that are presented, but
+	// also accepts clients that present none.
+	ClientAuthOptional = "optional"
+	// ClientAuthNone doesn't ask clients for a certificate.
+	ClientAuthNone = "none"
+)
+
+// reloadInterval bounds how often the files of a Reloader are checked for
+// changes.
+const reloadInterval = time.Second
+
+// Config describes a TLS certificate, key and authority. Each of them can be
+// given either inline as PEM, or as the path to a PEM file.
+type Config struct {
+	Certificate     string
+	CertificateFile string
+	Key             string
+	KeyFile         string
+	Authority       string
+	AuthorityFile   string
+	// ClientAuth is one of ClientAuthRequire, ClientAuthOptional or
+	// ClientAuthNone, and only applies to servers.
+	ClientAuth string
+}
+
+// HasCertificate returns whether a certificate and key are configured.
+func (c Config) HasCertificate() bool {
+	return c.Certificate != "" || c.CertificateFile != "" ||
+		c.Key != "" || c.KeyFile != ""
+}
+
+// HasAuthority returns whether an authority certificate is configured.
+func (c Config) HasAuthority() bool {
+	return c.Authority != "" || c.AuthorityFile != ""
+}
+
+// Reloader holds the current certificate and authority of a Config, and
+// reloads them when the files they were read from change.
+type Reloader struct {
+	config Config
+
+	mtx         sync.RWMutex
+	certificate *tls.Certificate
+	authority   *x509.CertPool
+	modTimes    map[string]time.Time
+	lastCheck   time.Time
+}
+
+// New loads the certificate and authority described by config.
+func New(config Config) (*Reloader, error) {
+	switch config.ClientAuth {
+	case "", ClientAuthRequire, ClientAuthOptional, ClientAuthNone:
+	default:
+		return nil, fmt.Errorf("unknown client auth mode %q", config.ClientAuth)
+	}
+	if config.Certificate != "" && config.CertificateFile != "" {
+		return nil, errors.New("a certificate and a certificate file can't both be set")
+	}
+	if config.Key != "" && config.KeyFile != "" {
+		return nil, errors.New("a key and a key file can't both be set")
+	}
+	if config.Authority != "" && config.AuthorityFile != "" {
+		return nil, errors.New("an authority and an authority file can't both be set")
+	}
+	if config.HasCertificate() &&
+		(config.Certificate == "" && config.CertificateFile == "" ||
+			config.Key == "" && config.KeyFile == "") {
+		return nil, errors.New("a certificate and a key must both be set")
+	}
+
+	r := &Reloader{
+		config:   config,
+		modTimes: map[string]time.Time{},
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.lastCheck = time.Now()
+	return r, nil
+}
+
+// ServerConfig returns a TLS configuration for a server. It presents the
+// current certificate, and verifies clients against the current authority
+// according to the ClientAuth mode. Other settings made on the returned
+// configuration, such as NextProtos or MinVersion, apply to every handshake.
+func (r *Reloader) ServerConfig() *tls.Config {
+	clientAuth := tls.NoClientCert
+	if r.config.HasAuthority() {
+		switch r.config.ClientAuth {
+		case "", ClientAuthRequire:
+			clientAuth = tls.RequireAndVerifyClientCert
+		case ClientAuthOptional:
+			clientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	base := &tls.Config{ClientAuth: clientAuth}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.reloadIfChanged()
+		r.mtx.RLock()
+		defer r.mtx.RUnlock()
+		config := base.Clone()
+		config.GetConfigForClient = nil
+		config.ClientCAs = r.authority
+		config.Certificates = nil
+		if r.certificate != nil {
+			config.Certificates = []tls.Certificate{*r.certificate}
+		}
+		return config, nil
+	}
+	return base
+}
+
+// GRPCServerConfig returns a server configuration like ServerConfig, for a
+// gRPC server. credentials.NewTLS adds HTTP/2 to the protocols of a copy of
+// the configuration it's given, which the per-handshake configuration isn't
+// cloned from, so HTTP/2 is set here instead.
+func (r *Reloader) GRPCServerConfig() *tls.Config {
+	config := r.ServerConfig()
+	config.NextProtos = []string{"h2"}
+	return config
+}
+
+// ClientConfig returns a TLS configuration for a client. It presents the
+// current certificate, if there is one, and verifies servers against the
+// current authority, or the system's roots if there's no authority.
+func (r *Reloader) ClientConfig() *tls.Config {
+	return &tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			r.reloadIfChanged()
+			r.mtx.RLock()
+			defer r.mtx.RUnlock()
+			if r.certificate == nil {
+				return &tls.Certificate{}, nil
+			}
+			return r.certificate, nil
+		},
+		// The authority can change after the connection is configured, so
+		// the server's certificate is verified against it in
+		// VerifyConnection instead of by the TLS library.
+		InsecureSkipVerify: true,
+		VerifyConnection:   r.verifyServer,
+	}
+}
+
+// verifyServer verifies a server's certificate chain and name, as the TLS
+// library would if InsecureSkipVerify were false.
+func (r *Reloader) verifyServer(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server presented no certificates")
+	}
+	r.reloadIfChanged()
+	r.mtx.RLock()
+	roots := r.authority
+	r.mtx.RUnlock()
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       state.ServerName,
+		Intermediates: intermediates,
+		Roots:         roots,
+	})
+	return err
+}
+
+// reloadIfChanged reloads the certificate and authority if any of the files
+// they're read from changed. Files are checked at most once per
+// reloadInterval. If reloading fails, the previous certificate and authority
+// stay in use.
+func (r *Reloader) reloadIfChanged() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if time.Since(r.lastCheck) < reloadInterval {
+		return
+	}
+	r.lastCheck = time.Now()
+
+	changed := false
+	for _, path := range r.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return
+		}
+		if !info.ModTime().Equal(r.modTimes[path]) {
+			changed = true
+		}
+	}
+	if changed {
+		r.loadLocked()
+	}
+}
+
+func (r *Reloader) files() []string {
+	var files []string
+	for _, path := range []string{
+		r.config.CertificateFile, r.config.KeyFile, r.config.AuthorityFile,
+	} {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+func (r *Reloader) load() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.loadLocked()
+}
+
+// loadLocked reads the certificate and authority. It must be called with mtx
+// held.
+func (r *Reloader) loadLocked() error {
+	modTimes := map[string]time.Time{}
+	read := func(inline, path string) (string, error) {
+		if path == "" {
+			return inline, nil
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		modTimes[path] = info.ModTime()
+		contents, err := ioutil.ReadFile(path)
+		return string(contents), err
+	}
+
+	certPEM, err := read(r.config.Certificate, r.config.CertificateFile)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := read(r.config.Key, r.config.KeyFile)
+	if err != nil {
+		return err
+	}
+	authorityPEM, err := read(r.config.Authority, r.config.AuthorityFile)
+	if err != nil {
+		return err
+	}
+
+	var certificate *tls.Certificate
+	if certPEM != "" {
+		cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			return err
+		}
+		certificate = &cert
+	}
+	var authority *x509.CertPool
+	if authorityPEM != "" {
+		authority = x509.NewCertPool()
+		if !authority.AppendCertsFromPEM([]byte(authorityPEM)) {
+			return errors.New("could not load any authority certificates")
+		}
+	}
+
+	r.certificate = certificate
+	r.authority = authority
+	r.modTimes = modTimes
+	return nil
+}
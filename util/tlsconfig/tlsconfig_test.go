@@ -0,0 +1,297 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  string
+}
+
+var serial int64
+
+func newAuthority(t *testing.T) testAuthority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial++
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "test authority"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return testAuthority{
+		cert: cert,
+		key:  key,
+		pem:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+}
+
+// issue returns a certificate and key signed by the authority, valid for
+// localhost as both a server and a client.
+func (a testAuthority) issue(t *testing.T, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth,
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+type accepted struct {
+	conn *tls.Conn
+	err  error
+}
+
+// handshake connects a client to a server over TLS, and returns the name of
+// the server's certificate and the client certificates seen by the server.
+func handshake(
+	t *testing.T, server, client *tls.Config,
+) (serverName string, clientCerts []*x509.Certificate, err error) {
+	listener, err := tls.Listen("tcp", "localhost:0", server)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	acceptedChannel := make(chan accepted, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			acceptedChannel <- accepted{err: err}
+			return
+		}
+		tlsConn := conn.(*tls.Conn)
+		acceptedChannel <- accepted{conn: tlsConn, err: tlsConn.Handshake()}
+	}()
+
+	client = client.Clone()
+	client.ServerName = "localhost"
+	conn, clientErr := tls.Dial("tcp", listener.Addr().String(), client)
+	if clientErr == nil {
+		defer conn.Close()
+	}
+	// TLS 1.3 clients finish the handshake before the server verifies their
+	// certificate, so wait for the server's side to complete.
+	result := <-acceptedChannel
+	if result.conn != nil {
+		defer result.conn.Close()
+	}
+	if clientErr != nil {
+		return "", nil, clientErr
+	}
+	if result.err != nil {
+		return "", nil, result.err
+	}
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName,
+		result.conn.ConnectionState().PeerCertificates, nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	authority := newAuthority(t)
+	serverCert, serverKey := authority.issue(t, "server")
+	clientCert, clientKey := authority.issue(t, "client")
+
+	server, err := New(Config{
+		Certificate: serverCert,
+		Key:         serverKey,
+		Authority:   authority.pem,
+	})
+	require.NoError(t, err)
+	client, err := New(Config{
+		Certificate: clientCert,
+		Key:         clientKey,
+		Authority:   authority.pem,
+	})
+	require.NoError(t, err)
+
+	name, clientCerts, err := handshake(t, server.ServerConfig(), client.ClientConfig())
+	require.NoError(t, err)
+	assert.Equal(t, "server", name)
+	require.Len(t, clientCerts, 1)
+	assert.Equal(t, "client", clientCerts[0].Subject.CommonName)
+
+	anonymous, err := New(Config{Authority: authority.pem})
+	require.NoError(t, err)
+	_, _, err = handshake(t, server.ServerConfig(), anonymous.ClientConfig())
+	assert.Error(t, err, "a client certificate should be required")
+}
+
+func TestClientAuthOptional(t *testing.T) {
+	authority := newAuthority(t)
+	serverCert, serverKey := authority.issue(t, "server")
+	server, err := New(Config{
+		Certificate: serverCert,
+		Key:         serverKey,
+		Authority:   authority.pem,
+		ClientAuth:  ClientAuthOptional,
+	})
+	require.NoError(t, err)
+
+	anonymous, err := New(Config{Authority: authority.pem})
+	require.NoError(t, err)
+	_, clientCerts, err := handshake(t, server.ServerConfig(), anonymous.ClientConfig())
+	require.NoError(t, err)
+	assert.Empty(t, clientCerts)
+
+	// a certificate from another authority is still rejected
+	other := newAuthority(t)
+	otherCert, otherKey := other.issue(t, "client")
+	untrusted, err := New(Config{
+		Certificate: otherCert,
+		Key:         otherKey,
+		Authority:   authority.pem,
+	})
+	require.NoError(t, err)
+	_, _, err = handshake(t, server.ServerConfig(), untrusted.ClientConfig())
+	assert.Error(t, err)
+}
+
+func TestServerConfigKeepsSettings(t *testing.T) {
+	authority := newAuthority(t)
+	serverCert, serverKey := authority.issue(t, "server")
+	server, err := New(Config{
+		Certificate: serverCert,
+		Key:         serverKey,
+		Authority:   authority.pem,
+	})
+	require.NoError(t, err)
+
+	base := server.ServerConfig()
+	base.NextProtos = []string{"h2"}
+	base.MinVersion = tls.VersionTLS12
+	config, err := base.GetConfigForClient(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"h2"}, config.NextProtos)
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+	assert.NotNil(t, config.ClientCAs)
+	assert.Len(t, config.Certificates, 1)
+	assert.Nil(t, config.GetConfigForClient)
+}
+
+func TestGRPCServerConfig(t *testing.T) {
+	authority := newAuthority(t)
+	serverCert, serverKey := authority.issue(t, "server")
+	server, err := New(Config{Certificate: serverCert, Key: serverKey})
+	require.NoError(t, err)
+
+	// credentials.NewTLS clones the configuration it's given
+	base := server.GRPCServerConfig().Clone()
+	config, err := base.GetConfigForClient(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"h2"}, config.NextProtos)
+}
+
+func TestClientVerifiesServer(t *testing.T) {
+	authority := newAuthority(t)
+	other := newAuthority(t)
+	serverCert, serverKey := other.issue(t, "server")
+	server, err := New(Config{Certificate: serverCert, Key: serverKey})
+	require.NoError(t, err)
+	client, err := New(Config{Authority: authority.pem})
+	require.NoError(t, err)
+
+	_, _, err = handshake(t, server.ServerConfig(), client.ClientConfig())
+	assert.Error(t, err, "a server signed by another authority should be rejected")
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+		// make sure the change is visible regardless of the file system's
+		// mtime resolution
+		later := time.Now().Add(time.Duration(serial) * time.Second)
+		require.NoError(t, os.Chtimes(path, later, later))
+	}
+
+	authority := newAuthority(t)
+	cert, key := authority.issue(t, "first")
+	write("cert.pem", cert)
+	write("key.pem", key)
+	write("ca.pem", authority.pem)
+	server, err := New(Config{
+		CertificateFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:         filepath.Join(dir, "key.pem"),
+		AuthorityFile:   filepath.Join(dir, "ca.pem"),
+		ClientAuth:      ClientAuthNone,
+	})
+	require.NoError(t, err)
+	client, err := New(Config{Authority: authority.pem})
+	require.NoError(t, err)
+
+	name, _, err := handshake(t, server.ServerConfig(), client.ClientConfig())
+	require.NoError(t, err)
+	assert.Equal(t, "first", name)
+
+	cert, key = authority.issue(t, "second")
+	write("cert.pem", cert)
+	write("key.pem", key)
+	server.lastCheck = time.Time{}
+	name, _, err = handshake(t, server.ServerConfig(), client.ClientConfig())
+	require.NoError(t, err)
+	assert.Equal(t, "second", name)
+
+	// a broken certificate leaves the previous one in use
+	write("cert.pem", "not a certificate")
+	server.lastCheck = time.Time{}
+	name, _, err = handshake(t, server.ServerConfig(), client.ClientConfig())
+	require.NoError(t, err)
+	assert.Equal(t, "second", name)
+}
+
+func TestInvalidConfig(t *testing.T) {
+	authority := newAuthority(t)
+	cert, key := authority.issue(t, "server")
+	for name, config := range map[string]Config{
+		"certificate without key": {Certificate: cert},
+		"key without certificate": {Key: key},
+		"inline and file":         {Certificate: cert, CertificateFile: "cert.pem", Key: key},
+		"unknown client auth":     {Certificate: cert, Key: key, ClientAuth: "sometimes"},
+		"missing file":            {CertificateFile: "/nonexistent/cert.pem", Key: key},
+		"bad authority":           {Authority: "not a certificate"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(config)
+			assert.Error(t, err)
+		})
+	}
+}
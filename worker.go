@@ -15,6 +15,7 @@ import (
 	"github.com/stripe/veneur/v14/samplers/metricpb"
 	"github.com/stripe/veneur/v14/scopedstatsd"
 	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/sinks/ssfmetrics"
 	"github.com/stripe/veneur/v14/ssf"
 	"github.com/stripe/veneur/v14/trace"
 	"github.com/stripe/veneur/v14/trace/metrics"
@@ -46,7 +47,13 @@ type Worker struct {
 	traceClient           *trace.Client
 	logger                *logrus.Logger
 	wm                    WorkerMetrics
-	stats                 scopedstatsd.Client
+	// replayed holds the metrics that local Veneurs replayed from their
+	// forward buffers, by the Unix time of the interval they belong to.
+	replayed map[int64]WorkerMetrics
+	// interval is the flush interval in seconds, that replayed metrics are
+	// grouped by.
+	interval int64
+	stats    scopedstatsd.Client
 }
 
 // IngestUDP on a Worker feeds the metric into the worker's PacketChan.
@@ -73,6 +80,8 @@ type WorkerMetrics struct {
 	// Instead, everything is forwarded.
 	globalHistograms map[samplers.MetricKey]*samplers.Histo
 	globalTimers     map[samplers.MetricKey]*samplers.Histo
+	// status checks which are combined across hosts on the global tier
+	globalStatusChecks map[samplers.MetricKey]*samplers.StatusCheck
 
 	// these are used for metrics that shouldn't be forwarded
 	localHistograms   map[samplers.MetricKey]*samplers.Histo
@@ -84,19 +93,20 @@ type WorkerMetrics struct {
 // NewWorkerMetrics initializes a WorkerMetrics struct
 func NewWorkerMetrics() WorkerMetrics {
 	return WorkerMetrics{
-		counters:          map[samplers.MetricKey]*samplers.Counter{},
-		globalCounters:    map[samplers.MetricKey]*samplers.Counter{},
-		globalGauges:      map[samplers.MetricKey]*samplers.Gauge{},
-		globalHistograms:  map[samplers.MetricKey]*samplers.Histo{},
-		globalTimers:      map[samplers.MetricKey]*samplers.Histo{},
-		gauges:            map[samplers.MetricKey]*samplers.Gauge{},
-		histograms:        map[samplers.MetricKey]*samplers.Histo{},
-		sets:              map[samplers.MetricKey]*samplers.Set{},
-		timers:            map[samplers.MetricKey]*samplers.Histo{},
-		localHistograms:   map[samplers.MetricKey]*samplers.Histo{},
-		localSets:         map[samplers.MetricKey]*samplers.Set{},
-		localTimers:       map[samplers.MetricKey]*samplers.Histo{},
-		localStatusChecks: map[samplers.MetricKey]*samplers.StatusCheck{},
+		counters:           map[samplers.MetricKey]*samplers.Counter{},
+		globalCounters:     map[samplers.MetricKey]*samplers.Counter{},
+		globalGauges:       map[samplers.MetricKey]*samplers.Gauge{},
+		globalHistograms:   map[samplers.MetricKey]*samplers.Histo{},
+		globalTimers:       map[samplers.MetricKey]*samplers.Histo{},
+		globalStatusChecks: map[samplers.MetricKey]*samplers.StatusCheck{},
+		gauges:             map[samplers.MetricKey]*samplers.Gauge{},
+		histograms:         map[samplers.MetricKey]*samplers.Histo{},
+		sets:               map[samplers.MetricKey]*samplers.Set{},
+		timers:             map[samplers.MetricKey]*samplers.Histo{},
+		localHistograms:    map[samplers.MetricKey]*samplers.Histo{},
+		localSets:          map[samplers.MetricKey]*samplers.Set{},
+		localTimers:        map[samplers.MetricKey]*samplers.Histo{},
+		localStatusChecks:  map[samplers.MetricKey]*samplers.StatusCheck{},
 	}
 }
 
@@ -165,8 +175,14 @@ func (wm WorkerMetrics) Upsert(mk samplers.MetricKey, Scope samplers.MetricScope
 			}
 		}
 	case StatusTypeName:
-		if _, present = wm.localStatusChecks[mk]; !present {
-			wm.localStatusChecks[mk] = samplers.NewStatusCheck(mk.Name, tags)
+		if Scope == samplers.GlobalOnly {
+			if _, present = wm.globalStatusChecks[mk]; !present {
+				wm.globalStatusChecks[mk] = samplers.NewStatusCheck(mk.Name, tags)
+			}
+		} else {
+			if _, present = wm.localStatusChecks[mk]; !present {
+				wm.localStatusChecks[mk] = samplers.NewStatusCheck(mk.Name, tags)
+			}
 		}
 		// no need to raise errors on unknown types
 		// the caller will probably end up doing that themselves
@@ -180,7 +196,7 @@ func (wm WorkerMetrics) ForwardableMetrics(
 	cl *trace.Client, logger *logrus.Entry,
 ) []*metricpb.Metric {
 	bufLen := len(wm.histograms) + len(wm.sets) + len(wm.timers) +
-		len(wm.globalCounters) + len(wm.globalGauges)
+		len(wm.globalCounters) + len(wm.globalGauges) + len(wm.globalStatusChecks)
 
 	metrics := make([]*metricpb.Metric, 0, bufLen)
 	for _, count := range wm.globalCounters {
@@ -211,6 +227,10 @@ func (wm WorkerMetrics) ForwardableMetrics(
 		metrics = wm.appendExportedMetric(
 			metrics, histo, metricpb.Type_Timer, cl, samplers.GlobalOnly, logger)
 	}
+	for _, status := range wm.globalStatusChecks {
+		metrics = wm.appendExportedMetric(
+			metrics, status, metricpb.Type_Status, cl, samplers.GlobalOnly, logger)
+	}
 
 	return metrics
 }
@@ -229,7 +249,6 @@ func (wm WorkerMetrics) appendExportedMetric(
 	cl *trace.Client, scope samplers.MetricScope, logger *logrus.Entry,
 ) []*metricpb.Metric {
 	m, err := exp.Metric()
-	m.Scope = scope.ToPB()
 	if err != nil {
 		logger.WithFields(logrus.Fields{
 			logrus.ErrorKey: err,
@@ -245,6 +264,7 @@ func (wm WorkerMetrics) appendExportedMetric(
 	}
 
 	m.Type = mType
+	m.Scope = scope.ToPB()
 	return append(res, m)
 }
 
@@ -265,6 +285,7 @@ func NewWorker(id int, isLocal bool, countUniqueTimeseries bool, cl *trace.Clien
 		traceClient:           cl,
 		logger:                logger,
 		wm:                    NewWorkerMetrics(),
+		replayed:              map[int64]WorkerMetrics{},
 		stats:                 scopedstatsd.Ensure(stats),
 	}
 }
@@ -337,7 +358,9 @@ func (w *Worker) SampleTimeseries(m *samplers.UDPMetric) {
 			w.uniqueMTS.Insert(digest)
 		}
 	case StatusTypeName:
-		w.uniqueMTS.Insert(digest)
+		if m.Scope != samplers.GlobalOnly {
+			w.uniqueMTS.Insert(digest)
+		}
 	default:
 		w.logger.WithField("type", m.Type).
 			Error("Unknown metric type for counting")
@@ -388,7 +411,11 @@ func (w *Worker) ProcessMetric(m *samplers.UDPMetric) {
 		}
 	case StatusTypeName:
 		v := float64(m.Value.(ssf.SSFSample_Status))
-		w.wm.localStatusChecks[m.MetricKey].Sample(v, m.SampleRate, m.Message, m.HostName)
+		if m.Scope == samplers.GlobalOnly {
+			w.wm.globalStatusChecks[m.MetricKey].Sample(v, m.SampleRate, m.Message, m.HostName)
+		} else {
+			w.wm.localStatusChecks[m.MetricKey].Sample(v, m.SampleRate, m.Message, m.HostName)
+		}
 	default:
 		w.logger.WithField("type", m.Type).
 			Error("Unknown metric type for processing")
@@ -398,15 +425,32 @@ func (w *Worker) ProcessMetric(m *samplers.UDPMetric) {
 // ImportMetric receives a metric from another veneur instance over gRPC.
 //
 // In practice, this is only called when in the aggregation tier, so we don't
-// handle LocalOnly scope.
+// handle LocalOnly scope. On the global tier, metrics with a timestamp were
+// replayed from a local Veneur's forward buffer, and are kept apart from the
+// current interval. Local Veneurs don't flush at the same time, so replayed
+// metrics are grouped by the start of the interval their timestamp falls in.
 func (w *Worker) ImportMetric(other *metricpb.Metric) (err error) {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
+	wm := w.wm
+	if other.Timestamp != 0 && !w.isLocal {
+		timestamp := other.Timestamp
+		if w.interval > 0 {
+			timestamp -= timestamp % w.interval
+		}
+		var ok bool
+		if wm, ok = w.replayed[timestamp]; !ok {
+			wm = NewWorkerMetrics()
+			w.replayed[timestamp] = wm
+		}
+	}
+
 	key := samplers.NewMetricKeyFromMetric(other, []matcher.TagMatcher{})
 
 	scope := samplers.ScopeFromPB(other.Scope)
-	if other.Type == metricpb.Type_Counter || other.Type == metricpb.Type_Gauge {
+	if other.Type == metricpb.Type_Counter || other.Type == metricpb.Type_Gauge ||
+		other.Type == metricpb.Type_Status {
 		scope = samplers.GlobalOnly
 	}
 
@@ -414,31 +458,33 @@ func (w *Worker) ImportMetric(other *metricpb.Metric) (err error) {
 		return fmt.Errorf("gRPC import does not accept local metrics")
 	}
 
-	w.wm.Upsert(key, scope, other.Tags)
+	wm.Upsert(key, scope, other.Tags)
 	w.imported++
 
 	switch v := other.GetValue().(type) {
 	case *metricpb.Metric_Counter:
-		w.wm.globalCounters[key].Merge(v.Counter)
+		wm.globalCounters[key].Merge(v.Counter)
 	case *metricpb.Metric_Gauge:
-		w.wm.globalGauges[key].Merge(v.Gauge)
+		wm.globalGauges[key].Merge(v.Gauge)
+	case *metricpb.Metric_Status:
+		wm.globalStatusChecks[key].Merge(v.Status)
 	case *metricpb.Metric_Set:
-		if merr := w.wm.sets[key].Merge(v.Set); merr != nil {
+		if merr := wm.sets[key].Merge(v.Set); merr != nil {
 			err = fmt.Errorf("could not merge a set: %v", err)
 		}
 	case *metricpb.Metric_Histogram:
 		switch other.Type {
 		case metricpb.Type_Histogram:
 			if other.Scope == metricpb.Scope_Mixed {
-				w.wm.histograms[key].Merge(v.Histogram)
+				wm.histograms[key].Merge(v.Histogram)
 			} else if other.Scope == metricpb.Scope_Global {
-				w.wm.globalHistograms[key].Merge(v.Histogram)
+				wm.globalHistograms[key].Merge(v.Histogram)
 			}
 		case metricpb.Type_Timer:
 			if other.Scope == metricpb.Scope_Mixed {
-				w.wm.timers[key].Merge(v.Histogram)
+				wm.timers[key].Merge(v.Histogram)
 			} else if other.Scope == metricpb.Scope_Global {
-				w.wm.globalTimers[key].Merge(v.Histogram)
+				wm.globalTimers[key].Merge(v.Histogram)
 			}
 		}
 	case nil:
@@ -480,6 +526,16 @@ func (w *Worker) Flush() WorkerMetrics {
 	return ret
 }
 
+// FlushReplayed resets the worker's replayed metrics and returns them, by the
+// Unix time of the interval they belong to.
+func (w *Worker) FlushReplayed() map[int64]WorkerMetrics {
+	w.mutex.Lock()
+	ret := w.replayed
+	w.replayed = map[int64]WorkerMetrics{}
+	w.mutex.Unlock()
+	return ret
+}
+
 // Stop tells the worker to stop listening for work requests.
 //
 // Note that the worker will only stop *after* it has finished its work.
@@ -543,6 +599,10 @@ type SpanWorker struct {
 	sinks      []sinks.SpanSink
 	logger     *logrus.Entry
 
+	// spans forwarded by local Veneurs, and which sinks they skip
+	forwardedSpanChan <-chan *ssf.SSFSpan
+	localOnly         []bool
+
 	// cumulative time spent per sink, in nanoseconds
 	cumulativeTimes []int64
 	traceClient     *trace.Client
@@ -558,16 +618,19 @@ func NewSpanWorker(
 	logger *logrus.Entry,
 ) *SpanWorker {
 	tags := make([]map[string]string, len(sinks))
+	localOnly := make([]bool, len(sinks))
 	for i, sink := range sinks {
 		tags[i] = map[string]string{
 			"sink": sink.Name(),
 		}
+		_, localOnly[i] = sink.(ssfmetrics.DerivedMetricsSink)
 	}
 
 	return &SpanWorker{
 		SpanChan:        spanChan,
 		sinks:           sinks,
 		sinkTags:        tags,
+		localOnly:       localOnly,
 		commonTags:      commonTags,
 		cumulativeTimes: make([]int64, len(sinks)),
 		traceClient:     cl,
@@ -579,90 +642,107 @@ func NewSpanWorker(
 // Work will start the SpanWorker listening for spans.
 // This function will never return.
 func (tw *SpanWorker) Work() {
-	const Timeout = 9 * time.Second
 	capcmp := cap(tw.SpanChan) - 1
-	for m := range tw.SpanChan {
-		// If we are at or one below cap, increment the counter.
-		if len(tw.SpanChan) >= capcmp {
-			atomic.AddInt64(&tw.capCount, 1)
+	for {
+		select {
+		case m, ok := <-tw.SpanChan:
+			if !ok {
+				return
+			}
+			// If we are at or one below cap, increment the counter.
+			if len(tw.SpanChan) >= capcmp {
+				atomic.AddInt64(&tw.capCount, 1)
+			}
+			tw.ingest(m, false)
+		case m := <-tw.forwardedSpanChan:
+			tw.ingest(m, true)
 		}
+	}
+}
 
-		if m.Tags == nil && len(tw.commonTags) != 0 {
-			m.Tags = make(map[string]string, len(tw.commonTags))
-		}
+// ingest passes a span to each sink. Spans forwarded by a local Veneur skip
+// the sinks that extract metrics from spans, as the local Veneur already
+// extracted them.
+func (tw *SpanWorker) ingest(m *ssf.SSFSpan, forwarded bool) {
+	const Timeout = 9 * time.Second
+	if m.Tags == nil && len(tw.commonTags) != 0 {
+		m.Tags = make(map[string]string, len(tw.commonTags))
+	}
 
-		for k, v := range tw.commonTags {
-			if _, has := m.Tags[k]; !has {
-				m.Tags[k] = v
-			}
+	for k, v := range tw.commonTags {
+		if _, has := m.Tags[k]; !has {
+			m.Tags[k] = v
 		}
+	}
 
-		// An SSF packet may contain a valid span, one or more valid metrics,
-		// or both (a valid span *and* one or more valid metrics).
-		// If it contains neither, it is the result of a client error, and the
-		// span does not need to be passed to any sink.
-		// If the span is empty but one or more metrics exist, the span still needs
-		// to be passed to the sinks for potential metric extraction.
-		if err := protocol.ValidateTrace(m); err != nil {
-			if len(m.Metrics) == 0 {
-				atomic.AddInt64(&tw.emptySSFCount, 1)
-				tw.logger.WithError(err).Debug(
-					"Invalid SSF packet: packet contains neither valid metrics nor a valid span")
-				continue
-			}
+	// An SSF packet may contain a valid span, one or more valid metrics,
+	// or both (a valid span *and* one or more valid metrics).
+	// If it contains neither, it is the result of a client error, and the
+	// span does not need to be passed to any sink.
+	// If the span is empty but one or more metrics exist, the span still needs
+	// to be passed to the sinks for potential metric extraction.
+	if err := protocol.ValidateTrace(m); err != nil {
+		if len(m.Metrics) == 0 {
+			atomic.AddInt64(&tw.emptySSFCount, 1)
+			tw.logger.WithError(err).Debug(
+				"Invalid SSF packet: packet contains neither valid metrics nor a valid span")
+			return
 		}
+	}
 
-		var wg sync.WaitGroup
-		for i, s := range tw.sinks {
-			tags := tw.sinkTags[i]
-			wg.Add(1)
-			go func(i int, sink sinks.SpanSink, span *ssf.SSFSpan, wg *sync.WaitGroup) {
-				defer wg.Done()
-
-				done := make(chan struct{})
-				start := time.Now()
-
-				go func() {
-					// Give each sink a change to ingest.
-					err := sink.Ingest(span)
-					if err != nil {
-						if _, isNoTrace := err.(*protocol.InvalidTrace); !isNoTrace {
-							// If a sink goes wacko and errors a lot, we stand to emit a
-							// loooot of metrics towards all span workers here since
-							// span ingest rates can be very high. C'est la vie.
-							t := make([]string, 0, len(tags)+1)
-							for k, v := range tags {
-								t = append(t, k+":"+v)
-							}
-
-							t = append(t, "sink:"+sink.Name())
-							tw.statsd.Incr("worker.span.ingest_error_total", t, 1.0)
+	var wg sync.WaitGroup
+	for i, s := range tw.sinks {
+		if forwarded && tw.localOnly[i] {
+			continue
+		}
+		tags := tw.sinkTags[i]
+		wg.Add(1)
+		go func(i int, sink sinks.SpanSink, span *ssf.SSFSpan, wg *sync.WaitGroup) {
+			defer wg.Done()
+
+			done := make(chan struct{})
+			start := time.Now()
+
+			go func() {
+				// Give each sink a change to ingest.
+				err := sink.Ingest(span)
+				if err != nil {
+					if _, isNoTrace := err.(*protocol.InvalidTrace); !isNoTrace {
+						// If a sink goes wacko and errors a lot, we stand to emit a
+						// loooot of metrics towards all span workers here since
+						// span ingest rates can be very high. C'est la vie.
+						t := make([]string, 0, len(tags)+1)
+						for k, v := range tags {
+							t = append(t, k+":"+v)
 						}
-					}
-					done <- struct{}{}
-				}()
-
-				select {
-				case _ = <-done:
-				case <-time.After(Timeout):
-					tw.logger.WithFields(logrus.Fields{
-						"sink":  sink.Name(),
-						"index": i,
-					}).Error("Timed out on sink ingestion")
-
-					t := make([]string, 0, len(tags)+1)
-					for k, v := range tags {
-						t = append(t, k+":"+v)
-					}
 
-					t = append(t, "sink:"+sink.Name())
-					tw.statsd.Incr("worker.span.ingest_timeout_total", t, 1.0)
+						t = append(t, "sink:"+sink.Name())
+						tw.statsd.Incr("worker.span.ingest_error_total", t, 1.0)
+					}
 				}
-				atomic.AddInt64(&tw.cumulativeTimes[i], int64(time.Since(start)/time.Nanosecond))
-			}(i, s, m, &wg)
-		}
-		wg.Wait()
+				done <- struct{}{}
+			}()
+
+			select {
+			case _ = <-done:
+			case <-time.After(Timeout):
+				tw.logger.WithFields(logrus.Fields{
+					"sink":  sink.Name(),
+					"index": i,
+				}).Error("Timed out on sink ingestion")
+
+				t := make([]string, 0, len(tags)+1)
+				for k, v := range tags {
+					t = append(t, k+":"+v)
+				}
+
+				t = append(t, "sink:"+sink.Name())
+				tw.statsd.Incr("worker.span.ingest_timeout_total", t, 1.0)
+			}
+			atomic.AddInt64(&tw.cumulativeTimes[i], int64(time.Since(start)/time.Nanosecond))
+		}(i, s, m, &wg)
 	}
+	wg.Wait()
 }
 
 // Flush invokes flush on each sink.
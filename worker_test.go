@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/sinks/ssfmetrics"
 	"github.com/stripe/veneur/v14/ssf"
 	"github.com/stripe/veneur/v14/trace"
 	"github.com/stripe/veneur/v14/trace/testbackend"
@@ -122,6 +123,34 @@ func TestWorkerStatusMetric(t *testing.T) {
 	assert.Len(t, nometrics.localStatusChecks, 0, "Should flush no metrics")
 }
 
+func TestWorkerGlobalStatusMetric(t *testing.T) {
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+
+	m := samplers.UDPMetric{
+		MetricKey: samplers.MetricKey{
+			Name: "a.b.c",
+			Type: "status",
+		},
+		Value:   ssf.SSFSample_CRITICAL,
+		Digest:  12345,
+		Message: "you've got mail!",
+		Scope:   samplers.GlobalOnly,
+	}
+	w.ProcessMetric(&m)
+
+	wm := w.Flush()
+	assert.Len(t, wm.localStatusChecks, 0, "Global status checks should not be flushed locally")
+	assert.Len(t, wm.globalStatusChecks, 1, "Number of global status checks")
+
+	forwarded := wm.ForwardableMetrics(nil, logrus.NewEntry(logrus.New()))
+	if assert.Len(t, forwarded, 1) {
+		assert.Equal(t, metricpb.Type_Status, forwarded[0].Type)
+		assert.Equal(t, metricpb.Scope_Global, forwarded[0].Scope)
+		assert.Equal(t, int64(1), forwarded[0].GetStatus().Critical)
+		assert.Equal(t, m.Message, forwarded[0].GetStatus().Message)
+	}
+}
+
 func TestSpanWorkerTagApplication(t *testing.T) {
 	tags := map[string]func() map[string]string{
 		"foo": func() map[string]string {
@@ -209,6 +238,68 @@ func TestSpanWorkerTagApplication(t *testing.T) {
 	close(quitch)
 }
 
+type capturingProcessor struct {
+	metrics chan samplers.UDPMetric
+}
+
+func (p capturingProcessor) IngestUDP(metric samplers.UDPMetric) {
+	p.metrics <- metric
+}
+
+func TestSpanWorkerForwardedSpansSkipMetricExtraction(t *testing.T) {
+	cl, clch := newTestClient(t, 1)
+	go func() {
+		for range clch {
+		}
+	}()
+	logger := logrus.New()
+
+	processor := capturingProcessor{metrics: make(chan samplers.UDPMetric, 10)}
+	parser := samplers.NewParser(nil)
+	extraction, err := ssfmetrics.NewMetricExtractionSink(
+		[]ssfmetrics.Processor{processor}, "", "", cl, logger, &parser)
+	require.NoError(t, err)
+	fake := &fakeSpanSink{wg: &sync.WaitGroup{}}
+
+	spanChan := make(chan *ssf.SSFSpan)
+	forwardedSpanChan := make(chan *ssf.SSFSpan)
+	worker := NewSpanWorker([]sinks.SpanSink{fake, extraction}, cl, nil,
+		spanChan, nil, logrus.NewEntry(logger))
+	worker.forwardedSpanChan = forwardedSpanChan
+	go worker.Work()
+	defer close(spanChan)
+
+	testSpan := func(metricName string) *ssf.SSFSpan {
+		return &ssf.SSFSpan{
+			TraceId:        1,
+			Id:             2,
+			StartTimestamp: time.Now().UnixNano(),
+			EndTimestamp:   time.Now().UnixNano(),
+			// no service, so the extraction sink doesn't randomly sample
+			// a uniqueness metric from the span
+			Name:    "test.span",
+			Metrics: []*ssf.SSFSample{ssf.Count(metricName, 1, nil)},
+		}
+	}
+
+	// The worker handles one span at a time, so the forwarded span is done
+	// with by the time the local span's metric is extracted.
+	fake.wg.Add(2)
+	forwardedSpanChan <- testSpan("forwarded.count")
+	spanChan <- testSpan("local.count")
+	fake.wg.Wait()
+	assert.Len(t, fake.spans, 2, "both spans should reach the span sinks")
+
+	select {
+	case metric := <-processor.metrics:
+		assert.Equal(t, "local.count", metric.Name,
+			"metrics should not be extracted from forwarded spans")
+	case <-time.After(time.Second):
+		t.Fatal("no metric was extracted from the local span")
+	}
+	assert.Empty(t, processor.metrics)
+}
+
 type fakeSpanSink struct {
 	wg    *sync.WaitGroup
 	spans []*ssf.SSFSpan
@@ -295,6 +386,14 @@ func TestWorkerImportMetricGRPC(t *testing.T) {
 		assert.Len(t, exportMetricAndFlush(t, s).sets, 1,
 			"The number of flushed sets is not correct")
 	})
+	t.Run("status", func(t *testing.T) {
+		t.Parallel()
+		s := samplers.NewStatusCheck("test.status", nil)
+		s.Sample(float64(ssf.SSFSample_WARNING), 1.0, "uh oh", "host1")
+
+		assert.Len(t, exportMetricAndFlush(t, s).globalStatusChecks, 1,
+			"The number of flushed status checks is not correct")
+	})
 }
 
 func TestWorkerImportMetricGRPCNilValue(t *testing.T) {